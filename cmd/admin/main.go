@@ -0,0 +1,188 @@
+// Command admin runs database migrations and maintenance tasks against the plibrary
+// database, using the migrations embedded in the binary.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/joho/godotenv"
+	"github.com/themilar/plibrary/internal/migrate"
+	"github.com/themilar/plibrary/internal/models"
+	"github.com/themilar/plibrary/internal/settings"
+	"github.com/themilar/plibrary/migrations"
+)
+
+const usage = `usage: admin [-config FILE] [-db-dsn DSN] <command> [arguments]
+
+The database is configured as for the API: -db-dsn, PLIBRARY_DB_DSN or DATABASE_URL, or the
+db-dsn setting of the file named by -config or PLIBRARY_CONFIG.
+
+commands:
+  migrate up [N]        apply all or the next N pending migrations
+  migrate down N|-all   revert the last N migrations, or all of them
+  migrate status        list migrations and the current schema version
+  migrate force V       record version V as applied without running anything
+  seed [-copies N]      load fixture books, skipping titles that already exist
+  reindex               rebuild the indexes of the catalogue tables
+  check                 exit with an error unless the schema is up to date
+`
+
+type admin struct {
+	db       *pgxpool.Pool
+	migrator *migrate.Migrator
+	models   models.Models
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "admin:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	godotenv.Load()
+	fs := flag.NewFlagSet("admin", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(fs.Output(), usage) }
+	var dsn string
+	loader := settings.Loader{
+		Register: func(fs *flag.FlagSet) { fs.StringVar(&dsn, "db-dsn", "", "PostgreSQL DSN") },
+		Partial:  true,
+	}
+	if _, err := loader.Load(fs, args, os.Getenv); err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		fs.Usage()
+		return errors.New("missing command")
+	}
+
+	db, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+	app := &admin{db: db, migrator: migrator, models: models.NewModels(db)}
+
+	switch args[0] {
+	case "migrate":
+		if len(args) < 2 {
+			return errors.New("usage: admin migrate up|down|status|force")
+		}
+		return app.migrate(ctx, args[1], args[2:])
+	case "seed":
+		return app.seed(ctx, args[1:])
+	case "reindex":
+		return app.reindex(ctx)
+	case "check":
+		if err := app.migrator.Check(ctx); err != nil {
+			return err
+		}
+		fmt.Printf("schema is up to date at version %d\n", app.migrator.Latest())
+		return nil
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func (app *admin) migrate(ctx context.Context, command string, args []string) error {
+	switch command {
+	case "up":
+		n := 0
+		if len(args) > 0 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+				return fmt.Errorf("invalid number of migrations %q", args[0])
+			}
+		}
+		applied, err := app.migrator.Up(ctx, n)
+		for _, m := range applied {
+			fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+		}
+		if errors.Is(err, migrate.ErrNoChange) {
+			fmt.Println("no pending migrations")
+			return nil
+		}
+		return err
+	case "down":
+		// Reverting everything drops the data, so it has to be asked for explicitly.
+		if len(args) != 1 {
+			return errors.New("usage: admin migrate down N|-all")
+		}
+		n := 0
+		if args[0] != "-all" {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+				return fmt.Errorf("invalid number of migrations %q", args[0])
+			}
+		}
+		reverted, err := app.migrator.Down(ctx, n)
+		for _, m := range reverted {
+			fmt.Printf("reverted %d_%s\n", m.Version, m.Name)
+		}
+		if errors.Is(err, migrate.ErrNoChange) {
+			fmt.Println("no applied migrations")
+			return nil
+		}
+		return err
+	case "status":
+		version, dirty, err := app.migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range app.migrator.Migrations {
+			state := "pending"
+			if m.Version <= version {
+				state = "applied"
+			}
+			if m.Version == version && dirty {
+				state = "dirty"
+			}
+			fmt.Printf("%-8s %06d_%s\n", state, m.Version, m.Name)
+		}
+		fmt.Printf("version %d of %d\n", version, app.migrator.Latest())
+		return nil
+	case "force":
+		if len(args) != 1 {
+			return errors.New("usage: admin migrate force V")
+		}
+		version, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[0])
+		}
+		if err := app.migrator.Force(ctx, version); err != nil {
+			return err
+		}
+		fmt.Printf("forced version %d\n", version)
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %q", command)
+}
+
+// reindex rebuilds the indexes of the catalogue tables, including the full text search
+// index on book titles, and refreshes the planner statistics.
+func (app *admin) reindex(ctx context.Context) error {
+	for _, table := range []string{"books", "copies"} {
+		if _, err := app.db.Exec(ctx, "REINDEX TABLE "+table); err != nil {
+			return fmt.Errorf("reindexing %s: %w", table, err)
+		}
+		if _, err := app.db.Exec(ctx, "ANALYZE "+table); err != nil {
+			return fmt.Errorf("analyzing %s: %w", table, err)
+		}
+		fmt.Printf("reindexed %s\n", table)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"github.com/themilar/plibrary/internal"
+	"github.com/themilar/plibrary/internal/models"
+)
+
+//go:embed fixtures/books.json
+var fixtureBooks []byte
+
+var branches = []string{"Central", "Riverside", "Northgate"}
+
+// seed loads the fixture books, each with a few copies spread over the branches. Books whose
+// title is already in the catalogue are skipped, so seeding twice does nothing.
+func (app *admin) seed(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	copies := fs.Int("copies", 2, "Copies to add for each new book")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := app.migrator.Check(ctx); err != nil {
+		return err
+	}
+	var fixtures []struct {
+		Title     string   `json:"title"`
+		Authors   []string `json:"authors"`
+		Publisher string   `json:"publisher"`
+		Published int      `json:"published"`
+		Pages     int      `json:"pages"`
+		Genres    []string `json:"genres"`
+	}
+	if err := json.Unmarshal(fixtureBooks, &fixtures); err != nil {
+		return err
+	}
+
+	added := 0
+	filters := internal.Filters{Page: 1, Size: 1, Sort: "id"}
+	for _, fixture := range fixtures {
+		book := &models.Book{
+			Title:     fixture.Title,
+			Authors:   fixture.Authors,
+			Publisher: fixture.Publisher,
+			Published: fixture.Published,
+			Pages:     fixture.Pages,
+			Genres:    fixture.Genres,
+		}
+		existing, _, err := app.models.Books.All(ctx, book.Title, []string{}, filters, "id")
+		if err != nil {
+			return err
+		}
+		if len(existing) > 0 {
+			continue
+		}
+		if errs := book.Validate(); len(errs) > 0 {
+			return fmt.Errorf("fixture %q: %v", book.Title, errs)
+		}
+		if err := app.models.Books.Insert(ctx, book); err != nil {
+			return fmt.Errorf("fixture %q: %w", book.Title, err)
+		}
+		for i := 0; i < *copies; i++ {
+			copy := &models.Copy{
+				BookID:  book.ID,
+				Barcode: fmt.Sprintf("PL%06d%02d", book.ID, i+1),
+				Branch:  branches[(int(book.ID)+i)%len(branches)],
+			}
+			if err := app.models.Copies.Insert(copy); err != nil {
+				return fmt.Errorf("copy of %q: %w", book.Title, err)
+			}
+		}
+		added++
+	}
+	fmt.Printf("added %d of %d fixture books\n", added, len(fixtures))
+	return nil
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/themilar/plibrary/internal/e2etest"
+)
+
+func TestAPI(t *testing.T) {
+	e2etest.Run(t, func(t *testing.T, db *pgxpool.Pool, logger *slog.Logger) http.Handler {
+		return newApplication(config{}, logger, db).routes()
+	})
+}
@@ -4,43 +4,51 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"github.com/themilar/plibrary/internal"
 	"github.com/themilar/plibrary/internal/models"
 )
 
-var createInput struct {
+type createInput struct {
 	Title     string   `json:"title" `
+	Authors   []string `json:"authors" `
+	Publisher string   `json:"publisher" `
 	Published int      `json:"published" `
 	Pages     int      `json:"pages" `
 	Genres    []string `json:"genres" `
 }
 
-// type updateInput struct {
-// 	Title     *string  `json:"title" `
-// 	Published *int     `json:"published" `
-// 	Pages     *int     `json:"pages" `
-// 	Genres    []string `json:"genres" `
-// }
+type updateInput struct {
+	Title     *string  `json:"title" `
+	Authors   []string `json:"authors" `
+	Publisher *string  `json:"publisher" `
+	Published *int     `json:"published" `
+	Pages     *int     `json:"pages" `
+	Genres    []string `json:"genres" `
+}
 
-var listInput struct {
+type listInput struct {
 	Title  string
 	Genres []string
 	internal.Filters
 }
 
 func createBook(app *application, w http.ResponseWriter, r *http.Request) (*models.Book, http.Header) {
-	err := app.readJson(w, r, &createInput)
+	var input createInput
+	err := app.readJson(w, r, &input)
 	if err != nil {
 		app.badRequestErrorResponse(w, r, err)
 		return &models.Book{}, nil
 	}
 	book := &models.Book{
-		Title:     createInput.Title,
-		Published: createInput.Published,
-		Pages:     createInput.Pages,
-		Genres:    createInput.Genres,
+		Title:     input.Title,
+		Authors:   input.Authors,
+		Publisher: input.Publisher,
+		Published: input.Published,
+		Pages:     input.Pages,
+		Genres:    input.Genres,
 	}
 
 	validationErrors := book.Validate()
@@ -48,7 +56,7 @@ func createBook(app *application, w http.ResponseWriter, r *http.Request) (*mode
 		app.failedValidationErrorResponse(w, r, validationErrors)
 		return &models.Book{}, nil
 	}
-	err = app.models.Books.Insert(book)
+	err = app.models.Books.Insert(r.Context(), book)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return &models.Book{}, nil
@@ -58,7 +66,7 @@ func createBook(app *application, w http.ResponseWriter, r *http.Request) (*mode
 	return book, headers
 }
 func updateBook(app *application, w http.ResponseWriter, r *http.Request, id int64) *models.Book {
-	book, err := app.models.Books.Get(id)
+	book, err := app.models.Books.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -68,12 +76,13 @@ func updateBook(app *application, w http.ResponseWriter, r *http.Request, id int
 		}
 		return nil
 	}
-	var input struct {
-		Title     *string  `json:"title" `
-		Published *int     `json:"published" `
-		Pages     *int     `json:"pages" `
-		Genres    []string `json:"genres" `
+	// Clients holding an older copy of the record can send its version to avoid
+	// overwriting changes they have not seen.
+	if expected := r.Header.Get("X-Expected-Version"); expected != "" && expected != strconv.Itoa(book.Version) {
+		app.editConflictErrorResponse(w, r)
+		return nil
 	}
+	var input updateInput
 	err = app.readJson(w, r, &input)
 	if err != nil {
 		app.badRequestErrorResponse(w, r, err)
@@ -82,6 +91,12 @@ func updateBook(app *application, w http.ResponseWriter, r *http.Request, id int
 	if input.Title != nil {
 		book.Title = *input.Title
 	}
+	if input.Authors != nil {
+		book.Authors = input.Authors
+	}
+	if input.Publisher != nil {
+		book.Publisher = *input.Publisher
+	}
 	if input.Published != nil {
 		book.Published = *input.Published
 	}
@@ -96,7 +111,7 @@ func updateBook(app *application, w http.ResponseWriter, r *http.Request, id int
 		app.failedValidationErrorResponse(w, r, validationErrors)
 		return nil
 	}
-	err = app.models.Books.Update(book)
+	err = app.models.Books.Update(r.Context(), book)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrEditConflict):
@@ -108,8 +123,8 @@ func updateBook(app *application, w http.ResponseWriter, r *http.Request, id int
 	}
 	return book
 }
-func getBookDetail(app *application, w http.ResponseWriter, r *http.Request, id int64) *models.Book {
-	book, err := app.models.Books.Get(id)
+func getBookDetail(app *application, w http.ResponseWriter, r *http.Request, id int64, fields []string) *models.Book {
+	book, err := app.models.Books.Get(r.Context(), id, fields...)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -122,7 +137,7 @@ func getBookDetail(app *application, w http.ResponseWriter, r *http.Request, id
 	return book
 }
 func deleteBook(app *application, w http.ResponseWriter, r *http.Request, id int64) bool {
-	err := app.models.Books.Delete(id)
+	err := app.models.Books.Delete(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -135,33 +150,40 @@ func deleteBook(app *application, w http.ResponseWriter, r *http.Request, id int
 	return true
 
 }
-func getBookList(app *application, w http.ResponseWriter, r *http.Request) ([]*models.Book, *internal.PaginationMetadata) {
+func getBookList(app *application, w http.ResponseWriter, r *http.Request, fields []string) ([]*models.Book, *internal.PaginationMetadata) {
 	qs := r.URL.Query()
-	listInput.Title = app.readString(qs, "title", "")
-	listInput.Genres = app.readCSV(qs, "genres", []string{})
+	var input listInput
+	input.Title = app.readString(qs, "title", "")
+	input.Genres = app.readCSV(qs, "genres", []string{})
+	filters, filterErrors := readFilters(app, qs, "id")
+	if len(filterErrors) > 0 {
+		app.failedValidationErrorResponse(w, r, filterErrors)
+		return nil, nil
+	}
+	input.Filters = filters
+	books, metadata, err := app.models.Books.All(r.Context(), input.Title, input.Genres, input.Filters, fields...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, nil
+	}
+	return books, metadata
+}
+
+func readFilters(app *application, qs url.Values, defaultSort string) (internal.Filters, map[string]string) {
+	var filters internal.Filters
 	filterTypeErrors := map[string]string{}
 	p := app.checkEmptyStrings(qs.Get("page"), "1")
 	page, err := strconv.Atoi(p)
 	if err != nil {
 		filterTypeErrors["page"] = "must be an integer"
 	}
-	listInput.Filters.Page = page
+	filters.Page = page
 	s := app.checkEmptyStrings(qs.Get("size"), "12")
 	size, err := strconv.Atoi(s)
 	if err != nil {
 		filterTypeErrors["size"] = "must be an integer"
 	}
-	listInput.Filters.Size = size
-	listInput.Filters.Sort = app.readString(qs, "sort", "id")
-	filterErrors := internal.ValidateFilters(listInput.Filters, filterTypeErrors)
-	if len(filterErrors) > 0 {
-		app.failedValidationErrorResponse(w, r, filterErrors)
-		return nil, nil
-	}
-	books, metadata, err := app.models.Books.All(listInput.Title, listInput.Genres, listInput.Filters)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return nil, nil
-	}
-	return books, metadata
+	filters.Size = size
+	filters.Sort = app.readString(qs, "sort", defaultSort)
+	return filters, internal.ValidateFilters(filters, filterTypeErrors)
 }
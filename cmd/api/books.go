@@ -2,12 +2,18 @@ package main
 
 import (
 	"net/http"
+
+	"github.com/themilar/plibrary/internal/models"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func (app *application) bookCreate(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "bookCreate")
+	defer span.End()
 	book, headers := createBook(app, w, r)
 	if headers != nil {
-		err := app.writeJson(w, http.StatusCreated, envelope{"book": book}, headers)
+		span.SetAttributes(attribute.Int64("book.id", book.ID))
+		err := app.writeResponse(w, r, http.StatusCreated, envelope{"book": book}, headers)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 		}
@@ -15,14 +21,30 @@ func (app *application) bookCreate(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) bookDetail(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "bookDetail")
+	defer span.End()
 	id, err := app.readIDParam(r)
 	if err != nil || id < 1 {
 		app.notFoundErrorResponse(w, r)
 		return
 	}
-	book := getBookDetail(app, w, r, id)
+	span.SetAttributes(attribute.Int64("book.id", id))
+	q, ok := app.bookQuery(w, r)
+	if !ok {
+		return
+	}
+	book := getBookDetail(app, w, r, id, q.fields)
 	if book != nil {
-		if err = app.writeJson(w, http.StatusAccepted, envelope{"book": book}, nil); err != nil {
+		if format := app.citationFormat(r); format != "" {
+			err = app.writeCitations(w, r, format, []*models.Book{book})
+		} else {
+			var resources []bookResource
+			resources, err = app.bookResources(r.Context(), []*models.Book{book}, q)
+			if err == nil {
+				err = app.writeResponse(w, r, http.StatusAccepted, envelope{"book": resources[0]}, nil)
+			}
+		}
+		if err != nil {
 			app.serverErrorResponse(w, r, err)
 		}
 	}
@@ -30,14 +52,17 @@ func (app *application) bookDetail(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) bookUpdate(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "bookUpdate")
+	defer span.End()
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundErrorResponse(w, r)
 		return
 	}
+	span.SetAttributes(attribute.Int64("book.id", id))
 	book := updateBook(app, w, r, id)
 	if book != nil {
-		err = app.writeJson(w, http.StatusOK, envelope{"book": book}, nil)
+		err = app.writeResponse(w, r, http.StatusOK, envelope{"book": book}, nil)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 		}
@@ -45,14 +70,17 @@ func (app *application) bookUpdate(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) bookDelete(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "bookDelete")
+	defer span.End()
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundErrorResponse(w, r)
 		return
 	}
+	span.SetAttributes(attribute.Int64("book.id", id))
 	ok := deleteBook(app, w, r, id)
 	if ok {
-		err = app.writeJson(w, http.StatusNoContent, envelope{}, nil)
+		err = app.writeResponse(w, r, http.StatusNoContent, envelope{}, nil)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 		}
@@ -60,9 +88,25 @@ func (app *application) bookDelete(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) bookList(w http.ResponseWriter, r *http.Request) {
-	books, metadata := getBookList(app, w, r)
+	r, span := startSpan(r, "bookList")
+	defer span.End()
+	q, ok := app.bookQuery(w, r)
+	if !ok {
+		return
+	}
+	books, metadata := getBookList(app, w, r, q.fields)
 	if books != nil {
-		err := app.writeJson(w, http.StatusOK, envelope{"books": books, "metadata": metadata}, nil)
+		span.SetAttributes(attribute.Int("books.count", len(books)), attribute.Int("books.total", metadata.TotalRecords))
+		var err error
+		if format := app.citationFormat(r); format != "" {
+			err = app.writeCitations(w, r, format, books)
+		} else {
+			var resources []bookResource
+			resources, err = app.bookResources(r.Context(), books, q)
+			if err == nil {
+				err = app.writeResponse(w, r, http.StatusOK, envelope{"books": resources, "metadata": metadata}, nil)
+			}
+		}
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 		}
@@ -70,14 +114,29 @@ func (app *application) bookList(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) bookSearch(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "bookSearch")
+	defer span.End()
 	qs := r.URL.Query()
 	title := app.readString(qs, "q", "")
-	books, err := app.models.Books.FullTextSearch(title)
+	q, ok := app.bookQuery(w, r)
+	if !ok {
+		return
+	}
+	books, err := app.models.Books.FullTextSearch(r.Context(), title, q.fields...)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJson(w, http.StatusOK, envelope{"books": books}, nil)
+	span.SetAttributes(attribute.Int("books.count", len(books)))
+	if format := app.citationFormat(r); format != "" {
+		err = app.writeCitations(w, r, format, books)
+	} else {
+		var resources []bookResource
+		resources, err = app.bookResources(r.Context(), books, q)
+		if err == nil {
+			err = app.writeResponse(w, r, http.StatusOK, envelope{"books": resources}, nil)
+		}
+	}
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
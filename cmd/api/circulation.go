@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/themilar/plibrary/internal/models"
+)
+
+const (
+	wsPingInterval = 30 * time.Second
+	wsWriteTimeout = 10 * time.Second
+	wsReadLimit    = 4096
+	wsSendBuffer   = 64
+	wsMaxTopics    = 100
+	wsAuthTimeout  = 10 * time.Second
+)
+
+// wsRequest is a message from a client: auth with a token, or subscribe and unsubscribe with
+// a topic. ID is echoed in the reply.
+type wsRequest struct {
+	Type  string `json:"type"`
+	ID    string `json:"id,omitempty"`
+	Token string `json:"token,omitempty"`
+	Topic string `json:"topic,omitempty"`
+}
+
+// wsMessage is a message to a client: the reply to a request (ok or error) or an event on a
+// subscribed topic.
+type wsMessage struct {
+	Type           string       `json:"type"`
+	ID             string       `json:"id,omitempty"`
+	Topic          string       `json:"topic,omitempty"`
+	Event          string       `json:"event,omitempty"`
+	Copy           *models.Copy `json:"copy,omitempty"`
+	PreviousStatus string       `json:"previous_status,omitempty"`
+	Patron         string       `json:"patron,omitempty"`
+	PreviousPatron string       `json:"previous_patron,omitempty"`
+	At             *time.Time   `json:"at,omitempty"`
+	Message        string       `json:"message,omitempty"`
+}
+
+// circulationEvent names the desk event for a copy moving from one status to another, or
+// passing from one patron to another.
+func circulationEvent(change *models.CopyChange) string {
+	switch {
+	case change.PreviousStatus == "":
+		return "copy_added"
+	case change.PreviousStatus == change.Copy.Status:
+		return "patron_changed"
+	case change.Copy.Status == "on_loan":
+		return "checkout"
+	case change.PreviousStatus == "on_loan" && change.Copy.Status == "available":
+		return "return"
+	case change.Copy.Status == "on_hold":
+		return "hold_ready"
+	}
+	return "status_changed"
+}
+
+// parseTopic validates a subscription topic: branch:NAME, book:ID or patron:ID.
+func parseTopic(topic string) error {
+	kind, value, _ := strings.Cut(topic, ":")
+	switch kind {
+	case "branch":
+		if value != "" {
+			return nil
+		}
+	case "book":
+		if id, err := strconv.ParseInt(value, 10, 64); err == nil && id > 0 {
+			return nil
+		}
+	case "patron":
+		if value != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid topic %q, must be branch:NAME, book:ID or patron:ID", topic)
+}
+
+type wsClient struct {
+	topics map[string]bool
+	send   chan *wsMessage
+	// done is closed, with the close status set, when the client is dropped.
+	done   chan struct{}
+	code   websocket.StatusCode
+	reason string
+}
+
+// circulationHub fans copy status changes out to the WebSocket clients subscribed to them.
+type circulationHub struct {
+	mu      sync.Mutex
+	clients map[*wsClient]struct{}
+	closed  bool
+	// running counts the connections still open, so shutdown can wait for their close
+	// handshakes.
+	running sync.WaitGroup
+}
+
+func newCirculationHub() *circulationHub {
+	return &circulationHub{clients: map[*wsClient]struct{}{}}
+}
+
+func (h *circulationHub) register() (*wsClient, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, false
+	}
+	c := &wsClient{topics: map[string]bool{}, send: make(chan *wsMessage, wsSendBuffer), done: make(chan struct{})}
+	h.clients[c] = struct{}{}
+	h.running.Add(1)
+	return c, true
+}
+
+func (h *circulationHub) unregister(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.drop(c, websocket.StatusNormalClosure, "")
+	h.running.Done()
+}
+
+// drop removes a client, telling its writer to close the connection. The caller holds the
+// lock.
+func (h *circulationHub) drop(c *wsClient, code websocket.StatusCode, reason string) {
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		c.code, c.reason = code, reason
+		close(c.done)
+	}
+}
+
+// deliver queues m for c, dropping clients that do not keep up. The caller holds the lock.
+func (h *circulationHub) deliver(c *wsClient, m *wsMessage) {
+	select {
+	case c.send <- m:
+	default:
+		h.drop(c, websocket.StatusTryAgainLater, "too far behind, reconnect")
+	}
+}
+
+func (h *circulationHub) reply(c *wsClient, m *wsMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; ok {
+		h.deliver(c, m)
+	}
+}
+
+func (h *circulationHub) subscribe(c *wsClient, topic string, on bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if on && !c.topics[topic] && len(c.topics) >= wsMaxTopics {
+		return fmt.Errorf("no more than %d topics can be subscribed to", wsMaxTopics)
+	}
+	if on {
+		c.topics[topic] = true
+	} else {
+		delete(c.topics, topic)
+	}
+	return nil
+}
+
+func (h *circulationHub) publish(change *models.CopyChange) {
+	now := time.Now().UTC()
+	topics := []string{"branch:" + change.Copy.Branch, fmt.Sprintf("book:%d", change.Copy.BookID)}
+	// A patron hears of the copies lent to or held for them, and of those they give back.
+	for _, patron := range []string{change.Patron, change.PreviousPatron} {
+		if patron != "" && !slices.Contains(topics, "patron:"+patron) {
+			topics = append(topics, "patron:"+patron)
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		for _, topic := range topics {
+			if c.topics[topic] {
+				h.deliver(c, &wsMessage{Type: "event", Topic: topic, Event: circulationEvent(change), Copy: change.Copy, PreviousStatus: change.PreviousStatus, Patron: change.Patron, PreviousPatron: change.PreviousPatron, At: &now})
+				break
+			}
+		}
+	}
+}
+
+// shutdown closes every connection with 1001 Going Away and waits for them to finish, or
+// for ctx to be done.
+func (h *circulationHub) shutdown(ctx context.Context) {
+	h.mu.Lock()
+	h.closed = true
+	for c := range h.clients {
+		h.drop(c, websocket.StatusGoingAway, "server shutting down")
+	}
+	h.mu.Unlock()
+	finished := make(chan struct{})
+	go func() {
+		h.running.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+	}
+}
+
+func (app *application) staffToken(token string) bool {
+	for _, staff := range strings.Split(app.config.auth.staffTokens, ",") {
+		staff = strings.TrimSpace(staff)
+		if staff != "" && subtle.ConstantTimeCompare([]byte(staff), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// listenCopyChanges feeds the circulation hub from the database until ctx is cancelled.
+func (app *application) listenCopyChanges(ctx context.Context) {
+	app.keepListening(ctx, "copy", func(ctx context.Context) error {
+		return app.models.Copies.ListenChanges(ctx, app.circulation.publish)
+	})
+}
+
+// circulationSocket serves the circulation desk WebSocket. Clients authenticate with a staff
+// token, as a bearer token or in an auth message sent first, then subscribe to topics.
+func (app *application) circulationSocket(w http.ResponseWriter, r *http.Request) {
+	if strings.TrimSpace(app.config.auth.staffTokens) == "" {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+	var authenticated atomic.Bool
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if !app.staffToken(token) {
+			app.errorResponse(w, r, http.StatusUnauthorized, errCodeUnauthorized, "invalid authentication token")
+			return
+		}
+		authenticated.Store(true)
+	}
+	client, ok := app.circulation.register()
+	if !ok {
+		app.serviceUnavailableResponse(w, r, "the server is shutting down")
+		return
+	}
+	defer app.circulation.unregister(client)
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: app.config.originHosts()})
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(wsReadLimit)
+
+	// The request context is not cancelled once the connection is hijacked.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go app.writeSocket(ctx, cancel, conn, client)
+
+	authDeadline := time.AfterFunc(wsAuthTimeout, func() {
+		if !authenticated.Load() {
+			conn.Close(websocket.StatusPolicyViolation, "authentication timed out")
+		}
+	})
+	defer authDeadline.Stop()
+
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			app.circulation.reply(client, &wsMessage{Type: "error", Message: "messages must be JSON objects"})
+			continue
+		}
+		reply := &wsMessage{Type: "ok", ID: req.ID, Topic: req.Topic}
+		switch {
+		case req.Type == "auth":
+			if !app.staffToken(req.Token) {
+				conn.Close(websocket.StatusPolicyViolation, "invalid authentication token")
+				return
+			}
+			authenticated.Store(true)
+		case !authenticated.Load():
+			reply = &wsMessage{Type: "error", ID: req.ID, Message: "authenticate before subscribing"}
+		case req.Type == "subscribe" || req.Type == "unsubscribe":
+			err := parseTopic(req.Topic)
+			if err == nil {
+				err = app.circulation.subscribe(client, req.Topic, req.Type == "subscribe")
+			}
+			if err != nil {
+				reply = &wsMessage{Type: "error", ID: req.ID, Topic: req.Topic, Message: err.Error()}
+			}
+		default:
+			reply = &wsMessage{Type: "error", ID: req.ID, Message: fmt.Sprintf("unknown message type %q", req.Type)}
+		}
+		app.circulation.reply(client, reply)
+	}
+}
+
+// writeSocket sends the messages queued for client and pings it until ctx is done or the
+// client is dropped, when it closes the connection with the reason.
+func (app *application) writeSocket(ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn, client *wsClient) {
+	defer cancel()
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-client.done:
+			// Close waits a few seconds at most for the client to answer.
+			conn.Close(client.code, client.reason)
+			return
+		case m := <-client.send:
+			writeCtx, done := context.WithTimeout(ctx, wsWriteTimeout)
+			data, _ := json.Marshal(m)
+			err := conn.Write(writeCtx, websocket.MessageText, data)
+			done()
+			if err != nil {
+				return
+			}
+		case <-ping.C:
+			pingCtx, done := context.WithTimeout(ctx, wsWriteTimeout)
+			err := conn.Ping(pingCtx)
+			done()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
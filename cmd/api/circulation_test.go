@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/themilar/plibrary/internal/models"
+)
+
+func TestParseTopic(t *testing.T) {
+	for _, topic := range []string{"branch:Central", "book:12", "patron:P-1001"} {
+		if err := parseTopic(topic); err != nil {
+			t.Errorf("parseTopic(%q) = %v, want nil", topic, err)
+		}
+	}
+	for _, topic := range []string{"", "branch:", "book:0", "book:x", "patron:", "loan:1"} {
+		if err := parseTopic(topic); err == nil {
+			t.Errorf("parseTopic(%q) = nil, want an error", topic)
+		}
+	}
+}
+
+func TestCirculationEvent(t *testing.T) {
+	for _, test := range []struct {
+		previous, status string
+		event            string
+	}{
+		{"", "available", "copy_added"},
+		{"available", "on_loan", "checkout"},
+		{"on_loan", "available", "return"},
+		{"available", "on_hold", "hold_ready"},
+		{"on_hold", "on_hold", "patron_changed"},
+		{"available", "lost", "status_changed"},
+	} {
+		change := &models.CopyChange{Copy: &models.Copy{Status: test.status}, PreviousStatus: test.previous}
+		if got := circulationEvent(change); got != test.event {
+			t.Errorf("circulationEvent(%s -> %s) = %s, want %s", test.previous, test.status, got, test.event)
+		}
+	}
+}
+
+func TestCirculationHubPatronTopics(t *testing.T) {
+	hub := newCirculationHub()
+	subscribe := func(topics ...string) *wsClient {
+		c, _ := hub.register()
+		for _, topic := range topics {
+			if err := hub.subscribe(c, topic, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return c
+	}
+	borrower, returner, desk, other := subscribe("patron:P-1"), subscribe("patron:P-2"), subscribe("branch:Central", "patron:P-1"), subscribe("patron:P-3")
+
+	// A copy returned by P-2 and checked out to P-1 in the same change reaches both.
+	hub.publish(&models.CopyChange{
+		Copy:           &models.Copy{ID: 1, BookID: 1, Branch: "Central", Status: "on_loan", Patron: "P-1"},
+		PreviousStatus: "on_hold",
+		Patron:         "P-1",
+		PreviousPatron: "P-2",
+	})
+	for name, test := range map[string]struct {
+		client *wsClient
+		topic  string
+	}{
+		"borrower": {borrower, "patron:P-1"},
+		"returner": {returner, "patron:P-2"},
+		"desk":     {desk, "branch:Central"},
+	} {
+		select {
+		case m := <-test.client.send:
+			if m.Topic != test.topic || m.Patron != "P-1" || m.PreviousPatron != "P-2" || m.Event != "checkout" {
+				t.Errorf("%s received %+v, want a checkout on %s", name, m, test.topic)
+			}
+		default:
+			t.Errorf("%s received nothing", name)
+		}
+		if len(test.client.send) != 0 {
+			t.Errorf("%s received %d more messages, want one per change", name, len(test.client.send))
+		}
+	}
+	if len(other.send) != 0 {
+		t.Errorf("another patron received %d messages, want none", len(other.send))
+	}
+
+	// Patrons are kept out of the copy itself, as in the catalogue.
+	data, _ := json.Marshal(&wsMessage{Copy: &models.Copy{Patron: "P-1"}, Patron: "P-1"})
+	if strings.Count(string(data), "P-1") != 1 {
+		t.Errorf("message %s, want the patron only beside the copy", data)
+	}
+}
+
+func TestCirculationSocketOrigins(t *testing.T) {
+	var cfg config
+	cfg.auth.staffTokens = "desk-1"
+	cfg.cors.trustedOrigins = "https://desk.example.org, http://localhost:9000"
+	server := httptest.NewServer(newMemoryApplication(t, cfg).routes())
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/ws"
+
+	for _, test := range []struct {
+		origin string
+		ok     bool
+	}{
+		{"https://desk.example.org", true},
+		{"http://localhost:9000", true},
+		{"https://evil.example.com", false},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		header := http.Header{"Origin": {test.origin}, "Authorization": {"Bearer desk-1"}}
+		conn, resp, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{HTTPHeader: header})
+		cancel()
+		if (err == nil) != test.ok {
+			t.Errorf("dial from %s: error %v, want success %v", test.origin, err, test.ok)
+		}
+		if conn != nil {
+			conn.Close(websocket.StatusNormalClosure, "")
+		}
+		if !test.ok && resp != nil && resp.StatusCode != http.StatusForbidden {
+			t.Errorf("dial from %s: status %d, want 403", test.origin, resp.StatusCode)
+		}
+	}
+
+	// The same origins are allowed by CORS.
+	req := httptest.NewRequest(http.MethodGet, "/v1/books", nil)
+	req.Header.Set("Origin", "https://desk.example.org")
+	rr := httptest.NewRecorder()
+	newMemoryApplication(t, cfg).routes().ServeHTTP(rr, req)
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "https://desk.example.org" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want https://desk.example.org", got)
+	}
+}
+
+func TestCirculationHubTopics(t *testing.T) {
+	hub := newCirculationHub()
+	subscribe := func(topics ...string) *wsClient {
+		c, _ := hub.register()
+		for _, topic := range topics {
+			if err := hub.subscribe(c, topic, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return c
+	}
+	branch, book, both, other := subscribe("branch:Central"), subscribe("book:1"), subscribe("branch:Central", "book:1"), subscribe("branch:East")
+
+	hub.publish(&models.CopyChange{Copy: &models.Copy{ID: 1, BookID: 1, Branch: "Central", Status: "on_loan"}, PreviousStatus: "available"})
+	for name, test := range map[string]struct {
+		client *wsClient
+		topic  string
+	}{
+		"branch": {branch, "branch:Central"},
+		"book":   {book, "book:1"},
+		"both":   {both, "branch:Central"},
+	} {
+		select {
+		case m := <-test.client.send:
+			if m.Topic != test.topic || m.Event != "checkout" || m.Copy.ID != 1 {
+				t.Errorf("%s received %+v, want a checkout on %s", name, m, test.topic)
+			}
+		default:
+			t.Errorf("%s received nothing", name)
+		}
+		if len(test.client.send) != 0 {
+			t.Errorf("%s received %d more messages, want one per change", name, len(test.client.send))
+		}
+	}
+	if len(other.send) != 0 {
+		t.Errorf("another branch received %d messages, want none", len(other.send))
+	}
+}
+
+func TestCirculationSocket(t *testing.T) {
+	app := newTestApplication(t)
+	app.circulation = newCirculationHub()
+	server := httptest.NewServer(app.routes())
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/ws"
+
+	// Without staff tokens the endpoint does not exist.
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/ws", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("GET /v1/ws without staff tokens: status %d, want 404", rr.Code)
+	}
+
+	app.config.auth.staffTokens = "desk-1, desk-2"
+	app.config.cors.trustedOrigins = "http://localhost:9000"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dial := func(token string) (*websocket.Conn, *http.Response, error) {
+		header := http.Header{"Origin": {"http://localhost:9000"}}
+		if token != "" {
+			header.Set("Authorization", "Bearer "+token)
+		}
+		return websocket.Dial(ctx, wsURL, &websocket.DialOptions{HTTPHeader: header})
+	}
+
+	if _, resp, err := dial("desk-3"); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("dial with an unknown token: error %v, want 401", err)
+	}
+
+	conn, _, err := dial("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseNow()
+	exchange := func(req wsRequest) wsMessage {
+		t.Helper()
+		data, _ := json.Marshal(req)
+		if err := conn.Write(ctx, websocket.MessageText, data); err != nil {
+			t.Fatal(err)
+		}
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m wsMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	if m := exchange(wsRequest{Type: "subscribe", ID: "1", Topic: "book:1"}); m.Type != "error" || m.ID != "1" {
+		t.Errorf("subscribing before authenticating: %+v, want an error", m)
+	}
+	if m := exchange(wsRequest{Type: "auth", ID: "2", Token: "desk-2"}); m.Type != "ok" {
+		t.Errorf("auth: %+v, want ok", m)
+	}
+	if m := exchange(wsRequest{Type: "subscribe", ID: "3", Topic: "shelf:9"}); m.Type != "error" || m.Topic != "shelf:9" {
+		t.Errorf("subscribing to an unknown topic: %+v, want an error", m)
+	}
+	if m := exchange(wsRequest{Type: "subscribe", ID: "4", Topic: "book:1"}); m.Type != "ok" || m.ID != "4" {
+		t.Errorf("subscribing to book:1: %+v, want ok", m)
+	}
+
+	app.circulation.publish(&models.CopyChange{Copy: &models.Copy{ID: 9, BookID: 1, Branch: "Central", Status: "available"}, PreviousStatus: "on_loan"})
+	_, data, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m wsMessage
+	if err := json.Unmarshal(data, &m); err != nil || m.Type != "event" || m.Event != "return" || m.Topic != "book:1" {
+		t.Errorf("event %s, %v; want the return of copy 9 on book:1", data, err)
+	}
+}
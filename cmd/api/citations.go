@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/themilar/plibrary/internal/models"
+)
+
+// citationFormats maps the ?format= values and the media types accepted through the Accept
+// header to the Content-Type of the rendered citations. It is passed to negotiateContent for
+// the book routes.
+var citationFormats = map[string]string{
+	"bibtex":                              "application/x-bibtex",
+	"ris":                                 "application/x-research-info-systems",
+	"csl-json":                            "application/vnd.citationstyles.csl+json",
+	"application/x-bibtex":                "application/x-bibtex",
+	"application/x-research-info-systems": "application/x-research-info-systems",
+	"application/vnd.citationstyles.csl+json": "application/vnd.citationstyles.csl+json",
+}
+
+// citationFormat returns the citation media type negotiated for the request, or an empty
+// string when the client did not ask for citations.
+func (app *application) citationFormat(r *http.Request) string {
+	mediaType := app.responseFormat(r).mediaType
+	if _, ok := citationFormats[mediaType]; ok {
+		return mediaType
+	}
+	return ""
+}
+
+func (app *application) writeCitations(w http.ResponseWriter, r *http.Request, contentType string, books []*models.Book) error {
+	var body []byte
+	var err error
+	baseURL := requestBaseURL(r)
+	switch contentType {
+	case "application/x-bibtex":
+		body = []byte(bibtexCitations(books))
+	case "application/x-research-info-systems":
+		body = []byte(risCitations(books, baseURL))
+	default:
+		body, err = json.MarshalIndent(cslCitations(books, baseURL), "", "\t")
+		if err != nil {
+			return err
+		}
+		body = append(body, '\n')
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+	return nil
+}
+
+// splitAuthorName splits a personal name into family and given names. Both "Family, Given"
+// and "Given Family" are understood; single-word names are returned as the family name.
+func splitAuthorName(name string) (family, given string) {
+	name = strings.TrimSpace(name)
+	if family, given, ok := strings.Cut(name, ","); ok {
+		return strings.TrimSpace(family), strings.TrimSpace(given)
+	}
+	i := strings.LastIndex(name, " ")
+	if i < 0 {
+		return name, ""
+	}
+	return name[i+1:], strings.TrimSpace(name[:i])
+}
+
+func citationKey(book *models.Book) string {
+	return fmt.Sprintf("plibrary%d", book.ID)
+}
+
+var bibtexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`&`, `\&`,
+	`%`, `\%`,
+	`$`, `\$`,
+	`#`, `\#`,
+	`_`, `\_`,
+	`~`, `\textasciitilde{}`,
+	`^`, `\textasciicircum{}`,
+)
+
+func bibtexCitations(books []*models.Book) string {
+	var sb strings.Builder
+	for i, book := range books {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "@book{%s,\n", citationKey(book))
+		field := func(name, value string) {
+			if value != "" {
+				fmt.Fprintf(&sb, "  %s = {%s},\n", name, bibtexEscaper.Replace(value))
+			}
+		}
+		field("title", book.Title)
+		if len(book.Authors) > 0 {
+			// Family names are braced so that an "and" inside them is not read as a separator.
+			authors := make([]string, len(book.Authors))
+			for i, author := range book.Authors {
+				family, given := splitAuthorName(author)
+				authors[i] = "{" + bibtexEscaper.Replace(family) + "}"
+				if given != "" {
+					authors[i] += ", " + bibtexEscaper.Replace(given)
+				}
+			}
+			fmt.Fprintf(&sb, "  author = {%s},\n", strings.Join(authors, " and "))
+		}
+		field("publisher", book.Publisher)
+		field("year", strconv.Itoa(book.Published))
+		if book.Pages > 0 {
+			field("pagetotal", strconv.Itoa(book.Pages))
+		}
+		field("keywords", strings.Join(book.Genres, ", "))
+		sb.WriteString("}\n")
+	}
+	return sb.String()
+}
+
+func risCitations(books []*models.Book, baseURL string) string {
+	var sb strings.Builder
+	// RIS is line oriented, so line breaks inside values would start a new tag.
+	clean := strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ")
+	tag := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&sb, "%s  - %s\r\n", name, clean.Replace(value))
+		}
+	}
+	for _, book := range books {
+		tag("TY", "BOOK")
+		tag("ID", citationKey(book))
+		tag("TI", book.Title)
+		for _, author := range book.Authors {
+			family, given := splitAuthorName(author)
+			if given != "" {
+				family += ", " + given
+			}
+			tag("AU", family)
+		}
+		tag("PB", book.Publisher)
+		tag("PY", strconv.Itoa(book.Published))
+		if book.Pages > 0 {
+			tag("SP", strconv.Itoa(book.Pages))
+		}
+		for _, genre := range book.Genres {
+			tag("KW", genre)
+		}
+		tag("UR", fmt.Sprintf("%s/v1/books/%d", baseURL, book.ID))
+		sb.WriteString("ER  - \r\n")
+	}
+	return sb.String()
+}
+
+type cslName struct {
+	Family  string `json:"family,omitempty"`
+	Given   string `json:"given,omitempty"`
+	Literal string `json:"literal,omitempty"`
+}
+
+type cslDate struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+type cslItem struct {
+	ID            string    `json:"id"`
+	Type          string    `json:"type"`
+	Title         string    `json:"title"`
+	Author        []cslName `json:"author,omitempty"`
+	Publisher     string    `json:"publisher,omitempty"`
+	Issued        cslDate   `json:"issued"`
+	NumberOfPages string    `json:"number-of-pages,omitempty"`
+	Keyword       string    `json:"keyword,omitempty"`
+	URL           string    `json:"URL"`
+}
+
+func cslCitations(books []*models.Book, baseURL string) []cslItem {
+	items := make([]cslItem, 0, len(books))
+	for _, book := range books {
+		item := cslItem{
+			ID:        citationKey(book),
+			Type:      "book",
+			Title:     book.Title,
+			Publisher: book.Publisher,
+			Issued:    cslDate{DateParts: [][]int{{book.Published}}},
+			Keyword:   strings.Join(book.Genres, ", "),
+			URL:       fmt.Sprintf("%s/v1/books/%d", baseURL, book.ID),
+		}
+		if book.Pages > 0 {
+			item.NumberOfPages = strconv.Itoa(book.Pages)
+		}
+		for _, author := range book.Authors {
+			family, given := splitAuthorName(author)
+			if given == "" {
+				item.Author = append(item.Author, cslName{Literal: family})
+			} else {
+				item.Author = append(item.Author, cslName{Family: family, Given: given})
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
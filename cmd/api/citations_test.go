@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/themilar/plibrary/internal/models"
+)
+
+func TestSplitAuthorName(t *testing.T) {
+	for _, test := range []struct {
+		name, family, given string
+	}{
+		{"Frank Herbert", "Herbert", "Frank"},
+		{"J. R. R. Tolkien", "Tolkien", "J. R. R."},
+		{"Le Guin, Ursula K.", "Le Guin", "Ursula K."},
+		{" Homer ", "Homer", ""},
+	} {
+		family, given := splitAuthorName(test.name)
+		if family != test.family || given != test.given {
+			t.Errorf("splitAuthorName(%q) = %q, %q; want %q, %q", test.name, family, given, test.family, test.given)
+		}
+	}
+}
+
+var citedBook = &models.Book{
+	ID:        7,
+	Title:     "Profit & Loss_100%",
+	Authors:   []string{"Marks and Spencer, Ann", "Homer"},
+	Publisher: "Line\nBreak Press",
+	Published: 1999,
+	Pages:     320,
+	Genres:    []string{"business", "poetry"},
+}
+
+func TestBibtexCitations(t *testing.T) {
+	want := `@book{plibrary7,
+  title = {Profit \& Loss\_100\%},
+  author = {{Marks and Spencer}, Ann and {Homer}},
+  publisher = {Line
+Break Press},
+  year = {1999},
+  pagetotal = {320},
+  keywords = {business, poetry},
+}
+`
+	if got := bibtexCitations([]*models.Book{citedBook}); got != want {
+		t.Errorf("bibtexCitations =\n%s\nwant\n%s", got, want)
+	}
+	if got := bibtexCitations([]*models.Book{{ID: 1, Title: "A"}, {ID: 2, Title: "B"}}); !strings.Contains(got, "}\n\n@book{plibrary2,") {
+		t.Errorf("bibtexCitations does not separate entries with a blank line:\n%s", got)
+	}
+}
+
+func TestRisCitations(t *testing.T) {
+	want := strings.Join([]string{
+		"TY  - BOOK",
+		"ID  - plibrary7",
+		"TI  - Profit & Loss_100%",
+		"AU  - Marks and Spencer, Ann",
+		"AU  - Homer",
+		"PB  - Line Break Press",
+		"PY  - 1999",
+		"SP  - 320",
+		"KW  - business",
+		"KW  - poetry",
+		"UR  - https://library.example.org/v1/books/7",
+		"ER  - ",
+		"",
+	}, "\r\n")
+	if got := risCitations([]*models.Book{citedBook}, "https://library.example.org"); got != want {
+		t.Errorf("risCitations =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestCslCitations(t *testing.T) {
+	items := cslCitations([]*models.Book{citedBook, {ID: 8, Title: "Untitled", Published: 2001}}, "https://library.example.org")
+	got, err := json.Marshal(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"id":"plibrary7","type":"book","title":"Profit \u0026 Loss_100%",` +
+		`"author":[{"family":"Marks and Spencer","given":"Ann"},{"literal":"Homer"}],` +
+		`"publisher":"Line\nBreak Press","issued":{"date-parts":[[1999]]},"number-of-pages":"320",` +
+		`"keyword":"business, poetry","URL":"https://library.example.org/v1/books/7"},` +
+		`{"id":"plibrary8","type":"book","title":"Untitled","issued":{"date-parts":[[2001]]},` +
+		`"URL":"https://library.example.org/v1/books/8"}]`
+	if string(got) != want {
+		t.Errorf("cslCitations =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCitationResponses(t *testing.T) {
+	handler := newMemoryApplication(t, config{}).routes()
+
+	for _, test := range []struct {
+		target, accept, contentType, contains string
+	}{
+		{"/v1/books/1?format=bibtex", "", "application/x-bibtex; charset=utf-8", "@book{plibrary1,\n  title = {Dune},"},
+		{"/v1/books/1", "application/x-research-info-systems", "application/x-research-info-systems; charset=utf-8", "TI  - Dune\r\n"},
+		{"/v1/books?genres=sci-fi&format=csl-json", "", "application/vnd.citationstyles.csl+json; charset=utf-8", `"id": "plibrary2"`},
+		// Citations need the whole record, so a sparse fieldset is ignored.
+		{"/v1/books/3?format=ris&fields=title", "", "application/x-research-info-systems; charset=utf-8", "AU  - Tolkien, J. R. R.\r\n"},
+		{"/v1/books/3?fields=title", "", "application/json", `"title": "The Hobbit"`},
+	} {
+		req := httptest.NewRequest(http.MethodGet, test.target, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK && rr.Code != http.StatusAccepted {
+			t.Errorf("GET %s: status %d; body %s", test.target, rr.Code, rr.Body)
+			continue
+		}
+		if got := rr.Header().Get("Content-Type"); got != test.contentType {
+			t.Errorf("GET %s: Content-Type %q, want %q", test.target, got, test.contentType)
+		}
+		if !strings.Contains(rr.Body.String(), test.contains) {
+			t.Errorf("GET %s: body %q does not contain %q", test.target, rr.Body, test.contains)
+		}
+	}
+}
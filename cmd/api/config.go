@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/themilar/plibrary/internal/logging"
+	"github.com/themilar/plibrary/internal/settings"
+)
+
+type config struct {
+	port        int
+	env         string
+	problemJSON bool
+	db          struct {
+		dsn            string
+		checkSchema    bool
+		connectTimeout time.Duration
+		queryTimeout   time.Duration
+	}
+	limiter struct {
+		enabled bool
+		rpm     int
+	}
+	oai struct {
+		adminEmail string
+	}
+	openapi struct {
+		validate bool
+	}
+	grpc struct {
+		port int
+	}
+	graphql struct {
+		maxDepth      int
+		maxComplexity int
+	}
+	webhooks struct {
+		pollInterval time.Duration
+		// allowPrivateTargets lets webhooks reach the addresses isPublicAddr rejects.
+		allowPrivateTargets bool
+	}
+	outbox struct {
+		sinks        string
+		pollInterval time.Duration
+	}
+	auth struct {
+		staffTokens string
+	}
+	cors struct {
+		trustedOrigins string
+	}
+	tracing struct {
+		exporter    string
+		sampleRatio float64
+	}
+	log struct {
+		level  string
+		format string
+	}
+	// effective holds every setting by name once the configuration is loaded.
+	effective map[string]settings.Setting
+}
+
+// configFlags registers a flag for every setting of cfg, with its default.
+func configFlags(fs *flag.FlagSet, cfg *config) {
+	fs.IntVar(&cfg.port, "port", 4000, "API server port")
+	fs.IntVar(&cfg.grpc.port, "grpc-port", 4001, "gRPC server port (0 to disable)")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+	fs.DurationVar(&cfg.db.connectTimeout, "db-connect-timeout", 30*time.Second, "How long to keep retrying to reach the database at startup before giving up")
+	fs.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", 5*time.Second, "Longest a book query may run before it is abandoned (0 for no limit)")
+	fs.BoolVar(&cfg.db.checkSchema, "db-check-schema", true, "Refuse to start unless the database schema is up to date")
+	fs.BoolVar(&cfg.limiter.enabled, "limitenabled", true, "Enable rate limiter")
+	fs.IntVar(&cfg.limiter.rpm, "limitrpm", 50, "rate limiter maximum requests per minute")
+	fs.BoolVar(&cfg.problemJSON, "problem-json", false, "Always render errors as application/problem+json")
+	fs.StringVar(&cfg.oai.adminEmail, "oai-admin-email", "admin@localhost", "OAI-PMH repository administrator email")
+	fs.BoolVar(&cfg.openapi.validate, "openapi-validate", false, "Validate requests and responses against the OpenAPI document (development and testing only)")
+	fs.IntVar(&cfg.graphql.maxDepth, "graphql-max-depth", 8, "Maximum nesting depth of GraphQL queries (0 for no limit)")
+	fs.IntVar(&cfg.graphql.maxComplexity, "graphql-max-complexity", 1000, "Maximum number of books, authors, copies and genres a GraphQL request may resolve (0 for no limit)")
+	fs.DurationVar(&cfg.webhooks.pollInterval, "webhook-poll-interval", 5*time.Second, "How often to look for due webhook deliveries (0 to disable delivery)")
+	fs.BoolVar(&cfg.webhooks.allowPrivateTargets, "webhook-allow-private-targets", false, "Let webhooks target private, loopback and link-local addresses (development and testing only)")
+	fs.StringVar(&cfg.outbox.sinks, "outbox-sinks", "webhooks", "Comma separated sinks for change events: webhooks, stdout, file:PATH, http:URL")
+	fs.DurationVar(&cfg.outbox.pollInterval, "outbox-poll-interval", time.Second, "How often to relay recorded change events (0 to disable the relay)")
+	fs.StringVar(&cfg.auth.staffTokens, "staff-tokens", "", "Comma separated tokens accepted from circulation desk staff (none disables /v1/ws, /v1/admin/config and /v1/webhooks)")
+	fs.StringVar(&cfg.cors.trustedOrigins, "cors-trusted-origins", "http://localhost:9000", "Comma separated origins browsers may call the API and open /v1/ws from (* for any)")
+	fs.StringVar(&cfg.tracing.exporter, "trace-exporter", "none", "Export traces: none, stdout or otlp (configured with the OTEL_EXPORTER_OTLP_* variables)")
+	fs.Float64Var(&cfg.tracing.sampleRatio, "trace-sample-ratio", 1, "Fraction of new traces to sample; traces continued from clients follow their sampling decision")
+	fs.StringVar(&cfg.log.level, "log-level", "info", "Minimum level of the messages logged (debug|info|warn|error)")
+	fs.StringVar(&cfg.log.format, "log-format", "json", "Format of the logs (json|text)")
+}
+
+// loadConfig registers the settings as flags of fs and loads them with settings.Loader, from
+// their defaults, the configuration file, the environment and args. Flags registered on fs
+// beforehand are only read from the command line.
+func loadConfig(fs *flag.FlagSet, args []string, getenv func(string) string) (config, error) {
+	var cfg config
+	loader := settings.Loader{Register: func(fs *flag.FlagSet) { configFlags(fs, &cfg) }}
+	effective, err := loader.Load(fs, args, getenv)
+	cfg.effective = effective
+	return cfg, err
+}
+
+// validate reports every invalid setting of cfg at once.
+func (cfg config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(slices.Contains([]string{"development", "staging", "production"}, cfg.env),
+		"env: %q must be development, staging or production", cfg.env)
+	check(cfg.port > 0 && cfg.port < 65536, "port: %d must be between 1 and 65535", cfg.port)
+	check(cfg.grpc.port >= 0 && cfg.grpc.port < 65536, "grpc-port: %d must be between 0 and 65535", cfg.grpc.port)
+	check(cfg.grpc.port != cfg.port, "grpc-port: %d is already the port of the API server", cfg.grpc.port)
+	check(cfg.db.dsn != "", "db-dsn: must be provided")
+	check(cfg.db.connectTimeout > 0, "db-connect-timeout: %s must be positive", cfg.db.connectTimeout)
+	check(cfg.db.queryTimeout >= 0, "db-query-timeout: %s must not be negative", cfg.db.queryTimeout)
+	check(!cfg.limiter.enabled || cfg.limiter.rpm > 0, "limitrpm: %d must be positive when the rate limiter is enabled", cfg.limiter.rpm)
+	_, err := mail.ParseAddress(cfg.oai.adminEmail)
+	check(err == nil, "oai-admin-email: %q must be an email address", cfg.oai.adminEmail)
+	check(cfg.graphql.maxDepth >= 0, "graphql-max-depth: %d must not be negative", cfg.graphql.maxDepth)
+	check(cfg.graphql.maxComplexity >= 0, "graphql-max-complexity: %d must not be negative", cfg.graphql.maxComplexity)
+	check(cfg.webhooks.pollInterval >= 0, "webhook-poll-interval: %s must not be negative", cfg.webhooks.pollInterval)
+	check(cfg.outbox.pollInterval >= 0, "outbox-poll-interval: %s must not be negative", cfg.outbox.pollInterval)
+	for _, origin := range cfg.trustedOrigins() {
+		u, err := url.Parse(origin)
+		check(origin == "*" || err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.Path == "" && u.User == nil,
+			"cors-trusted-origins: %q must be * or an http or https origin, as in https://catalogue.example.org", origin)
+	}
+	check(slices.Contains([]string{"none", "stdout", "otlp"}, cfg.tracing.exporter),
+		"trace-exporter: %q must be none, stdout or otlp", cfg.tracing.exporter)
+	check(cfg.tracing.sampleRatio >= 0 && cfg.tracing.sampleRatio <= 1, "trace-sample-ratio: %g must be between 0 and 1", cfg.tracing.sampleRatio)
+	if _, err := logging.New(io.Discard, cfg.log.level, cfg.log.format); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// trustedOrigins lists the origins of cors-trusted-origins.
+func (cfg config) trustedOrigins() []string {
+	var origins []string
+	for _, origin := range strings.Split(cfg.cors.trustedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// originHosts returns the hosts of the trusted origins, which is what WebSocket origins are
+// checked against.
+func (cfg config) originHosts() []string {
+	var hosts []string
+	for _, origin := range cfg.trustedOrigins() {
+		if u, err := url.Parse(origin); err == nil && u.Host != "" {
+			hosts = append(hosts, u.Host)
+		} else if origin == "*" {
+			hosts = append(hosts, origin)
+		}
+	}
+	return hosts
+}
+
+// configHandler shows the effective configuration to staff, with the source of every setting
+// and credentials redacted.
+func (app *application) configHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"config": app.config.effective}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
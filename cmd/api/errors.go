@@ -1,43 +1,168 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/themilar/plibrary/internal/logging"
+	"github.com/themilar/plibrary/internal/models"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const problemContentType = "application/problem+json"
+
+// statusClientClosedRequest is the non-standard status, borrowed from nginx, recorded for
+// requests abandoned because the client went away.
+const statusClientClosedRequest = 499
+
+// Machine readable error codes, reported as "code" in problem+json responses.
+const (
+	errCodeServerError      = "server_error"
+	errCodeNotFound         = "not_found"
+	errCodeMethodNotAllowed = "method_not_allowed"
+	errCodeBadRequest       = "bad_request"
+	errCodeValidationFailed = "validation_failed"
+	errCodeEditConflict     = "edit_conflict"
+	errCodeRateLimited      = "rate_limited"
+	errCodeNotAcceptable    = "not_acceptable"
+	errCodeUnavailable      = "service_unavailable"
+	errCodeUnauthorized     = "unauthorized"
+	errCodeQueryTooComplex  = "query_too_complex"
+)
+
+type invalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// problem is an RFC 7807 problem details object.
+type problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	Code          string         `json:"code"`
+	InvalidParams []invalidParam `json:"invalid_params,omitempty"`
+}
+
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.Error(err.Error(), "request_method", r.Method, "request_url", r.URL.String())
+	span := trace.SpanFromContext(r.Context())
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	logging.FromContext(r.Context()).Error(err.Error(), "request_method", r.Method, "request_url", r.URL.String())
+}
+
+// wantsProblem reports whether errors for r should be rendered as problem+json, either
+// because the client accepts it or because it is enabled for every request.
+func (app *application) wantsProblem(r *http.Request) bool {
+	return app.config.problemJSON || strings.Contains(r.Header.Get("Accept"), problemContentType)
 }
 
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
-	env := envelope{"error": message}
-	err := app.writeJson(w, status, env, nil)
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, message interface{}) {
+	var err error
+	if app.wantsProblem(r) {
+		err = app.writeProblem(w, r, status, code, message)
+	} else {
+		env := envelope{"error": message}
+		err = app.writeResponse(w, r, status, env, nil)
+	}
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
 	}
 }
+
+func (app *application) writeProblem(w http.ResponseWriter, r *http.Request, status int, code string, message interface{}) error {
+	p := problem{
+		Type:     "urn:plibrary:problem:" + code,
+		Title:    http.StatusText(status),
+		Status:   status,
+		Instance: r.URL.RequestURI(),
+		Code:     code,
+	}
+	switch m := message.(type) {
+	case string:
+		p.Detail = m
+	case map[string]string:
+		p.Detail = "one or more parameters failed validation"
+		for name, reason := range m {
+			p.InvalidParams = append(p.InvalidParams, invalidParam{Name: name, Reason: reason})
+		}
+		sort.Slice(p.InvalidParams, func(i, j int) bool { return p.InvalidParams[i].Name < p.InvalidParams[j].Name })
+	default:
+		p.Detail = fmt.Sprint(m)
+	}
+	resp, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return err
+	}
+	resp = append(resp, '\n')
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(status)
+	w.Write(resp)
+	return nil
+}
+
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, models.ErrCanceled) || errors.Is(err, models.ErrTimeout) {
+		app.abandonedResponse(w, r, err)
+		return
+	}
 	app.logError(r, err)
 	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, errCodeServerError, message)
 }
 func (app *application) notFoundErrorResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, errCodeNotFound, message)
 
 }
 func (app *application) methodNotAllowedErrorResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, message)
 }
 func (app *application) badRequestErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error())
 }
 func (app *application) failedValidationErrorResponse(w http.ResponseWriter, r *http.Request, err map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, err)
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, errCodeValidationFailed, err)
 }
 func (app *application) editConflictErrorResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to complete the update due to a conflict, try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	app.errorResponse(w, r, http.StatusConflict, errCodeEditConflict, message)
+}
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+	app.metrics.rateLimited.Inc()
+	message := "rate limit exceeded"
+	app.errorResponse(w, r, http.StatusTooManyRequests, errCodeRateLimited, message)
+}
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request, available []string) {
+	message := fmt.Sprintf("the requested representation is not available, supported media types: %s", strings.Join(available, ", "))
+	app.errorResponse(w, r, http.StatusNotAcceptable, errCodeNotAcceptable, message)
+}
+
+// abandonedResponse answers a request whose queries were abandoned. A query running out of
+// time or the server shutting down is reported as 503 Service Unavailable. When the client
+// went away nobody reads the response, so only a 499 status is recorded for the access log
+// and metrics.
+func (app *application) abandonedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, models.ErrTimeout):
+		app.logError(r, err)
+		app.serviceUnavailableResponse(w, r, "the request took too long to process, try again later")
+	case errors.Is(context.Cause(r.Context()), errServerShutdown):
+		app.serviceUnavailableResponse(w, r, errServerShutdown.Error())
+	default:
+		w.WriteHeader(statusClientClosedRequest)
+	}
+}
+func (app *application) serviceUnavailableResponse(w http.ResponseWriter, r *http.Request, message string) {
+	app.errorResponse(w, r, http.StatusServiceUnavailable, errCodeUnavailable, message)
 }
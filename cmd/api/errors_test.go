@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/themilar/plibrary/internal/models"
+)
+
+func TestProblemResponses(t *testing.T) {
+	handler := newMemoryApplication(t, config{}).routes()
+
+	for _, test := range []struct {
+		method, target string
+		status         int
+		code, detail   string
+		invalid        []invalidParam
+	}{
+		{http.MethodGet, "/v1/books/99", http.StatusNotFound, errCodeNotFound, "the requested resource could not be found", nil},
+		{http.MethodPut, "/v1/health/live", http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "the PUT method is not supported for this resource", nil},
+		{http.MethodGet, "/v1/books?format=yaml", http.StatusBadRequest, errCodeBadRequest, `unsupported format "yaml"`, nil},
+		{http.MethodGet, "/v1/books?page=0&size=0", http.StatusUnprocessableEntity, errCodeValidationFailed, "one or more parameters failed validation", []invalidParam{
+			{Name: "page", Reason: "value must be greater than: 1"},
+			{Name: "size", Reason: "value must be greater than: 1"},
+		}},
+	} {
+		req := httptest.NewRequest(test.method, test.target, nil)
+		req.Header.Set("Accept", problemContentType)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != test.status || rr.Header().Get("Content-Type") != problemContentType {
+			t.Errorf("%s %s: status %d, Content-Type %q; want %d, %s", test.method, test.target, rr.Code, rr.Header().Get("Content-Type"), test.status, problemContentType)
+			continue
+		}
+		var p problem
+		if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		want := problem{
+			Type:          "urn:plibrary:problem:" + test.code,
+			Title:         http.StatusText(test.status),
+			Status:        test.status,
+			Detail:        test.detail,
+			Instance:      test.target,
+			Code:          test.code,
+			InvalidParams: test.invalid,
+		}
+		if fmt.Sprint(p) != fmt.Sprint(want) {
+			t.Errorf("%s %s: problem %+v, want %+v", test.method, test.target, p, want)
+		}
+	}
+}
+
+func TestWantsProblem(t *testing.T) {
+	for _, test := range []struct {
+		problemJSON bool
+		accept      string
+		contentType string
+	}{
+		{false, "", "application/json"},
+		{false, "application/json", "application/json"},
+		{false, "application/json, application/problem+json", problemContentType},
+		{true, "", problemContentType},
+		{true, "application/xml", problemContentType},
+	} {
+		var cfg config
+		cfg.problemJSON = test.problemJSON
+		req := httptest.NewRequest(http.MethodGet, "/v1/books/99", nil)
+		req.Header.Set("Accept", test.accept)
+		rr := httptest.NewRecorder()
+		newMemoryApplication(t, cfg).routes().ServeHTTP(rr, req)
+		if rr.Code != http.StatusNotFound || rr.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("problem-json %t, Accept %q: status %d, Content-Type %q; want 404, %s",
+				test.problemJSON, test.accept, rr.Code, rr.Header().Get("Content-Type"), test.contentType)
+		}
+	}
+}
+
+func TestServerErrorResponse(t *testing.T) {
+	app := newMemoryApplication(t, config{})
+	shutdown, cancel := context.WithCancelCause(context.Background())
+	cancel(errServerShutdown)
+
+	for _, test := range []struct {
+		name   string
+		ctx    context.Context
+		err    error
+		status int
+		code   string
+	}{
+		{"failure", context.Background(), errors.New("disk on fire"), http.StatusInternalServerError, errCodeServerError},
+		{"timeout", context.Background(), fmt.Errorf("listing books: %w", models.ErrTimeout), http.StatusServiceUnavailable, errCodeUnavailable},
+		{"shutdown", shutdown, models.ErrCanceled, http.StatusServiceUnavailable, errCodeUnavailable},
+		{"client gone", context.Background(), models.ErrCanceled, statusClientClosedRequest, ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/books", nil).WithContext(test.ctx)
+		req.Header.Set("Accept", problemContentType)
+		rr := httptest.NewRecorder()
+		app.serverErrorResponse(rr, req, test.err)
+		if rr.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, rr.Code, test.status)
+		}
+		var p problem
+		if test.code != "" {
+			if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+				t.Fatalf("%s: %v; body %s", test.name, err, rr.Body)
+			}
+		}
+		if p.Code != test.code {
+			t.Errorf("%s: code %q, want %q", test.name, p.Code, test.code)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/themilar/plibrary/internal/models"
+)
+
+// bookIncludes are the relations that can be embedded in book responses with ?include=.
+// Authors are stored on the book itself, so including them only adds the field to a sparse
+// fieldset; copies are loaded for the whole page in one query.
+var bookIncludes = []string{"authors", "copies"}
+
+// bookQuery holds the sparse fieldset and embedded relations requested for book responses.
+type bookQuery struct {
+	fields        []string
+	includeCopies bool
+}
+
+func (app *application) readBookQuery(qs url.Values) (bookQuery, map[string]string) {
+	var q bookQuery
+	errs := map[string]string{}
+	for _, field := range app.readCSV(qs, "fields", nil) {
+		field = strings.TrimSpace(field)
+		switch {
+		case field == "":
+		case !slices.Contains(models.BookFields, field):
+			errs["fields"] = fmt.Sprintf("unknown field %q, must be one of: %s", field, strings.Join(models.BookFields, ", "))
+		case !slices.Contains(q.fields, field):
+			q.fields = append(q.fields, field)
+		}
+	}
+	for _, include := range app.readCSV(qs, "include", nil) {
+		switch strings.TrimSpace(include) {
+		case "":
+		case "authors":
+			if len(q.fields) > 0 && !slices.Contains(q.fields, "authors") {
+				q.fields = append(q.fields, "authors")
+			}
+		case "copies":
+			q.includeCopies = true
+		default:
+			errs["include"] = fmt.Sprintf("unknown relation %q, must be one of: %s", include, strings.Join(bookIncludes, ", "))
+		}
+	}
+	return q, errs
+}
+
+// bookQuery reads ?fields= and ?include= for a book endpoint, writing a validation error
+// response when they are invalid. Citations need the whole record, so both are ignored when
+// a citation format was negotiated.
+func (app *application) bookQuery(w http.ResponseWriter, r *http.Request) (bookQuery, bool) {
+	if app.citationFormat(r) != "" {
+		return bookQuery{}, true
+	}
+	q, errs := app.readBookQuery(r.URL.Query())
+	if len(errs) > 0 {
+		app.failedValidationErrorResponse(w, r, errs)
+		return q, false
+	}
+	return q, true
+}
+
+// bookResource renders a book restricted to the requested fields, followed by any embedded
+// relations.
+type bookResource struct {
+	book          *models.Book
+	fields        []string
+	includeCopies bool
+	copies        []*models.Copy
+}
+
+func (res bookResource) MarshalJSON() ([]byte, error) {
+	raw, err := json.Marshal(res.book)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, err
+	}
+	fields := res.fields
+	if len(fields) == 0 {
+		fields = models.BookFields
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	write := func(key string, value json.RawMessage) {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	for _, field := range fields {
+		// Fields dropped by omitempty stay omitted, as in the full representation.
+		if value, ok := values[field]; ok {
+			write(field, value)
+		}
+	}
+	if res.includeCopies {
+		copies := res.copies
+		if copies == nil {
+			copies = []*models.Copy{}
+		}
+		value, err := json.Marshal(copies)
+		if err != nil {
+			return nil, err
+		}
+		write("copies", value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// bookResources wraps books for rendering, loading the copies of every book in a single query
+// when they were requested.
+func (app *application) bookResources(ctx context.Context, books []*models.Book, q bookQuery) ([]bookResource, error) {
+	var copies map[int64][]*models.Copy
+	if q.includeCopies {
+		ids := make([]int64, len(books))
+		for i, book := range books {
+			ids[i] = book.ID
+		}
+		var err error
+		copies, err = app.models.Copies.ForBooks(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+	resources := make([]bookResource, len(books))
+	for i, book := range books {
+		resources[i] = bookResource{book: book, fields: q.fields, includeCopies: q.includeCopies, copies: copies[book.ID]}
+	}
+	return resources, nil
+}
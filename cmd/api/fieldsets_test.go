@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/themilar/plibrary/internal/models"
+)
+
+func TestReadBookQuery(t *testing.T) {
+	app := &application{}
+	for _, test := range []struct {
+		query         string
+		fields        string
+		includeCopies bool
+		errs          []string
+	}{
+		{"", "", false, nil},
+		{"fields=title,id", "title id", false, nil},
+		{"fields=title,,title, pages", "title pages", false, nil},
+		{"include=copies", "", true, nil},
+		// Authors only need adding when the fields are restricted.
+		{"include=authors", "", false, nil},
+		{"fields=title&include=authors,copies", "title authors", true, nil},
+		{"fields=title,authors&include=authors", "title authors", false, nil},
+		{"fields=isbn", "", false, []string{"fields"}},
+		{"include=loans", "", false, []string{"include"}},
+		{"fields=title,isbn&include=reviews", "title", false, []string{"fields", "include"}},
+	} {
+		qs, _ := url.ParseQuery(test.query)
+		q, errs := app.readBookQuery(qs)
+		if got := strings.Join(q.fields, " "); got != test.fields || q.includeCopies != test.includeCopies {
+			t.Errorf("readBookQuery(%s) = fields %q, copies %t; want %q, %t", test.query, got, q.includeCopies, test.fields, test.includeCopies)
+		}
+		if len(errs) != len(test.errs) {
+			t.Errorf("readBookQuery(%s) errors = %v, want errors for %v", test.query, errs, test.errs)
+		}
+		for _, key := range test.errs {
+			if errs[key] == "" {
+				t.Errorf("readBookQuery(%s) has no %s error", test.query, key)
+			}
+		}
+	}
+}
+
+func TestBookResourceJSON(t *testing.T) {
+	book := &models.Book{ID: 1, Title: "Dune", Authors: []string{"Frank Herbert"}, Published: 1965, Pages: 412, Genres: []string{"sci-fi"}, Version: 2}
+	copies := []*models.Copy{{ID: 10, BookID: 1, Barcode: "B-1", Branch: "main", Status: "available", Patron: "p-7", Version: 1}}
+
+	for _, test := range []struct {
+		name string
+		res  bookResource
+		want string
+	}{
+		{"full", bookResource{book: book}, `{"id":1,"title":"Dune","authors":["Frank Herbert"],"published":1965,"pages":"412","genres":["sci-fi"],"version":2}`},
+		{"sparse", bookResource{book: book, fields: []string{"title", "id"}}, `{"title":"Dune","id":1}`},
+		{"omitted", bookResource{book: book, fields: []string{"publisher"}}, `{}`},
+		{"copies", bookResource{book: book, fields: []string{"id"}, includeCopies: true, copies: copies}, `{"id":1,"copies":[{"id":10,"book_id":1,"barcode":"B-1","branch":"main","status":"available","version":1}]}`},
+		{"no copies", bookResource{book: book, fields: []string{"id"}, includeCopies: true}, `{"id":1,"copies":[]}`},
+		{"only copies", bookResource{book: &models.Book{}, fields: []string{"publisher"}, includeCopies: true}, `{"copies":[]}`},
+	} {
+		got, err := json.Marshal(test.res)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSparseFieldsets(t *testing.T) {
+	handler := newMemoryApplication(t, config{}).routes()
+
+	for _, test := range []struct {
+		target string
+		status int
+		want   string
+	}{
+		{"/v1/books/1?fields=title,published&compact=true", http.StatusAccepted, `{"book":{"title":"Dune","published":1965}}`},
+		{"/v1/books?genres=fantasy&fields=id&compact=true", http.StatusOK, `{"books":[{"id":3}],`},
+		{"/v1/books/search?q=dune&fields=title&compact=true", http.StatusOK, `{"books":[{"title":"Dune"},{"title":"Children Of Dune"}]`},
+		{"/v1/books/1?fields=isbn&compact=true", http.StatusUnprocessableEntity, `{"error":{"fields":"unknown field \"isbn\"`},
+		{"/v1/books/1?include=loans&compact=true", http.StatusUnprocessableEntity, `{"error":{"include":"unknown relation \"loans\"`},
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, test.target, nil))
+		if rr.Code != test.status || !strings.HasPrefix(rr.Body.String(), test.want) {
+			t.Errorf("GET %s: status %d, body %s; want %d and a body starting with %s", test.target, rr.Code, rr.Body, test.status, test.want)
+		}
+	}
+}
+
+func TestSparseFieldsetErrors(t *testing.T) {
+	handler := newTestApplication(t).routes()
+
+	for _, test := range []struct {
+		target string
+		want   string
+	}{
+		{"/v1/books/1?fields=isbn&compact=true", `{"error":{"fields":"unknown field \"isbn\"`},
+		{"/v1/books/1?include=loans&compact=true", `{"error":{"include":"unknown relation \"loans\"`},
+		{"/v1/books?fields=title,isbn&compact=true", `{"error":{"fields":"unknown field \"isbn\"`},
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, test.target, nil))
+		if rr.Code != http.StatusUnprocessableEntity || !strings.HasPrefix(rr.Body.String(), test.want) {
+			t.Errorf("GET %s: status %d, body %s; want 422 and a body starting with %s", test.target, rr.Code, rr.Body, test.want)
+		}
+	}
+}
@@ -0,0 +1,731 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/themilar/plibrary/internal"
+	"github.com/themilar/plibrary/internal/logging"
+	"github.com/themilar/plibrary/internal/models"
+)
+
+// graphqlSDL is the schema of the catalogue; %s is replaced by the values of BookSort.
+const graphqlSDL = `schema {
+	query: Query
+	mutation: Mutation
+}
+
+"A physical copy of a book held at a branch."
+type Copy {
+	id: ID!
+	barcode: String!
+	branch: String!
+	status: CopyStatus!
+	version: Int!
+}
+
+enum CopyStatus {
+	AVAILABLE
+	ON_LOAN
+	ON_HOLD
+	LOST
+}
+
+type Availability {
+	"Number of copies held."
+	total: Int!
+	"Number of copies that can be borrowed now."
+	available: Int!
+}
+
+type Genre {
+	name: String!
+	"Number of books in the genre."
+	count: Int!
+}
+
+type PaginationMetadata {
+	currentPage: Int!
+	pageSize: Int!
+	firstPage: Int!
+	lastPage: Int!
+	totalRecords: Int!
+}
+
+"An author has no record of its own; it is the name stored on its books."
+type Author {
+	name: String!
+	books: [Book!]!
+}
+
+type Book {
+	id: ID!
+	title: String!
+	authors: [Author!]!
+	publisher: String
+	"Year of publication."
+	published: Int!
+	pages: Int!
+	genres: [String!]!
+	version: Int!
+	copies: [Copy!]!
+	availability: Availability!
+}
+
+type BookPage {
+	books: [Book!]!
+	metadata: PaginationMetadata!
+}
+
+"Order of a page of books. Ties are broken by id."
+enum BookSort {
+%s}
+
+input BookInput {
+	title: String!
+	authors: [String!]
+	publisher: String
+	published: Int!
+	pages: Int!
+	genres: [String!]!
+}
+
+input BookPatch {
+	title: String
+	authors: [String!]
+	publisher: String
+	published: Int
+	pages: Int
+	genres: [String!]
+}
+
+type Query {
+	book(id: ID!): Book
+	"A page of books, filtered like GET /v1/books."
+	books(
+		"Exact title, ignoring case."
+		title: String
+		"Genres the books must all have."
+		genres: [String!]
+		page: Int = 1
+		size: Int = 12
+		sort: BookSort = ID_ASC
+	): BookPage!
+	"Full text search on book titles."
+	searchBooks(query: String!): [Book!]!
+	author(name: String!): Author
+	genres: [Genre!]!
+}
+
+type Mutation {
+	createBook(input: BookInput!): Book!
+	"Update some fields of a book. With expectedVersion, the update fails when the book has changed since."
+	updateBook(id: ID!, input: BookPatch!, expectedVersion: Int): Book!
+	"Delete a book, returning its id."
+	deleteBook(id: ID!): ID!
+}
+`
+
+// sortEnumName returns the BookSort name of a value of internal.SortValues, so that "-title"
+// becomes TITLE_DESC and "title" TITLE_ASC.
+func sortEnumName(value string) string {
+	if strings.HasPrefix(value, "-") {
+		return strings.ToUpper(value[1:]) + "_DESC"
+	}
+	return strings.ToUpper(value) + "_ASC"
+}
+
+var bookSortValues = func() map[string]string {
+	values := make(map[string]string, len(internal.SortValues))
+	for _, value := range internal.SortValues {
+		values[sortEnumName(value)] = value
+	}
+	return values
+}()
+
+func graphqlSchemaSDL() string {
+	var values strings.Builder
+	for _, value := range internal.SortValues {
+		fmt.Fprintf(&values, "\t%s\n", sortEnumName(value))
+	}
+	return fmt.Sprintf(graphqlSDL, values.String())
+}
+
+// graphqlError is a resolver error reported with a machine readable code, the same codes used
+// by problem+json responses, and the invalid fields when validation failed.
+type graphqlError struct {
+	message string
+	code    string
+	fields  map[string]string
+}
+
+func (e *graphqlError) Error() string {
+	return e.message
+}
+
+func (e *graphqlError) Extensions() map[string]any {
+	extensions := map[string]any{"code": e.code}
+	if len(e.fields) > 0 {
+		extensions["fields"] = e.fields
+	}
+	return extensions
+}
+
+var (
+	errGraphQLTooComplex = &graphqlError{message: "the query resolves too many objects, ask for fewer or smaller pages", code: errCodeQueryTooComplex}
+	// errGraphQLReadOnly is returned by mutations sent with GET, which the handler answers with 405.
+	errGraphQLReadOnly = &graphqlError{message: "mutations must be sent with POST", code: errCodeMethodNotAllowed}
+)
+
+func graphqlValidationError(fields map[string]string) error {
+	return &graphqlError{message: "one or more fields failed validation", code: errCodeValidationFailed, fields: fields}
+}
+
+func graphqlNotFoundError() error {
+	return &graphqlError{message: "the requested resource could not be found", code: errCodeNotFound}
+}
+
+// graphqlServerError logs err and hides its details from the client.
+func graphqlServerError(ctx context.Context, err error) error {
+	if errors.Is(err, models.ErrCanceled) || errors.Is(err, models.ErrTimeout) {
+		return &graphqlError{message: "the request was abandoned before it could be processed, try again later", code: errCodeUnavailable}
+	}
+	logging.FromContext(ctx).Error(err.Error(), "component", "graphql")
+	return &graphqlError{message: "the server encountered a problem and could not process your request", code: errCodeServerError}
+}
+
+// graphqlLogger logs the panics recovered by resolvers with the request's logger.
+type graphqlLogger struct{}
+
+func (graphqlLogger) LogPanic(ctx context.Context, value any) {
+	logging.FromContext(ctx).Error(fmt.Sprint(value), "component", "graphql")
+}
+
+// graphqlLoader is the state of one request. Every book and author handed to the executor is
+// counted against the request's object budget, and recorded so that the first Book.copies or
+// Author.books field loads the copies or books of all of them with one query.
+type graphqlLoader struct {
+	mu       sync.Mutex
+	limit    int
+	resolved int
+	readOnly bool
+	bookIDs  []int64
+	authors  []string
+	copies   map[int64][]*models.Copy
+	books    map[string][]*models.Book
+}
+
+type graphqlLoaderKey struct{}
+
+func loaderFrom(ctx context.Context) *graphqlLoader {
+	return ctx.Value(graphqlLoaderKey{}).(*graphqlLoader)
+}
+
+// spend takes n objects from the budget of the request, if it has one.
+func (l *graphqlLoader) spend(n int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit == 0 {
+		return nil
+	}
+	if l.resolved += n; l.resolved > l.limit {
+		return errGraphQLTooComplex
+	}
+	return nil
+}
+
+func (app *application) copiesOf(ctx context.Context, id int64) ([]*models.Copy, error) {
+	l := loaderFrom(ctx)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if copies, ok := l.copies[id]; ok {
+		return copies, nil
+	}
+	ids := l.bookIDs
+	l.bookIDs = nil
+	if !slices.Contains(ids, id) {
+		ids = append(ids, id)
+	}
+	copies, err := app.models.Copies.ForBooks(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		l.copies[id] = copies[id]
+	}
+	return l.copies[id], nil
+}
+
+func (app *application) booksOf(ctx context.Context, name string) ([]*models.Book, error) {
+	l := loaderFrom(ctx)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if books, ok := l.books[name]; ok {
+		return books, nil
+	}
+	names := l.authors
+	l.authors = nil
+	if !slices.Contains(names, name) {
+		names = append(names, name)
+	}
+	books, err := app.models.Books.ByAuthors(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		l.books[name] = books[name]
+	}
+	return l.books[name], nil
+}
+
+func (app *application) bookResolvers(ctx context.Context, books []*models.Book) ([]*bookResolver, error) {
+	l := loaderFrom(ctx)
+	if err := l.spend(len(books)); err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	resolvers := make([]*bookResolver, len(books))
+	for i, book := range books {
+		if _, ok := l.copies[book.ID]; !ok {
+			l.bookIDs = append(l.bookIDs, book.ID)
+		}
+		resolvers[i] = &bookResolver{app: app, book: book}
+	}
+	return resolvers, nil
+}
+
+func (app *application) authorResolvers(ctx context.Context, names []string) ([]*authorResolver, error) {
+	l := loaderFrom(ctx)
+	if err := l.spend(len(names)); err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	resolvers := make([]*authorResolver, len(names))
+	for i, name := range names {
+		if _, ok := l.books[name]; !ok {
+			l.authors = append(l.authors, name)
+		}
+		resolvers[i] = &authorResolver{app: app, name: name}
+	}
+	return resolvers, nil
+}
+
+// parseBookID returns the id of a book from an ID argument, or 0 when it is not a valid id.
+func parseBookID(id graphql.ID) int64 {
+	n, err := strconv.ParseInt(string(id), 10, 64)
+	if err != nil || n < 1 {
+		return 0
+	}
+	return n
+}
+
+func graphqlID(id int64) graphql.ID {
+	return graphql.ID(strconv.FormatInt(id, 10))
+}
+
+type graphqlResolver struct {
+	app *application
+}
+
+func (r *graphqlResolver) Book(ctx context.Context, args struct{ ID graphql.ID }) (*bookResolver, error) {
+	book, err := r.app.models.Books.Get(ctx, parseBookID(args.ID))
+	switch {
+	case errors.Is(err, models.ErrRecordNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, graphqlServerError(ctx, err)
+	}
+	books, err := r.app.bookResolvers(ctx, []*models.Book{book})
+	if err != nil {
+		return nil, err
+	}
+	return books[0], nil
+}
+
+func (r *graphqlResolver) Books(ctx context.Context, args struct {
+	Title  *string
+	Genres *[]string
+	Page   int32
+	Size   int32
+	Sort   string
+}) (*bookPageResolver, error) {
+	filters := internal.Filters{Page: int(args.Page), Size: int(args.Size), Sort: bookSortValues[args.Sort]}
+	if errs := internal.ValidateFilters(filters, nil); len(errs) > 0 {
+		return nil, graphqlValidationError(errs)
+	}
+	var title string
+	if args.Title != nil {
+		title = *args.Title
+	}
+	var genres []string
+	if args.Genres != nil {
+		genres = *args.Genres
+	}
+	books, metadata, err := r.app.models.Books.All(ctx, title, genres, filters)
+	if err != nil {
+		return nil, graphqlServerError(ctx, err)
+	}
+	resolvers, err := r.app.bookResolvers(ctx, books)
+	if err != nil {
+		return nil, err
+	}
+	return &bookPageResolver{books: resolvers, metadata: metadata}, nil
+}
+
+func (r *graphqlResolver) SearchBooks(ctx context.Context, args struct{ Query string }) ([]*bookResolver, error) {
+	books, err := r.app.models.Books.FullTextSearch(ctx, args.Query)
+	if err != nil {
+		return nil, graphqlServerError(ctx, err)
+	}
+	return r.app.bookResolvers(ctx, books)
+}
+
+func (r *graphqlResolver) Author(ctx context.Context, args struct{ Name string }) (*authorResolver, error) {
+	authors, err := r.app.authorResolvers(ctx, []string{args.Name})
+	if err != nil {
+		return nil, err
+	}
+	return authors[0], nil
+}
+
+func (r *graphqlResolver) Genres(ctx context.Context) ([]*genreResolver, error) {
+	genres, err := r.app.models.Books.Genres(ctx)
+	if err != nil {
+		return nil, graphqlServerError(ctx, err)
+	}
+	if err := loaderFrom(ctx).spend(len(genres)); err != nil {
+		return nil, err
+	}
+	resolvers := make([]*genreResolver, len(genres))
+	for i, genre := range genres {
+		resolvers[i] = &genreResolver{genre}
+	}
+	return resolvers, nil
+}
+
+// bookInput mirrors createInput; Book.Validate checks the values.
+type bookInput struct {
+	Title     string
+	Authors   *[]string
+	Publisher *string
+	Published int32
+	Pages     int32
+	Genres    []string
+}
+
+// bookPatch mirrors updateInput. Null clears the publisher and leaves required fields to fail
+// validation.
+type bookPatch struct {
+	Title     graphql.NullString
+	Authors   *[]string
+	Publisher graphql.NullString
+	Published graphql.NullInt
+	Pages     graphql.NullInt
+	Genres    *[]string
+}
+
+func (p bookPatch) apply(book *models.Book) {
+	if p.Title.Set {
+		book.Title = ""
+		if p.Title.Value != nil {
+			book.Title = *p.Title.Value
+		}
+	}
+	if p.Authors != nil {
+		book.Authors = *p.Authors
+	}
+	if p.Publisher.Set {
+		book.Publisher = ""
+		if p.Publisher.Value != nil {
+			book.Publisher = *p.Publisher.Value
+		}
+	}
+	if p.Published.Set {
+		book.Published = 0
+		if p.Published.Value != nil {
+			book.Published = int(*p.Published.Value)
+		}
+	}
+	if p.Pages.Set {
+		book.Pages = 0
+		if p.Pages.Value != nil {
+			book.Pages = int(*p.Pages.Value)
+		}
+	}
+	if p.Genres != nil {
+		book.Genres = *p.Genres
+	}
+}
+
+func (r *graphqlResolver) CreateBook(ctx context.Context, args struct{ Input bookInput }) (*bookResolver, error) {
+	if loaderFrom(ctx).readOnly {
+		return nil, errGraphQLReadOnly
+	}
+	book := &models.Book{
+		Title:     args.Input.Title,
+		Published: int(args.Input.Published),
+		Pages:     int(args.Input.Pages),
+		Genres:    args.Input.Genres,
+	}
+	if args.Input.Authors != nil {
+		book.Authors = *args.Input.Authors
+	}
+	if args.Input.Publisher != nil {
+		book.Publisher = *args.Input.Publisher
+	}
+	if errs := book.Validate(); len(errs) > 0 {
+		return nil, graphqlValidationError(errs)
+	}
+	if err := r.app.models.Books.Insert(ctx, book); err != nil {
+		return nil, graphqlServerError(ctx, err)
+	}
+	return &bookResolver{app: r.app, book: book}, nil
+}
+
+func (r *graphqlResolver) UpdateBook(ctx context.Context, args struct {
+	ID              graphql.ID
+	Input           bookPatch
+	ExpectedVersion *int32
+}) (*bookResolver, error) {
+	if loaderFrom(ctx).readOnly {
+		return nil, errGraphQLReadOnly
+	}
+	book, err := r.app.models.Books.Get(ctx, parseBookID(args.ID))
+	switch {
+	case errors.Is(err, models.ErrRecordNotFound):
+		return nil, graphqlNotFoundError()
+	case err != nil:
+		return nil, graphqlServerError(ctx, err)
+	}
+	conflict := &graphqlError{message: "unable to complete the update due to a conflict, try again", code: errCodeEditConflict}
+	if args.ExpectedVersion != nil && int(*args.ExpectedVersion) != book.Version {
+		return nil, conflict
+	}
+	args.Input.apply(book)
+	if errs := book.Validate(); len(errs) > 0 {
+		return nil, graphqlValidationError(errs)
+	}
+	err = r.app.models.Books.Update(ctx, book)
+	switch {
+	case errors.Is(err, models.ErrEditConflict):
+		return nil, conflict
+	case err != nil:
+		return nil, graphqlServerError(ctx, err)
+	}
+	return &bookResolver{app: r.app, book: book}, nil
+}
+
+func (r *graphqlResolver) DeleteBook(ctx context.Context, args struct{ ID graphql.ID }) (graphql.ID, error) {
+	if loaderFrom(ctx).readOnly {
+		return "", errGraphQLReadOnly
+	}
+	err := r.app.models.Books.Delete(ctx, parseBookID(args.ID))
+	switch {
+	case errors.Is(err, models.ErrRecordNotFound):
+		return "", graphqlNotFoundError()
+	case err != nil:
+		return "", graphqlServerError(ctx, err)
+	}
+	return args.ID, nil
+}
+
+type bookResolver struct {
+	app  *application
+	book *models.Book
+}
+
+func (b *bookResolver) ID() graphql.ID     { return graphqlID(b.book.ID) }
+func (b *bookResolver) Title() string      { return b.book.Title }
+func (b *bookResolver) Publisher() *string { return &b.book.Publisher }
+func (b *bookResolver) Published() int32   { return int32(b.book.Published) }
+func (b *bookResolver) Pages() int32       { return int32(b.book.Pages) }
+func (b *bookResolver) Genres() []string   { return b.book.Genres }
+func (b *bookResolver) Version() int32     { return int32(b.book.Version) }
+
+func (b *bookResolver) Authors(ctx context.Context) ([]*authorResolver, error) {
+	return b.app.authorResolvers(ctx, b.book.Authors)
+}
+
+func (b *bookResolver) Copies(ctx context.Context) ([]*copyResolver, error) {
+	copies, err := b.app.copiesOf(ctx, b.book.ID)
+	if err != nil {
+		return nil, graphqlServerError(ctx, err)
+	}
+	if err := loaderFrom(ctx).spend(len(copies)); err != nil {
+		return nil, err
+	}
+	resolvers := make([]*copyResolver, len(copies))
+	for i, copy := range copies {
+		resolvers[i] = &copyResolver{copy}
+	}
+	return resolvers, nil
+}
+
+func (b *bookResolver) Availability(ctx context.Context) (*availabilityResolver, error) {
+	copies, err := b.app.copiesOf(ctx, b.book.ID)
+	if err != nil {
+		return nil, graphqlServerError(ctx, err)
+	}
+	availability := &availabilityResolver{total: int32(len(copies))}
+	for _, copy := range copies {
+		if copy.Status == "available" {
+			availability.available++
+		}
+	}
+	return availability, nil
+}
+
+type authorResolver struct {
+	app  *application
+	name string
+}
+
+func (a *authorResolver) Name() string { return a.name }
+
+func (a *authorResolver) Books(ctx context.Context) ([]*bookResolver, error) {
+	books, err := a.app.booksOf(ctx, a.name)
+	if err != nil {
+		return nil, graphqlServerError(ctx, err)
+	}
+	return a.app.bookResolvers(ctx, books)
+}
+
+type copyResolver struct {
+	copy *models.Copy
+}
+
+func (c *copyResolver) ID() graphql.ID  { return graphqlID(c.copy.ID) }
+func (c *copyResolver) Barcode() string { return c.copy.Barcode }
+func (c *copyResolver) Branch() string  { return c.copy.Branch }
+func (c *copyResolver) Status() string  { return strings.ToUpper(c.copy.Status) }
+func (c *copyResolver) Version() int32  { return int32(c.copy.Version) }
+
+type availabilityResolver struct {
+	total, available int32
+}
+
+func (a *availabilityResolver) Total() int32     { return a.total }
+func (a *availabilityResolver) Available() int32 { return a.available }
+
+type genreResolver struct {
+	genre *models.Genre
+}
+
+func (g *genreResolver) Name() string { return g.genre.Name }
+func (g *genreResolver) Count() int32 { return int32(g.genre.Count) }
+
+type bookPageResolver struct {
+	books    []*bookResolver
+	metadata *internal.PaginationMetadata
+}
+
+func (p *bookPageResolver) Books() []*bookResolver { return p.books }
+func (p *bookPageResolver) Metadata() *metadataResolver {
+	return &metadataResolver{p.metadata}
+}
+
+type metadataResolver struct {
+	metadata *internal.PaginationMetadata
+}
+
+func (m *metadataResolver) CurrentPage() int32  { return int32(m.metadata.CurrentPage) }
+func (m *metadataResolver) PageSize() int32     { return int32(m.metadata.PageSize) }
+func (m *metadataResolver) FirstPage() int32    { return int32(m.metadata.FirstPage) }
+func (m *metadataResolver) LastPage() int32     { return int32(m.metadata.LastPage) }
+func (m *metadataResolver) TotalRecords() int32 { return int32(m.metadata.TotalRecords) }
+
+// graphqlSchema parses the schema of the catalogue. Queries deeper than graphql-max-depth are
+// rejected before execution; graphql-max-complexity caps the books, authors, copies and genres a
+// request may resolve.
+func (app *application) graphqlSchema() *graphql.Schema {
+	return graphql.MustParseSchema(graphqlSchemaSDL(), &graphqlResolver{app: app},
+		graphql.UseStringDescriptions(),
+		graphql.MaxDepth(app.config.graphql.maxDepth),
+		graphql.Logger(graphqlLogger{}),
+	)
+}
+
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+type graphqlResponse struct {
+	// Data is absent when the request failed before execution, and null when an error
+	// in a non-null root field nulled the whole result.
+	Data   any                     `json:"data,omitempty"`
+	Errors []*gqlerrors.QueryError `json:"errors,omitempty"`
+}
+
+// graphqlHandler serves GraphQL over HTTP: POST with a JSON body, or GET with query,
+// operationName and variables parameters for queries only. As GraphQL over HTTP asks for
+// application/json responses, every well-formed request is answered with 200, errors included;
+// only requests that are not GraphQL requests at all get a 400.
+func (app *application) graphqlHandler(schema *graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req graphqlRequest
+		if r.Method == http.MethodGet {
+			qs := r.URL.Query()
+			req.Query = qs.Get("query")
+			req.OperationName = qs.Get("operationName")
+			if variables := qs.Get("variables"); variables != "" {
+				if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+					app.badRequestErrorResponse(w, r, errors.New("variables must be a JSON object"))
+					return
+				}
+			}
+		} else if err := app.readJson(w, r, &req); err != nil {
+			app.badRequestErrorResponse(w, r, err)
+			return
+		}
+		if strings.TrimSpace(req.Query) == "" {
+			app.badRequestErrorResponse(w, r, errors.New("query must be provided"))
+			return
+		}
+
+		loader := &graphqlLoader{
+			limit:    app.config.graphql.maxComplexity,
+			readOnly: r.Method == http.MethodGet,
+			copies:   map[int64][]*models.Copy{},
+			books:    map[string][]*models.Book{},
+		}
+		resp := schema.Exec(context.WithValue(r.Context(), graphqlLoaderKey{}, loader), req.Query, req.OperationName, req.Variables)
+		for _, err := range resp.Errors {
+			if err.ResolverError == errGraphQLReadOnly {
+				w.Header().Set("Allow", http.MethodPost)
+				app.methodNotAllowedErrorResponse(w, r)
+				return
+			}
+		}
+		data := envelope{}
+		if len(resp.Data) > 0 {
+			data["data"] = resp.Data
+		}
+		if len(resp.Errors) > 0 {
+			data["errors"] = resp.Errors
+		}
+		if err := app.writeJson(w, http.StatusOK, data, nil); err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) graphqlSchemaHandler() http.HandlerFunc {
+	sdl := graphqlSchemaSDL()
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(sdl))
+	}
+}
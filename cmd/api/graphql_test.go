@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/themilar/plibrary/internal"
+)
+
+func postGraphQL(t *testing.T, handler http.Handler, query string) map[string]any {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"query": query})
+	req := httptest.NewRequest(http.MethodPost, "/v1/graphql", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("POST /v1/graphql: status %d; body %s", rr.Code, rr.Body)
+	}
+	var resp map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestGraphQLBookSort(t *testing.T) {
+	app := newMemoryApplication(t, config{})
+	handler := app.graphqlHandler(app.graphqlSchema())
+
+	resp := postGraphQL(t, handler, `{ __type(name: "BookSort") { enumValues { name } } }`)
+	var names []string
+	for _, value := range resp["data"].(map[string]any)["__type"].(map[string]any)["enumValues"].([]any) {
+		names = append(names, value.(map[string]any)["name"].(string))
+	}
+	if want := []string{"ID_ASC", "TITLE_ASC", "PUBLISHED_ASC", "PAGES_ASC", "ID_DESC", "TITLE_DESC", "PUBLISHED_DESC", "PAGES_DESC"}; !slices.Equal(names, want) {
+		t.Errorf("BookSort = %v, want %v", names, want)
+	}
+	if len(names) != len(internal.SortValues) {
+		t.Errorf("BookSort has %d values, internal.SortValues %d", len(names), len(internal.SortValues))
+	}
+
+	resp = postGraphQL(t, handler, `{ books(sort: PUBLISHED_DESC) { books { title } metadata { totalRecords } } }`)
+	got, _ := json.Marshal(resp["data"])
+	if want := `{"books":{"books":[{"title":"Children Of Dune"},{"title":"Dune"},{"title":"The Hobbit"}],"metadata":{"totalRecords":3}}}`; string(got) != want {
+		t.Errorf("books sorted by -published = %s, want %s", got, want)
+	}
+
+	resp = postGraphQL(t, handler, `{ books(size: 50) { books { id } } }`)
+	errs, _ := json.Marshal(resp["errors"])
+	if !strings.Contains(string(errs), `"code":"validation_failed"`) || !strings.Contains(string(errs), `"size":"value must be less than: 20"`) {
+		t.Errorf("books with size 50: errors %s, want a validation failure on size", errs)
+	}
+}
+
+func TestGraphQLIntrospection(t *testing.T) {
+	app := newMemoryApplication(t, config{})
+	app.config.graphql.maxDepth = 6
+	handler := app.graphqlHandler(app.graphqlSchema())
+
+	resp := postGraphQL(t, handler, `{ __schema { types { name } } }`)
+	if resp["errors"] != nil {
+		t.Fatalf("errors = %v", resp["errors"])
+	}
+	var names []string
+	for _, typ := range resp["data"].(map[string]any)["__schema"].(map[string]any)["types"].([]any) {
+		names = append(names, typ.(map[string]any)["name"].(string))
+	}
+	for _, name := range []string{"Book", "BookInput", "BookPatch", "BookSort", "Copy", "CopyStatus", "Mutation", "Query", "__Type"} {
+		if !slices.Contains(names, name) {
+			t.Errorf("types %v are missing %s", names, name)
+		}
+	}
+
+	// Introspection is also served over GET, as GraphiQL does.
+	req := httptest.NewRequest(http.MethodGet, "/v1/graphql?query="+url.QueryEscape(`{ __type(name: "Copy") { fields { name } } }`), nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	var got bytes.Buffer
+	if err := json.Compact(&got, rr.Body.Bytes()); err != nil {
+		t.Fatalf("GET /v1/graphql: status %d; body %s", rr.Code, rr.Body)
+	}
+	if want := `{"data":{"__type":{"fields":[{"name":"id"},{"name":"barcode"},{"name":"branch"},{"name":"status"},{"name":"version"}]}}}`; got.String() != want {
+		t.Errorf("GET __type(Copy) = %s, want %s", got.String(), want)
+	}
+}
+
+func TestGraphQLRequests(t *testing.T) {
+	handler := newTestApplication(t).routes()
+
+	for _, test := range []struct {
+		method, target, body string
+		status               int
+		contains             string
+	}{
+		{http.MethodGet, "/v1/graphql", "", http.StatusBadRequest, "query must be provided"},
+		{http.MethodGet, "/v1/graphql?query=" + url.QueryEscape("{ book(id: 1) { title } }") + "&variables=7", "", http.StatusBadRequest, "variables must be a JSON object"},
+		{http.MethodGet, "/v1/graphql?query=" + url.QueryEscape(`mutation { deleteBook(id: 1) }`), "", http.StatusMethodNotAllowed, ""},
+		{http.MethodPost, "/v1/graphql", `{"query": "{ book(id: 1) { title "}`, http.StatusOK, `"errors"`},
+		{http.MethodPost, "/v1/graphql", `{"query": "{ book(id: 1) { isbn } }"}`, http.StatusOK, `"errors"`},
+		{http.MethodPost, "/v1/graphql", `{"query": `, http.StatusBadRequest, ""},
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(test.method, test.target, strings.NewReader(test.body)))
+		if rr.Code != test.status || !strings.Contains(rr.Body.String(), test.contains) {
+			t.Errorf("%s %s %s: status %d, body %s; want %d with %s", test.method, test.target, test.body, rr.Code, rr.Body, test.status, test.contains)
+		}
+		if test.status == http.StatusOK && strings.Contains(rr.Body.String(), `"data"`) {
+			t.Errorf("%s: invalid document returned data: %s", test.body, rr.Body)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/graphql/schema", nil))
+	if sdl := rr.Body.String(); !strings.Contains(sdl, "type Book {") || !strings.Contains(sdl, "type Query {") {
+		t.Errorf("GET /v1/graphql/schema = %s, want the Book and Query types", sdl)
+	}
+}
+
+func TestGraphQLBooks(t *testing.T) {
+	app := newMemoryApplication(t, config{})
+	handler := app.graphqlHandler(app.graphqlSchema())
+
+	resp := postGraphQL(t, handler, `{ books(genres: ["sci-fi"]) { books { title } metadata { totalRecords } } }`)
+	got, _ := json.Marshal(resp["data"])
+	if want := `{"books":{"books":[{"title":"Dune"},{"title":"Children Of Dune"}],"metadata":{"totalRecords":2}}}`; string(got) != want {
+		t.Errorf("sci-fi books = %s, want %s", got, want)
+	}
+
+	resp = postGraphQL(t, handler, `{ book(id: "2") { title authors { name books { title } } } }`)
+	got, _ = json.Marshal(resp["data"])
+	if want := `{"book":{"authors":[{"books":[{"title":"Dune"},{"title":"Children Of Dune"}],"name":"Frank Herbert"}],"title":"Children Of Dune"}}`; string(got) != want {
+		t.Errorf("book 2 = %s, want %s", got, want)
+	}
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	app := newMemoryApplication(t, config{})
+	app.config.graphql.maxDepth = 3
+	app.config.graphql.maxComplexity = 4
+	handler := app.graphqlHandler(app.graphqlSchema())
+
+	resp := postGraphQL(t, handler, `{ book(id: "1") { authors { books { title } } } }`)
+	if errs, _ := json.Marshal(resp["errors"]); !strings.Contains(string(errs), "exceeds max depth 3") {
+		t.Errorf("query of depth 4: errors %s, want a depth error", errs)
+	}
+
+	app.config.graphql.maxDepth = 0
+	handler = app.graphqlHandler(app.graphqlSchema())
+
+	resp = postGraphQL(t, handler, `{ books { books { title } } }`)
+	if resp["errors"] != nil {
+		t.Errorf("3 books: errors %v", resp["errors"])
+	}
+	resp = postGraphQL(t, handler, `{ books { books { authors { name } } } }`)
+	if errs, _ := json.Marshal(resp["errors"]); !strings.Contains(string(errs), `"code":"query_too_complex"`) {
+		t.Errorf("3 books and their authors: errors %s, want query_too_complex", errs)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/themilar/plibrary/internal"
+	"github.com/themilar/plibrary/internal/logging"
+	"github.com/themilar/plibrary/internal/models"
+	"github.com/themilar/plibrary/pkg/bookspb"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// exportBatchSize is the number of books read from the database at a time by ExportBooks.
+const exportBatchSize = 100
+
+// bookServer implements the gRPC BookService on top of the same models as the REST handlers.
+type bookServer struct {
+	bookspb.UnimplementedBookServiceServer
+	app *application
+}
+
+func (app *application) grpcServer() *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(app.grpcUnaryLogger),
+		grpc.ChainStreamInterceptor(app.grpcStreamLogger),
+	)
+	bookspb.RegisterBookServiceServer(srv, &bookServer{app: app})
+	reflection.Register(srv)
+	return srv
+}
+
+// grpcRequestIDKey is the metadata key of request ids, the gRPC form of X-Request-ID.
+const grpcRequestIDKey = "x-request-id"
+
+// grpcRequestContext tags an RPC with the request id sent by the client in its metadata when it
+// is usable, or a new one. Like requestID does for HTTP requests, it returns the id and a context
+// carrying a logger with the id and the trace id.
+func (app *application) grpcRequestContext(ctx context.Context) (context.Context, string) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(grpcRequestIDKey); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	logger := app.logger.With("request_id", id)
+	if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+		logger = logger.With("trace_id", span.SpanContext().TraceID().String())
+	}
+	return logging.WithLogger(ctx, logger), id
+}
+
+func (app *application) grpcUnaryLogger(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx, id := app.grpcRequestContext(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(grpcRequestIDKey, id))
+	resp, err := handler(ctx, req)
+	logging.FromContext(ctx).Info("logging rpc", "method", info.FullMethod, "code", status.Code(err).String(), "duration", time.Since(start).String())
+	return resp, err
+}
+
+// grpcServerStream replaces the context of a stream with one carrying the request's logger.
+type grpcServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (app *application) grpcStreamLogger(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, id := app.grpcRequestContext(ss.Context())
+	ss.SetHeader(metadata.Pairs(grpcRequestIDKey, id))
+	err := handler(srv, &grpcServerStream{ServerStream: ss, ctx: ctx})
+	logging.FromContext(ctx).Info("logging rpc", "method", info.FullMethod, "code", status.Code(err).String(), "duration", time.Since(start).String())
+	return err
+}
+
+// grpcError maps model errors to gRPC status codes. Anything unexpected is logged and
+// reported as INTERNAL without its details.
+func (app *application) grpcError(ctx context.Context, method string, err error) error {
+	switch {
+	case errors.Is(err, models.ErrRecordNotFound):
+		return status.Error(codes.NotFound, "the requested resource could not be found")
+	case errors.Is(err, models.ErrEditConflict):
+		return status.Error(codes.Aborted, "unable to complete the update due to a conflict, try again")
+	case errors.Is(err, models.ErrCanceled):
+		return status.Error(codes.Canceled, "the request was canceled")
+	case errors.Is(err, models.ErrTimeout):
+		return status.Error(codes.DeadlineExceeded, "the request took too long to process, try again later")
+	}
+	logging.FromContext(ctx).Error(err.Error(), "rpc_method", method)
+	return status.Error(codes.Internal, "the server encountered a problem and could not process your request")
+}
+
+// grpcValidationError reports failed fields as BadRequest field violations.
+func grpcValidationError(errs map[string]string) error {
+	violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(errs))
+	for field, reason := range errs {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: field, Description: reason})
+	}
+	sort.Slice(violations, func(i, j int) bool { return violations[i].Field < violations[j].Field })
+	st, err := status.New(codes.InvalidArgument, "one or more fields failed validation").
+		WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "one or more fields failed validation")
+	}
+	return st.Err()
+}
+
+func bookToProto(book *models.Book) *bookspb.Book {
+	return &bookspb.Book{
+		Id:        book.ID,
+		Title:     book.Title,
+		Authors:   book.Authors,
+		Publisher: book.Publisher,
+		Published: int32(book.Published),
+		Pages:     int32(book.Pages),
+		Genres:    book.Genres,
+		Version:   int32(book.Version),
+	}
+}
+
+func booksToProto(books []*models.Book) []*bookspb.Book {
+	out := make([]*bookspb.Book, len(books))
+	for i, book := range books {
+		out[i] = bookToProto(book)
+	}
+	return out
+}
+
+func (s *bookServer) ListBooks(ctx context.Context, req *bookspb.ListBooksRequest) (*bookspb.ListBooksResponse, error) {
+	filters := internal.Filters{Page: int(req.Page), Size: int(req.Size), Sort: req.Sort}
+	if filters.Page == 0 {
+		filters.Page = 1
+	}
+	if filters.Size == 0 {
+		filters.Size = 12
+	}
+	if filters.Sort == "" {
+		filters.Sort = "id"
+	}
+	if errs := internal.ValidateFilters(filters, nil); len(errs) > 0 {
+		return nil, grpcValidationError(errs)
+	}
+	genres := req.Genres
+	if genres == nil {
+		genres = []string{}
+	}
+	books, metadata, err := s.app.models.Books.All(ctx, req.Title, genres, filters)
+	if err != nil {
+		return nil, s.app.grpcError(ctx, "ListBooks", err)
+	}
+	return &bookspb.ListBooksResponse{
+		Books: booksToProto(books),
+		Metadata: &bookspb.PaginationMetadata{
+			CurrentPage:  int32(metadata.CurrentPage),
+			PageSize:     int32(metadata.PageSize),
+			FirstPage:    int32(metadata.FirstPage),
+			LastPage:     int32(metadata.LastPage),
+			TotalRecords: int32(metadata.TotalRecords),
+		},
+	}, nil
+}
+
+func (s *bookServer) GetBook(ctx context.Context, req *bookspb.GetBookRequest) (*bookspb.Book, error) {
+	book, err := s.app.models.Books.Get(ctx, req.Id)
+	if err != nil {
+		return nil, s.app.grpcError(ctx, "GetBook", err)
+	}
+	return bookToProto(book), nil
+}
+
+func (s *bookServer) SearchBooks(ctx context.Context, req *bookspb.SearchBooksRequest) (*bookspb.SearchBooksResponse, error) {
+	books, err := s.app.models.Books.FullTextSearch(ctx, req.Query)
+	if err != nil {
+		return nil, s.app.grpcError(ctx, "SearchBooks", err)
+	}
+	return &bookspb.SearchBooksResponse{Books: booksToProto(books)}, nil
+}
+
+func (s *bookServer) CreateBook(ctx context.Context, req *bookspb.CreateBookRequest) (*bookspb.Book, error) {
+	input := req.GetBook()
+	book := &models.Book{
+		Title:     input.GetTitle(),
+		Authors:   input.GetAuthors(),
+		Publisher: input.GetPublisher(),
+		Published: int(input.GetPublished()),
+		Pages:     int(input.GetPages()),
+		Genres:    input.GetGenres(),
+	}
+	if errs := book.Validate(); len(errs) > 0 {
+		return nil, grpcValidationError(errs)
+	}
+	if err := s.app.models.Books.Insert(ctx, book); err != nil {
+		return nil, s.app.grpcError(ctx, "CreateBook", err)
+	}
+	return bookToProto(book), nil
+}
+
+func (s *bookServer) UpdateBook(ctx context.Context, req *bookspb.UpdateBookRequest) (*bookspb.Book, error) {
+	input := req.GetBook()
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		paths = []string{"title", "authors", "publisher", "published", "pages", "genres"}
+	}
+	book, err := s.app.models.Books.Get(ctx, input.GetId())
+	if err != nil {
+		return nil, s.app.grpcError(ctx, "UpdateBook", err)
+	}
+	if req.ExpectedVersion != 0 && int(req.ExpectedVersion) != book.Version {
+		return nil, s.app.grpcError(ctx, "UpdateBook", models.ErrEditConflict)
+	}
+	for _, path := range paths {
+		switch path {
+		case "title":
+			book.Title = input.GetTitle()
+		case "authors":
+			book.Authors = input.GetAuthors()
+		case "publisher":
+			book.Publisher = input.GetPublisher()
+		case "published":
+			book.Published = int(input.GetPublished())
+		case "pages":
+			book.Pages = int(input.GetPages())
+		case "genres":
+			book.Genres = input.GetGenres()
+		default:
+			return nil, grpcValidationError(map[string]string{"update_mask": "unknown field " + path})
+		}
+	}
+	if errs := book.Validate(); len(errs) > 0 {
+		return nil, grpcValidationError(errs)
+	}
+	if err := s.app.models.Books.Update(ctx, book); err != nil {
+		return nil, s.app.grpcError(ctx, "UpdateBook", err)
+	}
+	return bookToProto(book), nil
+}
+
+func (s *bookServer) DeleteBook(ctx context.Context, req *bookspb.DeleteBookRequest) (*emptypb.Empty, error) {
+	if err := s.app.models.Books.Delete(ctx, req.Id); err != nil {
+		return nil, s.app.grpcError(ctx, "DeleteBook", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// ExportBooks pages through the catalogue by id rather than by offset, so books added or
+// removed while the stream runs never cause others to be sent twice or skipped.
+func (s *bookServer) ExportBooks(req *bookspb.ExportBooksRequest, stream grpc.ServerStreamingServer[bookspb.Book]) error {
+	var afterID int64
+	for {
+		if err := stream.Context().Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		books, _, err := s.app.models.Books.Harvest(stream.Context(), nil, nil, req.Genre, afterID, exportBatchSize)
+		if err != nil {
+			return s.app.grpcError(stream.Context(), "ExportBooks", err)
+		}
+		for _, book := range books {
+			if err := stream.Send(bookToProto(book)); err != nil {
+				return err
+			}
+		}
+		if len(books) < exportBatchSize {
+			return nil
+		}
+		afterID = books[len(books)-1].ID
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/themilar/plibrary/internal/logging"
+	"github.com/themilar/plibrary/internal/models"
+	"github.com/themilar/plibrary/pkg/bookspb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// newGRPCClient serves app.grpcServer over an in-memory listener and returns a client for it.
+func newGRPCClient(t *testing.T, app *application) bookspb.BookServiceClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	srv := app.grpcServer()
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return bookspb.NewBookServiceClient(conn)
+}
+
+func titles(books []*bookspb.Book) string {
+	var s []string
+	for _, book := range books {
+		s = append(s, book.Title)
+	}
+	return strings.Join(s, ", ")
+}
+
+func TestGRPCReadBooks(t *testing.T) {
+	var logs bytes.Buffer
+	app := newMemoryApplication(t, config{})
+	app.logger = slog.New(slog.NewTextHandler(&logs, nil))
+	client := newGRPCClient(t, app)
+	ctx := context.Background()
+
+	list, err := client.ListBooks(ctx, &bookspb.ListBooksRequest{Genres: []string{"sci-fi"}, Sort: "-published", Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if titles(list.Books) != "Children Of Dune" || list.Metadata.TotalRecords != 2 || list.Metadata.LastPage != 2 || list.Metadata.PageSize != 1 {
+		t.Errorf("ListBooks = %s, %v", titles(list.Books), list.Metadata)
+	}
+	list, err = client.ListBooks(ctx, &bookspb.ListBooksRequest{})
+	if err != nil || titles(list.Books) != "Dune, Children Of Dune, The Hobbit" || list.Metadata.CurrentPage != 1 {
+		t.Errorf("ListBooks with defaults = %v, %v", list, err)
+	}
+
+	book, err := client.GetBook(ctx, &bookspb.GetBookRequest{Id: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Title != "The Hobbit" || book.Published != 1937 || book.Pages != 310 || book.Version != 1 || strings.Join(book.Authors, ",") != "J. R. R. Tolkien" {
+		t.Errorf("GetBook(3) = %v", book)
+	}
+
+	search, err := client.SearchBooks(ctx, &bookspb.SearchBooksRequest{Query: "dune"})
+	if err != nil || titles(search.Books) != "Dune, Children Of Dune" {
+		t.Errorf("SearchBooks(dune) = %v, %v", search, err)
+	}
+
+	if !strings.Contains(logs.String(), "method=/plibrary.v1.BookService/GetBook code=OK") {
+		t.Errorf("calls are not logged:\n%s", logs.String())
+	}
+}
+
+func TestGRPCWriteBooks(t *testing.T) {
+	client := newGRPCClient(t, newMemoryApplication(t, config{}))
+	ctx := context.Background()
+
+	created, err := client.CreateBook(ctx, &bookspb.CreateBookRequest{Book: &bookspb.Book{
+		Title: "Dune Messiah", Authors: []string{"Frank Herbert"}, Published: 1969, Pages: 256, Genres: []string{"sci-fi"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Id != 4 || created.Version != 1 {
+		t.Errorf("CreateBook = %v", created)
+	}
+
+	// Only the fields in the mask change.
+	updated, err := client.UpdateBook(ctx, &bookspb.UpdateBookRequest{
+		Book:            &bookspb.Book{Id: created.Id, Title: "ignored", Pages: 331},
+		UpdateMask:      &fieldmaskpb.FieldMask{Paths: []string{"pages"}},
+		ExpectedVersion: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Title != "Dune Messiah" || updated.Pages != 331 || updated.Version != 2 {
+		t.Errorf("UpdateBook = %v", updated)
+	}
+
+	_, err = client.UpdateBook(ctx, &bookspb.UpdateBookRequest{
+		Book:            &bookspb.Book{Id: created.Id, Pages: 300},
+		UpdateMask:      &fieldmaskpb.FieldMask{Paths: []string{"pages"}},
+		ExpectedVersion: 1,
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("UpdateBook with a stale version: %v, want ABORTED", err)
+	}
+
+	if _, err := client.DeleteBook(ctx, &bookspb.DeleteBookRequest{Id: created.Id}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetBook(ctx, &bookspb.GetBookRequest{Id: created.Id}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetBook after DeleteBook: %v, want NOT_FOUND", err)
+	}
+	if _, err := client.DeleteBook(ctx, &bookspb.DeleteBookRequest{Id: created.Id}); status.Code(err) != codes.NotFound {
+		t.Errorf("DeleteBook twice: %v, want NOT_FOUND", err)
+	}
+}
+
+func TestGRPCValidation(t *testing.T) {
+	client := newGRPCClient(t, newMemoryApplication(t, config{}))
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		name   string
+		call   func() error
+		fields string
+	}{
+		{"ListBooks", func() error {
+			_, err := client.ListBooks(ctx, &bookspb.ListBooksRequest{Page: -1, Sort: "isbn"})
+			return err
+		}, "page, sort"},
+		{"CreateBook", func() error {
+			_, err := client.CreateBook(ctx, &bookspb.CreateBookRequest{Book: &bookspb.Book{Published: 1965, Pages: 412, Genres: []string{"sci-fi"}}})
+			return err
+		}, "title"},
+		{"UpdateBook", func() error {
+			_, err := client.UpdateBook(ctx, &bookspb.UpdateBookRequest{
+				Book:       &bookspb.Book{Id: 1},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"isbn"}},
+			})
+			return err
+		}, "update_mask"},
+	} {
+		st := status.Convert(test.call())
+		var fields []string
+		for _, detail := range st.Details() {
+			if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+				for _, violation := range badRequest.FieldViolations {
+					fields = append(fields, violation.Field)
+				}
+			}
+		}
+		if st.Code() != codes.InvalidArgument || strings.Join(fields, ", ") != test.fields {
+			t.Errorf("%s: %v with violations of %v, want INVALID_ARGUMENT for %s", test.name, st.Err(), fields, test.fields)
+		}
+	}
+}
+
+func TestGRPCExportBooks(t *testing.T) {
+	app := newMemoryApplication(t, config{})
+	// Enough books for the export to take more than one batch.
+	for i := range exportBatchSize {
+		book := &models.Book{Title: fmt.Sprintf("Volume %d", i), Published: 2000, Pages: 100, Genres: []string{"reference"}}
+		if err := app.models.Books.Insert(context.Background(), book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := newGRPCClient(t, app)
+
+	export := func(genre string) ([]*bookspb.Book, error) {
+		stream, err := client.ExportBooks(context.Background(), &bookspb.ExportBooksRequest{Genre: genre})
+		if err != nil {
+			return nil, err
+		}
+		var books []*bookspb.Book
+		for {
+			book, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return books, nil
+			}
+			if err != nil {
+				return books, err
+			}
+			books = append(books, book)
+		}
+	}
+
+	books, err := export("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != exportBatchSize+3 {
+		t.Fatalf("ExportBooks sent %d books, want %d", len(books), exportBatchSize+3)
+	}
+	for i, book := range books {
+		if book.Id != int64(i+1) {
+			t.Fatalf("book %d of the export has id %d", i, book.Id)
+		}
+	}
+
+	books, err = export("fantasy")
+	if err != nil || titles(books) != "The Hobbit" {
+		t.Errorf("ExportBooks(fantasy) = %s, %v", titles(books), err)
+	}
+}
+
+func TestGRPCError(t *testing.T) {
+	var logs bytes.Buffer
+	app := newMemoryApplication(t, config{})
+	ctx := logging.WithLogger(context.Background(), slog.New(slog.NewTextHandler(&logs, nil)).With("request_id", "rpc-7"))
+
+	for _, test := range []struct {
+		err  error
+		code codes.Code
+	}{
+		{models.ErrRecordNotFound, codes.NotFound},
+		{fmt.Errorf("updating: %w", models.ErrEditConflict), codes.Aborted},
+		{models.ErrCanceled, codes.Canceled},
+		{models.ErrTimeout, codes.DeadlineExceeded},
+		{errors.New("pq: password authentication failed"), codes.Internal},
+	} {
+		st := status.Convert(app.grpcError(ctx, "GetBook", test.err))
+		if st.Code() != test.code {
+			t.Errorf("grpcError(%v) = %v, want %v", test.err, st.Code(), test.code)
+		}
+		if strings.Contains(st.Message(), "password") {
+			t.Errorf("grpcError(%v) leaks the error: %q", test.err, st.Message())
+		}
+	}
+	if !strings.Contains(logs.String(), "password authentication failed") || !strings.Contains(logs.String(), "rpc_method=GetBook") ||
+		!strings.Contains(logs.String(), "request_id=rpc-7") {
+		t.Errorf("unexpected errors are not logged:\n%s", logs.String())
+	}
+}
+
+func TestGRPCRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	app := newMemoryApplication(t, config{})
+	app.logger = slog.New(slog.NewTextHandler(&logs, nil))
+	client := newGRPCClient(t, app)
+
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "rpc-7")
+	if _, err := client.GetBook(ctx, &bookspb.GetBookRequest{Id: 99}, grpc.Header(&header)); status.Code(err) != codes.NotFound {
+		t.Fatalf("GetBook(99) error = %v, want NotFound", err)
+	}
+	if got := header.Get("x-request-id"); len(got) != 1 || got[0] != "rpc-7" {
+		t.Errorf("x-request-id header = %v, want rpc-7", got)
+	}
+	if !strings.Contains(logs.String(), "request_id=rpc-7") || !strings.Contains(logs.String(), "method="+bookspb.BookService_GetBook_FullMethodName) {
+		t.Errorf("the RPC is not logged with its request id:\n%s", logs.String())
+	}
+
+	// Streams are tagged too, with a new id when the client sends none.
+	stream, err := client.ExportBooks(context.Background(), &bookspb.ExportBooksRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	header, _ = stream.Header()
+	if got := header.Get("x-request-id"); len(got) != 1 || !validRequestID(got[0]) || got[0] == "rpc-7" {
+		t.Errorf("x-request-id header of the stream = %v, want a new id", got)
+	}
+}
+
+func TestBookToProto(t *testing.T) {
+	book := bookToProto(&models.Book{ID: 3, Title: "The Hobbit", Authors: []string{"J. R. R. Tolkien"}, Publisher: "Allen & Unwin", Published: 1937, Pages: 310, Genres: []string{"fantasy"}, Version: 2})
+	if book.Id != 3 || book.Title != "The Hobbit" || book.Publisher != "Allen & Unwin" || book.Published != 1937 || book.Pages != 310 || book.Version != 2 {
+		t.Errorf("bookToProto = %v", book)
+	}
+	if strings.Join(book.Authors, ",") != "J. R. R. Tolkien" || strings.Join(book.Genres, ",") != "fantasy" {
+		t.Errorf("bookToProto authors %v, genres %v", book.Authors, book.Genres)
+	}
+}
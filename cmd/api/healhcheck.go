@@ -1,10 +1,30 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"time"
+
+	"github.com/themilar/plibrary/internal/migrate"
+	"github.com/themilar/plibrary/migrations"
 )
 
+// readinessTimeout bounds the checks of a readiness probe, so that it answers before the
+// probe itself times out.
+const readinessTimeout = 2 * time.Second
+
+// componentHealth is the state of a dependency checked by the readiness probe.
+type componentHealth struct {
+	Status    string `json:"status" enum:"up down"`
+	LatencyMS int64  `json:"latency_ms"`
+	Version   int64  `json:"version,omitempty"`
+	Latest    int64  `json:"latest,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// healthcheckHandler answers liveness probes: it only reports that the process is serving
+// requests, whatever the state of its dependencies.
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
 
 	data := envelope{
@@ -16,7 +36,6 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		}}
 	resp, err := json.Marshal(data)
 	if err != nil {
-		app.logger.Info(err.Error())
 		app.serverErrorResponse(w, r, err)
 		return
 	}
@@ -25,3 +44,59 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 	w.Write(resp)
 
 }
+
+// readinessHandler answers readiness probes: it checks that the database answers and that
+// its schema is up to date, reporting 503 Service Unavailable when either is not.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	components := map[string]componentHealth{
+		"database":   app.checkDatabase(ctx),
+		"migrations": app.checkMigrations(ctx),
+	}
+	readiness, status := "ready", http.StatusOK
+	for _, component := range components {
+		if component.Status != "up" {
+			readiness, status = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	err := app.writeJson(w, status, envelope{"status": readiness, "components": components}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) checkDatabase(ctx context.Context) componentHealth {
+	if app.db == nil {
+		return componentHealth{Status: "down", Error: "no database configured"}
+	}
+	start := time.Now()
+	err := app.db.Ping(ctx)
+	health := componentHealth{Status: "up", LatencyMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		health.Status, health.Error = "down", err.Error()
+	}
+	return health
+}
+
+func (app *application) checkMigrations(ctx context.Context) componentHealth {
+	if app.db == nil {
+		return componentHealth{Status: "down", Error: "no database configured"}
+	}
+	start := time.Now()
+	migrator, err := migrate.New(app.db, migrations.FS)
+	if err != nil {
+		return componentHealth{Status: "down", Error: err.Error()}
+	}
+	health := componentHealth{Status: "up", Latest: migrator.Latest()}
+	// The probe only reads: a database without a migrations table is not ready.
+	version, dirty, err := migrator.ReadVersion(ctx)
+	health.Version = version
+	err = migrator.CheckVersion(version, dirty, err)
+	health.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		health.Status, health.Error = "down", err.Error()
+	}
+	return health
+}
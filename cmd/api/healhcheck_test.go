@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/themilar/plibrary/internal/e2etest"
+	"github.com/themilar/plibrary/internal/migrate"
+)
+
+type readiness struct {
+	Status     string                     `json:"status"`
+	Components map[string]componentHealth `json:"components"`
+}
+
+func getReadiness(t *testing.T, app *application) (int, readiness) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/health/ready", nil))
+	var body readiness
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("GET /v1/health/ready: %v; body %s", err, rr.Body)
+	}
+	if got := rr.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+	return rr.Code, body
+}
+
+func TestHealthcheck(t *testing.T) {
+	app := newMemoryApplication(t, config{env: "testing"})
+	for _, path := range []string{"/v1/healthcheck", "/v1/health/live"} {
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		var body struct {
+			Status     string            `json:"status"`
+			SystemInfo map[string]string `json:"system_info"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d, body %s", path, rr.Code, rr.Body)
+		}
+		if body.Status != "available" || body.SystemInfo["environment"] != "testing" {
+			t.Errorf("GET %s = %+v", path, body)
+		}
+	}
+
+	// Liveness ignores the database; readiness does not.
+	code, body := getReadiness(t, app)
+	if code != http.StatusServiceUnavailable || body.Status != "unavailable" {
+		t.Errorf("readiness without a database: status %d, %+v; want 503, unavailable", code, body)
+	}
+	for _, name := range []string{"database", "migrations"} {
+		if body.Components[name].Status != "down" {
+			t.Errorf("component %s = %+v, want down", name, body.Components[name])
+		}
+	}
+}
+
+func TestReadinessMigrations(t *testing.T) {
+	db := e2etest.NewDatabase(t)
+	app := newApplication(config{}, e2etest.NewLogger(t), db)
+
+	code, body := getReadiness(t, app)
+	migrations := body.Components["migrations"]
+	if code != http.StatusOK || body.Status != "ready" || migrations.Version == 0 || migrations.Version != migrations.Latest {
+		t.Fatalf("readiness of a migrated database: status %d, %+v", code, body)
+	}
+
+	// A database that has never been migrated is not ready, and the probe leaves it as it was.
+	if _, err := db.Exec(context.Background(), `DROP TABLE schema_migrations`); err != nil {
+		t.Fatal(err)
+	}
+	code, body = getReadiness(t, app)
+	migrations = body.Components["migrations"]
+	if code != http.StatusServiceUnavailable || migrations.Status != "down" || migrations.Error != migrate.ErrNotMigrated.Error() {
+		t.Errorf("readiness without schema_migrations: status %d, %+v; want 503 with %q", code, migrations, migrate.ErrNotMigrated)
+	}
+	var exists bool
+	if err := db.QueryRow(context.Background(), `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil || exists {
+		t.Errorf("schema_migrations exists after the probe: %v, %v", exists, err)
+	}
+}
@@ -2,67 +2,165 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
+	"github.com/themilar/plibrary/internal/logging"
+	"github.com/themilar/plibrary/internal/migrate"
 	"github.com/themilar/plibrary/internal/models"
+	"github.com/themilar/plibrary/migrations"
 )
 
-var version string = "1.0.0"
+//go:generate go run . -openapi-out ../../openapi.json
 
-type config struct {
-	port int
-	env  string
-	db   struct {
-		dsn string
-	}
-	limiter struct {
-		enabled bool
-		rpm     int
-	}
-}
+var version string = "1.0.0"
 
 type application struct {
-	config config
-	logger *slog.Logger
-	models models.Models
+	config      config
+	logger      *slog.Logger
+	db          *pgxpool.Pool
+	models      models.Models
+	changes     *changeHub
+	circulation *circulationHub
+	metrics     *metrics
+	// webhookClient sends webhook deliveries.
+	webhookClient *http.Client
 }
 
 func main() {
-	var cfg config
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	// Variables set in .env are read as if they were set in the environment.
 	err := godotenv.Load()
 	if err != nil {
 		logger.Error("Error loading env file")
 	}
 
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
-	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("DATABASE_URL"), "PostgreSQL DSN")
-	flag.BoolVar(&cfg.limiter.enabled, "limitenabled", true, "Enable rate limiter")
-	flag.IntVar(&cfg.limiter.rpm, "limitrpm", 50, "rate limiter maximum requests per minute")
-	flag.Parse()
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	openAPIOut := fs.String("openapi-out", "", "Write the OpenAPI document to this file and exit")
+	cfg, err := loadConfig(fs, os.Args[1:], os.Getenv)
+	if err != nil {
+		logger.Error("invalid configuration", "error", err.Error())
+		os.Exit(1)
+	}
 
-	db, err := pgxpool.New(context.Background(), cfg.db.dsn)
+	logger, err = logging.New(os.Stdout, cfg.log.level, cfg.log.format)
 	if err != nil {
-		logger.Error(err.Error())
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	if *openAPIOut != "" {
+		app := &application{config: cfg, logger: logger, metrics: newMetrics(nil, models.Models{})}
+		resp, err := json.MarshalIndent(app.openAPISpec(), "", "\t")
+		if err == nil {
+			err = os.WriteFile(*openAPIOut, append(resp, '\n'), 0o644)
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if err := cfg.validate(); err != nil {
+		logger.Error("invalid configuration", "error", err.Error())
+		os.Exit(1)
+	}
+
+	shutdownTracing, err := setupTracing(cfg)
+	if err != nil {
+		logger.Error("could not set up tracing", "error", err.Error())
+		os.Exit(1)
 	}
+
+	db, err := openDB(cfg, logger)
+	if err != nil {
+		logger.Error("could not connect to the database", "error", err.Error())
+		os.Exit(1)
+	}
+	// Close waits for the connections in use to be released, so that queries still running at
+	// shutdown finish or are abandoned before the process exits.
 	defer db.Close()
 	logger.Info("Database connection pool established")
 
-	app := &application{
-		config: cfg,
-		logger: logger,
-		models: models.NewModels(db),
+	if cfg.db.checkSchema {
+		migrator, err := migrate.New(db, migrations.FS)
+		if err == nil {
+			err = migrator.Check(context.Background())
+		}
+		if err != nil {
+			logger.Error("database schema check failed, run the admin migrate command", "error", err.Error())
+			os.Exit(1)
+		}
 	}
 
+	app := newApplication(cfg, logger, db)
 	err = app.serve()
 	if err != nil {
 		logger.Error(err.Error())
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("could not flush traces", "error", err.Error())
+	}
+
+}
 
+// newApplication wires the application to its database, logging to logger.
+func newApplication(cfg config, logger *slog.Logger, db *pgxpool.Pool) *application {
+	app := &application{
+		config:      cfg,
+		logger:      logger,
+		db:          db,
+		models:      models.NewModels(db),
+		changes:     newChangeHub(),
+		circulation: newCirculationHub(),
+	}
+	app.webhookClient = newWebhookClient(cfg.webhooks.allowPrivateTargets)
+	app.models.Books = models.BookModel{DB: db, QueryTimeout: cfg.db.queryTimeout}
+	app.metrics = newMetrics(db, app.models)
+	return app
+}
+
+// openDB connects to the database, retrying with an increasing delay until it answers or
+// the connect timeout runs out, so that the server never starts without it.
+func openDB(cfg config, logger *slog.Logger) (*pgxpool.Pool, error) {
+	db, err := pgxpool.New(context.Background(), cfg.db.dsn)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.db.connectTimeout)
+	defer cancel()
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		pingErr := db.Ping(ctx)
+		if pingErr == nil {
+			return db, nil
+		}
+		// A ping cut short by the timeout says less than the failure before it.
+		if ctx.Err() != nil && err != nil {
+			break
+		}
+		err = pingErr
+		if ctx.Err() != nil {
+			break
+		}
+		logger.Warn("database unreachable", "attempt", attempt, "error", err.Error(), "retry_in", backoff.String())
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 10*time.Second)
+	}
+	db.Close()
+	return nil, fmt.Errorf("database unreachable after %s: %w", cfg.db.connectTimeout, err)
 }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/themilar/plibrary/internal/models"
+)
+
+// newTestApplication returns an application without a database, for the handlers and
+// helpers that never reach one.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+	return &application{
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		metrics: newMetrics(nil, models.Models{}),
+	}
+}
+
+// newMemoryApplication returns an application with cfg whose books are kept in memory,
+// starting with three of them.
+func newMemoryApplication(t *testing.T, cfg config) *application {
+	t.Helper()
+	books := models.NewMemoryBookModel()
+	for _, book := range []*models.Book{
+		{Title: "Dune", Authors: []string{"Frank Herbert"}, Published: 1965, Pages: 412, Genres: []string{"sci-fi"}},
+		{Title: "Children Of Dune", Authors: []string{"Frank Herbert"}, Published: 1976, Pages: 444, Genres: []string{"sci-fi"}},
+		{Title: "The Hobbit", Authors: []string{"J. R. R. Tolkien"}, Published: 1937, Pages: 310, Genres: []string{"fantasy"}},
+	} {
+		if err := books.Insert(context.Background(), book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := &application{
+		config:      cfg,
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models:      models.Models{Books: books},
+		changes:     newChangeHub(),
+		circulation: newCirculationHub(),
+	}
+	app.metrics = newMetrics(nil, app.models)
+	return app
+}
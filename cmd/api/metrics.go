@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/themilar/plibrary/internal/models"
+)
+
+const metricsNamespace = "plibrary"
+
+// statsMaxAge is how long catalogue totals are reused between scrapes, so that frequent
+// scrapes do not each count every book.
+const statsMaxAge = 30 * time.Second
+
+// metrics holds the Prometheus collectors of the application.
+type metrics struct {
+	registry    *prometheus.Registry
+	requests    *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	inFlight    prometheus.Gauge
+	rateLimited prometheus.Counter
+}
+
+func newMetrics(db *pgxpool.Pool, m models.Models) *metrics {
+	registry := prometheus.NewRegistry()
+	metrics := &metrics{
+		registry: registry,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "http_requests_total",
+			Help: "HTTP requests by method, route pattern and status code.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace, Name: "http_request_duration_seconds",
+			Help:    "Time taken to serve HTTP requests by method and route pattern.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Name: "http_requests_in_flight",
+			Help: "HTTP requests being served.",
+		}),
+		rateLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "rate_limited_requests_total",
+			Help: "Requests rejected by the rate limiter.",
+		}),
+	}
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		metrics.requests, metrics.duration, metrics.inFlight, metrics.rateLimited,
+	)
+	if db != nil {
+		registry.MustRegister(poolCollector{db}, &statsCollector{models: m})
+	}
+	return metrics
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrument records every request under the route pattern it matched, rather than its URL,
+// so that ids in paths do not create a series each.
+func (m *metrics) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.requests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		m.duration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+var (
+	poolAcquiredDesc = prometheus.NewDesc(metricsNamespace+"_db_pool_acquired_connections", "Connections in use.", nil, nil)
+	poolIdleDesc     = prometheus.NewDesc(metricsNamespace+"_db_pool_idle_connections", "Idle connections.", nil, nil)
+	poolTotalDesc    = prometheus.NewDesc(metricsNamespace+"_db_pool_connections", "Open connections.", nil, nil)
+	poolMaxDesc      = prometheus.NewDesc(metricsNamespace+"_db_pool_max_connections", "Maximum size of the pool.", nil, nil)
+	poolAcquireDesc  = prometheus.NewDesc(metricsNamespace+"_db_pool_acquires_total", "Connections acquired from the pool.", nil, nil)
+	poolWaitDesc     = prometheus.NewDesc(metricsNamespace+"_db_pool_acquire_waits_total", "Acquires that had to wait for a connection.", nil, nil)
+	poolWaitTimeDesc = prometheus.NewDesc(metricsNamespace+"_db_pool_acquire_wait_seconds_total", "Time spent waiting for a connection.", nil, nil)
+)
+
+// poolCollector reports the statistics of the database connection pool.
+type poolCollector struct {
+	db *pgxpool.Pool
+}
+
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.db.Stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquiredDesc, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleDesc, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalDesc, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxDesc, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireDesc, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolWaitDesc, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolWaitTimeDesc, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
+
+var (
+	booksDesc       = prometheus.NewDesc(metricsNamespace+"_books", "Books in the catalogue.", nil, nil)
+	copiesDesc      = prometheus.NewDesc(metricsNamespace+"_copies", "Copies by status.", []string{"status"}, nil)
+	activeLoansDesc = prometheus.NewDesc(metricsNamespace+"_active_loans", "Copies on loan.", nil, nil)
+)
+
+// statsCollector reports catalogue totals, refreshed at most every statsMaxAge.
+type statsCollector struct {
+	models  models.Models
+	mu      sync.Mutex
+	stats   *models.Stats
+	fetched time.Time
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- booksDesc
+	ch <- copiesDesc
+	ch <- activeLoansDesc
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stats == nil || time.Since(c.fetched) > statsMaxAge {
+		stats, err := c.models.Stats()
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(booksDesc, err)
+			return
+		}
+		c.stats, c.fetched = stats, time.Now()
+	}
+	ch <- prometheus.MustNewConstMetric(booksDesc, prometheus.GaugeValue, float64(c.stats.Books))
+	for status, count := range c.stats.Copies {
+		ch <- prometheus.MustNewConstMetric(copiesDesc, prometheus.GaugeValue, float64(count), status)
+	}
+	ch <- prometheus.MustNewConstMetric(activeLoansDesc, prometheus.GaugeValue, float64(c.stats.Copies["on_loan"]))
+}
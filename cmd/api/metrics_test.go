@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/themilar/plibrary/internal/e2etest"
+	"github.com/themilar/plibrary/internal/models"
+)
+
+func scrape(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /metrics: status %d", rr.Code)
+	}
+	return rr.Body.String()
+}
+
+func TestMetricsInstrument(t *testing.T) {
+	app := newMemoryApplication(t, config{})
+	handler := app.routes()
+	for _, target := range []string{"/v1/books/1", "/v1/books/2", "/v1/books/99", "/v1/books", "/no/such/page"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	body := scrape(t, handler)
+	for _, want := range []string{
+		`plibrary_http_requests_total{method="GET",route="/v1/books/{id}",status="202"} 2`,
+		`plibrary_http_requests_total{method="GET",route="/v1/books/{id}",status="404"} 1`,
+		`plibrary_http_requests_total{method="GET",route="/v1/books",status="200"} 1`,
+		`plibrary_http_request_duration_seconds_count{method="GET",route="/v1/books/{id}"} 3`,
+		// The scrape itself is in flight.
+		`plibrary_http_requests_in_flight 1`,
+		`plibrary_rate_limited_requests_total 0`,
+		"go_goroutines ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics are missing %s", want)
+		}
+	}
+	if strings.Contains(body, `route="/v1/books/1"`) || strings.Contains(body, "/no/such/page") {
+		t.Error("requests are recorded under their URL rather than their route pattern")
+	}
+	// Database metrics are only registered with a database.
+	if strings.Contains(body, "plibrary_db_pool") || strings.Contains(body, "plibrary_books ") {
+		t.Error("database metrics are reported without a database")
+	}
+}
+
+func TestMetricsRateLimited(t *testing.T) {
+	var cfg config
+	cfg.limiter.enabled = true
+	cfg.limiter.rpm = 1
+	app := newMemoryApplication(t, cfg)
+	handler := app.routes()
+	for range 3 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/books/1", nil))
+	}
+	if got := testutil.ToFloat64(app.metrics.rateLimited); got != 2 {
+		t.Errorf("rate limited requests = %v, want 2", got)
+	}
+	// The limiter answers before routing, so rejections have no route pattern.
+	if got := testutil.ToFloat64(app.metrics.requests.WithLabelValues("GET", "unmatched", "429")); got != 2 {
+		t.Errorf("requests counted with status 429 = %v, want 2", got)
+	}
+}
+
+func TestMetricsDatabase(t *testing.T) {
+	db := e2etest.NewDatabase(t)
+	app := newApplication(config{}, e2etest.NewLogger(t), db)
+	book := &models.Book{Title: "Dune", Published: 1965, Pages: 412, Genres: []string{"sci-fi"}}
+	if err := app.models.Books.Insert(context.Background(), book); err != nil {
+		t.Fatal(err)
+	}
+
+	body := scrape(t, app.routes())
+	for _, want := range []string{
+		"plibrary_books 1\n",
+		`plibrary_copies{status="available"} 0`,
+		"plibrary_active_loans 0\n",
+		"plibrary_db_pool_max_connections ",
+		"plibrary_db_pool_acquires_total ",
+		"plibrary_db_pool_acquire_wait_seconds_total ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics are missing %q", want)
+		}
+	}
+
+	// Totals are reused between scrapes for statsMaxAge.
+	book = &models.Book{Title: "Dune Messiah", Published: 1969, Pages: 256, Genres: []string{"sci-fi"}}
+	if err := app.models.Books.Insert(context.Background(), book); err != nil {
+		t.Fatal(err)
+	}
+	if body := scrape(t, app.routes()); !strings.Contains(body, "plibrary_books 1\n") {
+		t.Error("the book total was counted again within statsMaxAge")
+	}
+}
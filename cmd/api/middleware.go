@@ -1,10 +1,112 @@
 package main
 
-import "net/http"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
 
-func (app *application) requestLogger(next http.Handler) http.Handler {
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/themilar/plibrary/internal/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	requestIDMaxLength = 128
+)
+
+// validRequestID reports whether a client supplied request id can be used as is: it must be
+// short and printable ASCII without spaces, so that it is safe to log and echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > requestIDMaxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestID tags every request with an id, the X-Request-ID sent by the client when it is
+// usable. The id is echoed in the response and added to the logger of the request, along
+// with the trace id, so that everything logged for a request can be found together.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		logger := app.logger.With("request_id", id)
+		if span := trace.SpanFromContext(r.Context()); span.SpanContext().IsValid() {
+			span.SetAttributes(attribute.String("http.request.id", id))
+			logger = logger.With("trace_id", span.SpanContext().TraceID().String())
+		}
+		next.ServeHTTP(w, r.WithContext(logging.WithLogger(r.Context(), logger)))
+	})
+}
+
+// logRequests logs every request once it has been served, with the status, size and time
+// taken to send the response. Server errors are logged at error level.
+func (app *application) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		var route string
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			route = rctx.RoutePattern()
+		}
+		logging.FromContext(r.Context()).LogAttrs(r.Context(), level, "request served",
+			slog.String("method", r.Method),
+			slog.String("uri", r.RequestURI),
+			slog.String("route", route),
+			slog.String("protocol", r.Proto),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("user_agent", r.UserAgent()),
+			slog.Int("status", status),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.String("duration", time.Since(start).String()),
+		)
+	})
+}
+
+// requireStaff lets through the requests bearing a staff token. Without staff tokens
+// configured, the routes it guards are hidden.
+func (app *application) requireStaff(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.logger.Info("logging request", "remote address", r.RemoteAddr, "protocol", r.Proto, "method", r.Method, "uri", r.RequestURI)
+		if strings.TrimSpace(app.config.auth.staffTokens) == "" {
+			app.notFoundErrorResponse(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !app.staffToken(token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.errorResponse(w, r, http.StatusUnauthorized, errCodeUnauthorized, "invalid or missing authentication token")
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
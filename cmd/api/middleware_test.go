@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/themilar/plibrary/internal/logging"
+)
+
+// logEntries decodes the JSON lines logged to buf.
+func logEntries(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var entries []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var entry map[string]any
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestValidRequestID(t *testing.T) {
+	for id, valid := range map[string]bool{
+		"req-1":                                 true,
+		"4bf92f3577b34da6a3ce929d0e0e4736":      true,
+		"":                                      false,
+		"two words":                             false,
+		"line\nbreak":                           false,
+		"café":                                  false,
+		strings.Repeat("x", requestIDMaxLength): true,
+		strings.Repeat("x", requestIDMaxLength+1): false,
+	} {
+		if validRequestID(id) != valid {
+			t.Errorf("validRequestID(%q) = %t, want %t", id, !valid, valid)
+		}
+	}
+	if id := newRequestID(); len(id) != 32 || !validRequestID(id) || id == newRequestID() {
+		t.Errorf("newRequestID() = %q", id)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	app := newMemoryApplication(t, config{})
+	app.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	var seen string
+	handler := app.requestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Info("handling")
+		seen = w.Header().Get(requestIDHeader)
+	}))
+
+	for sent, kept := range map[string]bool{"req-1": true, "": false, "has spaces": false} {
+		logs.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/v1/books", nil)
+		if sent != "" {
+			req.Header.Set(requestIDHeader, sent)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		id := rr.Header().Get(requestIDHeader)
+		if (id == sent) != kept || !validRequestID(id) || seen != id {
+			t.Errorf("request id %q: response has %q", sent, id)
+		}
+		if entries := logEntries(t, &logs); len(entries) != 1 || entries[0]["request_id"] != id {
+			t.Errorf("request id %q: handler logged %v", sent, entries)
+		}
+	}
+}
+
+func TestLogRequests(t *testing.T) {
+	var logs bytes.Buffer
+	app := newMemoryApplication(t, config{})
+	app.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	handler := app.routes()
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/books/1?fields=title", nil)
+	req.Header.Set(requestIDHeader, "req-1")
+	req.Header.Set("User-Agent", "plib/1.0")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	entries := logEntries(t, &logs)
+	if len(entries) != 1 {
+		t.Fatalf("logged %d entries for one request, want 1: %v", len(entries), entries)
+	}
+	entry := entries[0]
+	for key, want := range map[string]any{
+		"level":      "INFO",
+		"msg":        "request served",
+		"request_id": "req-1",
+		"method":     "GET",
+		"uri":        "/v1/books/1?fields=title",
+		"route":      "/v1/books/{id}",
+		"user_agent": "plib/1.0",
+		"status":     float64(http.StatusAccepted),
+		"bytes":      float64(rr.Body.Len()),
+	} {
+		if entry[key] != want {
+			t.Errorf("access log %s = %v, want %v", key, entry[key], want)
+		}
+	}
+	if _, ok := entry["duration"].(string); !ok {
+		t.Errorf("access log has no duration: %v", entry)
+	}
+}
+
+func TestLogRequestsServerError(t *testing.T) {
+	var logs bytes.Buffer
+	app := newMemoryApplication(t, config{})
+	app.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	handler := app.requestID(app.logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.serverErrorResponse(w, r, errors.New("disk full"))
+	})))
+	req := httptest.NewRequest(http.MethodGet, "/v1/books", nil)
+	req.Header.Set(requestIDHeader, "req-2")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := logEntries(t, &logs)
+	if len(entries) != 2 {
+		t.Fatalf("logged %v, want the error and the request", entries)
+	}
+	// The error is logged with the request it happened in.
+	if entries[0]["msg"] != "disk full" || entries[0]["request_id"] != "req-2" || entries[0]["request_url"] != "/v1/books" {
+		t.Errorf("error log = %v", entries[0])
+	}
+	if entries[1]["level"] != "ERROR" || entries[1]["status"] != float64(http.StatusInternalServerError) {
+		t.Errorf("access log of a server error = %v", entries[1])
+	}
+}
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/themilar/plibrary/internal/models"
+)
+
+const (
+	oaiPageSize          = 100
+	oaiIdentifierPrefix  = "oai:plibrary:"
+	oaiDatestampLayout   = "2006-01-02T15:04:05Z"
+	oaiDayLayout         = "2006-01-02"
+	oaiDCMetadataPrefix  = "oai_dc"
+	oaiDCNamespace       = "http://www.openarchives.org/OAI/2.0/oai_dc/"
+	dublinCoreNamespace  = "http://purl.org/dc/elements/1.1/"
+	xmlSchemaInstanceNS  = "http://www.w3.org/2001/XMLSchema-instance"
+	oaiPMHSchemaLocation = "http://www.openarchives.org/OAI/2.0/ http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd"
+)
+
+// oaiVerbArguments lists, for every supported verb, the arguments it accepts and whether
+// each one is required. resumptionToken is exclusive and handled separately.
+var oaiVerbArguments = map[string]map[string]bool{
+	"Identify":            {},
+	"ListMetadataFormats": {"identifier": false},
+	"ListSets":            {"resumptionToken": false},
+	"GetRecord":           {"identifier": true, "metadataPrefix": true},
+	"ListIdentifiers":     {"metadataPrefix": true, "from": false, "until": false, "set": false, "resumptionToken": false},
+	"ListRecords":         {"metadataPrefix": true, "from": false, "until": false, "set": false, "resumptionToken": false},
+}
+
+type oaiError struct {
+	Code    string `xml:"code,attr"`
+	Message string `xml:",chardata"`
+}
+
+func (e *oaiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+type oaiRequest struct {
+	Verb            string `xml:"verb,attr,omitempty"`
+	Identifier      string `xml:"identifier,attr,omitempty"`
+	MetadataPrefix  string `xml:"metadataPrefix,attr,omitempty"`
+	From            string `xml:"from,attr,omitempty"`
+	Until           string `xml:"until,attr,omitempty"`
+	Set             string `xml:"set,attr,omitempty"`
+	ResumptionToken string `xml:"resumptionToken,attr,omitempty"`
+	BaseURL         string `xml:",chardata"`
+}
+
+type oaiIdentify struct {
+	RepositoryName    string `xml:"repositoryName"`
+	BaseURL           string `xml:"baseURL"`
+	ProtocolVersion   string `xml:"protocolVersion"`
+	AdminEmail        string `xml:"adminEmail"`
+	EarliestDatestamp string `xml:"earliestDatestamp"`
+	DeletedRecord     string `xml:"deletedRecord"`
+	Granularity       string `xml:"granularity"`
+}
+
+type oaiMetadataFormat struct {
+	MetadataPrefix    string `xml:"metadataPrefix"`
+	Schema            string `xml:"schema"`
+	MetadataNamespace string `xml:"metadataNamespace"`
+}
+
+type oaiSet struct {
+	SetSpec string `xml:"setSpec"`
+	SetName string `xml:"setName"`
+}
+
+type oaiHeader struct {
+	Identifier string   `xml:"identifier"`
+	Datestamp  string   `xml:"datestamp"`
+	SetSpecs   []string `xml:"setSpec"`
+}
+
+// dublinCore is a simple Dublin Core description of a book. The wrapping element and its
+// namespace declarations depend on the protocol it is served through (oai_dc, SRU).
+type dublinCore struct {
+	XmlnsOAIDC     string   `xml:"xmlns:oai_dc,attr,omitempty"`
+	XmlnsSRWDC     string   `xml:"xmlns:srw_dc,attr,omitempty"`
+	XmlnsDC        string   `xml:"xmlns:dc,attr"`
+	XmlnsXSI       string   `xml:"xmlns:xsi,attr,omitempty"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr,omitempty"`
+	Title          string   `xml:"dc:title"`
+	Creators       []string `xml:"dc:creator"`
+	Publisher      string   `xml:"dc:publisher,omitempty"`
+	Date           string   `xml:"dc:date"`
+	Subjects       []string `xml:"dc:subject"`
+	Type           string   `xml:"dc:type"`
+	Format         string   `xml:"dc:format,omitempty"`
+	Identifiers    []string `xml:"dc:identifier"`
+}
+
+type oaiRecord struct {
+	Header   oaiHeader `xml:"header"`
+	Metadata struct {
+		DC *dublinCore `xml:"oai_dc:dc"`
+	} `xml:"metadata"`
+}
+
+type oaiResumptionToken struct {
+	CompleteListSize int    `xml:"completeListSize,attr"`
+	Cursor           int    `xml:"cursor,attr"`
+	Token            string `xml:",chardata"`
+}
+
+type oaiList struct {
+	Headers         []oaiHeader         `xml:"header"`
+	Records         []oaiRecord         `xml:"record"`
+	Sets            []oaiSet            `xml:"set"`
+	MetadataFormats []oaiMetadataFormat `xml:"metadataFormat"`
+	ResumptionToken *oaiResumptionToken `xml:"resumptionToken,omitempty"`
+}
+
+type oaiResponse struct {
+	XMLName             xml.Name     `xml:"http://www.openarchives.org/OAI/2.0/ OAI-PMH"`
+	XmlnsXSI            string       `xml:"xmlns:xsi,attr"`
+	SchemaLocation      string       `xml:"xsi:schemaLocation,attr"`
+	ResponseDate        string       `xml:"responseDate"`
+	Request             oaiRequest   `xml:"request"`
+	Errors              []*oaiError  `xml:"error"`
+	Identify            *oaiIdentify `xml:"Identify,omitempty"`
+	ListMetadataFormats *oaiList     `xml:"ListMetadataFormats,omitempty"`
+	ListSets            *oaiList     `xml:"ListSets,omitempty"`
+	GetRecord           *oaiList     `xml:"GetRecord,omitempty"`
+	ListIdentifiers     *oaiList     `xml:"ListIdentifiers,omitempty"`
+	ListRecords         *oaiList     `xml:"ListRecords,omitempty"`
+}
+
+// oaiHarvest holds the selective harvesting arguments of a list request. It is also the
+// state carried in resumption tokens, together with the position reached so far.
+type oaiHarvest struct {
+	metadataPrefix string
+	from, until    *time.Time
+	fromArg        string
+	untilArg       string
+	set            string
+	genre          string
+	afterID        int64
+	cursor         int
+}
+
+func (h oaiHarvest) token() string {
+	v := url.Values{}
+	v.Set("metadataPrefix", h.metadataPrefix)
+	v.Set("from", h.fromArg)
+	v.Set("until", h.untilArg)
+	v.Set("set", h.set)
+	v.Set("after", strconv.FormatInt(h.afterID, 10))
+	v.Set("cursor", strconv.Itoa(h.cursor))
+	return base64.RawURLEncoding.EncodeToString([]byte(v.Encode()))
+}
+
+func parseOAIResumptionToken(token string) (oaiHarvest, error) {
+	badToken := &oaiError{Code: "badResumptionToken", Message: "the value of the resumptionToken argument is invalid or expired"}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return oaiHarvest{}, badToken
+	}
+	v, err := url.ParseQuery(string(raw))
+	if err != nil {
+		return oaiHarvest{}, badToken
+	}
+	h, err := parseOAIHarvest(v.Get("metadataPrefix"), v.Get("from"), v.Get("until"), v.Get("set"))
+	if err != nil {
+		return oaiHarvest{}, badToken
+	}
+	h.afterID, err = strconv.ParseInt(v.Get("after"), 10, 64)
+	if err != nil || h.afterID < 0 {
+		return oaiHarvest{}, badToken
+	}
+	h.cursor, err = strconv.Atoi(v.Get("cursor"))
+	if err != nil || h.cursor < 0 {
+		return oaiHarvest{}, badToken
+	}
+	return h, nil
+}
+
+// parseOAIDatestamp accepts both day and seconds granularity. Day granularity is widened to
+// the end of the day for until so that the bound stays inclusive.
+func parseOAIDatestamp(value string, until bool) (*time.Time, string, error) {
+	if value == "" {
+		return nil, "", nil
+	}
+	if t, err := time.Parse(oaiDatestampLayout, value); err == nil {
+		return &t, oaiDatestampLayout, nil
+	}
+	t, err := time.Parse(oaiDayLayout, value)
+	if err != nil {
+		return nil, "", &oaiError{Code: "badArgument", Message: fmt.Sprintf("%q is not a valid datestamp", value)}
+	}
+	if until {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return &t, oaiDayLayout, nil
+}
+
+func parseOAIHarvest(metadataPrefix, from, until, set string) (oaiHarvest, error) {
+	if metadataPrefix != oaiDCMetadataPrefix {
+		return oaiHarvest{}, &oaiError{Code: "cannotDisseminateFormat", Message: fmt.Sprintf("the metadata format %q is not supported", metadataPrefix)}
+	}
+	h := oaiHarvest{metadataPrefix: metadataPrefix, fromArg: from, untilArg: until, set: set}
+	var fromLayout, untilLayout string
+	var err error
+	if h.from, fromLayout, err = parseOAIDatestamp(from, false); err != nil {
+		return oaiHarvest{}, err
+	}
+	if h.until, untilLayout, err = parseOAIDatestamp(until, true); err != nil {
+		return oaiHarvest{}, err
+	}
+	if h.from != nil && h.until != nil {
+		if fromLayout != untilLayout {
+			return oaiHarvest{}, &oaiError{Code: "badArgument", Message: "from and until must have the same granularity"}
+		}
+		if h.from.After(*h.until) {
+			return oaiHarvest{}, &oaiError{Code: "badArgument", Message: "from must not be later than until"}
+		}
+	}
+	if set != "" {
+		genre, err := url.PathUnescape(set)
+		if err != nil {
+			return oaiHarvest{}, &oaiError{Code: "badArgument", Message: fmt.Sprintf("%q is not a valid setSpec", set)}
+		}
+		h.genre = genre
+	}
+	return h, nil
+}
+
+func oaiBookIdentifier(book *models.Book) string {
+	return oaiIdentifierPrefix + strconv.FormatInt(book.ID, 10)
+}
+
+func parseOAIIdentifier(identifier string) (int64, error) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(identifier, oaiIdentifierPrefix), 10, 64)
+	if !strings.HasPrefix(identifier, oaiIdentifierPrefix) || err != nil || id < 1 {
+		return 0, &oaiError{Code: "idDoesNotExist", Message: fmt.Sprintf("%q is unknown or illegal in this repository", identifier)}
+	}
+	return id, nil
+}
+
+func oaiBookHeader(book *models.Book) oaiHeader {
+	header := oaiHeader{
+		Identifier: oaiBookIdentifier(book),
+		Datestamp:  book.UpdatedAt.UTC().Format(oaiDatestampLayout),
+	}
+	for _, genre := range book.Genres {
+		header.SetSpecs = append(header.SetSpecs, url.PathEscape(genre))
+	}
+	return header
+}
+
+func bookDublinCore(book *models.Book, baseURL string) *dublinCore {
+	dc := &dublinCore{
+		XmlnsDC:     dublinCoreNamespace,
+		Title:       book.Title,
+		Creators:    book.Authors,
+		Publisher:   book.Publisher,
+		Date:        strconv.Itoa(book.Published),
+		Subjects:    book.Genres,
+		Type:        "Text",
+		Identifiers: []string{fmt.Sprintf("%s/v1/books/%d", baseURL, book.ID)},
+	}
+	if book.Pages > 0 {
+		dc.Format = fmt.Sprintf("%d pages", book.Pages)
+	}
+	return dc
+}
+
+func oaiBookRecord(book *models.Book, baseURL string) oaiRecord {
+	record := oaiRecord{Header: oaiBookHeader(book)}
+	record.Metadata.DC = bookDublinCore(book, baseURL)
+	record.Metadata.DC.XmlnsOAIDC = oaiDCNamespace
+	record.Metadata.DC.XmlnsXSI = xmlSchemaInstanceNS
+	record.Metadata.DC.SchemaLocation = oaiDCNamespace + " http://www.openarchives.org/OAI/2.0/oai_dc.xsd"
+	return record
+}
+
+// requestBaseURL reconstructs the scheme and host the client used to reach the server.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// oaiHandler implements the OAI-PMH 2.0 protocol. Protocol errors are reported inside the
+// OAI-PMH document with a 200 status, as the specification requires.
+func (app *application) oaiHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+	baseURL := requestBaseURL(r)
+	resp := &oaiResponse{
+		XmlnsXSI:       xmlSchemaInstanceNS,
+		SchemaLocation: oaiPMHSchemaLocation,
+		ResponseDate:   time.Now().UTC().Format(oaiDatestampLayout),
+		Request:        oaiRequest{BaseURL: baseURL + "/oai"},
+	}
+	err = app.oaiDispatch(r.Context(), resp, r.Form, baseURL)
+	if err != nil {
+		var oaiErr *oaiError
+		if !errors.As(err, &oaiErr) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		resp.Errors = append(resp.Errors, oaiErr)
+		if oaiErr.Code == "badVerb" || oaiErr.Code == "badArgument" {
+			resp.Request = oaiRequest{BaseURL: resp.Request.BaseURL}
+		}
+	}
+	err = app.writeXML(w, http.StatusOK, resp, "text/xml; charset=utf-8", nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) oaiDispatch(ctx context.Context, resp *oaiResponse, args url.Values, baseURL string) error {
+	verb := args.Get("verb")
+	allowed, ok := oaiVerbArguments[verb]
+	if !ok || len(args["verb"]) > 1 {
+		return &oaiError{Code: "badVerb", Message: "the verb argument is missing, repeated or not a legal OAI-PMH verb"}
+	}
+	for key, values := range args {
+		if key == "verb" {
+			continue
+		}
+		if _, ok := allowed[key]; !ok {
+			return &oaiError{Code: "badArgument", Message: fmt.Sprintf("the argument %q is not allowed for %s", key, verb)}
+		}
+		if len(values) > 1 {
+			return &oaiError{Code: "badArgument", Message: fmt.Sprintf("the argument %q is repeated", key)}
+		}
+	}
+	token := args.Get("resumptionToken")
+	if token != "" && len(args) > 2 {
+		return &oaiError{Code: "badArgument", Message: "resumptionToken is an exclusive argument"}
+	}
+	if token == "" {
+		for key, required := range allowed {
+			if required && args.Get(key) == "" {
+				return &oaiError{Code: "badArgument", Message: fmt.Sprintf("the required argument %q is missing", key)}
+			}
+		}
+	}
+	resp.Request = oaiRequest{
+		Verb:            verb,
+		Identifier:      args.Get("identifier"),
+		MetadataPrefix:  args.Get("metadataPrefix"),
+		From:            args.Get("from"),
+		Until:           args.Get("until"),
+		Set:             args.Get("set"),
+		ResumptionToken: token,
+		BaseURL:         resp.Request.BaseURL,
+	}
+
+	switch verb {
+	case "Identify":
+		return app.oaiIdentify(ctx, resp, baseURL)
+	case "ListMetadataFormats":
+		return app.oaiListMetadataFormats(ctx, resp, args.Get("identifier"))
+	case "ListSets":
+		return app.oaiListSets(ctx, resp, token)
+	case "GetRecord":
+		return app.oaiGetRecord(ctx, resp, args.Get("identifier"), args.Get("metadataPrefix"), baseURL)
+	default:
+		var h oaiHarvest
+		var err error
+		if token != "" {
+			h, err = parseOAIResumptionToken(token)
+		} else {
+			h, err = parseOAIHarvest(args.Get("metadataPrefix"), args.Get("from"), args.Get("until"), args.Get("set"))
+		}
+		if err != nil {
+			return err
+		}
+		return app.oaiList(ctx, resp, verb, h, baseURL)
+	}
+}
+
+func (app *application) oaiIdentify(ctx context.Context, resp *oaiResponse, baseURL string) error {
+	earliest, err := app.models.Books.EarliestDatestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if earliest.IsZero() {
+		earliest = time.Now()
+	}
+	resp.Identify = &oaiIdentify{
+		RepositoryName:    "plibrary",
+		BaseURL:           baseURL + "/oai",
+		ProtocolVersion:   "2.0",
+		AdminEmail:        app.config.oai.adminEmail,
+		EarliestDatestamp: earliest.UTC().Format(oaiDatestampLayout),
+		DeletedRecord:     "no",
+		Granularity:       "YYYY-MM-DDThh:mm:ssZ",
+	}
+	return nil
+}
+
+func (app *application) oaiListMetadataFormats(ctx context.Context, resp *oaiResponse, identifier string) error {
+	if identifier != "" {
+		id, err := parseOAIIdentifier(identifier)
+		if err != nil {
+			return err
+		}
+		_, err = app.models.Books.Get(ctx, id)
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				return &oaiError{Code: "idDoesNotExist", Message: fmt.Sprintf("%q is unknown or illegal in this repository", identifier)}
+			}
+			return err
+		}
+	}
+	resp.ListMetadataFormats = &oaiList{MetadataFormats: []oaiMetadataFormat{{
+		MetadataPrefix:    oaiDCMetadataPrefix,
+		Schema:            "http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+		MetadataNamespace: oaiDCNamespace,
+	}}}
+	return nil
+}
+
+func (app *application) oaiListSets(ctx context.Context, resp *oaiResponse, token string) error {
+	if token != "" {
+		return &oaiError{Code: "badResumptionToken", Message: "the value of the resumptionToken argument is invalid or expired"}
+	}
+	genres, err := app.models.Books.Genres(ctx)
+	if err != nil {
+		return err
+	}
+	resp.ListSets = &oaiList{}
+	for _, genre := range genres {
+		resp.ListSets.Sets = append(resp.ListSets.Sets, oaiSet{SetSpec: url.PathEscape(genre.Name), SetName: genre.Name})
+	}
+	return nil
+}
+
+func (app *application) oaiGetRecord(ctx context.Context, resp *oaiResponse, identifier, metadataPrefix, baseURL string) error {
+	id, err := parseOAIIdentifier(identifier)
+	if err != nil {
+		return err
+	}
+	book, err := app.models.Books.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			return &oaiError{Code: "idDoesNotExist", Message: fmt.Sprintf("%q is unknown or illegal in this repository", identifier)}
+		}
+		return err
+	}
+	if metadataPrefix != oaiDCMetadataPrefix {
+		return &oaiError{Code: "cannotDisseminateFormat", Message: fmt.Sprintf("the metadata format %q is not supported", metadataPrefix)}
+	}
+	resp.GetRecord = &oaiList{Records: []oaiRecord{oaiBookRecord(book, baseURL)}}
+	return nil
+}
+
+func (app *application) oaiList(ctx context.Context, resp *oaiResponse, verb string, h oaiHarvest, baseURL string) error {
+	books, total, err := app.models.Books.Harvest(ctx, h.from, h.until, h.genre, h.afterID, oaiPageSize)
+	if err != nil {
+		return err
+	}
+	if len(books) == 0 {
+		if h.cursor > 0 {
+			return &oaiError{Code: "badResumptionToken", Message: "the value of the resumptionToken argument is invalid or expired"}
+		}
+		return &oaiError{Code: "noRecordsMatch", Message: "the combination of arguments results in an empty list"}
+	}
+	list := &oaiList{}
+	for _, book := range books {
+		if verb == "ListIdentifiers" {
+			list.Headers = append(list.Headers, oaiBookHeader(book))
+		} else {
+			list.Records = append(list.Records, oaiBookRecord(book, baseURL))
+		}
+	}
+	// Incomplete lists carry a resumption token, which is left empty on the last page.
+	if h.cursor > 0 || len(books) < total {
+		next := h
+		next.afterID = books[len(books)-1].ID
+		next.cursor = h.cursor + len(books)
+		list.ResumptionToken = &oaiResumptionToken{CompleteListSize: total, Cursor: h.cursor}
+		if next.cursor < total {
+			list.ResumptionToken.Token = next.token()
+		}
+	}
+	if verb == "ListIdentifiers" {
+		resp.ListIdentifiers = list
+	} else {
+		resp.ListRecords = list
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/themilar/plibrary/internal/models"
+)
+
+// oaiDocument picks out of an OAI-PMH response what the tests compare.
+type oaiDocument struct {
+	Request struct {
+		Verb string `xml:"verb,attr"`
+	} `xml:"request"`
+	Errors []struct {
+		Code string `xml:"code,attr"`
+	} `xml:"error"`
+	Identify struct {
+		RepositoryName string `xml:"repositoryName"`
+		AdminEmail     string `xml:"adminEmail"`
+	} `xml:"Identify"`
+	Sets    []string `xml:"ListSets>set>setSpec"`
+	Formats []string `xml:"ListMetadataFormats>metadataFormat>metadataPrefix"`
+	Record  struct {
+		Identifier string `xml:"header>identifier"`
+		Title      string `xml:"metadata>dc>title"`
+	} `xml:"GetRecord>record"`
+	Identifiers     []string `xml:"ListIdentifiers>header>identifier"`
+	Titles          []string `xml:"ListRecords>record>metadata>dc>title"`
+	ResumptionToken *struct {
+		Token            string `xml:",chardata"`
+		CompleteListSize int    `xml:"completeListSize,attr"`
+		Cursor           int    `xml:"cursor,attr"`
+	} `xml:"ListIdentifiers>resumptionToken"`
+}
+
+func (doc oaiDocument) errorCode() string {
+	if len(doc.Errors) == 0 {
+		return ""
+	}
+	return doc.Errors[0].Code
+}
+
+func getOAI(t *testing.T, handler http.Handler, query string) oaiDocument {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/oai?"+query, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /oai?%s: status %d; body %s", query, rr.Code, rr.Body)
+	}
+	var doc oaiDocument
+	if err := xml.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("GET /oai?%s: %v; body %s", query, err, rr.Body)
+	}
+	return doc
+}
+
+func TestOAIVerbs(t *testing.T) {
+	var cfg config
+	cfg.oai.adminEmail = "catalogue@example.org"
+	handler := newMemoryApplication(t, cfg).routes()
+
+	identify := getOAI(t, handler, "verb=Identify")
+	if identify.errorCode() != "" || identify.Identify.AdminEmail != "catalogue@example.org" {
+		t.Errorf("Identify: error %q, adminEmail %q", identify.errorCode(), identify.Identify.AdminEmail)
+	}
+
+	sets := getOAI(t, handler, "verb=ListSets")
+	if got, want := strings.Join(sets.Sets, " "), "fantasy sci-fi"; got != want {
+		t.Errorf("ListSets = %s, want %s", got, want)
+	}
+
+	formats := getOAI(t, handler, "verb=ListMetadataFormats&identifier=oai:plibrary:1")
+	if got := strings.Join(formats.Formats, " "); got != "oai_dc" {
+		t.Errorf("ListMetadataFormats = %s, want oai_dc", got)
+	}
+
+	record := getOAI(t, handler, "verb=GetRecord&identifier=oai:plibrary:3&metadataPrefix=oai_dc")
+	if record.Record.Identifier != "oai:plibrary:3" || record.Record.Title != "The Hobbit" {
+		t.Errorf("GetRecord = %s titled %q, want oai:plibrary:3 titled The Hobbit", record.Record.Identifier, record.Record.Title)
+	}
+
+	identifiers := getOAI(t, handler, "verb=ListIdentifiers&metadataPrefix=oai_dc&set=sci-fi")
+	if got, want := strings.Join(identifiers.Identifiers, " "), "oai:plibrary:1 oai:plibrary:2"; got != want {
+		t.Errorf("ListIdentifiers in sci-fi = %s, want %s", got, want)
+	}
+	if identifiers.ResumptionToken != nil {
+		t.Errorf("complete list has resumption token %+v", identifiers.ResumptionToken)
+	}
+
+	records := getOAI(t, handler, "verb=ListRecords&metadataPrefix=oai_dc&from=2000-01-01")
+	if got, want := strings.Join(records.Titles, ", "), "Dune, Children Of Dune, The Hobbit"; got != want {
+		t.Errorf("ListRecords = %s, want %s", got, want)
+	}
+}
+
+func TestOAIErrors(t *testing.T) {
+	handler := newMemoryApplication(t, config{}).routes()
+
+	for _, test := range []struct {
+		query, code string
+	}{
+		{"", "badVerb"},
+		{"verb=Harvest", "badVerb"},
+		{"verb=Identify&verb=Identify", "badVerb"},
+		{"verb=Identify&set=sci-fi", "badArgument"},
+		{"verb=GetRecord&identifier=oai:plibrary:1", "badArgument"},
+		{"verb=ListRecords&metadataPrefix=oai_dc&metadataPrefix=oai_dc", "badArgument"},
+		{"verb=ListRecords&metadataPrefix=oai_dc&resumptionToken=x", "badArgument"},
+		{"verb=ListRecords&metadataPrefix=oai_dc&from=yesterday", "badArgument"},
+		{"verb=ListRecords&metadataPrefix=oai_dc&from=2024-01-01&until=2024-01-01T00:00:00Z", "badArgument"},
+		{"verb=ListRecords&metadataPrefix=oai_dc&from=2024-02-01&until=2024-01-01", "badArgument"},
+		{"verb=ListRecords&metadataPrefix=marc21", "cannotDisseminateFormat"},
+		{"verb=GetRecord&identifier=oai:plibrary:1&metadataPrefix=marc21", "cannotDisseminateFormat"},
+		{"verb=GetRecord&identifier=oai:plibrary:99&metadataPrefix=oai_dc", "idDoesNotExist"},
+		{"verb=GetRecord&identifier=urn:isbn:0441013597&metadataPrefix=oai_dc", "idDoesNotExist"},
+		{"verb=ListMetadataFormats&identifier=oai:plibrary:99", "idDoesNotExist"},
+		{"verb=ListRecords&metadataPrefix=oai_dc&set=romance", "noRecordsMatch"},
+		{"verb=ListRecords&metadataPrefix=oai_dc&until=1999-12-31", "noRecordsMatch"},
+		{"verb=ListIdentifiers&resumptionToken=not-a-token", "badResumptionToken"},
+		{"verb=ListSets&resumptionToken=x", "badResumptionToken"},
+	} {
+		doc := getOAI(t, handler, test.query)
+		if doc.errorCode() != test.code {
+			t.Errorf("GET /oai?%s: error %q, want %q", test.query, doc.errorCode(), test.code)
+		}
+		// The request element drops its attributes for badVerb and badArgument.
+		if (test.code == "badVerb" || test.code == "badArgument") && doc.Request.Verb != "" {
+			t.Errorf("GET /oai?%s: request verb %q, want none", test.query, doc.Request.Verb)
+		}
+	}
+}
+
+func TestOAIResumptionToken(t *testing.T) {
+	app := newMemoryApplication(t, config{})
+	for i := range oaiPageSize {
+		book := &models.Book{Title: fmt.Sprintf("Volume %d", i+1), Published: 2000, Pages: 100, Genres: []string{"reference"}}
+		if err := app.models.Books.Insert(context.Background(), book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := app.routes()
+	total := oaiPageSize + 3
+
+	first := getOAI(t, handler, "verb=ListIdentifiers&metadataPrefix=oai_dc")
+	token := first.ResumptionToken
+	if len(first.Identifiers) != oaiPageSize || token == nil || token.Token == "" {
+		t.Fatalf("first page has %d identifiers, resumption token %+v", len(first.Identifiers), token)
+	}
+	if token.CompleteListSize != total || token.Cursor != 0 {
+		t.Errorf("first page token completeListSize %d, cursor %d; want %d, 0", token.CompleteListSize, token.Cursor, total)
+	}
+
+	last := getOAI(t, handler, "verb=ListIdentifiers&resumptionToken="+url.QueryEscape(token.Token))
+	token = last.ResumptionToken
+	if len(last.Identifiers) != 3 || last.Identifiers[0] != fmt.Sprintf("oai:plibrary:%d", oaiPageSize+1) {
+		t.Errorf("last page = %v, want the 3 remaining identifiers", last.Identifiers)
+	}
+	if token == nil || token.Token != "" || token.Cursor != oaiPageSize || token.CompleteListSize != total {
+		t.Errorf("last page token %+v, want an empty token at cursor %d", token, oaiPageSize)
+	}
+
+	h, err := parseOAIResumptionToken(first.ResumptionToken.Token)
+	if err != nil || h.afterID != oaiPageSize || h.cursor != oaiPageSize || h.metadataPrefix != "oai_dc" {
+		t.Errorf("parseOAIResumptionToken = %+v, %v", h, err)
+	}
+	h.afterID = int64(total)
+	expired := getOAI(t, handler, "verb=ListIdentifiers&resumptionToken="+url.QueryEscape(h.token()))
+	if expired.errorCode() != "badResumptionToken" {
+		t.Errorf("token past the end: error %q, want badResumptionToken", expired.errorCode())
+	}
+}
+
+func TestOAIListMetadataFormats(t *testing.T) {
+	handler := newTestApplication(t).routes()
+
+	formats := getOAI(t, handler, "verb=ListMetadataFormats")
+	if got := strings.Join(formats.Formats, " "); got != "oai_dc" || formats.errorCode() != "" {
+		t.Errorf("ListMetadataFormats = %s, error %q; want oai_dc", got, formats.errorCode())
+	}
+}
+
+func TestOAIBookRecord(t *testing.T) {
+	book := &models.Book{
+		ID:        7,
+		Title:     "Dune",
+		Published: 1965,
+		Pages:     412,
+		Genres:    []string{"sci-fi", "space opera"},
+		UpdatedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600)),
+	}
+	record := oaiBookRecord(book, "https://library.example.org")
+
+	if record.Header.Identifier != "oai:plibrary:7" || record.Header.Datestamp != "2024-03-01T11:00:00Z" {
+		t.Errorf("header = %s at %s, want oai:plibrary:7 at 2024-03-01T11:00:00Z", record.Header.Identifier, record.Header.Datestamp)
+	}
+	if got := strings.Join(record.Header.SetSpecs, " "); got != "sci-fi space%20opera" {
+		t.Errorf("setSpecs = %s, want sci-fi space%%20opera", got)
+	}
+	dc := record.Metadata.DC
+	if dc.Title != "Dune" || dc.Date != "1965" || dc.Format != "412 pages" {
+		t.Errorf("dc title %q, date %q, format %q", dc.Title, dc.Date, dc.Format)
+	}
+	if len(dc.Identifiers) != 1 || dc.Identifiers[0] != "https://library.example.org/v1/books/7" {
+		t.Errorf("dc identifiers = %v, want the book URL", dc.Identifiers)
+	}
+
+	if id, err := parseOAIIdentifier(record.Header.Identifier); err != nil || id != 7 {
+		t.Errorf("parseOAIIdentifier(%s) = %d, %v", record.Header.Identifier, id, err)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/themilar/plibrary/internal"
+	"github.com/themilar/plibrary/internal/models"
+)
+
+const (
+	opdsNavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	opdsEntryType       = "application/atom+xml;type=entry;profile=opds-catalog"
+	openSearchType      = "application/opensearchdescription+xml"
+)
+
+type atomLink struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+	Count int    `xml:"thr:count,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Scheme string `xml:"scheme,attr,omitempty"`
+	Term   string `xml:"term,attr"`
+	Label  string `xml:"label,attr,omitempty"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Authors    []atomAuthor   `xml:"author"`
+	Publisher  string         `xml:"dc:publisher,omitempty"`
+	Issued     string         `xml:"dc:issued,omitempty"`
+	Extent     string         `xml:"dc:extent,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Content    *atomContent   `xml:"content,omitempty"`
+	Links      []atomLink     `xml:"link"`
+}
+
+type atomFeed struct {
+	XMLName         xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	XmlnsDC         string      `xml:"xmlns:dc,attr"`
+	XmlnsOpenSearch string      `xml:"xmlns:opensearch,attr"`
+	XmlnsOPDS       string      `xml:"xmlns:opds,attr"`
+	XmlnsThr        string      `xml:"xmlns:thr,attr"`
+	ID              string      `xml:"id"`
+	Title           string      `xml:"title"`
+	Updated         string      `xml:"updated"`
+	Author          *atomAuthor `xml:"author,omitempty"`
+	Links           []atomLink  `xml:"link"`
+	TotalResults    int         `xml:"opensearch:totalResults,omitempty"`
+	ItemsPerPage    int         `xml:"opensearch:itemsPerPage,omitempty"`
+	StartIndex      int         `xml:"opensearch:startIndex,omitempty"`
+	Entries         []atomEntry `xml:"entry"`
+}
+
+// opdsEntryDocument is a standalone OPDS catalog entry, as served for a single book.
+type opdsEntryDocument struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom entry"`
+	XmlnsDC string   `xml:"xmlns:dc,attr"`
+	atomEntry
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Template string `xml:"template,attr"`
+}
+
+type openSearchDescription struct {
+	XMLName        xml.Name        `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+	ShortName      string          `xml:"ShortName"`
+	Description    string          `xml:"Description"`
+	InputEncoding  string          `xml:"InputEncoding"`
+	OutputEncoding string          `xml:"OutputEncoding"`
+	URLs           []openSearchURL `xml:"Url"`
+}
+
+func newOPDSFeed(id, title, self, kind string) *atomFeed {
+	return &atomFeed{
+		XmlnsDC:         "http://purl.org/dc/terms/",
+		XmlnsOpenSearch: "http://a9.com/-/spec/opensearch/1.1/",
+		XmlnsOPDS:       "http://opds-spec.org/2010/catalog",
+		XmlnsThr:        "http://purl.org/syndication/thread/1.0",
+		ID:              id,
+		Title:           title,
+		Updated:         time.Now().UTC().Format(time.RFC3339),
+		Author:          &atomAuthor{Name: "plibrary", URI: "/opds"},
+		Links: []atomLink{
+			{Rel: "self", Href: self, Type: kind},
+			{Rel: "start", Href: "/opds", Type: opdsNavigationType},
+			{Rel: "search", Href: "/opds/opensearch.xml", Type: openSearchType},
+		},
+	}
+}
+
+func opdsBookEntry(book *models.Book) atomEntry {
+	entry := atomEntry{
+		Title:     book.Title,
+		ID:        fmt.Sprintf("urn:plibrary:book:%d", book.ID),
+		Updated:   book.UpdatedAt.UTC().Format(time.RFC3339),
+		Issued:    strconv.Itoa(book.Published),
+		Publisher: book.Publisher,
+		Links: []atomLink{
+			{Rel: "alternate", Href: fmt.Sprintf("/opds/books/%d", book.ID), Type: opdsEntryType},
+			{Rel: "http://opds-spec.org/acquisition/borrow", Href: fmt.Sprintf("/v1/books/%d", book.ID), Type: "application/json"},
+		},
+	}
+	if book.Pages > 0 {
+		entry.Extent = fmt.Sprintf("%d pages", book.Pages)
+		entry.Content = &atomContent{Type: "text", Body: fmt.Sprintf("Published %d, %d pages.", book.Published, book.Pages)}
+	}
+	for _, author := range book.Authors {
+		entry.Authors = append(entry.Authors, atomAuthor{Name: author})
+	}
+	for _, genre := range book.Genres {
+		entry.Categories = append(entry.Categories, atomCategory{Scheme: "/opds/genres", Term: genre, Label: genre})
+	}
+	return entry
+}
+
+// opdsPageLinks derives first/previous/next/last links for an acquisition feed from the
+// pagination metadata, preserving any other query parameters of the current request.
+func opdsPageLinks(path string, qs url.Values, metadata *internal.PaginationMetadata) []atomLink {
+	if metadata == nil || metadata.LastPage == 0 {
+		return nil
+	}
+	pageHref := func(page int) string {
+		q := url.Values{}
+		for k, v := range qs {
+			q[k] = v
+		}
+		q.Set("page", strconv.Itoa(page))
+		q.Set("size", strconv.Itoa(metadata.PageSize))
+		return path + "?" + q.Encode()
+	}
+	links := []atomLink{{Rel: "first", Href: pageHref(metadata.FirstPage), Type: opdsAcquisitionType}}
+	if metadata.CurrentPage > metadata.FirstPage {
+		links = append(links, atomLink{Rel: "previous", Href: pageHref(metadata.CurrentPage - 1), Type: opdsAcquisitionType})
+	}
+	if metadata.CurrentPage < metadata.LastPage {
+		links = append(links, atomLink{Rel: "next", Href: pageHref(metadata.CurrentPage + 1), Type: opdsAcquisitionType})
+	}
+	links = append(links, atomLink{Rel: "last", Href: pageHref(metadata.LastPage), Type: opdsAcquisitionType})
+	return links
+}
+
+func opdsAcquisitionFeed(feed *atomFeed, path string, qs url.Values, books []*models.Book, metadata *internal.PaginationMetadata) {
+	feed.Links = append(feed.Links, opdsPageLinks(path, qs, metadata)...)
+	if metadata != nil && metadata.TotalRecords > 0 {
+		feed.TotalResults = metadata.TotalRecords
+		feed.ItemsPerPage = metadata.PageSize
+		feed.StartIndex = (metadata.CurrentPage-1)*metadata.PageSize + 1
+	}
+	for _, book := range books {
+		feed.Entries = append(feed.Entries, opdsBookEntry(book))
+	}
+}
+
+func (app *application) opdsCatalog(w http.ResponseWriter, r *http.Request) {
+	feed := newOPDSFeed("urn:plibrary:opds", "plibrary catalog", "/opds", opdsNavigationType)
+	feed.Entries = []atomEntry{
+		{
+			Title:   "New arrivals",
+			ID:      "urn:plibrary:opds:new",
+			Updated: feed.Updated,
+			Content: &atomContent{Type: "text", Body: "The most recently added books."},
+			Links:   []atomLink{{Rel: "http://opds-spec.org/sort/new", Href: "/opds/new", Type: opdsAcquisitionType}},
+		},
+		{
+			Title:   "Browse by genre",
+			ID:      "urn:plibrary:opds:genres",
+			Updated: feed.Updated,
+			Content: &atomContent{Type: "text", Body: "Books grouped by genre."},
+			Links:   []atomLink{{Rel: "subsection", Href: "/opds/genres", Type: opdsNavigationType}},
+		},
+	}
+	err := app.writeXML(w, http.StatusOK, feed, opdsNavigationType, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) opdsNewArrivals(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	qs.Del("sort")
+	filters, filterErrors := readFilters(app, qs, "-id")
+	if len(filterErrors) > 0 {
+		app.failedValidationErrorResponse(w, r, filterErrors)
+		return
+	}
+	books, metadata, err := app.models.Books.All(r.Context(), "", []string{}, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	feed := newOPDSFeed("urn:plibrary:opds:new", "New arrivals", r.URL.RequestURI(), opdsAcquisitionType)
+	opdsAcquisitionFeed(feed, "/opds/new", qs, books, metadata)
+	err = app.writeXML(w, http.StatusOK, feed, opdsAcquisitionType, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) opdsGenreList(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.models.Books.Genres(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	feed := newOPDSFeed("urn:plibrary:opds:genres", "Browse by genre", "/opds/genres", opdsNavigationType)
+	for _, genre := range genres {
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   genre.Name,
+			ID:      "urn:plibrary:opds:genres:" + url.PathEscape(genre.Name),
+			Updated: feed.Updated,
+			Content: &atomContent{Type: "text", Body: fmt.Sprintf("%d books", genre.Count)},
+			Links: []atomLink{{
+				Rel:   "subsection",
+				Href:  "/opds/genres/" + url.PathEscape(genre.Name),
+				Type:  opdsAcquisitionType,
+				Count: genre.Count,
+			}},
+		})
+	}
+	err = app.writeXML(w, http.StatusOK, feed, opdsNavigationType, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) opdsGenreFeed(w http.ResponseWriter, r *http.Request) {
+	genre, err := url.PathUnescape(chi.URLParam(r, "genre"))
+	if err != nil || genre == "" {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+	qs := r.URL.Query()
+	filters, filterErrors := readFilters(app, qs, "title")
+	if len(filterErrors) > 0 {
+		app.failedValidationErrorResponse(w, r, filterErrors)
+		return
+	}
+	books, metadata, err := app.models.Books.All(r.Context(), "", []string{genre}, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	path := "/opds/genres/" + url.PathEscape(genre)
+	feed := newOPDSFeed("urn:plibrary:opds:genres:"+url.PathEscape(genre), genre, r.URL.RequestURI(), opdsAcquisitionType)
+	feed.Links = append(feed.Links, atomLink{Rel: "up", Href: "/opds/genres", Type: opdsNavigationType})
+	opdsAcquisitionFeed(feed, path, qs, books, metadata)
+	err = app.writeXML(w, http.StatusOK, feed, opdsAcquisitionType, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) opdsSearch(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	qs.Del("sort")
+	filters, filterErrors := readFilters(app, qs, "id")
+	if len(filterErrors) > 0 {
+		app.failedValidationErrorResponse(w, r, filterErrors)
+		return
+	}
+	books, err := app.models.Books.FullTextSearch(r.Context(), app.readString(qs, "q", ""))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// FullTextSearch is not paginated, so the requested page is cut out of the full result set.
+	metadata := internal.CalculateMetadata(len(books), filters.Page, filters.Size)
+	start := min(filters.Offset(), len(books))
+	end := min(start+filters.Limit(), len(books))
+	feed := newOPDSFeed("urn:plibrary:opds:search", "Search results", r.URL.RequestURI(), opdsAcquisitionType)
+	opdsAcquisitionFeed(feed, "/opds/search", qs, books[start:end], metadata)
+	err = app.writeXML(w, http.StatusOK, feed, opdsAcquisitionType, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) opdsSearchDescription(w http.ResponseWriter, r *http.Request) {
+	description := openSearchDescription{
+		ShortName:      "plibrary",
+		Description:    "Search the plibrary catalog by title",
+		InputEncoding:  "UTF-8",
+		OutputEncoding: "UTF-8",
+		URLs: []openSearchURL{
+			{Type: opdsAcquisitionType, Template: "/opds/search?q={searchTerms}&page={startPage?}"},
+		},
+	}
+	err := app.writeXML(w, http.StatusOK, description, openSearchType, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) opdsBookDetail(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+	book := getBookDetail(app, w, r, id, nil)
+	if book != nil {
+		entry := opdsEntryDocument{XmlnsDC: "http://purl.org/dc/terms/", atomEntry: opdsBookEntry(book)}
+		if err = app.writeXML(w, http.StatusOK, entry, opdsEntryType, nil); err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/themilar/plibrary/internal"
+	"github.com/themilar/plibrary/internal/models"
+)
+
+// opdsDocument picks out of a feed or entry what the tests compare.
+type opdsDocument struct {
+	XMLName      xml.Name
+	Title        string `xml:"title"`
+	TotalResults int    `xml:"totalResults"`
+	Links        []struct {
+		Rel  string `xml:"rel,attr"`
+		Href string `xml:"href,attr"`
+	} `xml:"link"`
+	Entries []struct {
+		Title string `xml:"title"`
+	} `xml:"entry"`
+	Categories []struct {
+		Term string `xml:"term,attr"`
+	} `xml:"category"`
+}
+
+func (doc opdsDocument) link(rel string) string {
+	for _, link := range doc.Links {
+		if link.Rel == rel {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+func (doc opdsDocument) entryTitles() []string {
+	var titles []string
+	for _, entry := range doc.Entries {
+		titles = append(titles, entry.Title)
+	}
+	return titles
+}
+
+func getOPDS(t *testing.T, handler http.Handler, target string, status int, mediaType string) opdsDocument {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+	if rr.Code != status {
+		t.Fatalf("GET %s: status %d, want %d; body %s", target, rr.Code, status, rr.Body)
+	}
+	var doc opdsDocument
+	if status != http.StatusOK {
+		return doc
+	}
+	if got := rr.Header().Get("Content-Type"); got != mediaType {
+		t.Errorf("GET %s: Content-Type %q, want %q", target, got, mediaType)
+	}
+	if err := xml.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("GET %s: %v; body %s", target, err, rr.Body)
+	}
+	return doc
+}
+
+func TestOPDSNavigation(t *testing.T) {
+	handler := newMemoryApplication(t, config{}).routes()
+
+	catalog := getOPDS(t, handler, "/opds", http.StatusOK, opdsNavigationType)
+	if got, want := strings.Join(catalog.entryTitles(), ", "), "New arrivals, Browse by genre"; got != want {
+		t.Errorf("catalog entries = %s, want %s", got, want)
+	}
+	if catalog.link("search") != "/opds/opensearch.xml" || catalog.link("start") != "/opds" {
+		t.Errorf("catalog links = %v, want search and start links", catalog.Links)
+	}
+
+	genres := getOPDS(t, handler, "/opds/genres", http.StatusOK, opdsNavigationType)
+	if got, want := strings.Join(genres.entryTitles(), ", "), "fantasy, sci-fi"; got != want {
+		t.Errorf("genre entries = %s, want %s", got, want)
+	}
+
+	description := getOPDS(t, handler, "/opds/opensearch.xml", http.StatusOK, openSearchType)
+	if description.XMLName.Local != "OpenSearchDescription" {
+		t.Errorf("opensearch.xml root = %s, want OpenSearchDescription", description.XMLName.Local)
+	}
+}
+
+func TestOPDSAcquisitionFeeds(t *testing.T) {
+	handler := newMemoryApplication(t, config{}).routes()
+
+	for _, test := range []struct {
+		target string
+		titles string
+		total  int
+	}{
+		{"/opds/new", "The Hobbit, Children Of Dune, Dune", 3},
+		{"/opds/new?sort=title", "The Hobbit, Children Of Dune, Dune", 3},
+		{"/opds/genres/sci-fi", "Children Of Dune, Dune", 2},
+		{"/opds/genres/romance", "", 0},
+		{"/opds/search?q=dune", "Dune, Children Of Dune", 2},
+	} {
+		feed := getOPDS(t, handler, test.target, http.StatusOK, opdsAcquisitionType)
+		if got := strings.Join(feed.entryTitles(), ", "); got != test.titles {
+			t.Errorf("GET %s: entries %s, want %s", test.target, got, test.titles)
+		}
+		if feed.TotalResults != test.total {
+			t.Errorf("GET %s: totalResults %d, want %d", test.target, feed.TotalResults, test.total)
+		}
+	}
+
+	genre := getOPDS(t, handler, "/opds/genres/sci-fi", http.StatusOK, opdsAcquisitionType)
+	if genre.link("up") != "/opds/genres" {
+		t.Errorf("genre feed up link = %q, want /opds/genres", genre.link("up"))
+	}
+
+	getOPDS(t, handler, "/opds/new?size=0", http.StatusUnprocessableEntity, "")
+	getOPDS(t, handler, "/opds/search?q=dune&page=0", http.StatusUnprocessableEntity, "")
+}
+
+func TestOPDSPagination(t *testing.T) {
+	handler := newMemoryApplication(t, config{}).routes()
+
+	for _, test := range []struct {
+		target, titles string
+		rels           []string
+	}{
+		{"/opds/new?size=2", "The Hobbit, Children Of Dune", []string{"first", "next", "last"}},
+		{"/opds/new?size=2&page=2", "Dune", []string{"first", "previous", "last"}},
+		{"/opds/search?q=dune&size=1&page=2", "Children Of Dune", []string{"first", "previous", "last"}},
+		{"/opds/search?q=dune&size=1&page=5", "", []string{"first", "previous", "last"}},
+	} {
+		feed := getOPDS(t, handler, test.target, http.StatusOK, opdsAcquisitionType)
+		if got := strings.Join(feed.entryTitles(), ", "); got != test.titles {
+			t.Errorf("GET %s: entries %s, want %s", test.target, got, test.titles)
+		}
+		for _, rel := range []string{"first", "previous", "next", "last"} {
+			if has := feed.link(rel) != ""; has != slices.Contains(test.rels, rel) {
+				t.Errorf("GET %s: %s link %q, want one: %t", test.target, rel, feed.link(rel), !has)
+			}
+		}
+	}
+
+	// Page links keep the other query parameters of the request.
+	feed := getOPDS(t, handler, "/opds/search?q=dune&size=1", http.StatusOK, opdsAcquisitionType)
+	if next := feed.link("next"); next != "/opds/search?page=2&q=dune&size=1" {
+		t.Errorf("next link = %q, want /opds/search?page=2&q=dune&size=1", next)
+	}
+}
+
+func TestOPDSBookDetail(t *testing.T) {
+	handler := newMemoryApplication(t, config{}).routes()
+
+	entry := getOPDS(t, handler, "/opds/books/1", http.StatusOK, opdsEntryType)
+	if entry.XMLName.Local != "entry" || entry.Title != "Dune" {
+		t.Errorf("book entry %s titled %q, want an entry titled Dune", entry.XMLName.Local, entry.Title)
+	}
+	if len(entry.Categories) != 1 || entry.Categories[0].Term != "sci-fi" {
+		t.Errorf("book categories = %v, want sci-fi", entry.Categories)
+	}
+	if entry.link("http://opds-spec.org/acquisition/borrow") != "/v1/books/1" {
+		t.Errorf("book links = %v, want a borrow link to /v1/books/1", entry.Links)
+	}
+
+	getOPDS(t, handler, "/opds/books/99", http.StatusNotFound, "")
+	getOPDS(t, handler, "/opds/books/abc", http.StatusNotFound, "")
+}
+
+func TestOPDSRequestErrors(t *testing.T) {
+	handler := newTestApplication(t).routes()
+
+	getOPDS(t, handler, "/opds/new?size=0", http.StatusUnprocessableEntity, "")
+	getOPDS(t, handler, "/opds/search?q=dune&page=0", http.StatusUnprocessableEntity, "")
+	getOPDS(t, handler, "/opds/genres/sci-fi?sort=isbn", http.StatusUnprocessableEntity, "")
+	getOPDS(t, handler, "/opds/books/abc", http.StatusNotFound, "")
+	getOPDS(t, handler, "/opds/books/0", http.StatusNotFound, "")
+}
+
+func TestOPDSPageLinks(t *testing.T) {
+	qs := url.Values{"q": {"dune"}, "page": {"2"}}
+	for _, test := range []struct {
+		metadata *internal.PaginationMetadata
+		links    string
+	}{
+		{internal.CalculateMetadata(5, 2, 2), "first /opds/search?page=1&q=dune&size=2, " +
+			"previous /opds/search?page=1&q=dune&size=2, " +
+			"next /opds/search?page=3&q=dune&size=2, " +
+			"last /opds/search?page=3&q=dune&size=2"},
+		{internal.CalculateMetadata(5, 1, 5), "first /opds/search?page=1&q=dune&size=5, last /opds/search?page=1&q=dune&size=5"},
+		{internal.CalculateMetadata(0, 1, 5), ""},
+		{nil, ""},
+	} {
+		var links []string
+		for _, link := range opdsPageLinks("/opds/search", qs, test.metadata) {
+			links = append(links, link.Rel+" "+link.Href)
+		}
+		if got := strings.Join(links, ", "); got != test.links {
+			t.Errorf("opdsPageLinks(%+v) = %s, want %s", test.metadata, got, test.links)
+		}
+	}
+	if qs.Get("page") != "2" {
+		t.Error("opdsPageLinks changed the query of the request")
+	}
+}
+
+func TestOPDSBookEntry(t *testing.T) {
+	book := &models.Book{
+		ID: 1, Title: "Dune", Authors: []string{"Frank Herbert"}, Publisher: "Chilton Books",
+		Published: 1965, Pages: 412, Genres: []string{"sci-fi", "classics"},
+		UpdatedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+	entry := opdsBookEntry(book)
+	if entry.ID != "urn:plibrary:book:1" || entry.Updated != "2024-03-01T12:00:00Z" || entry.Issued != "1965" || entry.Extent != "412 pages" {
+		t.Errorf("opdsBookEntry = %+v", entry)
+	}
+	if len(entry.Authors) != 1 || entry.Authors[0].Name != "Frank Herbert" || entry.Publisher != "Chilton Books" {
+		t.Errorf("authors %v, publisher %q; want Frank Herbert and Chilton Books", entry.Authors, entry.Publisher)
+	}
+	if len(entry.Categories) != 2 || entry.Categories[1].Term != "classics" {
+		t.Errorf("categories = %v, want sci-fi and classics", entry.Categories)
+	}
+	if len(entry.Links) != 2 || entry.Links[1].Href != "/v1/books/1" {
+		t.Errorf("links = %v, want an alternate and a borrow link", entry.Links)
+	}
+
+	feed := newOPDSFeed("urn:test", "Test", "/opds/new", opdsAcquisitionType)
+	opdsAcquisitionFeed(feed, "/opds/new", url.Values{}, []*models.Book{book}, internal.CalculateMetadata(13, 2, 12))
+	if feed.TotalResults != 13 || feed.ItemsPerPage != 12 || feed.StartIndex != 13 || len(feed.Entries) != 1 {
+		t.Errorf("acquisition feed results %d, per page %d, start %d, %d entries", feed.TotalResults, feed.ItemsPerPage, feed.StartIndex, len(feed.Entries))
+	}
+}
@@ -0,0 +1,687 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/themilar/plibrary/internal"
+	"github.com/themilar/plibrary/internal/models"
+	"github.com/themilar/plibrary/internal/settings"
+)
+
+// jsonSchema is the subset of JSON Schema 2020-12 used by the generated OpenAPI document.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 any                    `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Enum                 []any                  `json:"enum,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+	UniqueItems          bool                   `json:"uniqueItems,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties any                    `json:"additionalProperties,omitempty"`
+	OneOf                []*jsonSchema          `json:"oneOf,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string      `json:"name"`
+	In          string      `json:"in"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Style       string      `json:"style,omitempty"`
+	Explode     *bool       `json:"explode,omitempty"`
+	Schema      *jsonSchema `json:"schema"`
+}
+
+type openAPIMediaType struct {
+	Schema *jsonSchema `json:"schema,omitempty"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                         `json:"required"`
+	Content  map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId,omitempty"`
+	Summary     string                      `json:"summary,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*jsonSchema `json:"schemas"`
+}
+
+// openAPISpec is the OpenAPI 3.1 document describing the routes of the API.
+type openAPISpec struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+// apiOperation documents a route. Request and response bodies are given as zero values of
+// the Go types that are decoded and encoded by the handlers; their schemas are generated by
+// reflection from the json, validate and enum struct tags.
+type apiOperation struct {
+	id, summary, tag string
+	params           []*openAPIParameter
+	request          any
+	response         any
+	status           int
+	// produces lists the media types of a successful response when it is not JSON.
+	produces []string
+	// also documents other statuses answered with a JSON body of their own rather than an
+	// error.
+	also map[int]any
+	// negotiated routes can also render XML, CSV and MessagePack and citations routes
+	// additionally BibTeX, RIS and CSL-JSON.
+	negotiated, citations bool
+}
+
+// Documentation-only shapes of the JSON responses.
+type (
+	bookDocument struct {
+		models.Book
+		Copies []models.Copy `json:"copies,omitempty"`
+	}
+	bookResponse struct {
+		Book bookDocument `json:"book"`
+	}
+	bookListResponse struct {
+		Books    []bookDocument              `json:"books"`
+		Metadata internal.PaginationMetadata `json:"metadata"`
+	}
+	bookSearchResponse struct {
+		Books []bookDocument `json:"books"`
+	}
+	healthcheckResponse struct {
+		Status     string `json:"status"`
+		SystemInfo struct {
+			Version     string `json:"version"`
+			Environment string `json:"environment"`
+		} `json:"system_info"`
+	}
+	readinessResponse struct {
+		Status     string                     `json:"status" enum:"ready unavailable"`
+		Components map[string]componentHealth `json:"components"`
+	}
+	configResponse struct {
+		Config map[string]settings.Setting `json:"config"`
+	}
+	errorDocument struct {
+		Error any `json:"error"`
+	}
+	webhookResponse struct {
+		Webhook models.Webhook `json:"webhook"`
+	}
+	webhookCreatedResponse struct {
+		Webhook createdWebhook `json:"webhook"`
+	}
+	webhookListResponse struct {
+		Webhooks []models.Webhook `json:"webhooks"`
+	}
+	deliveryResponse struct {
+		Delivery models.Delivery `json:"delivery"`
+	}
+	deliveryListResponse struct {
+		Deliveries []models.Delivery `json:"deliveries"`
+	}
+)
+
+// openAPISchemaNames names the component schemas of types whose Go names are not fit for
+// publishing.
+var openAPISchemaNames = map[reflect.Type]string{
+	reflect.TypeOf(bookDocument{}):                "Book",
+	reflect.TypeOf(createInput{}):                 "BookInput",
+	reflect.TypeOf(updateInput{}):                 "BookPatch",
+	reflect.TypeOf(problem{}):                     "Problem",
+	reflect.TypeOf(errorDocument{}):               "Error",
+	reflect.TypeOf(cslItem{}):                     "CSLItem",
+	reflect.TypeOf(internal.PaginationMetadata{}): "PaginationMetadata",
+	reflect.TypeOf(graphqlRequest{}):              "GraphQLRequest",
+	reflect.TypeOf(graphqlResponse{}):             "GraphQLResponse",
+	reflect.TypeOf(gqlerrors.QueryError{}):        "GraphQLError",
+	reflect.TypeOf(gqlerrors.Location{}):          "GraphQLLocation",
+	reflect.TypeOf(webhookInput{}):                "WebhookInput",
+	reflect.TypeOf(webhookPatch{}):                "WebhookPatch",
+	reflect.TypeOf(createdWebhook{}):              "CreatedWebhook",
+	reflect.TypeOf(readinessResponse{}):           "Readiness",
+}
+
+// openAPIInputs maps request body types to the model whose validate tags constrain them.
+// Partial inputs, used for PATCH, have no required properties.
+var openAPIInputs = map[reflect.Type]struct {
+	model   reflect.Type
+	partial bool
+}{
+	reflect.TypeOf(createInput{}):  {reflect.TypeOf(models.Book{}), false},
+	reflect.TypeOf(updateInput{}):  {reflect.TypeOf(models.Book{}), true},
+	reflect.TypeOf(webhookInput{}): {reflect.TypeOf(models.Webhook{}), false},
+	reflect.TypeOf(webhookPatch{}): {reflect.TypeOf(models.Webhook{}), true},
+}
+
+func bookIDParam() *openAPIParameter {
+	return &openAPIParameter{Name: "id", In: "path", Required: true, Schema: &jsonSchema{Type: "integer", Format: "int64", Minimum: ptr(1.0)}}
+}
+
+func deliveryIDParam() *openAPIParameter {
+	return &openAPIParameter{Name: "deliveryID", In: "path", Required: true, Schema: &jsonSchema{Type: "integer", Format: "int64", Minimum: ptr(1.0)}}
+}
+
+func expectedVersionParam() *openAPIParameter {
+	return &openAPIParameter{Name: "X-Expected-Version", In: "header", Description: "Version the client last saw; the update fails with 409 when the book has changed since",
+		Schema: &jsonSchema{Type: "integer", Minimum: ptr(1.0)}}
+}
+
+// staffTokenParam documents the Authorization header of the routes guarded by requireStaff.
+func staffTokenParam() *openAPIParameter {
+	return &openAPIParameter{Name: "Authorization", In: "header", Required: true, Description: "Bearer staff token", Schema: &jsonSchema{Type: "string"}}
+}
+
+func csvParam(name, description string, items *jsonSchema) *openAPIParameter {
+	return &openAPIParameter{Name: name, In: "query", Description: description, Style: "form", Explode: ptr(false),
+		Schema: &jsonSchema{Type: "array", Items: items}}
+}
+
+func stringParam(name, description string) *openAPIParameter {
+	return &openAPIParameter{Name: name, In: "query", Description: description, Schema: &jsonSchema{Type: "string"}}
+}
+
+// filterParams documents page, size and sort from the validate tags of internal.Filters, and
+// the values of sort from its safelist.
+func filterParams() []*openAPIParameter {
+	var params []*openAPIParameter
+	t := reflect.TypeOf(internal.Filters{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		schema := &jsonSchema{Type: "integer"}
+		if field.Type.Kind() == reflect.String {
+			schema.Type = "string"
+		}
+		applyValidateTag(schema, field.Tag.Get("validate"), field.Type)
+		if field.Name == "Sort" {
+			for _, value := range internal.SortValues {
+				schema.Enum = append(schema.Enum, value)
+			}
+		}
+		params = append(params, &openAPIParameter{Name: strings.ToLower(field.Name), In: "query", Schema: schema})
+	}
+	return params
+}
+
+func bookQueryParams() []*openAPIParameter {
+	fields := make([]any, len(models.BookFields))
+	for i, field := range models.BookFields {
+		fields[i] = field
+	}
+	includes := make([]any, len(bookIncludes))
+	for i, include := range bookIncludes {
+		includes[i] = include
+	}
+	return []*openAPIParameter{
+		csvParam("fields", "Comma separated book fields to return", &jsonSchema{Type: "string", Enum: fields}),
+		csvParam("include", "Comma separated relations to embed", &jsonSchema{Type: "string", Enum: includes}),
+	}
+}
+
+// negotiationParams documents ?format= and ?compact= accepted by negotiateContent.
+func negotiationParams(citations bool) []*openAPIParameter {
+	var formats []any
+	for _, table := range []map[string]string{responseMediaTypes, citationFormats} {
+		if !citations && len(formats) > 0 {
+			break
+		}
+		for name := range table {
+			if !strings.Contains(name, "/") {
+				formats = append(formats, name)
+			}
+		}
+	}
+	sort.Slice(formats, func(i, j int) bool { return formats[i].(string) < formats[j].(string) })
+	return []*openAPIParameter{
+		{Name: "format", In: "query", Description: "Response format, overriding the Accept header", Schema: &jsonSchema{Type: "string", Enum: formats}},
+		{Name: "compact", In: "query", Description: "Render JSON without indentation", Schema: &jsonSchema{Type: "boolean"}},
+	}
+}
+
+// apiOperations documents the routes registered in routes, keyed by method and pattern.
+func apiOperations() map[string]apiOperation {
+	listParams := append(append([]*openAPIParameter{
+		stringParam("title", "Exact title, ignoring case"),
+		csvParam("genres", "Comma separated genres the books must all have", &jsonSchema{Type: "string"}),
+	}, filterParams()...), bookQueryParams()...)
+	return map[string]apiOperation{
+		"GET /v1/healthcheck": {id: "healthcheck", summary: "Report the status of the service, the same as /v1/health/live", tag: "system",
+			response: healthcheckResponse{}},
+		"GET /v1/health/live": {id: "liveness", summary: "Liveness probe: the process is serving requests", tag: "system",
+			response: healthcheckResponse{}},
+		"GET /v1/health/ready": {id: "readiness", summary: "Readiness probe: the database answers and its schema is up to date", tag: "system",
+			response: readinessResponse{}, also: map[int]any{http.StatusServiceUnavailable: readinessResponse{}}},
+		"GET /metrics": {id: "metrics", summary: "Prometheus metrics", tag: "system", produces: []string{"text/plain"}},
+		"GET /v1/openapi.json": {id: "getOpenAPI", summary: "This OpenAPI document", tag: "system",
+			response: map[string]any{}},
+		"GET /v1/admin/config": {id: "getConfig", summary: "The effective configuration, with the source of every setting and credentials redacted, for staff tokens", tag: "system",
+			params: []*openAPIParameter{staffTokenParam()}, response: configResponse{}},
+		"GET /v1/books": {id: "listBooks", summary: "List books", tag: "books", params: listParams,
+			response: bookListResponse{}, negotiated: true, citations: true},
+		"GET /v1/books/search": {id: "searchBooks", summary: "Full text search on book titles", tag: "books",
+			params:   append([]*openAPIParameter{stringParam("q", "Words that must appear in the title")}, bookQueryParams()...),
+			response: bookSearchResponse{}, negotiated: true, citations: true},
+		"GET /v1/books/stream": {id: "streamBookChanges", summary: "Server-Sent Events of book changes, resumable with Last-Event-ID", tag: "books",
+			params: []*openAPIParameter{
+				csvParam("events", "Comma separated events to receive", &jsonSchema{Type: "string", Enum: []any{models.EventBookCreated, models.EventBookUpdated, models.EventBookDeleted}}),
+				csvParam("genres", "Comma separated genres, of which books must have at least one", &jsonSchema{Type: "string"}),
+				csvParam("ids", "Comma separated ids of the books to follow", &jsonSchema{Type: "integer", Format: "int64", Minimum: ptr(1.0)}),
+				{Name: "Last-Event-ID", In: "header", Description: "Id of the last event received, to resume after it", Schema: &jsonSchema{Type: "string"}},
+				stringParam("last_event_id", "Same as Last-Event-ID, for the first connection"),
+			},
+			produces: []string{"text/event-stream"}},
+		"GET /v1/books/{id}": {id: "getBook", summary: "Show a book", tag: "books",
+			params:   append([]*openAPIParameter{bookIDParam()}, bookQueryParams()...),
+			response: bookResponse{}, status: http.StatusAccepted, negotiated: true, citations: true},
+		"POST /v1/books": {id: "createBook", summary: "Create a book", tag: "books",
+			request: createInput{}, response: bookResponse{}, status: http.StatusCreated, negotiated: true},
+		"PATCH /v1/books/{id}": {id: "updateBook", summary: "Update some fields of a book", tag: "books",
+			params: []*openAPIParameter{bookIDParam(), expectedVersionParam()}, request: updateInput{}, response: bookResponse{}, negotiated: true},
+		"DELETE /v1/books/{id}": {id: "deleteBook", summary: "Delete a book", tag: "books",
+			params: []*openAPIParameter{bookIDParam()}, status: http.StatusNoContent, negotiated: true},
+
+		"GET /v1/webhooks": {id: "listWebhooks", summary: "List webhooks", tag: "webhooks",
+			params: []*openAPIParameter{staffTokenParam()}, response: webhookListResponse{}, negotiated: true},
+		"POST /v1/webhooks": {id: "createWebhook", summary: "Subscribe a URL to catalogue events; the response holds the only copy of the signing secret", tag: "webhooks",
+			params: []*openAPIParameter{staffTokenParam()}, request: webhookInput{}, response: webhookCreatedResponse{}, status: http.StatusCreated, negotiated: true},
+		"GET /v1/webhooks/{id}": {id: "getWebhook", summary: "Show a webhook", tag: "webhooks",
+			params: []*openAPIParameter{staffTokenParam(), bookIDParam()}, response: webhookResponse{}, negotiated: true},
+		"PATCH /v1/webhooks/{id}": {id: "updateWebhook", summary: "Update some fields of a webhook", tag: "webhooks",
+			params: []*openAPIParameter{staffTokenParam(), bookIDParam(), expectedVersionParam()}, request: webhookPatch{}, response: webhookResponse{}, negotiated: true},
+		"DELETE /v1/webhooks/{id}": {id: "deleteWebhook", summary: "Delete a webhook and its deliveries", tag: "webhooks",
+			params: []*openAPIParameter{staffTokenParam(), bookIDParam()}, status: http.StatusNoContent, negotiated: true},
+		"GET /v1/webhooks/{id}/deliveries": {id: "listWebhookDeliveries", summary: "The latest deliveries of a webhook", tag: "webhooks",
+			params: []*openAPIParameter{staffTokenParam(), bookIDParam(), {Name: "status", In: "query", Schema: &jsonSchema{Type: "string",
+				Enum: []any{models.DeliveryPending, models.DeliveryDelivered, models.DeliveryDead}}}},
+			response: deliveryListResponse{}, negotiated: true},
+		"GET /v1/webhooks/{id}/deliveries/{deliveryID}": {id: "getWebhookDelivery", summary: "Show a delivery with the log of its attempts", tag: "webhooks",
+			params: []*openAPIParameter{staffTokenParam(), bookIDParam(), deliveryIDParam()}, response: deliveryResponse{}, negotiated: true},
+		"POST /v1/webhooks/{id}/deliveries/{deliveryID}/redeliver": {id: "redeliverWebhookDelivery", summary: "Queue the event of a delivery again", tag: "webhooks",
+			params: []*openAPIParameter{staffTokenParam(), bookIDParam(), deliveryIDParam()}, response: deliveryResponse{}, status: http.StatusAccepted, negotiated: true},
+
+		"GET /v1/ws": {id: "circulationSocket", summary: "WebSocket of circulation desk events on branch:NAME, book:ID and patron:ID topics, for staff tokens", tag: "circulation",
+			params: []*openAPIParameter{
+				{Name: "Authorization", In: "header", Description: "Bearer staff token; otherwise the first message must be {\"type\":\"auth\",\"token\":...}", Schema: &jsonSchema{Type: "string"}},
+			},
+			status: http.StatusSwitchingProtocols},
+
+		"GET /v1/graphql": {id: "graphqlGet", summary: "Execute a GraphQL query", tag: "graphql",
+			params: []*openAPIParameter{
+				{Name: "query", In: "query", Required: true, Description: "GraphQL document; mutations must be sent with POST", Schema: &jsonSchema{Type: "string"}},
+				stringParam("operationName", "Operation to execute when the document has several"),
+				stringParam("variables", "JSON object of variable values"),
+			},
+			response: graphqlResponse{}},
+		"POST /v1/graphql": {id: "graphqlPost", summary: "Execute a GraphQL query or mutation", tag: "graphql",
+			request: graphqlRequest{}, response: graphqlResponse{}},
+		"GET /v1/graphql/schema": {id: "graphqlSchema", summary: "The GraphQL schema in SDL", tag: "graphql", produces: []string{"text/plain"}},
+
+		"GET /opds":                {id: "opdsCatalog", summary: "OPDS navigation feed", tag: "opds", produces: []string{opdsNavigationType}},
+		"GET /opds/new":            {id: "opdsNewArrivals", summary: "OPDS feed of recently added books", tag: "opds", produces: []string{opdsAcquisitionType}},
+		"GET /opds/genres":         {id: "opdsGenres", summary: "OPDS navigation feed of genres", tag: "opds", produces: []string{opdsNavigationType}},
+		"GET /opds/genres/{genre}": {id: "opdsGenre", summary: "OPDS feed of the books in a genre", tag: "opds", produces: []string{opdsAcquisitionType}},
+		"GET /opds/search":         {id: "opdsSearch", summary: "OPDS search results", tag: "opds", produces: []string{opdsAcquisitionType}},
+		"GET /opds/opensearch.xml": {id: "opdsOpenSearch", summary: "OpenSearch description", tag: "opds", produces: []string{openSearchType}},
+		"GET /opds/books/{id}":     {id: "opdsBook", summary: "OPDS entry of a book", tag: "opds", produces: []string{opdsEntryType}},
+		"GET /oai":                 {id: "oaiGet", summary: "OAI-PMH 2.0 requests", tag: "oai", produces: []string{"text/xml"}},
+		"POST /oai":                {id: "oaiPost", summary: "OAI-PMH 2.0 requests", tag: "oai", produces: []string{"text/xml"}},
+		"GET /sru":                 {id: "sruSearchRetrieve", summary: "SRU 2.0 searchRetrieve", tag: "sru", produces: []string{sruContentType}},
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// schemaGenerator builds schemas from Go types, collecting named structs as components.
+type schemaGenerator struct {
+	components map[string]*jsonSchema
+}
+
+func (g *schemaGenerator) schemaName(t reflect.Type) string {
+	if name, ok := openAPISchemaNames[t]; ok {
+		return name
+	}
+	return strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) *jsonSchema {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.Interface:
+		return &jsonSchema{}
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema := &jsonSchema{Type: "integer"}
+		if t.Kind() == reflect.Int64 {
+			schema.Format = "int64"
+		}
+		return schema
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &jsonSchema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return &jsonSchema{Type: "string", Format: "date-time"}
+		}
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := g.schemaName(t)
+		if _, ok := g.components[name]; !ok {
+			g.components[name] = nil // guards against recursive types
+			g.components[name] = g.structSchema(t)
+		}
+		return &jsonSchema{Ref: "#/components/schemas/" + name}
+	}
+	return &jsonSchema{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *jsonSchema {
+	schema := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}}
+	input, isInput := openAPIInputs[t]
+	if isInput {
+		schema.AdditionalProperties = false
+	}
+	g.addFields(schema, t, input.model, isInput, input.partial)
+	return schema
+}
+
+func (g *schemaGenerator) addFields(schema *jsonSchema, t, model reflect.Type, isInput, partial bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			g.addFields(schema, field.Type, model, isInput, partial)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		validateTag := field.Tag.Get("validate")
+		if model != nil {
+			validateTag = ""
+			if modelField, ok := structFieldByJSONName(model, name); ok {
+				validateTag = modelField.Tag.Get("validate")
+			}
+		}
+		var property *jsonSchema
+		var required bool
+		if strings.Contains(opts, "string") && field.Type.Kind() >= reflect.Int && field.Type.Kind() <= reflect.Uint64 {
+			// Numbers encoded as strings keep their validate rules out of the schema, since
+			// the numeric keywords do not apply to strings.
+			property = &jsonSchema{Type: "string", Pattern: "^-?[0-9]+$"}
+			required = strings.HasPrefix(validateTag, "required")
+		} else {
+			property = g.schema(field.Type)
+			required = applyValidateTag(property, validateTag, field.Type)
+		}
+		// Responses nothing validates list their values in an enum tag instead.
+		for _, value := range strings.Fields(field.Tag.Get("enum")) {
+			property.Enum = append(property.Enum, value)
+		}
+		// Null leaves a field unchanged on partial updates.
+		if isInput && (field.Type.Kind() == reflect.Pointer || partial && field.Type.Kind() == reflect.Slice) {
+			property.Type = []any{property.Type, "null"}
+		}
+
+		switch {
+		case isInput && required && !partial:
+			schema.Required = append(schema.Required, name)
+		case !isInput && !strings.Contains(opts, "omitempty"):
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+}
+
+// structFieldByJSONName finds the field of struct type t encoded under the given JSON name.
+func structFieldByJSONName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if tagName, _, _ := strings.Cut(field.Tag.Get("json"), ","); tagName == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// applyValidateTag translates the go-playground/validator rules understood by the models into
+// schema keywords and reports whether the value is required. Rules after dive apply to the
+// items of a slice.
+func applyValidateTag(schema *jsonSchema, tag string, t reflect.Type) bool {
+	if tag == "" {
+		return false
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	rules, itemRules, dive := strings.Cut(tag, ",dive,")
+	if dive && schema.Items != nil {
+		applyValidateTag(schema.Items, itemRules, t.Elem())
+	}
+	required := false
+	for _, rule := range strings.Split(rules, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		n, _ := strconv.Atoi(param)
+		f := float64(n)
+		switch kind := t.Kind(); {
+		case name == "required":
+			required = true
+			if kind == reflect.String {
+				schema.MinLength = ptr(1)
+			}
+		case name == "oneof" || name == "oneofci":
+			for _, value := range strings.Fields(param) {
+				schema.Enum = append(schema.Enum, value)
+			}
+		case name == "unique":
+			schema.UniqueItems = true
+		case name == "http_url" || name == "url":
+			schema.Format = "uri"
+		case name == "publication_date":
+			schema.Minimum, schema.Maximum = ptr(1430.0), ptr(float64(time.Now().Year()))
+		case kind == reflect.String:
+			switch name {
+			case "max":
+				schema.MaxLength = ptr(n)
+			case "min":
+				schema.MinLength = ptr(n)
+			}
+		case kind == reflect.Slice:
+			switch name {
+			case "max":
+				schema.MaxItems = ptr(n)
+			case "min":
+				schema.MinItems = ptr(n)
+			case "gt":
+				schema.MinItems = ptr(n + 1)
+			case "lt":
+				schema.MaxItems = ptr(n - 1)
+			}
+		default:
+			switch name {
+			case "max":
+				schema.Maximum = &f
+			case "min":
+				schema.Minimum = &f
+			case "gt":
+				schema.ExclusiveMinimum = &f
+			case "lt":
+				schema.ExclusiveMaximum = &f
+			}
+		}
+	}
+	return required
+}
+
+// build generates the document for the routes registered on router. Routes missing from
+// apiOperations are still listed, so the document never omits an endpoint.
+func (spec *openAPISpec) build(router chi.Routes) error {
+	g := &schemaGenerator{components: map[string]*jsonSchema{}}
+	*spec = openAPISpec{
+		OpenAPI:    "3.1.0",
+		Info:       openAPIInfo{Title: "plibrary API", Version: version},
+		Paths:      map[string]map[string]*openAPIOperation{},
+		Components: openAPIComponents{Schemas: g.components},
+	}
+	operations := apiOperations()
+	errorContent := map[string]*openAPIMediaType{
+		"application/json": {Schema: g.schema(reflect.TypeOf(errorDocument{}))},
+		problemContentType: {Schema: g.schema(reflect.TypeOf(problem{}))},
+	}
+	g.components["Error"].Properties["error"] = &jsonSchema{OneOf: []*jsonSchema{
+		{Type: "string"},
+		{Type: "object", AdditionalProperties: &jsonSchema{Type: "string"}},
+	}}
+
+	return chi.Walk(router, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		route = strings.TrimSuffix(route, "/*")
+		if route != "/" {
+			route = strings.TrimSuffix(route, "/")
+		}
+		op := operations[method+" "+route]
+		operation := &openAPIOperation{
+			OperationID: op.id,
+			Summary:     op.summary,
+			Parameters:  op.params,
+			Responses:   map[string]*openAPIResponse{},
+		}
+		if op.tag != "" {
+			operation.Tags = []string{op.tag}
+		}
+		if op.negotiated {
+			operation.Parameters = append(append([]*openAPIParameter{}, operation.Parameters...), negotiationParams(op.citations)...)
+		}
+		if op.request != nil {
+			operation.RequestBody = &openAPIRequestBody{
+				Required: true,
+				Content:  map[string]*openAPIMediaType{"application/json": {Schema: g.schema(reflect.TypeOf(op.request))}},
+			}
+		}
+
+		status := op.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := &openAPIResponse{Description: http.StatusText(status)}
+		if status != http.StatusNoContent && status != http.StatusSwitchingProtocols {
+			success.Content = map[string]*openAPIMediaType{}
+			switch {
+			case op.response != nil:
+				success.Content["application/json"] = &openAPIMediaType{Schema: g.schema(reflect.TypeOf(op.response))}
+			case len(op.produces) == 0:
+				success.Content["application/json"] = &openAPIMediaType{}
+			}
+			for _, mediaType := range op.produces {
+				success.Content[mediaType] = &openAPIMediaType{}
+			}
+		}
+		failure := &openAPIResponse{Description: "Error", Content: map[string]*openAPIMediaType{}}
+		for mediaType, content := range errorContent {
+			failure.Content[mediaType] = content
+		}
+		if op.negotiated {
+			for _, mediaType := range responseMediaTypePreference[1:] {
+				if success.Content != nil {
+					success.Content[mediaType] = &openAPIMediaType{}
+				}
+				failure.Content[mediaType] = &openAPIMediaType{}
+			}
+		}
+		if op.citations {
+			for name, mediaType := range citationFormats {
+				if name != mediaType {
+					continue
+				}
+				success.Content[mediaType] = &openAPIMediaType{}
+			}
+			success.Content["application/vnd.citationstyles.csl+json"].Schema = g.schema(reflect.TypeOf([]cslItem{}))
+		}
+		operation.Responses[strconv.Itoa(status)] = success
+		for status, response := range op.also {
+			operation.Responses[strconv.Itoa(status)] = &openAPIResponse{
+				Description: http.StatusText(status),
+				Content:     map[string]*openAPIMediaType{"application/json": {Schema: g.schema(reflect.TypeOf(response))}},
+			}
+		}
+		operation.Responses["default"] = failure
+
+		if spec.Paths[route] == nil {
+			spec.Paths[route] = map[string]*openAPIOperation{}
+		}
+		spec.Paths[route][strings.ToLower(method)] = operation
+		return nil
+	})
+}
+
+func (app *application) openAPIHandler(spec *openAPISpec) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp, err := json.MarshalIndent(spec, "", "\t")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		resp = append(resp, '\n')
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+	}
+}
+
+// openAPISpec returns the document for the routes of the application.
+func (app *application) openAPISpec() *openAPISpec {
+	spec := &openAPISpec{}
+	spec.build(app.routes())
+	return spec
+}
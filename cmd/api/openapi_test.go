@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// withoutPublishedMaximum drops the upper bound of publication years, which follows the
+// current year, from a decoded OpenAPI document.
+func withoutPublishedMaximum(doc map[string]any) {
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+	for _, schema := range schemas {
+		properties, _ := schema.(map[string]any)["properties"].(map[string]any)
+		if published, ok := properties["published"].(map[string]any); ok {
+			delete(published, "maximum")
+		}
+	}
+}
+
+func TestOpenAPIDocumentIsCurrent(t *testing.T) {
+	generated, err := json.Marshal(newTestApplication(t).openAPISpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed, err := os.ReadFile("../../openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want, got map[string]any
+	if err := json.Unmarshal(committed, &want); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(generated, &got); err != nil {
+		t.Fatal(err)
+	}
+	withoutPublishedMaximum(want)
+	withoutPublishedMaximum(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Error("openapi.json is out of date, run go generate in cmd/api")
+	}
+}
+
+func TestOpenAPIOperations(t *testing.T) {
+	spec := newMemoryApplication(t, config{}).openAPISpec()
+	seen := map[string]string{}
+	for path, operations := range spec.Paths {
+		for method, operation := range operations {
+			if operation.OperationID == "" || operation.Summary == "" {
+				t.Errorf("%s %s is missing from apiOperations", method, path)
+			}
+			if other, ok := seen[operation.OperationID]; ok {
+				t.Errorf("%s %s and %s share the operationId %s", method, path, other, operation.OperationID)
+			}
+			seen[operation.OperationID] = method + " " + path
+			if operation.Responses["default"] == nil {
+				t.Errorf("%s %s does not document error responses", method, path)
+			}
+		}
+	}
+
+	book := spec.Components.Schemas["BookInput"]
+	if book == nil || book.AdditionalProperties != false || len(book.Required) == 0 {
+		t.Fatalf("BookInput schema = %+v, want a closed object with required properties", book)
+	}
+	if title := book.Properties["title"]; title.MinLength == nil || *title.MinLength != 1 || title.MaxLength == nil || *title.MaxLength != 56 {
+		t.Errorf("BookInput title schema = %+v, want the lengths of its validate tag", title)
+	}
+	ready := spec.Components.Schemas["Readiness"].Properties["status"]
+	if ready == nil || !reflect.DeepEqual(ready.Enum, []any{"ready", "unavailable"}) {
+		t.Errorf("Readiness status schema = %+v, want the values of its enum tag", ready)
+	}
+}
+
+func TestFindOperation(t *testing.T) {
+	spec := newMemoryApplication(t, config{}).openAPISpec()
+	for _, test := range []struct {
+		method, path, id string
+		params           map[string]string
+	}{
+		{"GET", "/v1/books", "listBooks", map[string]string{}},
+		{"GET", "/v1/books/search", "searchBooks", map[string]string{}},
+		{"GET", "/v1/books/42", "getBook", map[string]string{"id": "42"}},
+		{"PATCH", "/v1/books/42/", "updateBook", map[string]string{"id": "42"}},
+		{"POST", "/v1/webhooks/3/deliveries/9/redeliver", "redeliverWebhookDelivery", map[string]string{"id": "3", "deliveryID": "9"}},
+		{"PUT", "/v1/books/42", "", nil},
+		{"GET", "/v2/books", "", nil},
+	} {
+		operation, params := spec.findOperation(test.method, test.path)
+		var id string
+		if operation != nil {
+			id = operation.OperationID
+		}
+		if id != test.id || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("findOperation(%s %s) = %q, %v; want %q, %v", test.method, test.path, id, params, test.id, test.params)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/themilar/plibrary/internal/logging"
+)
+
+// validate checks value, decoded with json.Decoder.UseNumber, against the schema and records
+// violations in errs keyed by the location of the offending value. Required properties are
+// not enforced when partial is set, which is the case for sparse fieldset responses.
+func (spec *openAPISpec) validate(schema *jsonSchema, value any, at string, partial bool, errs map[string]string) {
+	if schema == nil {
+		return
+	}
+	if schema.Ref != "" {
+		spec.validate(spec.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")], value, at, partial, errs)
+		return
+	}
+	fail := func(format string, args ...any) {
+		if _, ok := errs[at]; !ok {
+			errs[at] = fmt.Sprintf(format, args...)
+		}
+	}
+	if len(schema.OneOf) > 0 {
+		matches := 0
+		for _, option := range schema.OneOf {
+			optionErrs := map[string]string{}
+			spec.validate(option, value, at, partial, optionErrs)
+			if len(optionErrs) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			fail("must match exactly one schema, matched %d", matches)
+		}
+		return
+	}
+	if schema.Type != nil && !schemaAllowsType(schema.Type, value) {
+		fail("must be of type %v", schema.Type)
+		return
+	}
+	if len(schema.Enum) > 0 {
+		found := false
+		for _, option := range schema.Enum {
+			if fmt.Sprint(option) == fmt.Sprint(value) {
+				found = true
+			}
+		}
+		if !found {
+			fail("must be one of %v", schema.Enum)
+			return
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := len([]rune(v))
+		if schema.MinLength != nil && length < *schema.MinLength {
+			fail("must be at least %d characters long", *schema.MinLength)
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			fail("must be at most %d characters long", *schema.MaxLength)
+		}
+		if schema.Pattern != "" {
+			if ok, err := regexp.MatchString(schema.Pattern, v); err == nil && !ok {
+				fail("must match %s", schema.Pattern)
+			}
+		}
+	case json.Number:
+		n, _ := v.Float64()
+		if schema.Minimum != nil && n < *schema.Minimum {
+			fail("must be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && n > *schema.Maximum {
+			fail("must be at most %v", *schema.Maximum)
+		}
+		if schema.ExclusiveMinimum != nil && n <= *schema.ExclusiveMinimum {
+			fail("must be greater than %v", *schema.ExclusiveMinimum)
+		}
+		if schema.ExclusiveMaximum != nil && n >= *schema.ExclusiveMaximum {
+			fail("must be less than %v", *schema.ExclusiveMaximum)
+		}
+	case []any:
+		if schema.MinItems != nil && len(v) < *schema.MinItems {
+			fail("must contain at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(v) > *schema.MaxItems {
+			fail("must contain at most %d items", *schema.MaxItems)
+		}
+		for i, item := range v {
+			if schema.UniqueItems {
+				for _, other := range v[:i] {
+					if reflect.DeepEqual(item, other) {
+						fail("must not contain duplicate items")
+					}
+				}
+			}
+			spec.validate(schema.Items, item, fmt.Sprintf("%s[%d]", at, i), partial, errs)
+		}
+	case map[string]any:
+		if !partial {
+			for _, name := range schema.Required {
+				if _, ok := v[name]; !ok {
+					errs[joinPointer(at, name)] = "is required"
+				}
+			}
+		}
+		for name, property := range v {
+			if propertySchema, ok := schema.Properties[name]; ok {
+				spec.validate(propertySchema, property, joinPointer(at, name), partial, errs)
+				continue
+			}
+			switch additional := schema.AdditionalProperties.(type) {
+			case bool:
+				if !additional {
+					errs[joinPointer(at, name)] = "is not allowed"
+				}
+			case *jsonSchema:
+				spec.validate(additional, property, joinPointer(at, name), partial, errs)
+			}
+		}
+	}
+}
+
+func joinPointer(at, name string) string {
+	if at == "" {
+		return name
+	}
+	return at + "." + name
+}
+
+func schemaAllowsType(schemaType, value any) bool {
+	types, ok := schemaType.([]any)
+	if !ok {
+		types = []any{schemaType}
+	}
+	for _, t := range types {
+		switch v := value.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case json.Number:
+			f, err := v.Float64()
+			if t == "number" || t == "integer" && err == nil && f == math.Trunc(f) {
+				return true
+			}
+		case []any:
+			if t == "array" {
+				return true
+			}
+		case map[string]any:
+			if t == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// findOperation matches a request path against the documented paths, preferring the path
+// with the most literal segments so that /v1/books/search wins over /v1/books/{id}.
+func (spec *openAPISpec) findOperation(method, path string) (*openAPIOperation, map[string]string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	var best *openAPIOperation
+	var bestParams map[string]string
+	bestLiterals := -1
+	for template, operations := range spec.Paths {
+		operation, ok := operations[strings.ToLower(method)]
+		if !ok {
+			continue
+		}
+		parts := strings.Split(strings.Trim(template, "/"), "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+		params := map[string]string{}
+		literals := 0
+		matched := true
+		for i, part := range parts {
+			if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+				params[part[1:len(part)-1]] = segments[i]
+			} else if part == segments[i] {
+				literals++
+			} else {
+				matched = false
+				break
+			}
+		}
+		if matched && literals > bestLiterals {
+			best, bestParams, bestLiterals = operation, params, literals
+		}
+	}
+	return best, bestParams
+}
+
+// parameterValue converts a raw path or query parameter to the JSON value its schema expects.
+func parameterValue(param *openAPIParameter, raw string) any {
+	switch param.Schema.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(raw, 64); err == nil {
+			return json.Number(raw)
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(raw); err == nil {
+			return b
+		}
+	case "array":
+		items := []any{}
+		for _, item := range strings.Split(raw, ",") {
+			items = append(items, item)
+		}
+		return items
+	}
+	return raw
+}
+
+func (spec *openAPISpec) validateRequest(r *http.Request, operation *openAPIOperation, pathParams map[string]string) (map[string]string, error) {
+	errs := map[string]string{}
+	qs := r.URL.Query()
+	for _, param := range operation.Parameters {
+		raw, present := pathParams[param.Name], param.In == "path"
+		switch param.In {
+		case "query":
+			raw, present = qs.Get(param.Name), qs.Get(param.Name) != ""
+		case "header":
+			raw, present = r.Header.Get(param.Name), r.Header.Get(param.Name) != ""
+		}
+		if !present {
+			if param.Required {
+				errs[param.Name] = "is required"
+			}
+			continue
+		}
+		spec.validate(param.Schema, parameterValue(param, raw), param.Name, false, errs)
+	}
+
+	if operation.RequestBody == nil {
+		return errs, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	var value any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	// Bodies that are not JSON at all are left for the handler to reject.
+	if dec.Decode(&value) == nil {
+		spec.validate(operation.RequestBody.Content["application/json"].Schema, value, "body", false, errs)
+	}
+	return errs, nil
+}
+
+func (spec *openAPISpec) validateResponse(operation *openAPIOperation, status int, contentType string, body []byte, partial bool) map[string]string {
+	errs := map[string]string{}
+	response, ok := operation.Responses[strconv.Itoa(status)]
+	if !ok && status >= 400 {
+		response, ok = operation.Responses["default"]
+	}
+	if !ok {
+		errs["status"] = fmt.Sprintf("status %d is not documented", status)
+		return errs
+	}
+	if len(response.Content) == 0 {
+		return errs
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		errs["content-type"] = fmt.Sprintf("invalid Content-Type %q", contentType)
+		return errs
+	}
+	var content *openAPIMediaType
+	for documented, c := range response.Content {
+		if base, _, err := mime.ParseMediaType(documented); err == nil && base == mediaType {
+			content = c
+		}
+	}
+	if content == nil {
+		errs["content-type"] = fmt.Sprintf("Content-Type %s is not documented", mediaType)
+		return errs
+	}
+	if content.Schema == nil {
+		return errs
+	}
+	var value any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		errs["body"] = "is not valid JSON"
+		return errs
+	}
+	spec.validate(content.Schema, value, "body", partial, errs)
+	return errs
+}
+
+// bufferedResponse holds back a response so that it can be validated before it is sent.
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// validateOpenAPI checks requests and responses of documented operations against spec.
+// Invalid requests are rejected with 422 before reaching the handler; responses that do not
+// match are logged and sent unchanged. It buffers every response, so it is meant for
+// development and testing only.
+func (app *application) validateOpenAPI(spec *openAPISpec) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			operation, pathParams := spec.findOperation(r.Method, r.URL.Path)
+			if operation == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			errs, err := spec.validateRequest(r, operation, pathParams)
+			if err != nil {
+				app.badRequestErrorResponse(w, r, err)
+				return
+			}
+			if len(errs) > 0 {
+				app.failedValidationErrorResponse(w, r, errs)
+				return
+			}
+			// Streams cannot be held back until they end, and WebSockets take over the
+			// connection.
+			if success := operation.Responses["200"]; operation.Responses["101"] != nil || success != nil && success.Content["text/event-stream"] != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			buffered := &bufferedResponse{ResponseWriter: w}
+			next.ServeHTTP(buffered, r)
+			if buffered.status == 0 {
+				buffered.status = http.StatusOK
+			}
+			partial := r.URL.Query().Get("fields") != ""
+			errs = spec.validateResponse(operation, buffered.status, w.Header().Get("Content-Type"), buffered.body.Bytes(), partial)
+			if len(errs) > 0 {
+				logging.FromContext(r.Context()).Error("response does not match the OpenAPI document",
+					"request_method", r.Method, "request_url", r.URL.String(), "status", buffered.status, "errors", errs)
+			}
+			w.WriteHeader(buffered.status)
+			w.Write(buffered.body.Bytes())
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIValidate(t *testing.T) {
+	spec := newMemoryApplication(t, config{}).openAPISpec()
+	book := &jsonSchema{Ref: "#/components/schemas/BookInput"}
+
+	for _, test := range []struct {
+		body    string
+		partial bool
+		errs    map[string]string
+	}{
+		{`{"title": "Dune", "published": 1965, "pages": 412, "genres": ["sci-fi"]}`, false, map[string]string{}},
+		{`{"title": "Dune"}`, false, map[string]string{
+			"body.published": "is required",
+			"body.pages":     "is required",
+			"body.genres":    "is required",
+		}},
+		{`{"title": "Dune"}`, true, map[string]string{}},
+		{`{"title": "", "published": 1965.5, "pages": 0, "genres": ["sci-fi", "sci-fi"], "isbn": "0441013597"}`, false, map[string]string{
+			"body.title":     "must be at least 1 characters long",
+			"body.published": "must be of type integer",
+			"body.pages":     "must be greater than 0",
+			"body.genres":    "must not contain duplicate items",
+			"body.isbn":      "is not allowed",
+		}},
+		{`{"title": "Dune", "published": 1200, "pages": 412, "genres": [], "authors": ["Frank Herbert", 7]}`, false, map[string]string{
+			"body.published":  "must be at least 1430",
+			"body.genres":     "must contain at least 1 items",
+			"body.authors[1]": "must be of type string",
+		}},
+		{`["Dune"]`, false, map[string]string{"body": "must be of type object"}},
+	} {
+		var value any
+		dec := json.NewDecoder(strings.NewReader(test.body))
+		dec.UseNumber()
+		if err := dec.Decode(&value); err != nil {
+			t.Fatal(err)
+		}
+		errs := map[string]string{}
+		spec.validate(book, value, "body", test.partial, errs)
+		if len(errs) != len(test.errs) {
+			t.Errorf("validate(%s) = %v, want %v", test.body, errs, test.errs)
+			continue
+		}
+		for at, message := range test.errs {
+			if errs[at] != message {
+				t.Errorf("validate(%s) at %s = %q, want %q", test.body, at, errs[at], message)
+			}
+		}
+	}
+
+	// The error property of error documents is a message or a map of them.
+	for body, valid := range map[string]bool{
+		`{"error": "not found"}`:           true,
+		`{"error": {"title": "required"}}`: true,
+		`{"error": 404}`:                   false,
+	} {
+		errs := spec.validateResponse(spec.Paths["/v1/books/{id}"]["get"], http.StatusNotFound, "application/json", []byte(body), false)
+		if (len(errs) == 0) != valid {
+			t.Errorf("validateResponse(404, %s) = %v, want valid %t", body, errs, valid)
+		}
+	}
+}
+
+func TestValidateOpenAPIMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	var cfg config
+	cfg.openapi.validate = true
+	app := newMemoryApplication(t, cfg)
+	app.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	handler := app.routes()
+
+	for _, test := range []struct {
+		method, target, body string
+		status               int
+		errs                 string
+	}{
+		{http.MethodGet, "/v1/books?page=first", "", http.StatusUnprocessableEntity, `"page": "must be of type integer"`},
+		{http.MethodGet, "/v1/books/abc", "", http.StatusUnprocessableEntity, `"id": "must be of type integer"`},
+		{http.MethodGet, "/v1/books?sort=-title&size=2", "", http.StatusOK, ""},
+		{http.MethodGet, "/v1/books/1?fields=title", "", http.StatusAccepted, ""},
+		{http.MethodPost, "/v1/books", `{"title": "Dune", "isbn": "0441013597"}`, http.StatusUnprocessableEntity, `"body.isbn": "is not allowed"`},
+		// Bodies that are not JSON are left for the handler.
+		{http.MethodPost, "/v1/books", `{"title": `, http.StatusBadRequest, "badly-formed JSON"},
+		{http.MethodGet, "/opds/unknown", "", http.StatusNotFound, ""},
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(test.method, test.target, strings.NewReader(test.body)))
+		if rr.Code != test.status || !strings.Contains(rr.Body.String(), test.errs) {
+			t.Errorf("%s %s: status %d, body %s; want %d with %s", test.method, test.target, rr.Code, rr.Body, test.status, test.errs)
+		}
+	}
+	if !strings.Contains(logs.String(), "/v1/books") || strings.Contains(logs.String(), "does not match the OpenAPI document") {
+		t.Errorf("access logs are missing or report responses as not matching the document:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/themilar/plibrary/internal/models"
+)
+
+const (
+	outboxBatchSize = 100
+	// outboxBatchHold is the longest a batch may hold the relay lock while publishing.
+	outboxBatchHold = 30 * time.Second
+	// outboxRetention is how long published events are kept before they are purged.
+	outboxRetention = 7 * 24 * time.Hour
+)
+
+// outboxSink is a destination for change events. Events reach every sink at least once and
+// in order, but may be repeated after a failure; consumers discard copies by message id.
+type outboxSink interface {
+	publish(ctx context.Context, event *models.OutboxEvent, message []byte) error
+}
+
+// outboxMessage is the JSON form of an event sent to every sink.
+func outboxMessage(event *models.OutboxEvent) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"id":          event.DedupID,
+		"event":       event.Event,
+		"occurred_at": event.CreatedAt.UTC(),
+		"data":        event.Payload,
+	})
+}
+
+// webhookSink queues events for delivery to the webhooks subscribed to them.
+type webhookSink struct {
+	deliveries models.DeliveryModel
+}
+
+func (s webhookSink) publish(_ context.Context, event *models.OutboxEvent, message []byte) error {
+	_, err := s.deliveries.Enqueue(event.DedupID, event.Event, message)
+	return err
+}
+
+// writerSink writes events as JSON lines, syncing files after each one.
+type writerSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *writerSink) publish(_ context.Context, _ *models.OutboxEvent, message []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(append(message, '\n')); err != nil {
+		return err
+	}
+	if f, ok := s.w.(*os.File); ok && f != os.Stdout {
+		return f.Sync()
+	}
+	return nil
+}
+
+// httpSink POSTs each event to a URL, which must answer with a 2xx status.
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s httpSink) publish(ctx context.Context, event *models.OutboxEvent, message []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "plibrary-outbox/"+version)
+	req.Header.Set("Idempotency-Key", event.DedupID)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s answered %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+// outboxSinks parses the -outbox-sinks flag: a comma separated list of webhooks, stdout,
+// file:PATH and http:URL.
+func (app *application) outboxSinks(spec string) ([]outboxSink, error) {
+	var sinks []outboxSink
+	for _, item := range strings.Split(spec, ",") {
+		kind, target, _ := strings.Cut(strings.TrimSpace(item), ":")
+		switch {
+		case kind == "":
+		case kind == "webhooks":
+			sinks = append(sinks, webhookSink{deliveries: app.models.Deliveries})
+		case kind == "stdout":
+			sinks = append(sinks, &writerSink{w: os.Stdout})
+		case kind == "file" && target != "":
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, &writerSink{w: f})
+		case kind == "http" && target != "":
+			sinks = append(sinks, httpSink{url: target, client: &http.Client{Timeout: 10 * time.Second}})
+		default:
+			return nil, fmt.Errorf("invalid outbox sink %q", item)
+		}
+	}
+	return sinks, nil
+}
+
+// runOutboxRelay publishes recorded events to sinks every poll interval until ctx is
+// cancelled, and purges old published events once an hour.
+func (app *application) runOutboxRelay(ctx context.Context, sinks []outboxSink) {
+	ticker := time.NewTicker(app.config.outbox.pollInterval)
+	defer ticker.Stop()
+	purge := time.NewTicker(time.Hour)
+	defer purge.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.relayOutbox(ctx, sinks)
+		case <-purge.C:
+			if _, err := app.models.Outbox.Purge(ctx, time.Now().Add(-outboxRetention)); err != nil {
+				app.logger.Error("could not purge the outbox", "error", err.Error())
+			}
+		}
+	}
+}
+
+// relayOutbox publishes batches of events until none are left or a sink fails, in which case
+// the failed event is tried again, after those before it, on the next poll. A batch cut short
+// by outboxBatchHold carries on with the next one.
+func (app *application) relayOutbox(ctx context.Context, sinks []outboxSink) {
+	publish := func(ctx context.Context, event *models.OutboxEvent) error {
+		message, err := outboxMessage(event)
+		if err != nil {
+			return err
+		}
+		for _, sink := range sinks {
+			if err := sink.publish(ctx, event, message); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for ctx.Err() == nil {
+		n, err := app.models.Outbox.Relay(ctx, outboxBatchSize, outboxBatchHold, publish)
+		if err != nil {
+			if ctx.Err() == nil {
+				app.logger.Error("could not relay outbox events", "error", err.Error())
+			}
+			return
+		}
+		if n < outboxBatchSize {
+			return
+		}
+	}
+}
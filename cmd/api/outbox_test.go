@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/themilar/plibrary/internal/models"
+)
+
+var testEvent = &models.OutboxEvent{
+	ID:          7,
+	DedupID:     "3f1c2a52-8f0e-4d2b-9a43-6d3d2b1c0e11",
+	CreatedAt:   time.Date(2024, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600)),
+	Event:       models.EventBookCreated,
+	AggregateID: 1,
+	Payload:     json.RawMessage(`{"id":1,"title":"Dune"}`),
+}
+
+func TestOutboxMessage(t *testing.T) {
+	message, err := outboxMessage(testEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"data":{"id":1,"title":"Dune"},"event":"book.created","id":"3f1c2a52-8f0e-4d2b-9a43-6d3d2b1c0e11","occurred_at":"2024-03-01T11:00:00Z"}`
+	if string(message) != want {
+		t.Errorf("outboxMessage = %s, want %s", message, want)
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := &writerSink{w: &buf}
+	for _, message := range []string{`{"n":1}`, `{"n":2}`} {
+		if err := sink.publish(context.Background(), testEvent, []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := "{\"n\":1}\n{\"n\":2}\n"; buf.String() != want {
+		t.Errorf("written %q, want %q", buf.String(), want)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	status := http.StatusAccepted
+	var got *http.Request
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	sink := httpSink{url: receiver.URL, client: receiver.Client()}
+	if err := sink.publish(context.Background(), testEvent, []byte(`{"n":1}`)); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if got.Method != http.MethodPost || got.Header.Get("Content-Type") != "application/json" || string(body) != `{"n":1}` {
+		t.Errorf("received %s with Content-Type %q and body %s", got.Method, got.Header.Get("Content-Type"), body)
+	}
+	if key := got.Header.Get("Idempotency-Key"); key != testEvent.DedupID {
+		t.Errorf("Idempotency-Key = %q, want %q", key, testEvent.DedupID)
+	}
+
+	status = http.StatusServiceUnavailable
+	err := sink.publish(context.Background(), testEvent, []byte(`{"n":1}`))
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("publish to a receiver answering 503: error %v, want one naming the status", err)
+	}
+
+	// The relay's deadline reaches the request.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := sink.publish(ctx, testEvent, []byte(`{}`)); err == nil {
+		t.Error("publish with a cancelled context succeeded")
+	}
+}
+
+func TestOutboxSinks(t *testing.T) {
+	app := &application{}
+	path := t.TempDir() + "/events.jsonl"
+	for _, test := range []struct {
+		spec  string
+		kinds []string
+		err   bool
+	}{
+		{"", nil, false},
+		{"webhooks", []string{"main.webhookSink"}, false},
+		{"webhooks, stdout, file:" + path + ", http:https://example.com/events", []string{"main.webhookSink", "*main.writerSink", "*main.writerSink", "main.httpSink"}, false},
+		{"file:", nil, true},
+		{"http:", nil, true},
+		{"kafka:events", nil, true},
+	} {
+		sinks, err := app.outboxSinks(test.spec)
+		if (err != nil) != test.err {
+			t.Errorf("outboxSinks(%q) error = %v, want error %v", test.spec, err, test.err)
+			continue
+		}
+		var kinds []string
+		for _, sink := range sinks {
+			kinds = append(kinds, fmt.Sprintf("%T", sink))
+		}
+		if strings.Join(kinds, ",") != strings.Join(test.kinds, ",") {
+			t.Errorf("outboxSinks(%q) = %v, want %v", test.spec, kinds, test.kinds)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type contextKey string
+
+const responseFormatContextKey = contextKey("responseFormat")
+
+// responseFormat is the representation negotiated for a request by negotiateContent.
+type responseFormat struct {
+	mediaType string
+	compact   bool
+}
+
+// responseMediaTypes maps ?format= values and accepted media type aliases to the media types
+// writeResponse can render. The order of responseMediaTypePreference decides which one is
+// picked for wildcards such as */* or text/*.
+var responseMediaTypes = map[string]string{
+	"json":                  "application/json",
+	"xml":                   "application/xml",
+	"csv":                   "text/csv",
+	"msgpack":               "application/msgpack",
+	"application/json":      "application/json",
+	problemContentType:      "application/json",
+	"application/xml":       "application/xml",
+	"text/xml":              "application/xml",
+	"text/csv":              "text/csv",
+	"application/msgpack":   "application/msgpack",
+	"application/x-msgpack": "application/msgpack",
+}
+
+var responseMediaTypePreference = []string{"application/json", "application/xml", "text/csv", "application/msgpack"}
+
+// negotiateContent picks the response representation from the ?format= parameter or the
+// Accept header and stores it in the request context, responding with 406 Not Acceptable
+// when none of the accepted media types can be produced. Routes that can render additional
+// media types, such as citations, pass them in extra using the same shape as
+// responseMediaTypes. Clients can ask for unindented JSON with ?compact=true.
+func (app *application) negotiateContent(extra map[string]string) func(http.Handler) http.Handler {
+	available := make(map[string]string, len(responseMediaTypes)+len(extra))
+	preference := append([]string{}, responseMediaTypePreference...)
+	for k, v := range responseMediaTypes {
+		available[k] = v
+	}
+	for k, v := range extra {
+		available[k] = v
+		if k == v {
+			preference = append(preference, v)
+		}
+	}
+	sort.Strings(preference[len(responseMediaTypePreference):])
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			qs := r.URL.Query()
+			var format responseFormat
+			if compact := qs.Get("compact"); compact != "" {
+				c, err := strconv.ParseBool(compact)
+				if err != nil {
+					app.badRequestErrorResponse(w, r, errors.New("compact must be a boolean"))
+					return
+				}
+				format.compact = c
+			}
+			if f := qs.Get("format"); f != "" {
+				mediaType, ok := available[strings.ToLower(f)]
+				if !ok {
+					app.badRequestErrorResponse(w, r, fmt.Errorf("unsupported format %q", f))
+					return
+				}
+				format.mediaType = mediaType
+			} else {
+				mediaType, ok := negotiateMediaType(r.Header.Get("Accept"), available, preference)
+				if !ok {
+					app.notAcceptableResponse(w, r, preference)
+					return
+				}
+				format.mediaType = mediaType
+			}
+			ctx := context.WithValue(r.Context(), responseFormatContextKey, format)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+type acceptedMediaType struct {
+	mediaType string
+	q         float64
+}
+
+// negotiateMediaType returns the available media type the client prefers most. An absent
+// Accept header means anything is acceptable.
+func negotiateMediaType(accept string, available map[string]string, preference []string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return preference[0], true
+	}
+	var accepted []acceptedMediaType
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			accepted = append(accepted, acceptedMediaType{mediaType: mediaType, q: q})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+
+	for _, a := range accepted {
+		if a.mediaType == "*/*" {
+			return preference[0], true
+		}
+		if major, ok := strings.CutSuffix(a.mediaType, "/*"); ok {
+			for _, mediaType := range preference {
+				if strings.HasPrefix(mediaType, major+"/") {
+					return mediaType, true
+				}
+			}
+			continue
+		}
+		if mediaType, ok := available[a.mediaType]; ok && strings.Contains(a.mediaType, "/") {
+			return mediaType, true
+		}
+	}
+	return "", false
+}
+
+func (app *application) responseFormat(r *http.Request) responseFormat {
+	format, ok := r.Context().Value(responseFormatContextKey).(responseFormat)
+	if !ok {
+		return responseFormat{mediaType: "application/json"}
+	}
+	return format
+}
+
+// writeResponse renders data in the representation negotiated for the request, falling back
+// to indented JSON when no negotiation took place.
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	format := app.responseFormat(r)
+	w.Header().Add("Vary", "Accept")
+	var resp []byte
+	var err error
+	contentType := format.mediaType
+	switch format.mediaType {
+	case "application/xml":
+		resp, err = renderXML(data)
+	case "text/csv":
+		resp, err = renderCSV(data)
+		contentType = "text/csv; charset=utf-8"
+	case "application/msgpack":
+		resp, err = renderMsgpack(data)
+	default:
+		if !format.compact {
+			return app.writeJson(w, status, data, headers)
+		}
+		resp, err = json.Marshal(data)
+		resp = append(resp, '\n')
+		contentType = "application/json"
+	}
+	if err != nil {
+		return err
+	}
+
+	for k, v := range headers {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+
+	w.Write(resp)
+	return nil
+}
+
+// jsonField and jsonObject hold a decoded JSON object with its keys in document order, so
+// that the XML and CSV renderings follow the field order of the JSON representation.
+type jsonField struct {
+	key   string
+	value any
+}
+
+type jsonObject []jsonField
+
+// toOrderedJSON converts data to its generic JSON form, honouring json struct tags. Objects
+// become jsonObject, arrays []any and numbers json.Number.
+func toOrderedJSON(data any) (any, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	return decodeOrderedJSON(dec)
+}
+
+func decodeOrderedJSON(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := jsonObject{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, jsonField{key: key.(string), value: value})
+		}
+		_, err = dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []any{}
+		for dec.More() {
+			value, err := decodeOrderedJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		_, err = dec.Token()
+		return arr, err
+	}
+	return tok, nil
+}
+
+// xmlName turns a JSON key into a valid XML element name.
+func xmlName(key string) string {
+	name := []rune(key)
+	for i, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' && r != '.' {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || !unicode.IsLetter(name[0]) && name[0] != '_' {
+		name = append([]rune{'_'}, name...)
+	}
+	return string(name)
+}
+
+// xmlItemName names the elements of an array after the singular of the array's name.
+func xmlItemName(key string) string {
+	if len(key) > 1 && strings.HasSuffix(key, "s") {
+		return strings.TrimSuffix(key, "s")
+	}
+	return "item"
+}
+
+func encodeXMLValue(enc *xml.Encoder, name string, value any) error {
+	start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case jsonObject:
+		for _, field := range v {
+			if err := encodeXMLValue(enc, field.key, field.value); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if err := encodeXMLValue(enc, xmlItemName(name), item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		if err := enc.EncodeToken(xml.CharData(fmt.Sprint(v))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+func renderXML(data envelope) ([]byte, error) {
+	value, err := toOrderedJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "\t")
+	if err := encodeXMLValue(enc, "response", value); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// flattenCSV flattens nested objects into dotted column names and joins arrays of scalars
+// into a single comma separated cell.
+func flattenCSV(prefix string, value any, row map[string]string, columns *[]string) {
+	set := func(key, cell string) {
+		if _, ok := row[key]; !ok {
+			*columns = append(*columns, key)
+		}
+		row[key] = cell
+	}
+	switch v := value.(type) {
+	case jsonObject:
+		for _, field := range v {
+			key := field.key
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flattenCSV(key, field.value, row, columns)
+		}
+	case []any:
+		cells := make([]string, 0, len(v))
+		for _, item := range v {
+			if _, ok := item.(jsonObject); ok {
+				raw, _ := json.Marshal(item)
+				cells = append(cells, string(raw))
+				continue
+			}
+			cells = append(cells, fmt.Sprint(item))
+		}
+		set(prefix, strings.Join(cells, ","))
+	case nil:
+		set(prefix, "")
+	default:
+		set(prefix, fmt.Sprint(v))
+	}
+}
+
+// renderCSV writes one row per element when the envelope holds a single list (pagination
+// metadata aside), and a single row otherwise.
+func renderCSV(data envelope) ([]byte, error) {
+	value, err := toOrderedJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	var items []any
+	var fields jsonObject
+	for _, field := range value.(jsonObject) {
+		if field.key != "metadata" {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 1 {
+		switch v := fields[0].value.(type) {
+		case []any:
+			items = v
+		case jsonObject:
+			items = []any{v}
+		}
+	}
+	if items == nil {
+		items = []any{fields}
+	}
+
+	var columns []string
+	rows := make([]map[string]string, len(items))
+	for i, item := range items {
+		rows[i] = map[string]string{}
+		flattenCSV("", item, rows[i], &columns)
+	}
+	// Keep the first-seen column order while collecting columns that only appear in later rows.
+	seen := map[string]bool{}
+	var header []string
+	for _, column := range columns {
+		if !seen[column] {
+			seen[column] = true
+			header = append(header, column)
+		}
+	}
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	if len(header) > 0 {
+		cw.Write(header)
+	}
+	for _, row := range rows {
+		record := make([]string, len(header))
+		for i, column := range header {
+			record[i] = row[column]
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+// toMsgpackValue converts the generic JSON form into plain maps and numbers.
+func toMsgpackValue(value any) any {
+	switch v := value.(type) {
+	case jsonObject:
+		m := make(map[string]any, len(v))
+		for _, field := range v {
+			m[field.key] = toMsgpackValue(field.value)
+		}
+		return m
+	case []any:
+		for i := range v {
+			v[i] = toMsgpackValue(v[i])
+		}
+		return v
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return value
+}
+
+func renderMsgpack(data envelope) ([]byte, error) {
+	value, err := toOrderedJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(toMsgpackValue(value))
+}
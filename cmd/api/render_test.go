@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestNegotiateMediaType(t *testing.T) {
+	available := map[string]string{"json": "application/json", "application/x-bibtex": "application/x-bibtex"}
+	for k, v := range responseMediaTypes {
+		available[k] = v
+	}
+	preference := append(append([]string{}, responseMediaTypePreference...), "application/x-bibtex")
+
+	for _, test := range []struct {
+		accept, want string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"text/*", "text/csv"},
+		{"application/xml", "application/xml"},
+		{"text/xml", "application/xml"},
+		{"application/problem+json", "application/json"},
+		{"application/x-msgpack", "application/msgpack"},
+		{"application/x-bibtex", "application/x-bibtex"},
+		{"text/html, application/xml;q=0.5, text/csv;q=0.9", "text/csv"},
+		{"application/xml;q=0.4, */*;q=0.1", "application/xml"},
+		{"text/csv;q=0, application/json", "application/json"},
+		{"text/csv;q=oops, application/msgpack;q=0.2", "application/msgpack"},
+		// Aliases are only looked up as media types, not as ?format= values.
+		{"json", ""},
+		{"text/html", ""},
+		{"image/*", ""},
+		{"text/csv;q=0", ""},
+	} {
+		got, ok := negotiateMediaType(test.accept, available, preference)
+		if got != test.want || ok != (test.want != "") {
+			t.Errorf("negotiateMediaType(%q) = %q, %t; want %q", test.accept, got, ok, test.want)
+		}
+	}
+}
+
+var renderedEnvelope = envelope{
+	"book": map[string]any{
+		"id":      1,
+		"title":   "Dune & Sons",
+		"authors": []string{"Frank Herbert", "Brian Herbert"},
+		"details": map[string]any{"pages": 412, "2nd edition": nil},
+	},
+}
+
+func TestRenderXML(t *testing.T) {
+	got, err := renderXML(renderedEnvelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<response>
+	<book>
+		<authors>
+			<author>Frank Herbert</author>
+			<author>Brian Herbert</author>
+		</authors>
+		<details>
+			<_2nd_edition></_2nd_edition>
+			<pages>412</pages>
+		</details>
+		<id>1</id>
+		<title>Dune &amp; Sons</title>
+	</book>
+</response>
+`
+	if string(got) != want {
+		t.Errorf("renderXML =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRenderCSV(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data envelope
+		want string
+	}{
+		{"object", renderedEnvelope, "authors,details.2nd edition,details.pages,id,title\n\"Frank Herbert,Brian Herbert\",,412,1,Dune & Sons\n"},
+		{"list", envelope{
+			"books":    []map[string]any{{"id": 1, "title": "Dune"}, {"id": 2, "genres": []string{"sci-fi"}}},
+			"metadata": map[string]any{"total_records": 2},
+		}, "id,title,genres\n1,Dune,\n2,,sci-fi\n"},
+		{"fields", envelope{"status": "ready", "version": 3}, "status,version\nready,3\n"},
+	} {
+		got, err := renderCSV(test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("renderCSV(%s) =\n%q\nwant\n%q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRenderMsgpack(t *testing.T) {
+	raw, err := renderMsgpack(renderedEnvelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Book struct {
+			ID      int64    `msgpack:"id"`
+			Title   string   `msgpack:"title"`
+			Authors []string `msgpack:"authors"`
+			Details struct {
+				Pages   int64 `msgpack:"pages"`
+				Edition any   `msgpack:"2nd edition"`
+			} `msgpack:"details"`
+		} `msgpack:"book"`
+	}
+	if err := msgpack.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+	book := got.Book
+	if book.ID != 1 || book.Title != "Dune & Sons" || strings.Join(book.Authors, ", ") != "Frank Herbert, Brian Herbert" || book.Details.Pages != 412 || book.Details.Edition != nil {
+		t.Errorf("renderMsgpack decodes to %+v", book)
+	}
+}
+
+func TestNegotiateContent(t *testing.T) {
+	handler := newMemoryApplication(t, config{}).routes()
+
+	for _, test := range []struct {
+		target, accept string
+		status         int
+		contentType    string
+		prefix         string
+	}{
+		{"/v1/books/1", "", http.StatusAccepted, "application/json", "{\n\t\"book\""},
+		{"/v1/books/1?compact=true", "", http.StatusAccepted, "application/json", `{"book":{"id":1,`},
+		{"/v1/books/1?format=XML", "text/csv", http.StatusAccepted, "application/xml", `<?xml`},
+		{"/v1/books?format=csv&sort=id", "", http.StatusOK, "text/csv; charset=utf-8", "id,title,"},
+		{"/v1/books/1", "text/csv;q=0.5, application/msgpack", http.StatusAccepted, "application/msgpack", "\x81"},
+		{"/v1/books/1?format=yaml", "", http.StatusBadRequest, "application/json", ""},
+		{"/v1/books/1?compact=maybe", "", http.StatusBadRequest, "application/json", ""},
+		{"/v1/books/1", "text/html", http.StatusNotAcceptable, "application/json", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, test.target, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != test.status || rr.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("GET %s (Accept %q): status %d, Content-Type %q; want %d, %q",
+				test.target, test.accept, rr.Code, rr.Header().Get("Content-Type"), test.status, test.contentType)
+		}
+		if !strings.HasPrefix(rr.Body.String(), test.prefix) {
+			t.Errorf("GET %s (Accept %q): body %q, want it to start with %q", test.target, test.accept, rr.Body, test.prefix)
+		}
+		if test.status < 400 && !slices.Contains(rr.Header().Values("Vary"), "Accept") {
+			t.Errorf("GET %s: Vary %q does not name Accept", test.target, rr.Header().Values("Vary"))
+		}
+	}
+}
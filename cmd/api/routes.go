@@ -10,24 +10,82 @@ import (
 )
 
 func (app *application) routes() *chi.Mux {
+	spec := &openAPISpec{}
 	router := chi.NewRouter()
-	router.Use(app.requestLogger)
+	router.Use(app.traceRequests)
+	router.Use(app.requestID)
+	router.Use(app.metrics.instrument)
+	router.Use(app.logRequests)
 	router.Use(middleware.Recoverer)
 	if app.config.limiter.enabled {
-		router.Use(httprate.LimitByIP(app.config.limiter.rpm, time.Minute))
+		router.Use(httprate.Limit(app.config.limiter.rpm, time.Minute,
+			httprate.WithKeyByIP(),
+			httprate.WithLimitHandler(app.rateLimitExceededResponse),
+		))
+	}
+	if app.config.openapi.validate {
+		router.Use(app.validateOpenAPI(spec))
 	}
 	router.Use(cors.Handler(cors.Options{
-		AllowedOrigins: []string{"http://localhost:9000"},
+		AllowedOrigins: app.config.trustedOrigins(),
+		ExposedHeaders: []string{requestIDHeader},
 	}))
 	router.NotFound(app.notFoundErrorResponse)
 	router.MethodNotAllowed(app.methodNotAllowedErrorResponse)
 
 	router.Get("/v1/healthcheck", app.healthcheckHandler)
-	router.Get("/v1/books", app.bookList)
-	router.Get("/v1/books/search", app.bookSearch)
-	router.Post("/v1/books", app.bookCreate)
-	router.Get("/v1/books/{id}", app.bookDetail)
-	router.Patch("/v1/books/{id}", app.bookUpdate)
-	router.Delete("/v1/books/{id}", app.bookDelete)
+	router.Get("/v1/health/live", app.healthcheckHandler)
+	router.Get("/v1/health/ready", app.readinessHandler)
+	router.Method("GET", "/metrics", app.metrics.handler())
+	router.Get("/v1/openapi.json", app.openAPIHandler(spec))
+	router.With(app.requireStaff).Get("/v1/admin/config", app.configHandler)
+	router.Group(func(router chi.Router) {
+		router.Use(app.negotiateContent(citationFormats))
+		router.Get("/v1/books", app.bookList)
+		router.Get("/v1/books/search", app.bookSearch)
+		router.Get("/v1/books/{id}", app.bookDetail)
+	})
+	router.Get("/v1/books/stream", app.bookStream)
+	router.Get("/v1/ws", app.circulationSocket)
+	router.Group(func(router chi.Router) {
+		router.Use(app.negotiateContent(nil))
+		router.Post("/v1/books", app.bookCreate)
+		router.Patch("/v1/books/{id}", app.bookUpdate)
+		router.Delete("/v1/books/{id}", app.bookDelete)
+	})
+
+	router.Group(func(router chi.Router) {
+		router.Use(app.negotiateContent(nil), app.requireStaff)
+		router.Get("/v1/webhooks", app.webhookList)
+		router.Post("/v1/webhooks", app.webhookCreate)
+		router.Get("/v1/webhooks/{id}", app.webhookDetail)
+		router.Patch("/v1/webhooks/{id}", app.webhookUpdate)
+		router.Delete("/v1/webhooks/{id}", app.webhookDelete)
+		router.Get("/v1/webhooks/{id}/deliveries", app.webhookDeliveries)
+		router.Get("/v1/webhooks/{id}/deliveries/{deliveryID}", app.webhookDeliveryDetail)
+		router.Post("/v1/webhooks/{id}/deliveries/{deliveryID}/redeliver", app.webhookRedeliver)
+	})
+
+	schema := app.graphqlSchema()
+	router.Get("/v1/graphql", app.graphqlHandler(schema))
+	router.Post("/v1/graphql", app.graphqlHandler(schema))
+	router.Get("/v1/graphql/schema", app.graphqlSchemaHandler())
+
+	router.Get("/opds", app.opdsCatalog)
+	router.Get("/opds/new", app.opdsNewArrivals)
+	router.Get("/opds/genres", app.opdsGenreList)
+	router.Get("/opds/genres/{genre}", app.opdsGenreFeed)
+	router.Get("/opds/search", app.opdsSearch)
+	router.Get("/opds/opensearch.xml", app.opdsSearchDescription)
+	router.Get("/opds/books/{id}", app.opdsBookDetail)
+
+	router.Get("/oai", app.oaiHandler)
+	router.Post("/oai", app.oaiHandler)
+	router.Get("/sru", app.sruSearchRetrieve)
+
+	err := spec.build(router)
+	if err != nil {
+		app.logger.Error("could not generate the OpenAPI document", "error", err.Error())
+	}
 	return router
 }
@@ -5,28 +5,88 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
+
+	"google.golang.org/grpc"
 )
 
-func (app *application) serve() error {
-	var address string
+// errServerShutdown is the cause with which the requests still running when the shutdown
+// deadline passes are cancelled.
+var errServerShutdown = errors.New("the server is shutting down, try again")
+
+func (app *application) listenAddress(port int) string {
 	if app.config.env == "development" {
-		address = fmt.Sprintf("localhost:%d", app.config.port)
-	} else if app.config.env == "production" {
-		address = fmt.Sprintf(":%v", app.config.port)
+		return fmt.Sprintf("localhost:%d", port)
 	}
+	return fmt.Sprintf(":%d", port)
+}
+
+// serve runs the HTTP server, the gRPC server when it has a port and the background workers
+// (outbox relay, change listeners and webhook dispatcher) until SIGINT or SIGTERM, then
+// shuts them down gracefully within the same deadline.
+func (app *application) serve() error {
+	// Requests are given a context that is cancelled if they outlast the shutdown deadline,
+	// so that their queries are abandoned rather than left running.
+	baseCtx, cancelRequests := context.WithCancelCause(context.Background())
+	defer cancelRequests(nil)
 	srv := &http.Server{
-		Addr:         address,
+		Addr:         app.listenAddress(app.config.port),
+		BaseContext:  func(net.Listener) context.Context { return baseCtx },
 		Handler:      app.routes(),
-		ErrorLog:     slog.NewLogLogger(slog.NewJSONHandler(os.Stdout, nil), slog.LevelError),
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  time.Second * 10,
 		WriteTimeout: time.Second * 10,
 	}
+	sinks, err := app.outboxSinks(app.config.outbox.sinks)
+	if err != nil {
+		return err
+	}
+	var grpcSrv *grpc.Server
+	var grpcListener net.Listener
+	if app.config.grpc.port != 0 {
+		grpcListener, err = net.Listen("tcp", app.listenAddress(app.config.grpc.port))
+		if err != nil {
+			return err
+		}
+		grpcSrv = app.grpcServer()
+	}
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	if app.config.outbox.pollInterval > 0 {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			app.runOutboxRelay(workerCtx, sinks)
+		}()
+	}
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		app.listenBookChanges(workerCtx)
+	}()
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		app.listenCopyChanges(workerCtx)
+	}()
+	// Event streams never finish by themselves, so they are ended for Shutdown to return.
+	srv.RegisterOnShutdown(app.changes.close)
+	if app.config.webhooks.pollInterval > 0 {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			app.runWebhookDispatcher(workerCtx)
+		}()
+	}
+
 	shutdownError := make(chan error)
 	go func() {
 		quit := make(chan os.Signal, 1)
@@ -35,12 +95,44 @@ func (app *application) serve() error {
 		app.logger.Info("shutting down server", "signal", s.String())
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		shutdownError <- srv.Shutdown(ctx)
-
+		stopped := make(chan struct{})
+		if grpcSrv != nil {
+			go func() {
+				grpcSrv.GracefulStop()
+				close(stopped)
+			}()
+		}
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			cancelRequests(errServerShutdown)
+		}
+		// Shutdown does not wait for hijacked connections, so WebSockets are closed here.
+		app.circulation.shutdown(ctx)
+		if grpcSrv != nil {
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				// Streams still running at the deadline are cut off.
+				grpcSrv.Stop()
+			}
+		}
+		// Work in flight is abandoned: outbox events are relayed again and webhook
+		// deliveries retried after their lease.
+		stopWorkers()
+		workers.Wait()
+		shutdownError <- err
 	}()
 
+	if grpcSrv != nil {
+		go func() {
+			app.logger.Info("starting gRPC server", "addr", grpcListener.Addr().String())
+			if err := grpcSrv.Serve(grpcListener); err != nil {
+				app.logger.Error("gRPC server failed", "error", err.Error())
+			}
+		}()
+	}
 	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env)
-	err := srv.ListenAndServe()
+	err = srv.ListenAndServe()
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
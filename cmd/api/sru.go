@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/themilar/plibrary/internal/cql"
+	"github.com/themilar/plibrary/internal/models"
+)
+
+const (
+	sruContentType         = "application/sru+xml"
+	sruResponseNamespace   = "http://docs.oasis-open.org/ns/search-ws/sruResponse"
+	sruDiagnosticNamespace = "http://docs.oasis-open.org/ns/search-ws/diagnostic"
+	sruDCSchema            = "info:srw/schema/1/dc-v1.1"
+	sruMARCXMLSchema       = "info:srw/schema/1/marcxml-v1.1"
+	sruDefaultMaxRecords   = 10
+	sruMaxRecords          = 100
+)
+
+// Diagnostics from info:srw/diagnostic/1 raised by the protocol layer; query diagnostics
+// come from the cql package.
+const (
+	sruDiagUnsupportedParameterValue = 6
+	sruDiagMandatoryParameter        = 7
+	sruDiagFirstRecordOutOfRange     = 61
+	sruDiagUnknownSchema             = 66
+	sruDiagUnsupportedEscaping       = 71
+)
+
+// sruRecordSchemas maps the short and URI forms of the supported record schemas to the URI.
+var sruRecordSchemas = map[string]string{
+	"dc":             sruDCSchema,
+	sruDCSchema:      sruDCSchema,
+	"marcxml":        sruMARCXMLSchema,
+	sruMARCXMLSchema: sruMARCXMLSchema,
+}
+
+type sruDiagnostic struct {
+	XMLName xml.Name `xml:"diag:diagnostic"`
+	Xmlns   string   `xml:"xmlns:diag,attr"`
+	URI     string   `xml:"diag:uri"`
+	Details string   `xml:"diag:details,omitempty"`
+	Message string   `xml:"diag:message"`
+}
+
+func newSRUDiagnostic(code int, message, details string) sruDiagnostic {
+	return sruDiagnostic{
+		Xmlns:   sruDiagnosticNamespace,
+		URI:     fmt.Sprintf("info:srw/diagnostic/1/%d", code),
+		Details: details,
+		Message: message,
+	}
+}
+
+type marcSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+type marcDataField struct {
+	Tag       string         `xml:"tag,attr"`
+	Ind1      string         `xml:"ind1,attr"`
+	Ind2      string         `xml:"ind2,attr"`
+	Subfields []marcSubfield `xml:"subfield"`
+}
+
+type marcControlField struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type marcRecord struct {
+	XMLName       xml.Name           `xml:"http://www.loc.gov/MARC21/slim record"`
+	Leader        string             `xml:"leader"`
+	ControlFields []marcControlField `xml:"controlfield"`
+	DataFields    []marcDataField    `xml:"datafield"`
+}
+
+func bookMARCRecord(book *models.Book) *marcRecord {
+	// 008 fixed-length data: date entered, single known date, unknown place, undetermined language.
+	fixed := fmt.Sprintf("%ss%04d    xx %17sund d", book.CreatedAt.UTC().Format("060102"), book.Published, "")
+	record := &marcRecord{
+		Leader: "     nam a22     uu 4500",
+		ControlFields: []marcControlField{
+			{Tag: "001", Value: strconv.FormatInt(book.ID, 10)},
+			{Tag: "005", Value: book.UpdatedAt.UTC().Format("20060102150405.0")},
+			{Tag: "008", Value: fixed},
+		},
+	}
+	for i, author := range book.Authors {
+		// The first author is the main entry, any others are added entries.
+		tag := "700"
+		if i == 0 {
+			tag = "100"
+		}
+		record.DataFields = append(record.DataFields, marcDataField{Tag: tag, Ind1: "1", Ind2: " ", Subfields: []marcSubfield{{Code: "a", Value: author}}})
+	}
+	titleIndicator := "0"
+	if len(book.Authors) > 0 {
+		titleIndicator = "1"
+	}
+	record.DataFields = append(record.DataFields, marcDataField{Tag: "245", Ind1: titleIndicator, Ind2: "0", Subfields: []marcSubfield{{Code: "a", Value: book.Title}}})
+	imprint := marcDataField{Tag: "264", Ind1: " ", Ind2: "1"}
+	if book.Publisher != "" {
+		imprint.Subfields = append(imprint.Subfields, marcSubfield{Code: "b", Value: book.Publisher})
+	}
+	imprint.Subfields = append(imprint.Subfields, marcSubfield{Code: "c", Value: strconv.Itoa(book.Published)})
+	record.DataFields = append(record.DataFields, imprint)
+	if book.Pages > 0 {
+		record.DataFields = append(record.DataFields, marcDataField{Tag: "300", Ind1: " ", Ind2: " ", Subfields: []marcSubfield{{Code: "a", Value: fmt.Sprintf("%d pages", book.Pages)}}})
+	}
+	for _, genre := range book.Genres {
+		record.DataFields = append(record.DataFields, marcDataField{Tag: "655", Ind1: " ", Ind2: "4", Subfields: []marcSubfield{{Code: "a", Value: genre}}})
+	}
+	return record
+}
+
+type sruRecordData struct {
+	DC      *dublinCore `xml:"srw_dc:dc,omitempty"`
+	MARC    *marcRecord
+	Escaped string `xml:",chardata"`
+}
+
+type sruRecord struct {
+	Schema   string        `xml:"sru:recordSchema"`
+	Escaping string        `xml:"sru:recordXMLEscaping"`
+	Data     sruRecordData `xml:"sru:recordData"`
+	Position int           `xml:"sru:recordPosition"`
+}
+
+type sruRecords struct {
+	Records []sruRecord `xml:"sru:record"`
+}
+
+type sruDiagnostics struct {
+	Diagnostics []sruDiagnostic
+}
+
+type sruResponse struct {
+	XMLName            xml.Name        `xml:"sru:searchRetrieveResponse"`
+	Xmlns              string          `xml:"xmlns:sru,attr"`
+	Version            string          `xml:"sru:version"`
+	NumberOfRecords    int             `xml:"sru:numberOfRecords"`
+	Records            *sruRecords     `xml:"sru:records,omitempty"`
+	NextRecordPosition int             `xml:"sru:nextRecordPosition,omitempty"`
+	Diagnostics        *sruDiagnostics `xml:"sru:diagnostics,omitempty"`
+}
+
+func sruBookRecord(book *models.Book, schema, escaping, baseURL string, position int) (sruRecord, error) {
+	record := sruRecord{Schema: schema, Escaping: escaping, Position: position}
+	if schema == sruMARCXMLSchema {
+		record.Data.MARC = bookMARCRecord(book)
+	} else {
+		record.Data.DC = bookDublinCore(book, baseURL)
+		record.Data.DC.XmlnsSRWDC = "info:srw/schema/1/dc-schema"
+	}
+	if escaping == "string" {
+		var escaped strings.Builder
+		enc := xml.NewEncoder(&escaped)
+		var err error
+		if record.Data.MARC != nil {
+			err = enc.Encode(record.Data.MARC)
+		} else {
+			err = enc.EncodeElement(record.Data.DC, xml.StartElement{Name: xml.Name{Local: "srw_dc:dc"}})
+		}
+		if err != nil {
+			return sruRecord{}, err
+		}
+		record.Data = sruRecordData{Escaped: escaped.String()}
+	}
+	return record, nil
+}
+
+// readSRUInt reads an optional positive integer parameter, reporting a diagnostic when it
+// is malformed.
+func readSRUInt(value string, defaultValue, minimum int) (int, *sruDiagnostic) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < minimum {
+		diag := newSRUDiagnostic(sruDiagUnsupportedParameterValue, "Unsupported parameter value", value)
+		return 0, &diag
+	}
+	return i, nil
+}
+
+// sruSearchRetrieve implements the SRU 2.0 searchRetrieve operation. As with OAI-PMH, errors
+// in the request are reported as diagnostics inside the response rather than via the status.
+func (app *application) sruSearchRetrieve(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	resp := &sruResponse{Xmlns: sruResponseNamespace, Version: "2.0"}
+	writeResponse := func() {
+		err := app.writeXML(w, http.StatusOK, resp, sruContentType, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+	fail := func(diag sruDiagnostic) {
+		resp.Diagnostics = &sruDiagnostics{Diagnostics: []sruDiagnostic{diag}}
+		writeResponse()
+	}
+
+	query := qs.Get("query")
+	if query == "" {
+		fail(newSRUDiagnostic(sruDiagMandatoryParameter, "Mandatory parameter not supplied", "query"))
+		return
+	}
+	startRecord, diag := readSRUInt(qs.Get("startRecord"), 1, 1)
+	if diag != nil {
+		fail(*diag)
+		return
+	}
+	maximumRecords, diag := readSRUInt(qs.Get("maximumRecords"), sruDefaultMaxRecords, 0)
+	if diag != nil {
+		fail(*diag)
+		return
+	}
+	maximumRecords = min(maximumRecords, sruMaxRecords)
+	schema, ok := sruRecordSchemas[app.readString(qs, "recordSchema", "dc")]
+	if !ok {
+		fail(newSRUDiagnostic(sruDiagUnknownSchema, "Unknown schema for retrieval", qs.Get("recordSchema")))
+		return
+	}
+	escaping := app.readString(qs, "recordXMLEscaping", "xml")
+	if escaping != "xml" && escaping != "string" {
+		fail(newSRUDiagnostic(sruDiagUnsupportedEscaping, "Unsupported record packing", escaping))
+		return
+	}
+
+	node, err := cql.Parse(query)
+	var books []*models.Book
+	if err == nil {
+		books, resp.NumberOfRecords, err = app.models.Books.SearchCQL(r.Context(), node, startRecord-1, maximumRecords)
+	}
+	if err != nil {
+		var cqlErr *cql.Error
+		if errors.As(err, &cqlErr) {
+			fail(newSRUDiagnostic(cqlErr.Diagnostic, cqlErr.Message, cqlErr.Details))
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if startRecord > 1 && startRecord > resp.NumberOfRecords {
+		fail(newSRUDiagnostic(sruDiagFirstRecordOutOfRange, "First record position out of range", strconv.Itoa(startRecord)))
+		return
+	}
+
+	baseURL := requestBaseURL(r)
+	resp.Records = &sruRecords{}
+	for i, book := range books {
+		record, err := sruBookRecord(book, schema, escaping, baseURL, startRecord+i)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		resp.Records.Records = append(resp.Records.Records, record)
+	}
+	if next := startRecord + len(books); next <= resp.NumberOfRecords && len(books) > 0 {
+		resp.NextRecordPosition = next
+	}
+	writeResponse()
+}
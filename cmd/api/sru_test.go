@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/themilar/plibrary/internal/cql"
+	"github.com/themilar/plibrary/internal/models"
+)
+
+// sruDocument picks out of a searchRetrieve response what the tests compare.
+type sruDocument struct {
+	NumberOfRecords    int `xml:"numberOfRecords"`
+	NextRecordPosition int `xml:"nextRecordPosition"`
+	Records            []struct {
+		Schema   string `xml:"recordSchema"`
+		Position int    `xml:"recordPosition"`
+		Data     struct {
+			Title      string `xml:"dc>title"`
+			DataFields []struct {
+				Tag      string `xml:"tag,attr"`
+				Subfield string `xml:"subfield"`
+			} `xml:"record>datafield"`
+			Escaped string `xml:",chardata"`
+		} `xml:"recordData"`
+	} `xml:"records>record"`
+	Diagnostic struct {
+		URI     string `xml:"uri"`
+		Details string `xml:"details"`
+	} `xml:"diagnostics>diagnostic"`
+}
+
+func (doc sruDocument) titles() string {
+	var titles []string
+	for _, record := range doc.Records {
+		titles = append(titles, record.Data.Title)
+	}
+	return strings.Join(titles, ", ")
+}
+
+func getSRU(t *testing.T, handler http.Handler, params url.Values) sruDocument {
+	t.Helper()
+	params.Set("operation", "searchRetrieve")
+	params.Set("version", "2.0")
+	target := "/sru?" + params.Encode()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != sruContentType {
+		t.Fatalf("GET %s: status %d, Content-Type %q; body %s", target, rr.Code, rr.Header().Get("Content-Type"), rr.Body)
+	}
+	var doc sruDocument
+	if err := xml.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("GET %s: %v; body %s", target, err, rr.Body)
+	}
+	return doc
+}
+
+func TestSRUSearchRetrieve(t *testing.T) {
+	handler := newMemoryApplication(t, config{}).routes()
+
+	for _, test := range []struct {
+		params url.Values
+		titles string
+		total  int
+		next   int
+	}{
+		{url.Values{"query": {"title=dune"}}, "Dune, Children Of Dune", 2, 0},
+		{url.Values{"query": {"dc.title=hobbit or date<1970"}}, "Dune, The Hobbit", 2, 0},
+		{url.Values{"query": {"subject=sci-fi"}, "maximumRecords": {"1"}}, "Dune", 2, 2},
+		{url.Values{"query": {"subject=sci-fi"}, "maximumRecords": {"1"}, "startRecord": {"2"}}, "Children Of Dune", 2, 0},
+		{url.Values{"query": {"subject=sci-fi"}, "maximumRecords": {"0"}}, "", 2, 0},
+		{url.Values{"query": {"title=silmarillion"}}, "", 0, 0},
+	} {
+		doc := getSRU(t, handler, test.params)
+		if doc.Diagnostic.URI != "" {
+			t.Errorf("query %s: diagnostic %s %s", test.params.Get("query"), doc.Diagnostic.URI, doc.Diagnostic.Details)
+		}
+		if doc.titles() != test.titles || doc.NumberOfRecords != test.total || doc.NextRecordPosition != test.next {
+			t.Errorf("query %s (%v): records %q, %d in all, next %d; want %q, %d, %d",
+				test.params.Get("query"), test.params, doc.titles(), doc.NumberOfRecords, doc.NextRecordPosition, test.titles, test.total, test.next)
+		}
+	}
+
+	doc := getSRU(t, handler, url.Values{"query": {"title=hobbit"}, "recordSchema": {"marcxml"}})
+	if len(doc.Records) != 1 || doc.Records[0].Schema != sruMARCXMLSchema || doc.Records[0].Position != 1 {
+		t.Fatalf("marcxml records = %+v, want one at position 1", doc.Records)
+	}
+	var fields []string
+	for _, field := range doc.Records[0].Data.DataFields {
+		fields = append(fields, field.Tag+" "+field.Subfield)
+	}
+	if got, want := strings.Join(fields, ", "), "100 J. R. R. Tolkien, 245 The Hobbit, 264 1937, 300 310 pages, 655 fantasy"; got != want {
+		t.Errorf("marcxml data fields = %s, want %s", got, want)
+	}
+
+	doc = getSRU(t, handler, url.Values{"query": {"title=hobbit"}, "recordXMLEscaping": {"string"}})
+	if len(doc.Records) != 1 || !strings.Contains(doc.Records[0].Data.Escaped, "<dc:title>The Hobbit</dc:title>") {
+		t.Errorf("escaped records = %+v, want the Dublin Core record as a string", doc.Records)
+	}
+}
+
+func TestSRUDiagnostics(t *testing.T) {
+	handler := newMemoryApplication(t, config{}).routes()
+
+	for _, test := range []struct {
+		params  url.Values
+		code    int
+		details string
+	}{
+		{url.Values{}, sruDiagMandatoryParameter, "query"},
+		{url.Values{"query": {"dune"}, "startRecord": {"0"}}, sruDiagUnsupportedParameterValue, "0"},
+		{url.Values{"query": {"dune"}, "maximumRecords": {"many"}}, sruDiagUnsupportedParameterValue, "many"},
+		{url.Values{"query": {"dune"}, "recordSchema": {"mods"}}, sruDiagUnknownSchema, "mods"},
+		{url.Values{"query": {"dune"}, "recordXMLEscaping": {"json"}}, sruDiagUnsupportedEscaping, "json"},
+		{url.Values{"query": {"title=dune"}, "startRecord": {"3"}}, sruDiagFirstRecordOutOfRange, "3"},
+		{url.Values{"query": {"title="}}, cql.DiagQuerySyntax, ""},
+		{url.Values{"query": {"isbn=0441013597"}}, cql.DiagUnsupportedIndex, "isbn"},
+		{url.Values{"query": {"title=dun*"}}, cql.DiagMaskingNotSupported, "dun*"},
+		{url.Values{"query": {"title=dune prox title=hobbit"}}, cql.DiagUnsupportedBoolean, ""},
+	} {
+		doc := getSRU(t, handler, test.params)
+		uri := fmt.Sprintf("info:srw/diagnostic/1/%d", test.code)
+		if doc.Diagnostic.URI != uri || (test.details != "" && doc.Diagnostic.Details != test.details) {
+			t.Errorf("%v: diagnostic %s %q, want %s %q", test.params, doc.Diagnostic.URI, doc.Diagnostic.Details, uri, test.details)
+		}
+		if len(doc.Records) != 0 {
+			t.Errorf("%v: %d records alongside the diagnostic", test.params, len(doc.Records))
+		}
+	}
+}
+
+func TestSRUBookRecord(t *testing.T) {
+	book := &models.Book{
+		ID:        3,
+		Title:     "The Hobbit",
+		Published: 1937,
+		Pages:     310,
+		Genres:    []string{"fantasy"},
+		CreatedAt: time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC),
+	}
+
+	record, err := sruBookRecord(book, sruMARCXMLSchema, "xml", "http://localhost", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields []string
+	for _, field := range record.Data.MARC.DataFields {
+		fields = append(fields, field.Tag+" "+field.Subfields[0].Value)
+	}
+	if got, want := strings.Join(fields, ", "), "245 The Hobbit, 264 1937, 300 310 pages, 655 fantasy"; got != want {
+		t.Errorf("marcxml data fields = %s, want %s", got, want)
+	}
+	if fixed := record.Data.MARC.ControlFields[2].Value; !strings.HasPrefix(fixed, "240506s1937") || len(fixed) != 40 {
+		t.Errorf("008 = %q, want 40 characters starting 240506s1937", fixed)
+	}
+
+	record, err = sruBookRecord(book, sruDCSchema, "string", "http://localhost", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Data.DC != nil || !strings.Contains(record.Data.Escaped, "<dc:title>The Hobbit</dc:title>") {
+		t.Errorf("escaped record = %+v, want the Dublin Core record as a string", record.Data)
+	}
+}
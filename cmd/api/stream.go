@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/themilar/plibrary/internal/models"
+)
+
+const (
+	// streamHistory is the number of recent changes kept for clients resuming with
+	// Last-Event-ID.
+	streamHistory = 1024
+	// streamClientBuffer is the number of changes a client may fall behind by before it is
+	// disconnected, to resume once it has caught up.
+	streamClientBuffer = 64
+	streamHeartbeat    = 15 * time.Second
+	streamWriteTimeout = 10 * time.Second
+	streamRetry        = 3 * time.Second
+)
+
+var errStreamClosed = errors.New("the change stream is shutting down")
+
+// changeEvents names the events sent for the operations reported by the books trigger.
+var changeEvents = map[string]string{
+	"insert": models.EventBookCreated,
+	"update": models.EventBookUpdated,
+	"delete": models.EventBookDeleted,
+}
+
+// streamEvent is a change as sent to clients. A reset event tells clients that changes were
+// missed and that they should reload what they show.
+type streamEvent struct {
+	id   int64
+	name string
+	book *models.Book
+	data []byte
+}
+
+func (e *streamEvent) write(w http.ResponseWriter) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.id, e.name, e.data)
+	return err
+}
+
+type streamFilter struct {
+	events  []string
+	genres  []string
+	bookIDs []int64
+}
+
+// match reports whether a client with the filter wants e. Books match genres if they have
+// any of them.
+func (f streamFilter) match(e *streamEvent) bool {
+	if e.book == nil {
+		return true
+	}
+	if len(f.events) > 0 && !slices.Contains(f.events, e.name) {
+		return false
+	}
+	if len(f.bookIDs) > 0 && !slices.Contains(f.bookIDs, e.book.ID) {
+		return false
+	}
+	if len(f.genres) > 0 && !slices.ContainsFunc(e.book.Genres, func(genre string) bool {
+		return slices.ContainsFunc(f.genres, func(g string) bool { return strings.EqualFold(g, genre) })
+	}) {
+		return false
+	}
+	return true
+}
+
+type streamClient struct {
+	filter streamFilter
+	events chan *streamEvent
+	// done is closed when the client is dropped for falling behind or the hub closes.
+	done chan struct{}
+}
+
+// changeHub fans book changes out to the connected stream clients and keeps the latest for
+// clients that reconnect.
+type changeHub struct {
+	mu      sync.Mutex
+	clients map[*streamClient]struct{}
+	history []*streamEvent
+	// floor is the last change that is not in history and may have been missed.
+	floor     int64
+	listening bool
+	closed    bool
+}
+
+func newChangeHub() *changeHub {
+	return &changeHub{clients: map[*streamClient]struct{}{}}
+}
+
+// subscribe adds a client, returning the changes it missed after lastID when resume is set.
+// When those are no longer known, the backlog is a single reset event.
+func (h *changeHub) subscribe(filter streamFilter, lastID int64, resume bool) (*streamClient, []*streamEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, nil, errStreamClosed
+	}
+	c := &streamClient{filter: filter, events: make(chan *streamEvent, streamClientBuffer), done: make(chan struct{})}
+	h.clients[c] = struct{}{}
+	if !resume {
+		return c, nil, nil
+	}
+	var backlog []*streamEvent
+	i := slices.IndexFunc(h.history, func(e *streamEvent) bool { return e.id == lastID })
+	switch {
+	case i >= 0:
+		backlog = h.history[i+1:]
+	case lastID < h.floor:
+		return c, []*streamEvent{h.resetEvent()}, nil
+	default:
+		backlog = slices.DeleteFunc(slices.Clone(h.history), func(e *streamEvent) bool { return e.id <= lastID })
+	}
+	var matched []*streamEvent
+	for _, e := range backlog {
+		if filter.match(e) {
+			matched = append(matched, e)
+		}
+	}
+	return c, matched, nil
+}
+
+func (h *changeHub) unsubscribe(c *streamClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.drop(c)
+}
+
+// drop removes a client. The caller holds the lock.
+func (h *changeHub) drop(c *streamClient) {
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c.done)
+	}
+}
+
+// broadcast sends e to the clients that want it, dropping those that have fallen too far
+// behind rather than letting them hold up the others. The caller holds the lock.
+func (h *changeHub) broadcast(e *streamEvent) {
+	for c := range h.clients {
+		if !c.filter.match(e) {
+			continue
+		}
+		select {
+		case c.events <- e:
+		default:
+			h.drop(c)
+		}
+	}
+}
+
+func (h *changeHub) resetEvent() *streamEvent {
+	return &streamEvent{id: h.floor, name: "reset", data: []byte("{}")}
+}
+
+func (h *changeHub) publish(change *models.BookChange) {
+	data, err := json.Marshal(envelope{"book": change.Book})
+	if err != nil {
+		return
+	}
+	e := &streamEvent{id: change.Seq, name: changeEvents[change.Op], book: change.Book, data: data}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.history) == streamHistory {
+		h.floor = max(h.floor, h.history[0].id)
+		h.history = slices.Delete(h.history, 0, 1)
+	}
+	h.history = append(h.history, e)
+	h.broadcast(e)
+}
+
+// reset forgets the history after changes may have been missed, telling connected clients.
+func (h *changeHub) reset(floor int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.history = nil
+	h.floor = floor
+	if h.listening {
+		h.broadcast(h.resetEvent())
+	}
+	h.listening = true
+}
+
+// close disconnects every client and refuses new ones, so that streams end on shutdown.
+func (h *changeHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for c := range h.clients {
+		h.drop(c)
+	}
+}
+
+// listenBookChanges feeds the hub from the database until ctx is cancelled.
+func (app *application) listenBookChanges(ctx context.Context) {
+	app.keepListening(ctx, "book", func(ctx context.Context) error {
+		return app.models.Books.ListenChanges(ctx, app.changes.reset, app.changes.publish)
+	})
+}
+
+// keepListening runs listen until ctx is cancelled, starting it again after failures with
+// an increasing delay.
+func (app *application) keepListening(ctx context.Context, name string, listen func(context.Context) error) {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+		app.logger.Error("lost the "+name+" change notifications", "error", err.Error(), "retry_in", backoff.String())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func (app *application) readStreamFilter(r *http.Request) (streamFilter, map[string]string) {
+	qs := r.URL.Query()
+	errs := map[string]string{}
+	filter := streamFilter{
+		events: app.readCSV(qs, "events", nil),
+		genres: app.readCSV(qs, "genres", nil),
+	}
+	for _, event := range filter.events {
+		if !slices.Contains([]string{models.EventBookCreated, models.EventBookUpdated, models.EventBookDeleted}, event) {
+			errs["events"] = fmt.Sprintf("unknown event %q", event)
+		}
+	}
+	for _, s := range app.readCSV(qs, "ids", nil) {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 1 {
+			errs["ids"] = "must be a comma separated list of book ids"
+			break
+		}
+		filter.bookIDs = append(filter.bookIDs, id)
+	}
+	return filter, errs
+}
+
+// bookStream sends book changes as Server-Sent Events. EventSource clients reconnect with
+// Last-Event-ID and receive the changes they missed; last_event_id does the same for the
+// first connection.
+func (app *application) bookStream(w http.ResponseWriter, r *http.Request) {
+	filter, errs := app.readStreamFilter(r)
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	var lastID int64
+	if lastEventID != "" {
+		var err error
+		lastID, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil {
+			errs["last_event_id"] = "must be an event id"
+		}
+	}
+	if len(errs) > 0 {
+		app.failedValidationErrorResponse(w, r, errs)
+		return
+	}
+
+	client, backlog, err := app.changes.subscribe(filter, lastID, lastEventID != "")
+	if err != nil {
+		app.serviceUnavailableResponse(w, r, err.Error())
+		return
+	}
+	defer app.changes.unsubscribe(client)
+
+	// The server's write timeout is meant for ordinary responses, so it is pushed back before
+	// every write instead.
+	rc := http.NewResponseController(w)
+	send := func(write func() error) bool {
+		if err := rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return false
+		}
+		return write() == nil && rc.Flush() == nil
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	ok := send(func() error {
+		_, err := fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+		return err
+	})
+	for _, e := range backlog {
+		ok = ok && send(func() error { return e.write(w) })
+	}
+	if !ok {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.done:
+			return
+		case e := <-client.events:
+			if !send(func() error { return e.write(w) }) {
+				return
+			}
+		case <-heartbeat.C:
+			if !send(func() error {
+				_, err := fmt.Fprint(w, ": heartbeat\n\n")
+				return err
+			}) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/themilar/plibrary/internal/models"
+)
+
+func change(seq int64, op string, id int64, genres ...string) *models.BookChange {
+	return &models.BookChange{Seq: seq, Op: op, Book: &models.Book{ID: id, Title: fmt.Sprint("Book ", id), Genres: genres}}
+}
+
+// eventIDs formats the ids and names of events.
+func eventIDs(events []*streamEvent) string {
+	var s []string
+	for _, e := range events {
+		s = append(s, fmt.Sprintf("%d %s", e.id, e.name))
+	}
+	return strings.Join(s, ", ")
+}
+
+func TestStreamFilter(t *testing.T) {
+	e := &streamEvent{name: models.EventBookUpdated, book: &models.Book{ID: 7, Genres: []string{"Sci-Fi", "classics"}}}
+	for _, test := range []struct {
+		filter streamFilter
+		want   bool
+	}{
+		{streamFilter{}, true},
+		{streamFilter{events: []string{models.EventBookCreated, models.EventBookUpdated}}, true},
+		{streamFilter{events: []string{models.EventBookDeleted}}, false},
+		{streamFilter{bookIDs: []int64{3, 7}}, true},
+		{streamFilter{bookIDs: []int64{3}}, false},
+		{streamFilter{genres: []string{"fantasy", "sci-fi"}}, true},
+		{streamFilter{genres: []string{"fantasy"}}, false},
+		{streamFilter{events: []string{models.EventBookUpdated}, genres: []string{"fantasy"}}, false},
+	} {
+		if got := test.filter.match(e); got != test.want {
+			t.Errorf("%+v.match = %t, want %t", test.filter, got, test.want)
+		}
+	}
+	// Resets go to every client.
+	if !(streamFilter{bookIDs: []int64{3}}).match(&streamEvent{name: "reset"}) {
+		t.Error("a filter does not match reset events")
+	}
+}
+
+func TestChangeHubResume(t *testing.T) {
+	hub := newChangeHub()
+	hub.reset(10)
+	hub.publish(change(11, "insert", 1, "sci-fi"))
+	hub.publish(change(12, "update", 2, "fantasy"))
+	hub.publish(change(13, "delete", 1, "sci-fi"))
+
+	for _, test := range []struct {
+		filter streamFilter
+		lastID int64
+		resume bool
+		want   string
+	}{
+		{streamFilter{}, 0, false, ""},
+		{streamFilter{}, 11, true, "12 book.updated, 13 book.deleted"},
+		{streamFilter{genres: []string{"sci-fi"}}, 11, true, "13 book.deleted"},
+		{streamFilter{}, 10, true, "11 book.created, 12 book.updated, 13 book.deleted"},
+		{streamFilter{}, 13, true, ""},
+		{streamFilter{}, 99, true, ""},
+		// Changes before the hub started listening may have been missed.
+		{streamFilter{}, 4, true, "10 reset"},
+	} {
+		client, backlog, err := hub.subscribe(test.filter, test.lastID, test.resume)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hub.unsubscribe(client)
+		if got := eventIDs(backlog); got != test.want {
+			t.Errorf("subscribe(%+v, %d, %t) backlog = %q, want %q", test.filter, test.lastID, test.resume, got, test.want)
+		}
+	}
+
+	// Once the history overflows, the oldest change is no longer known.
+	for seq := int64(14); seq < 14+streamHistory; seq++ {
+		hub.publish(change(seq, "update", 2))
+	}
+	client, backlog, _ := hub.subscribe(streamFilter{}, 11, true)
+	hub.unsubscribe(client)
+	if got := eventIDs(backlog); got != "13 reset" {
+		t.Errorf("resuming from a change dropped from the history: %q, want 13 reset", got)
+	}
+}
+
+func TestChangeHubBroadcast(t *testing.T) {
+	hub := newChangeHub()
+	hub.reset(0)
+	slow, _, _ := hub.subscribe(streamFilter{}, 0, false)
+	fantasy, _, _ := hub.subscribe(streamFilter{genres: []string{"fantasy"}}, 0, false)
+
+	for seq := int64(1); seq <= streamClientBuffer+1; seq++ {
+		hub.publish(change(seq, "update", 1, "sci-fi"))
+	}
+	select {
+	case <-slow.done:
+	default:
+		t.Error("a client that fell behind was not dropped")
+	}
+	select {
+	case <-fantasy.done:
+		t.Error("a client that wanted none of the changes was dropped")
+	default:
+	}
+
+	// The hub resetting after a lost connection tells the clients still connected.
+	hub.reset(100)
+	if e := <-fantasy.events; e.name != "reset" || e.id != 100 {
+		t.Errorf("after reset, the client received %d %s", e.id, e.name)
+	}
+
+	hub.close()
+	select {
+	case <-fantasy.done:
+	default:
+		t.Error("close did not disconnect a client")
+	}
+	if _, _, err := hub.subscribe(streamFilter{}, 0, false); err != errStreamClosed {
+		t.Errorf("subscribe after close: %v, want errStreamClosed", err)
+	}
+}
+
+// readEvent reads lines from an event stream up to the next blank line.
+func readEvent(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the event stream after %q: %v", lines, err)
+		}
+		if line == "\n" {
+			return strings.Join(lines, "")
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestBookStream(t *testing.T) {
+	app := newMemoryApplication(t, config{})
+	server := httptest.NewServer(app.routes())
+	t.Cleanup(server.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	go app.listenBookChanges(ctx)
+	for {
+		app.changes.mu.Lock()
+		listening := app.changes.listening
+		app.changes.mu.Unlock()
+		if listening {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	open := func(target string, header http.Header) (*http.Response, *bufio.Reader) {
+		t.Helper()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+target, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp, bufio.NewReader(resp.Body)
+	}
+
+	resp, events := open("/v1/books/stream?genres=fantasy", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET /v1/books/stream: status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if e := readEvent(t, events); e != "retry: 3000\n" {
+		t.Errorf("first event = %q, want the retry delay", e)
+	}
+
+	for _, book := range []*models.Book{
+		{Title: "Dune Messiah", Published: 1969, Pages: 256, Genres: []string{"sci-fi"}},
+		{Title: "The Silmarillion", Published: 1977, Pages: 365, Genres: []string{"fantasy"}},
+	} {
+		if err := app.models.Books.Insert(ctx, book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e := readEvent(t, events)
+	if !strings.Contains(e, "event: book.created\ndata: {\"book\":{\"id\":5,") || !strings.Contains(e, "The Silmarillion") {
+		t.Errorf("event = %q, want the creation of The Silmarillion", e)
+	}
+	id := strings.TrimPrefix(strings.SplitN(e, "\n", 2)[0], "id: ")
+
+	// Resuming from the first change sends the ones after it.
+	var previous int64
+	fmt.Sscan(id, &previous)
+	_, events = open("/v1/books/stream", http.Header{"Last-Event-Id": {fmt.Sprint(previous - 1)}})
+	readEvent(t, events)
+	if e := readEvent(t, events); !strings.HasPrefix(e, "id: "+id+"\nevent: book.created\n") {
+		t.Errorf("resumed stream sent %q, want event %s", e, id)
+	}
+
+	for target, field := range map[string]string{
+		"/v1/books/stream?events=book.burned": "events",
+		"/v1/books/stream?ids=1,x":            "ids",
+		"/v1/books/stream?last_event_id=abc":  "last_event_id",
+	} {
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), `"`+field+`"`) {
+			t.Errorf("GET %s: status %d, body %s; want 422 for %s", target, rr.Code, rr.Body, field)
+		}
+	}
+
+	// Shutting down ends the streams and refuses new ones.
+	app.changes.close()
+	if _, err := events.ReadString('\n'); err == nil {
+		t.Error("the stream continued after the hub closed")
+	}
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/stream", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /v1/books/stream after close: status %d, want 503", rr.Code)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/themilar/plibrary/cmd/api")
+
+// setupTracing installs the tracer provider for the configured exporter and the W3C trace
+// context propagator. The returned function flushes the spans not yet exported.
+func setupTracing(cfg config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.tracing.exporter {
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	case "otlp":
+		// The endpoint, headers and the like are read from the OTEL_EXPORTER_OTLP_* variables.
+		exporter, err = otlptracehttp.New(context.Background())
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, must be none, stdout or otlp", cfg.tracing.exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("plibrary"),
+		semconv.ServiceVersion(version),
+		semconv.DeploymentEnvironment(cfg.env),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.tracing.sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// traceRequests starts a server span for every request, continuing the trace of the client
+// when it sends a traceparent header. Spans are named after the route pattern once the
+// request has been routed.
+func (app *application) traceRequests(next http.Handler) http.Handler {
+	routed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			span := trace.SpanFromContext(r.Context())
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+			span.SetAttributes(semconv.HTTPRoute(rctx.RoutePattern()))
+		}
+	})
+	return otelhttp.NewHandler(routed, "http.server",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string { return r.Method }),
+	)
+}
+
+// startSpan starts a span for the work of a handler. The request it returns carries the span,
+// so that the queries made with it are traced beneath it.
+func startSpan(r *http.Request, name string, attrs ...attribute.KeyValue) (*http.Request, trace.Span) {
+	ctx, span := tracer.Start(r.Context(), name, trace.WithAttributes(attrs...))
+	return r.WithContext(ctx), span
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	recordedSpans   = tracetest.NewSpanRecorder()
+	installRecorder sync.Once
+)
+
+// traceSpans returns the spans recorded in the trace with the given id.
+func traceSpans(t *testing.T, traceID string) map[string]sdktrace.ReadOnlySpan {
+	t.Helper()
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recordedSpans.Ended() {
+		if span.SpanContext().TraceID().String() == traceID {
+			spans[span.Name()] = span
+		}
+	}
+	return spans
+}
+
+// recordTraces makes recordedSpans the global tracer provider, only once since tracers bind
+// to the first provider set, and installs the propagators of setupTracing.
+func recordTraces(t *testing.T) {
+	t.Helper()
+	installRecorder.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recordedSpans)))
+	})
+	var cfg config
+	cfg.tracing.exporter = "none"
+	shutdown, err := setupTracing(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]string {
+	attrs := map[attribute.Key]string{}
+	for _, attr := range span.Attributes() {
+		attrs[attr.Key] = attr.Value.Emit()
+	}
+	return attrs
+}
+
+func TestSetupTracing(t *testing.T) {
+	for _, exporter := range []string{"", "jaeger"} {
+		var cfg config
+		cfg.tracing.exporter = exporter
+		if _, err := setupTracing(cfg); err == nil {
+			t.Errorf("setupTracing accepted the exporter %q", exporter)
+		}
+	}
+}
+
+func TestTraceRequests(t *testing.T) {
+	recordTraces(t)
+	handler := newMemoryApplication(t, config{}).routes()
+
+	// The trace of the client is continued.
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req := httptest.NewRequest(http.MethodGet, "/v1/books/1", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	req.Header.Set("X-Request-Id", "req-1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("GET /v1/books/1: status %d", rr.Code)
+	}
+
+	spans := traceSpans(t, traceID)
+	server, handlerSpan := spans["GET /v1/books/{id}"], spans["bookDetail"]
+	if server == nil || handlerSpan == nil {
+		t.Fatalf("spans of the request = %v, want GET /v1/books/{id} and bookDetail", spans)
+	}
+	if server.Parent().SpanID().String() != parentID || !server.Parent().IsRemote() || server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("server span has parent %v and kind %v", server.Parent(), server.SpanKind())
+	}
+	if attrs := attributes(server); attrs["http.route"] != "/v1/books/{id}" || attrs["http.request.id"] != "req-1" {
+		t.Errorf("server span attributes = %v", attrs)
+	}
+	if handlerSpan.Parent().SpanID() != server.SpanContext().SpanID() || attributes(handlerSpan)["book.id"] != "1" {
+		t.Errorf("handler span has parent %v and attributes %v", handlerSpan.Parent(), attributes(handlerSpan))
+	}
+
+	// Without a traceparent header, requests start a trace of their own.
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/no/such/page", nil))
+	ended := recordedSpans.Ended()
+	last := ended[len(ended)-1]
+	if last.Name() != "GET" || last.Parent().IsValid() {
+		t.Errorf("unrouted request span %q has parent %v, want GET without a parent", last.Name(), last.Parent())
+	}
+}
+
+func TestStartSpan(t *testing.T) {
+	recordTraces(t)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx, parent := otel.Tracer("test").Start(r.Context(), "parent")
+	r, span := startSpan(r.WithContext(ctx), "bookList", attribute.String("book.genres", "sci-fi"))
+	if trace.SpanFromContext(r.Context()) != span {
+		t.Error("the request returned by startSpan does not carry its span")
+	}
+	span.End()
+	parent.End()
+
+	got := traceSpans(t, parent.SpanContext().TraceID().String())["bookList"]
+	if got == nil || got.Parent().SpanID() != parent.SpanContext().SpanID() || attributes(got)["book.genres"] != "sci-fi" {
+		t.Errorf("startSpan recorded %v", got)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
@@ -75,6 +76,24 @@ func (app *application) writeJson(w http.ResponseWriter, status int, data envelo
 	return nil
 }
 
+func (app *application) writeXML(w http.ResponseWriter, status int, data any, contentType string, headers http.Header) error {
+	resp, err := xml.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+	resp = append([]byte(xml.Header), resp...)
+	resp = append(resp, '\n')
+
+	for k, v := range headers {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+
+	w.Write(resp)
+	return nil
+}
+
 func (app *application) readJson(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 	maxBytes := 1_048_576
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
@@ -93,7 +112,7 @@ func (app *application) readJson(w http.ResponseWriter, r *http.Request, dst int
 		case errors.Is(err, io.EOF):
 			return errors.New("body must not be empty")
 		case strings.HasPrefix(err.Error(), "json: unknown field"):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field")
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
 			return fmt.Errorf("body contains unknown key %s", fieldName)
 		case err.Error() == "http requet body too large":
 			return fmt.Errorf("body must be larger than %d bytes ", maxBytes)
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadJson(t *testing.T) {
+	app := &application{}
+	for _, test := range []struct {
+		body    string
+		message string
+	}{
+		{`{"title": "Dune"}`, ""},
+		{`{"title": "Dune", "isbn": "0441013597"}`, `body contains unknown key "isbn"`},
+		{`{"title": "Dune"`, "body contains badly-formed JSON"},
+		{`{"title": "Dune",}`, "body contains badly formed JSON (at character 18)"},
+		{``, "body must not be empty"},
+		{`{"title": 1}`, `body contains incorrect JSON type for field "title"`},
+		{`["Dune"]`, "body contains incorrect JSON type (at character 1)"},
+		{`{"title": "Dune"} {}`, "body must only contain a single JSON value"},
+	} {
+		var dst struct {
+			Title string `json:"title"`
+		}
+		r := httptest.NewRequest("POST", "/v1/books", strings.NewReader(test.body))
+		err := app.readJson(httptest.NewRecorder(), r, &dst)
+		var message string
+		if err != nil {
+			message = err.Error()
+		}
+		if message != test.message {
+			t.Errorf("readJson(%s) error = %q, want %q", test.body, message, test.message)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/themilar/plibrary/internal/models"
+)
+
+const (
+	// webhookMaxAttempts is the number of attempts made before a delivery is dead-lettered.
+	webhookMaxAttempts = 8
+	webhookBackoffBase = 30 * time.Second
+	webhookBackoffMax  = 6 * time.Hour
+	// webhookLease is how long a claimed delivery is hidden from other dispatchers. It must
+	// outlast the client timeout.
+	webhookLease        = time.Minute
+	webhookClaimBatch   = 10
+	webhookResponseBody = 1024
+)
+
+// nonPublicPrefixes are the ranges isPublicAddr rejects beyond those netip.Addr classifies:
+// "this network" and the carrier-grade NAT shared space.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// isPublicAddr reports whether a webhook may be delivered to addr: not a loopback, private,
+// link-local (such as the 169.254.169.254 metadata service), multicast or unspecified address,
+// so that webhooks cannot be used to reach the services next to the API.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// errWebhookTarget is the error of deliveries refused because their URL resolves to an address
+// that is not public.
+var errWebhookTarget = errors.New("webhook target is not a public address")
+
+// checkWebhookTarget resolves the host of a webhook URL, returning a message for the url field
+// when it cannot be resolved or one of its addresses is not public.
+func (app *application) checkWebhookTarget(ctx context.Context, rawURL string) string {
+	if app.config.webhooks.allowPrivateTargets {
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "must be an absolute http or https URL"
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return "must name a host that can be resolved"
+	}
+	for _, addr := range addrs {
+		if !isPublicAddr(addr) {
+			return "must not resolve to a private, loopback or link-local address"
+		}
+	}
+	return ""
+}
+
+// newWebhookClient returns the client deliveries are sent with. Unless private targets are
+// allowed, the address of every connection is checked once resolved, so that a host which
+// resolved to a public address at registration cannot be pointed elsewhere since.
+func newWebhookClient(allowPrivateTargets bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivateTargets {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !isPublicAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", errWebhookTarget, address)
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			// Deliveries never go through a proxy, which would connect on their behalf.
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+		// Redirects are reported as failed deliveries rather than followed to another host.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// webhookSignature signs a delivery so receivers can check it came from us and is recent:
+// the hex HMAC-SHA256 of the timestamp, a dot and the body, keyed by the webhook secret.
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the wait before retrying after the given number of failed attempts.
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookBackoffBase
+	for i := 1; i < attempts && backoff < webhookBackoffMax; i++ {
+		backoff *= 2
+	}
+	return min(backoff, webhookBackoffMax)
+}
+
+// runWebhookDispatcher sends due deliveries every poll interval until ctx is cancelled.
+func (app *application) runWebhookDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(app.config.webhooks.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.dispatchWebhooks(ctx)
+		}
+	}
+}
+
+// dispatchWebhooks sends batches of due deliveries until none are left.
+func (app *application) dispatchWebhooks(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := app.models.Deliveries.Claim(webhookClaimBatch, webhookLease)
+		if err != nil {
+			app.logger.Error("could not claim webhook deliveries", "error", err.Error())
+			return
+		}
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				app.deliverWebhook(ctx, delivery)
+			}()
+		}
+		wg.Wait()
+		if len(deliveries) < webhookClaimBatch {
+			return
+		}
+	}
+}
+
+func (app *application) deliverWebhook(ctx context.Context, delivery *models.DueDelivery) {
+	attempt := &models.DeliveryAttempt{AttemptedAt: time.Now()}
+	err := func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+		if err != nil {
+			return err
+		}
+		timestamp := strconv.FormatInt(attempt.AttemptedAt.Unix(), 10)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "plibrary-webhooks/"+version)
+		req.Header.Set("X-Plibrary-Event", delivery.Event)
+		req.Header.Set("X-Plibrary-Delivery", strconv.FormatInt(delivery.ID, 10))
+		req.Header.Set("X-Plibrary-Timestamp", timestamp)
+		req.Header.Set("X-Plibrary-Signature", webhookSignature(delivery.Secret, timestamp, delivery.Payload))
+		resp, err := app.webhookClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseBody))
+		attempt.ResponseStatus = resp.StatusCode
+		attempt.ResponseBody = string(bytes.ToValidUTF8(body, nil))
+		return nil
+	}()
+	attempt.DurationMS = int(time.Since(attempt.AttemptedAt).Milliseconds())
+	// Deliveries interrupted by shutdown are not held against the webhook; they become due
+	// again once their lease runs out.
+	if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	status, next := models.DeliveryPending, time.Now()
+	switch attempts := delivery.Attempts + 1; {
+	case err == nil && attempt.ResponseStatus >= 200 && attempt.ResponseStatus < 300:
+		status = models.DeliveryDelivered
+	case attempts >= webhookMaxAttempts:
+		status = models.DeliveryDead
+		app.logger.Warn("webhook delivery dead-lettered", "delivery_id", delivery.ID, "webhook_id", delivery.WebhookID, "attempts", attempts)
+	default:
+		next = next.Add(webhookBackoff(attempts))
+	}
+	err = app.models.Deliveries.RecordAttempt(delivery.ID, attempt, status, next)
+	if err != nil {
+		app.logger.Error("could not record webhook delivery attempt", "delivery_id", delivery.ID, "error", err.Error())
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/themilar/plibrary/internal/models"
+)
+
+// deliveryListLimit is the number of deliveries returned by the delivery log of a webhook.
+const deliveryListLimit = 50
+
+type webhookInput struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret is generated when it is left out.
+	Secret string `json:"secret"`
+	Active *bool  `json:"active"`
+}
+
+type webhookPatch struct {
+	URL    *string  `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+// createdWebhook is the only representation of a webhook that includes its secret.
+type createdWebhook struct {
+	models.Webhook
+	Secret string `json:"secret"`
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validateWebhook validates webhook and, when its URL is well formed, checks that it targets a
+// public address.
+func (app *application) validateWebhook(ctx context.Context, webhook *models.Webhook) map[string]string {
+	validationErrors := webhook.Validate()
+	if _, ok := validationErrors["url"]; !ok {
+		if message := app.checkWebhookTarget(ctx, webhook.URL); message != "" {
+			if validationErrors == nil {
+				validationErrors = map[string]string{}
+			}
+			validationErrors["url"] = message
+		}
+	}
+	return validationErrors
+}
+
+// getWebhook loads the webhook named by the id URL parameter, writing the error response
+// when it cannot.
+func (app *application) getWebhook(w http.ResponseWriter, r *http.Request) *models.Webhook {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return nil
+	}
+	webhook, err := app.models.Webhooks.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundErrorResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return webhook
+}
+
+func (app *application) webhookList(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.models.Webhooks.All()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"webhooks": webhooks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) webhookCreate(w http.ResponseWriter, r *http.Request) {
+	var input webhookInput
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+	webhook := &models.Webhook{
+		URL:    input.URL,
+		Events: input.Events,
+		Secret: input.Secret,
+		Active: true,
+	}
+	if input.Active != nil {
+		webhook.Active = *input.Active
+	}
+	if webhook.Secret == "" {
+		webhook.Secret, err = generateWebhookSecret()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	validationErrors := app.validateWebhook(r.Context(), webhook)
+	if len(validationErrors) != 0 {
+		app.failedValidationErrorResponse(w, r, validationErrors)
+		return
+	}
+	err = app.models.Webhooks.Insert(webhook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", webhook.ID))
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"webhook": createdWebhook{*webhook, webhook.Secret}}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) webhookDetail(w http.ResponseWriter, r *http.Request) {
+	webhook := app.getWebhook(w, r)
+	if webhook == nil {
+		return
+	}
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) webhookUpdate(w http.ResponseWriter, r *http.Request) {
+	webhook := app.getWebhook(w, r)
+	if webhook == nil {
+		return
+	}
+	if expected := r.Header.Get("X-Expected-Version"); expected != "" && expected != strconv.Itoa(webhook.Version) {
+		app.editConflictErrorResponse(w, r)
+		return
+	}
+	var input webhookPatch
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+	if input.URL != nil {
+		webhook.URL = *input.URL
+	}
+	if input.Events != nil {
+		webhook.Events = input.Events
+	}
+	if input.Active != nil {
+		webhook.Active = *input.Active
+	}
+	validationErrors := app.validateWebhook(r.Context(), webhook)
+	if len(validationErrors) != 0 {
+		app.failedValidationErrorResponse(w, r, validationErrors)
+		return
+	}
+	err = app.models.Webhooks.Update(webhook)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictErrorResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) webhookDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+	err = app.models.Webhooks.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundErrorResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) webhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhook := app.getWebhook(w, r)
+	if webhook == nil {
+		return
+	}
+	status := app.readString(r.URL.Query(), "status", "")
+	switch status {
+	case "", models.DeliveryPending, models.DeliveryDelivered, models.DeliveryDead:
+	default:
+		app.failedValidationErrorResponse(w, r, map[string]string{"status": "must be one of: pending, delivered, dead"})
+		return
+	}
+	deliveries, err := app.models.Deliveries.ForWebhook(webhook.ID, status, deliveryListLimit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"deliveries": deliveries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) readDeliveryIDParam(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "deliveryID"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid delivery id parameter")
+	}
+	return id, nil
+}
+
+func (app *application) webhookDeliveryDetail(w http.ResponseWriter, r *http.Request) {
+	webhookID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+	id, err := app.readDeliveryIDParam(r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+	delivery, err := app.models.Deliveries.Get(webhookID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundErrorResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"delivery": delivery}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// webhookRedeliver queues the event of an earlier delivery again, whatever became of it.
+func (app *application) webhookRedeliver(w http.ResponseWriter, r *http.Request) {
+	webhookID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+	id, err := app.readDeliveryIDParam(r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+	delivery, err := app.models.Deliveries.Redeliver(webhookID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundErrorResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d/deliveries/%d", webhookID, delivery.ID))
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"delivery": delivery}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/themilar/plibrary/internal/models"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	for _, test := range []struct {
+		addr   string
+		public bool
+	}{
+		{"93.184.215.14", true},
+		{"8.8.8.8", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"127.8.9.10", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"172.31.255.255", false},
+		{"172.32.0.1", true},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00:ec2::254", false},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"::", false},
+		{"100.64.0.1", false},
+		{"100.128.0.1", true},
+		{"224.0.0.1", false},
+		{"ff02::1", false},
+		// IPv4 addresses mapped into IPv6 are judged as IPv4.
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"::ffff:8.8.8.8", true},
+	} {
+		if got := isPublicAddr(netip.MustParseAddr(test.addr)); got != test.public {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", test.addr, got, test.public)
+		}
+	}
+}
+
+func TestCheckWebhookTarget(t *testing.T) {
+	app := &application{}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	private := "must not resolve to a private, loopback or link-local address"
+	for _, test := range []struct {
+		url     string
+		message string
+	}{
+		{"https://93.184.215.14/hooks", ""},
+		{"http://127.0.0.1:8080/hooks", private},
+		{"http://169.254.169.254/latest/meta-data/", private},
+		{"http://[::1]/", private},
+		{"http://[::ffff:10.0.0.1]/", private},
+		{"http://0.0.0.0:4000/", private},
+		{"http://localhost:4000/", private},
+		{"http://host.invalid/", "must name a host that can be resolved"},
+	} {
+		if got := app.checkWebhookTarget(ctx, test.url); got != test.message {
+			t.Errorf("checkWebhookTarget(%s) = %q, want %q", test.url, got, test.message)
+		}
+	}
+
+	app.config.webhooks.allowPrivateTargets = true
+	if got := app.checkWebhookTarget(ctx, "http://127.0.0.1/"); got != "" {
+		t.Errorf("checkWebhookTarget with private targets allowed = %q, want none", got)
+	}
+}
+
+func TestWebhookClient(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	// The address is checked when connecting, whatever the URL looked like when registered.
+	_, err := newWebhookClient(false).Post(receiver.URL, "application/json", strings.NewReader("{}"))
+	if !errors.Is(err, errWebhookTarget) {
+		t.Errorf("delivery to %s: error %v, want %v", receiver.URL, err, errWebhookTarget)
+	}
+
+	resp, err := newWebhookClient(true).Post(receiver.URL, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("delivery with private targets allowed: status %d, want 204", resp.StatusCode)
+	}
+}
+
+func TestWebhookRoutesRequireStaff(t *testing.T) {
+	send := func(handler http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Without staff tokens, the routes are hidden.
+	handler := newMemoryApplication(t, config{}).routes()
+	if rr := send(handler, http.MethodGet, "/v1/webhooks", "", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("GET /v1/webhooks without staff tokens: status %d, want 404", rr.Code)
+	}
+
+	var cfg config
+	cfg.auth.staffTokens = "desk-1, desk-2"
+	handler = newMemoryApplication(t, cfg).routes()
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/v1/webhooks"},
+		{http.MethodPost, "/v1/webhooks"},
+		{http.MethodGet, "/v1/webhooks/1"},
+		{http.MethodPatch, "/v1/webhooks/1"},
+		{http.MethodDelete, "/v1/webhooks/1"},
+		{http.MethodGet, "/v1/webhooks/1/deliveries"},
+		{http.MethodGet, "/v1/webhooks/1/deliveries/1"},
+		{http.MethodPost, "/v1/webhooks/1/deliveries/1/redeliver"},
+		{http.MethodGet, "/v1/admin/config"},
+	} {
+		for _, token := range []string{"", "desk-3"} {
+			rr := send(handler, route.method, route.path, token, "{}")
+			if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("%s %s with token %q: status %d, want 401 with a Bearer challenge", route.method, route.path, token, rr.Code)
+			}
+		}
+	}
+
+	// Targets are checked before anything is stored.
+	rr := send(handler, http.MethodPost, "/v1/webhooks", "desk-2", `{"url": "http://169.254.169.254/latest", "events": ["book.created"]}`)
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "must not resolve to a private, loopback or link-local address") {
+		t.Errorf("POST /v1/webhooks to the metadata service: status %d, body %s; want 422 on url", rr.Code, rr.Body)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	got := webhookSignature("whsec_0123456789abcdef", "1700000000", []byte(`{"event":"book.created"}`))
+	if want := "sha256=4180a052922214c5628384b867066a3a9ce4d8cb8d75f0fb012f6957f39121fc"; got != want {
+		t.Errorf("webhookSignature = %s, want %s", got, want)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	for _, test := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, 6 * time.Hour},
+		{50, 6 * time.Hour},
+	} {
+		if got := webhookBackoff(test.attempts); got != test.want {
+			t.Errorf("webhookBackoff(%d) = %v, want %v", test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestWebhookValidate(t *testing.T) {
+	for _, test := range []struct {
+		webhook models.Webhook
+		fields  string
+	}{
+		{models.Webhook{URL: "https://example.org/hook", Secret: strings.Repeat("s", 16), Events: []string{models.EventBookCreated}}, ""},
+		{models.Webhook{URL: "ftp://example.org/hook", Secret: "short", Events: []string{}}, "events secret url"},
+		{models.Webhook{URL: "https://example.org/hook", Secret: strings.Repeat("s", 16), Events: []string{"book.created", "book.created"}}, "events"},
+		{models.Webhook{URL: "https://example.org/hook", Secret: strings.Repeat("s", 16), Events: []string{"loan.created"}}, "events"},
+	} {
+		errs := test.webhook.Validate()
+		var fields []string
+		for _, field := range []string{"events", "secret", "url"} {
+			if errs[field] != "" {
+				fields = append(fields, field)
+			}
+		}
+		if got := strings.Join(fields, " "); got != test.fields || len(errs) != len(fields) {
+			t.Errorf("Validate(%+v) = %v, want errors for %q", test.webhook, errs, test.fields)
+		}
+	}
+}
+
+func TestWebhookRequestErrors(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.auth.staffTokens = "desk-1"
+	handler := app.routes()
+
+	for _, test := range []struct {
+		method, target, body string
+		accept               string
+		status               int
+		contentType          string
+	}{
+		{http.MethodGet, "/v1/webhooks/abc", "", "", http.StatusNotFound, "application/json"},
+		{http.MethodGet, "/v1/webhooks/1/deliveries/abc", "", "", http.StatusNotFound, "application/json"},
+		{http.MethodPost, "/v1/webhooks", `{"url": `, "", http.StatusBadRequest, "application/json"},
+		{http.MethodPost, "/v1/webhooks", `{"url": "https://example.org/hook", "events": ["loan.created"]}`, "", http.StatusUnprocessableEntity, "application/json"},
+		{http.MethodGet, "/v1/webhooks/abc", "", "application/xml", http.StatusNotFound, "application/xml"},
+		{http.MethodGet, "/v1/webhooks", "", "image/png", http.StatusNotAcceptable, "application/json"},
+	} {
+		req := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		req.Header.Set("Authorization", "Bearer desk-1")
+		req.Header.Set("Accept", test.accept)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != test.status || rr.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("%s %s with Accept %q: status %d, Content-Type %q; want %d, %s; body %s",
+				test.method, test.target, test.accept, rr.Code, rr.Header().Get("Content-Type"), test.status, test.contentType, rr.Body)
+		}
+	}
+}
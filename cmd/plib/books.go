@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/themilar/plibrary/pkg/client"
+)
+
+func readID(positional []string, usage string) (int64, error) {
+	if len(positional) != 1 {
+		return 0, errors.New("usage: " + usage)
+	}
+	id, err := strconv.ParseInt(positional[0], 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid book id %q", positional[0])
+	}
+	return id, nil
+}
+
+func (app *cli) booksList(args []string) error {
+	fs := flag.NewFlagSet("books list", flag.ContinueOnError)
+	var opts client.ListOptions
+	var genres stringList
+	fs.StringVar(&opts.Title, "title", "", "Exact title")
+	fs.Var(&genres, "genre", "Genre the books must have, repeatable")
+	fs.StringVar(&opts.Sort, "sort", "", "Sort field, prefixed with - for descending order")
+	fs.IntVar(&opts.Page, "page", 0, "Page number")
+	fs.IntVar(&opts.Size, "size", 0, "Page size")
+	all := fs.Bool("all", false, "Fetch every page")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	opts.Genres = genres
+
+	if !*all {
+		list, err := app.client.Books.List(app.ctx, opts)
+		if err != nil {
+			return err
+		}
+		return app.printBooks(list.Books)
+	}
+	var books []*client.Book
+	for book, err := range app.client.Books.All(app.ctx, opts) {
+		if err != nil {
+			return err
+		}
+		books = append(books, book)
+	}
+	return app.printBooks(books)
+}
+
+func (app *cli) booksGet(args []string) error {
+	fs := flag.NewFlagSet("books get", flag.ContinueOnError)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	id, err := readID(positional, "plib books get ID")
+	if err != nil {
+		return err
+	}
+	book, err := app.client.Books.Get(app.ctx, id)
+	if err != nil {
+		return err
+	}
+	return app.printBooks([]*client.Book{book})
+}
+
+func (app *cli) booksAdd(args []string) error {
+	fs := flag.NewFlagSet("books add", flag.ContinueOnError)
+	var input client.BookInput
+	var authors, genres stringList
+	fs.StringVar(&input.Title, "title", "", "Title")
+	fs.Var(&authors, "author", "Author, repeatable")
+	fs.StringVar(&input.Publisher, "publisher", "", "Publisher")
+	fs.IntVar(&input.Published, "published", 0, "Year of publication")
+	fs.IntVar(&input.Pages, "pages", 0, "Number of pages")
+	fs.Var(&genres, "genre", "Genre, repeatable")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("unexpected argument %q", positional[0])
+	}
+	input.Authors, input.Genres = authors, genres
+
+	book, err := app.client.Books.Create(app.ctx, input)
+	if err != nil {
+		return err
+	}
+	return app.printBooks([]*client.Book{book})
+}
+
+func (app *cli) booksEdit(args []string) error {
+	fs := flag.NewFlagSet("books edit", flag.ContinueOnError)
+	var authors, genres stringList
+	title := fs.String("title", "", "Title")
+	fs.Var(&authors, "author", "Author, repeatable")
+	publisher := fs.String("publisher", "", "Publisher")
+	published := fs.Int("published", 0, "Year of publication")
+	pages := fs.Int("pages", 0, "Number of pages")
+	fs.Var(&genres, "genre", "Genre, repeatable")
+	version := fs.Int("version", 0, "Only update the book if it is still at this version")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	id, err := readID(positional, "plib books edit ID [flags]")
+	if err != nil {
+		return err
+	}
+
+	// Only the flags given on the command line are sent.
+	var update client.BookUpdate
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "title":
+			update.Title = title
+		case "author":
+			update.Authors = append([]string{}, authors...)
+		case "publisher":
+			update.Publisher = publisher
+		case "published":
+			update.Published = published
+		case "pages":
+			update.Pages = pages
+		case "genre":
+			update.Genres = genres
+		}
+	})
+	book, err := app.client.Books.Update(app.ctx, id, update, *version)
+	if errors.Is(err, client.ErrEditConflict) {
+		return fmt.Errorf("book %d was changed by someone else, fetch it again and retry", id)
+	}
+	if err != nil {
+		return err
+	}
+	return app.printBooks([]*client.Book{book})
+}
+
+func (app *cli) booksDelete(args []string) error {
+	fs := flag.NewFlagSet("books delete", flag.ContinueOnError)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	id, err := readID(positional, "plib books delete ID")
+	if err != nil {
+		return err
+	}
+	return app.client.Books.Delete(app.ctx, id)
+}
+
+func (app *cli) search(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New(`usage: plib search "QUERY"`)
+	}
+	books, err := app.client.Books.Search(app.ctx, positional[0])
+	if err != nil {
+		return err
+	}
+	return app.printBooks(books)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/themilar/plibrary/pkg/client"
+)
+
+// importCSV creates a book for every row of a CSV file with the columns of csvHeader. The id
+// and version columns are optional and ignored. Rows that fail are reported and skipped.
+func (app *cli) importCSV(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Check the file without creating books")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: plib import [-dry-run] FILE.csv")
+	}
+	f, err := os.Open(positional[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("reading header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"title", "published", "pages", "genres"} {
+		if _, ok := columns[required]; !ok {
+			return fmt.Errorf("missing column %q", required)
+		}
+	}
+
+	created, failed := 0, 0
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		input, err := bookInput(record, columns)
+		if err == nil && !*dryRun {
+			var book *client.Book
+			book, err = app.client.Books.Create(app.ctx, input)
+			if err == nil {
+				fmt.Fprintf(app.stdout, "line %d: created book %d\n", line, book.ID)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(app.stdout, "line %d: %v\n", line, err)
+			failed++
+			continue
+		}
+		created++
+	}
+	verb := "imported"
+	if *dryRun {
+		verb = "valid"
+	}
+	fmt.Fprintf(app.stdout, "%d %s, %d failed\n", created, verb, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d rows could not be imported", failed)
+	}
+	return nil
+}
+
+func bookInput(record []string, columns map[string]int) (client.BookInput, error) {
+	get := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	list := func(name string) []string {
+		var values []string
+		for _, v := range strings.Split(get(name), ";") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		return values
+	}
+	input := client.BookInput{
+		Title:     get("title"),
+		Authors:   list("authors"),
+		Publisher: get("publisher"),
+		Genres:    list("genres"),
+	}
+	var err error
+	if input.Published, err = strconv.Atoi(get("published")); err != nil {
+		return input, fmt.Errorf("published must be a year, got %q", get("published"))
+	}
+	if input.Pages, err = strconv.Atoi(get("pages")); err != nil {
+		return input, fmt.Errorf("pages must be a number, got %q", get("pages"))
+	}
+	return input, nil
+}
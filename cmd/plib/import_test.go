@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeCSV(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "books.csv")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestImportCSV(t *testing.T) {
+	useConfigDir(t)
+	path := writeCSV(t, `Title, Authors, Published, Pages, Genres
+Dune, Frank Herbert, 1965, 412, sci-fi; classics
+, Nobody, 2001, 10, misc
+The Hobbit, J. R. R. Tolkien, 1937, many, fantasy
+Good Omens, "Terry Pratchett; Neil Gaiman", 1990, 288, fantasy;;humour
+`)
+
+	api := &fakeAPI{}
+	stdout, err := runPlib(t, api, "import", path)
+	if err == nil || err.Error() != "2 rows could not be imported" {
+		t.Errorf("import: error %v, want 2 rows could not be imported", err)
+	}
+	want := `line 2: created book 1
+line 3: plibrary: 422 Unprocessable Entity: map[title:must be provided]
+line 4: pages must be a number, got "many"
+line 5: created book 2
+2 imported, 2 failed
+`
+	if stdout != want {
+		t.Errorf("import output =\n%s\nwant\n%s", stdout, want)
+	}
+	if got, want := strings.Join(api.requests, "\n"), strings.Join([]string{
+		`POST /v1/books {"title":"Dune","authors":["Frank Herbert"],"published":1965,"pages":412,"genres":["sci-fi","classics"]}`,
+		`POST /v1/books {"title":"","authors":["Nobody"],"published":2001,"pages":10,"genres":["misc"]}`,
+		`POST /v1/books {"title":"Good Omens","authors":["Terry Pratchett","Neil Gaiman"],"published":1990,"pages":288,"genres":["fantasy","humour"]}`,
+	}, "\n"); got != want {
+		t.Errorf("import sent\n%s\nwant\n%s", got, want)
+	}
+
+	api = &fakeAPI{}
+	stdout, err = runPlib(t, api, "import", "-dry-run", path)
+	if err == nil || len(api.requests) != 0 || !strings.HasSuffix(stdout, "3 valid, 1 failed\n") {
+		t.Errorf("import -dry-run: output %q, error %v, %d requests", stdout, err, len(api.requests))
+	}
+}
+
+func TestImportCSVExported(t *testing.T) {
+	useConfigDir(t)
+	exported, err := runPlib(t, &fakeAPI{}, "-o", "csv", "books", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &fakeAPI{}
+	if _, err := runPlib(t, api, "import", writeCSV(t, exported)); err != nil {
+		t.Fatalf("importing exported CSV: %v", err)
+	}
+	want := `POST /v1/books {"title":"Dune","authors":["Frank Herbert"],"published":1965,"pages":412,"genres":["sci-fi","classics"]}`
+	if len(api.requests) != 1 || api.requests[0] != want {
+		t.Errorf("importing exported CSV sent %q, want %q", api.requests, want)
+	}
+}
+
+func TestImportCSVErrors(t *testing.T) {
+	useConfigDir(t)
+	for _, test := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"import"}, "usage: plib import [-dry-run] FILE.csv"},
+		{[]string{"import", filepath.Join(t.TempDir(), "missing.csv")}, "no such file or directory"},
+		{[]string{"import", writeCSV(t, "")}, "reading header: EOF"},
+		{[]string{"import", writeCSV(t, "title,published,pages\nDune,1965,412\n")}, `missing column "genres"`},
+		{[]string{"import", writeCSV(t, "title,published,pages,genres\n\"Dune,1965,412,sci-fi\n")}, "extraneous or missing \" in quoted-field"},
+	} {
+		api := &fakeAPI{}
+		_, err := runPlib(t, api, test.args...)
+		if err == nil || !strings.Contains(err.Error(), test.err) || len(api.requests) != 0 {
+			t.Errorf("plib %s: error %v after %d requests, want %q", strings.Join(test.args, " "), err, len(api.requests), test.err)
+		}
+	}
+}
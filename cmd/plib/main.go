@@ -0,0 +1,232 @@
+// Command plib manages the plibrary catalogue through the API.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/themilar/plibrary/pkg/client"
+)
+
+const usage = `usage: plib [-url URL] [-token TOKEN] [-o table|json|csv] <command> [arguments]
+
+commands:
+  books list [-title T] [-genre G]... [-sort FIELD] [-page N] [-size N] [-all]
+  books get ID
+  books add -title T -published YEAR -pages N -genre G... [-author A]... [-publisher P]
+  books edit ID [-title T] [-published YEAR] [-pages N] [-genre G]... [-author A]... [-publisher P] [-version N]
+  books delete ID
+  search QUERY
+  import FILE.csv
+  config show
+  config set base_url|token VALUE
+
+Settings are read from %s and can be overridden with the
+PLIB_URL and PLIB_TOKEN environment variables or the -url and -token flags.
+`
+
+// config is stored as JSON in the user configuration directory.
+type config struct {
+	BaseURL string `json:"base_url"`
+	Token   string `json:"token,omitempty"`
+}
+
+func configPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "plib", "config.json")
+}
+
+// readConfigFile reads the saved settings, which are the defaults when there is no file.
+func readConfigFile() (config, error) {
+	cfg := config{BaseURL: "http://localhost:4000"}
+	data, err := os.ReadFile(configPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", configPath(), err)
+	}
+	return cfg, nil
+}
+
+// loadConfig returns the saved settings with the environment overrides applied.
+func loadConfig() (config, error) {
+	cfg, err := readConfigFile()
+	if err != nil {
+		return cfg, err
+	}
+	if v := os.Getenv("PLIB_URL"); v != "" {
+		cfg.BaseURL = v
+	}
+	if v := os.Getenv("PLIB_TOKEN"); v != "" {
+		cfg.Token = v
+	}
+	return cfg, nil
+}
+
+func saveConfig(cfg config) error {
+	path := configPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cfg, "", "\t")
+	if err != nil {
+		return err
+	}
+	// The file may hold a token, so it is only readable by the user.
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// cli carries what every command needs.
+type cli struct {
+	ctx    context.Context
+	client *client.Client
+	output string
+	stdout io.Writer
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "plib:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout io.Writer) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("plib", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprintf(fs.Output(), usage, configPath()) }
+	baseURL := fs.String("url", cfg.BaseURL, "API base URL")
+	token := fs.String("token", cfg.Token, "API token")
+	output := fs.String("o", "table", "Output format (table|json|csv)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch *output {
+	case "table", "json", "csv":
+	default:
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		fs.Usage()
+		return errors.New("missing command")
+	}
+
+	if args[0] == "config" {
+		return configCommand(cfg, args[1:], stdout)
+	}
+	opts := []client.Option{client.WithUserAgent("plib")}
+	if *token != "" {
+		opts = append(opts, client.WithToken(*token))
+	}
+	c, err := client.New(*baseURL, opts...)
+	if err != nil {
+		return err
+	}
+	app := &cli{ctx: ctx, client: c, output: *output, stdout: stdout}
+
+	switch args[0] {
+	case "books":
+		if len(args) < 2 {
+			return errors.New("usage: plib books list|get|add|edit|delete")
+		}
+		switch args[1] {
+		case "list":
+			return app.booksList(args[2:])
+		case "get":
+			return app.booksGet(args[2:])
+		case "add":
+			return app.booksAdd(args[2:])
+		case "edit":
+			return app.booksEdit(args[2:])
+		case "delete":
+			return app.booksDelete(args[2:])
+		}
+		return fmt.Errorf("unknown books command %q", args[1])
+	case "search":
+		return app.search(args[1:])
+	case "import":
+		return app.importCSV(args[1:])
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func configCommand(cfg config, args []string, stdout io.Writer) error {
+	switch {
+	case len(args) == 1 && args[0] == "show":
+		if cfg.Token != "" {
+			cfg.Token = strings.Repeat("*", 8)
+		}
+		fmt.Fprintf(stdout, "file:     %s\nbase_url: %s\ntoken:    %s\n", configPath(), cfg.BaseURL, cfg.Token)
+		return nil
+	case len(args) == 3 && args[0] == "set":
+		// Environment overrides must not leak into the saved file.
+		saved, err := readConfigFile()
+		if err != nil {
+			return err
+		}
+		switch args[1] {
+		case "base_url":
+			saved.BaseURL = args[2]
+		case "token":
+			saved.Token = args[2]
+		default:
+			return fmt.Errorf("unknown setting %q", args[1])
+		}
+		return saveConfig(saved)
+	}
+	return errors.New("usage: plib config show | plib config set base_url|token VALUE")
+}
+
+// stringList is a flag that can be repeated or given comma separated values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// parseArgs parses flags given before, between or after positional arguments, so that
+// both "edit 12 -pages 300" and "edit -pages 300 12" work.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeAPI records the requests plib sends and answers them like the books endpoints.
+type fakeAPI struct {
+	mu       sync.Mutex
+	requests []string
+	nextID   int
+}
+
+func (api *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	request := r.Method + " " + r.URL.RequestURI()
+	if len(body) > 0 {
+		request += " " + string(body)
+	}
+	if v := r.Header.Get("X-Expected-Version"); v != "" {
+		request += " version=" + v
+	}
+	api.requests = append(api.requests, request)
+
+	book := `{"id": 12, "title": "Dune", "authors": ["Frank Herbert"], "published": 1965, "pages": "412", "genres": ["sci-fi", "classics"], "version": 3}`
+	switch {
+	case r.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPatch && r.Header.Get("X-Expected-Version") == "1":
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, `{"code": "edit_conflict", "detail": "unable to complete the update due to a conflict, try again"}`)
+	case r.Method == http.MethodPost:
+		var input struct {
+			Title string `json:"title"`
+		}
+		json.Unmarshal(body, &input)
+		if input.Title == "" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			io.WriteString(w, `{"error": {"title": "must be provided"}}`)
+			return
+		}
+		api.nextID++
+		fmt.Fprintf(w, `{"book": {"id": %d, "title": %q}}`, api.nextID, input.Title)
+	case r.URL.Path == "/v1/books" || r.URL.Path == "/v1/books/search":
+		fmt.Fprintf(w, `{"books": [%s], "metadata": {"current_page": 1, "last_page": 1}}`, book)
+	default:
+		fmt.Fprintf(w, `{"book": %s}`, book)
+	}
+}
+
+// runPlib runs plib against a fake API with its configuration in a temporary directory.
+func runPlib(t *testing.T, api *fakeAPI, args ...string) (string, error) {
+	t.Helper()
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	var stdout bytes.Buffer
+	err := run(context.Background(), append([]string{"-url", server.URL}, args...), &stdout)
+	return stdout.String(), err
+}
+
+func useConfigDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("PLIB_URL", "")
+	t.Setenv("PLIB_TOKEN", "")
+	return dir
+}
+
+func TestConfigCommand(t *testing.T) {
+	dir := useConfigDir(t)
+	var stdout bytes.Buffer
+	for _, args := range [][]string{
+		{"config", "set", "base_url", "https://library.example.org"},
+		{"config", "set", "token", "desk-1"},
+	} {
+		if err := run(context.Background(), args, &stdout); err != nil {
+			t.Fatalf("plib %s: %v", strings.Join(args, " "), err)
+		}
+	}
+	path := filepath.Join(dir, "plib", "config.json")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("%s has mode %v, want 0600", path, info.Mode().Perm())
+	}
+
+	t.Setenv("PLIB_URL", "http://localhost:9999")
+	stdout.Reset()
+	if err := run(context.Background(), []string{"config", "show"}, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("file:     %s\nbase_url: http://localhost:9999\ntoken:    ********\n", path)
+	if stdout.String() != want {
+		t.Errorf("config show =\n%s\nwant\n%s", stdout.String(), want)
+	}
+
+	// Environment overrides are not saved.
+	if err := run(context.Background(), []string{"config", "set", "token", "desk-2"}, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := readConfigFile()
+	if err != nil || saved.BaseURL != "https://library.example.org" || saved.Token != "desk-2" {
+		t.Errorf("saved configuration = %+v, %v", saved, err)
+	}
+
+	for _, args := range [][]string{{"config", "set", "colour", "blue"}, {"config"}, {"-o", "yaml", "books", "list"}, {}, {"shelve"}, {"books", "burn", "1"}} {
+		if err := run(context.Background(), args, io.Discard); err == nil {
+			t.Errorf("plib %s succeeded, want an error", strings.Join(args, " "))
+		}
+	}
+}
+
+func TestBooksCommands(t *testing.T) {
+	useConfigDir(t)
+	for _, test := range []struct {
+		args    []string
+		request string
+		err     string
+	}{
+		{[]string{"books", "list", "-genre", "sci-fi,classics", "-genre", "fantasy", "-sort", "-published", "-size", "5"},
+			"GET /v1/books?genres=sci-fi%2Cclassics%2Cfantasy&size=5&sort=-published", ""},
+		{[]string{"books", "list", "-all"}, "GET /v1/books?page=1", ""},
+		{[]string{"books", "get", "12"}, "GET /v1/books/12", ""},
+		{[]string{"books", "add", "-title", "Dune", "-published", "1965", "-pages", "412", "-genre", "sci-fi", "-author", "Frank Herbert"},
+			`POST /v1/books {"title":"Dune","authors":["Frank Herbert"],"published":1965,"pages":412,"genres":["sci-fi"]}`, ""},
+		{[]string{"books", "edit", "12", "-pages", "300", "-version", "3"},
+			`PATCH /v1/books/12 {"authors":null,"pages":300,"genres":null} version=3`, ""},
+		{[]string{"books", "edit", "-title", "Dune Messiah", "12", "-author", ""},
+			`PATCH /v1/books/12 {"title":"Dune Messiah","authors":[],"genres":null}`, ""},
+		{[]string{"books", "edit", "12", "-pages", "300", "-version", "1"},
+			`PATCH /v1/books/12 {"authors":null,"pages":300,"genres":null} version=1`, "book 12 was changed by someone else"},
+		{[]string{"books", "delete", "12"}, "DELETE /v1/books/12", ""},
+		{[]string{"search", "dune messiah"}, "GET /v1/books/search?q=dune+messiah", ""},
+		{[]string{"books", "get", "twelve"}, "", `invalid book id "twelve"`},
+		{[]string{"books", "delete"}, "", "usage: plib books delete ID"},
+		{[]string{"books", "add", "extra"}, "", `unexpected argument "extra"`},
+	} {
+		api := &fakeAPI{}
+		_, err := runPlib(t, api, test.args...)
+		var message string
+		if err != nil {
+			message = err.Error()
+		}
+		if !strings.Contains(message, test.err) || (test.err == "" && err != nil) {
+			t.Errorf("plib %s: error %v, want %q", strings.Join(test.args, " "), err, test.err)
+		}
+		var request string
+		if len(api.requests) > 0 {
+			request = api.requests[0]
+		}
+		if request != test.request || len(api.requests) > 1 {
+			t.Errorf("plib %s sent %q, want %q", strings.Join(test.args, " "), api.requests, test.request)
+		}
+	}
+}
+
+func TestOutputFormats(t *testing.T) {
+	useConfigDir(t)
+	for _, test := range []struct {
+		output, want string
+	}{
+		{"table", "ID  TITLE  AUTHORS        PUBLISHER  PUBLISHED  PAGES  GENRES            VERSION\n" +
+			"12  Dune   Frank Herbert             1965       412    sci-fi; classics  3\n"},
+		{"csv", "id,title,authors,publisher,published,pages,genres,version\n12,Dune,Frank Herbert,,1965,412,sci-fi; classics,3\n"},
+		{"json", `[
+  {
+    "id": 12,
+    "title": "Dune",
+    "authors": [
+      "Frank Herbert"
+    ],
+    "published": 1965,
+    "pages": "412",
+    "genres": [
+      "sci-fi",
+      "classics"
+    ],
+    "version": 3
+  }
+]
+`},
+	} {
+		got, err := runPlib(t, &fakeAPI{}, "-o", test.output, "books", "get", "12")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("-o %s =\n%s\nwant\n%s", test.output, got, test.want)
+		}
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	pages := fs.Int("pages", 0, "")
+	var genres stringList
+	fs.Var(&genres, "genre", "")
+	positional, err := parseArgs(fs, []string{"12", "-pages", "300", "extra", "-genre", " a, ,b", "-genre", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(positional, " ") != "12 extra" || *pages != 300 || genres.String() != "a,b,c" {
+		t.Errorf("parseArgs = %v, pages %d, genres %v", positional, *pages, genres)
+	}
+	fs.SetOutput(io.Discard)
+	if _, err := parseArgs(fs, []string{"-pages", "many"}); err == nil {
+		t.Error("parseArgs accepted an invalid flag value")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/themilar/plibrary/pkg/client"
+)
+
+// csvHeader is shared by CSV output and import, so exported files can be imported again.
+// Multiple authors and genres are separated by semicolons.
+var csvHeader = []string{"id", "title", "authors", "publisher", "published", "pages", "genres", "version"}
+
+func bookRow(book *client.Book) []string {
+	return []string{
+		strconv.FormatInt(book.ID, 10),
+		book.Title,
+		strings.Join(book.Authors, "; "),
+		book.Publisher,
+		strconv.Itoa(book.Published),
+		strconv.Itoa(book.Pages),
+		strings.Join(book.Genres, "; "),
+		strconv.Itoa(book.Version),
+	}
+}
+
+func (app *cli) printBooks(books []*client.Book) error {
+	if books == nil {
+		books = []*client.Book{}
+	}
+	switch app.output {
+	case "json":
+		data, err := json.MarshalIndent(books, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(app.stdout, string(data))
+		return err
+	case "csv":
+		w := csv.NewWriter(app.stdout)
+		w.Write(csvHeader)
+		for _, book := range books {
+			w.Write(bookRow(book))
+		}
+		w.Flush()
+		return w.Error()
+	}
+	w := tabwriter.NewWriter(app.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.ToUpper(strings.Join(csvHeader, "\t")))
+	for _, book := range books {
+		fmt.Fprintln(w, strings.Join(bookRow(book), "\t"))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,260 @@
+// Package cql parses the subset of the Contextual Query Language used by the SRU interface:
+// search clauses with an optional index and relation, combined with and/or/not and
+// parentheses.
+package cql
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Error is a query error carrying the matching SRU diagnostic number from
+// info:srw/diagnostic/1.
+type Error struct {
+	Diagnostic int
+	Message    string
+	Details    string
+}
+
+func (e *Error) Error() string {
+	if e.Details == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Message, e.Details)
+}
+
+const (
+	DiagQuerySyntax         = 10
+	DiagUnsupportedIndex    = 16
+	DiagUnsupportedRelation = 19
+	DiagUnsupportedModifier = 20
+	DiagMaskingNotSupported = 28
+	DiagInvalidTermFormat   = 36
+	DiagUnsupportedBoolean  = 37
+	DiagSortNotSupported    = 80
+)
+
+const (
+	DefaultIndex    = "cql.serverchoice"
+	DefaultRelation = "="
+)
+
+// Node is either a *Boolean or a *Clause.
+type Node interface {
+	String() string
+}
+
+// Boolean combines two sub-queries with and, or or not.
+type Boolean struct {
+	Op    string
+	Left  Node
+	Right Node
+}
+
+func (b *Boolean) String() string {
+	return fmt.Sprintf("(%s %s %s)", b.Left, b.Op, b.Right)
+}
+
+// Clause is a single search clause. Index and Relation are lower-cased and default to
+// cql.serverchoice and = when the query omits them.
+type Clause struct {
+	Index    string
+	Relation string
+	Term     string
+}
+
+func (c *Clause) String() string {
+	return fmt.Sprintf("%s %s %q", c.Index, c.Relation, c.Term)
+}
+
+type token struct {
+	text   string
+	quoted bool
+	pos    int
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses a CQL query into a tree of nodes.
+func Parse(query string) (Node, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, &Error{Diagnostic: DiagQuerySyntax, Message: "Query syntax error", Details: "empty query"}
+	}
+	p := &parser{tokens: tokens}
+	node, err := p.query()
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.peek(); ok {
+		if !t.quoted && strings.EqualFold(t.text, "sortby") {
+			return nil, &Error{Diagnostic: DiagSortNotSupported, Message: "Sort not supported"}
+		}
+		return nil, syntaxError(t, "unexpected token")
+	}
+	return node, nil
+}
+
+func syntaxError(t token, message string) *Error {
+	return &Error{Diagnostic: DiagQuerySyntax, Message: "Query syntax error", Details: fmt.Sprintf("%s %q at position %d", message, t.text, t.pos)}
+}
+
+func tokenize(query string) ([]token, error) {
+	var tokens []token
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == '/':
+			tokens = append(tokens, token{text: string(r), pos: i})
+			i++
+		case r == '=' || r == '<' || r == '>':
+			op := string(r)
+			if i+1 < len(runes) {
+				next := string(runes[i : i+2])
+				if next == "==" || next == "<>" || next == "<=" || next == ">=" {
+					op = next
+				}
+			}
+			tokens = append(tokens, token{text: op, pos: i})
+			i += len(op)
+		case r == '"':
+			var sb strings.Builder
+			start := i
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, &Error{Diagnostic: DiagQuerySyntax, Message: "Query syntax error", Details: fmt.Sprintf("unterminated string at position %d", start)}
+			}
+			i++
+			tokens = append(tokens, token{text: sb.String(), quoted: true, pos: start})
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`()/=<>"`, runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{text: string(runes[start:i]), pos: start})
+		}
+	}
+	return tokens, nil
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) next() (token, bool) {
+	t, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return t, ok
+}
+
+func isBoolean(t token) bool {
+	if t.quoted {
+		return false
+	}
+	switch strings.ToLower(t.text) {
+	case "and", "or", "not", "prox":
+		return true
+	}
+	return false
+}
+
+func isRelation(t token) bool {
+	if t.quoted {
+		return false
+	}
+	switch strings.ToLower(t.text) {
+	case "=", "==", "<>", "<", ">", "<=", ">=", "any", "all", "adj", "within", "encloses":
+		return true
+	}
+	return false
+}
+
+// query parses searchClauses joined by booleans, which associate to the left.
+func (p *parser) query() (Node, error) {
+	left, err := p.searchClause()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.peek()
+		if !ok || !isBoolean(t) {
+			return left, nil
+		}
+		p.pos++
+		op := strings.ToLower(t.text)
+		if op == "prox" {
+			return nil, &Error{Diagnostic: DiagUnsupportedBoolean, Message: "Unsupported boolean operator", Details: t.text}
+		}
+		if m, ok := p.peek(); ok && m.text == "/" {
+			return nil, &Error{Diagnostic: DiagUnsupportedBoolean, Message: "Unsupported boolean operator", Details: "boolean modifiers are not supported"}
+		}
+		right, err := p.searchClause()
+		if err != nil {
+			return nil, err
+		}
+		left = &Boolean{Op: op, Left: left, Right: right}
+	}
+}
+
+func (p *parser) searchClause() (Node, error) {
+	t, ok := p.next()
+	if !ok {
+		return nil, &Error{Diagnostic: DiagQuerySyntax, Message: "Query syntax error", Details: "unexpected end of query"}
+	}
+	if t.text == "(" && !t.quoted {
+		node, err := p.query()
+		if err != nil {
+			return nil, err
+		}
+		closing, ok := p.next()
+		if !ok || closing.text != ")" || closing.quoted {
+			return nil, &Error{Diagnostic: DiagQuerySyntax, Message: "Query syntax error", Details: fmt.Sprintf("missing closing parenthesis for position %d", t.pos)}
+		}
+		return node, nil
+	}
+	if !t.quoted && (t.text == ")" || t.text == "/" || isRelation(t) && !isWord(t)) {
+		return nil, syntaxError(t, "unexpected token")
+	}
+	if rel, ok := p.peek(); ok && isRelation(rel) && !t.quoted {
+		p.pos++
+		if m, ok := p.peek(); ok && m.text == "/" && !m.quoted {
+			return nil, &Error{Diagnostic: DiagUnsupportedModifier, Message: "Unsupported relation modifier"}
+		}
+		term, ok := p.next()
+		if !ok || !term.quoted && (term.text == "(" || term.text == ")" || term.text == "/") {
+			return nil, &Error{Diagnostic: DiagQuerySyntax, Message: "Query syntax error", Details: fmt.Sprintf("missing search term after %q", rel.text)}
+		}
+		return &Clause{Index: strings.ToLower(t.text), Relation: strings.ToLower(rel.text), Term: term.text}, nil
+	}
+	if isBoolean(t) {
+		return nil, syntaxError(t, "unexpected boolean")
+	}
+	return &Clause{Index: DefaultIndex, Relation: DefaultRelation, Term: t.text}, nil
+}
+
+// isWord reports whether a relation token is a named relation such as any or all, which
+// may also appear as a plain search term.
+func isWord(t token) bool {
+	return unicode.IsLetter([]rune(t.text)[0])
+}
@@ -0,0 +1,76 @@
+package cql
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		query string
+		want  string
+	}{
+		{`dune`, `cql.serverchoice = "dune"`},
+		{`"children of dune"`, `cql.serverchoice = "children of dune"`},
+		{`"say \"hi\""`, `cql.serverchoice = "say \"hi\""`},
+		{`Title = dune`, `title = "dune"`},
+		{`dc.title ADJ "children of"`, `dc.title adj "children of"`},
+		{`dc.date>=1970`, `dc.date >= "1970"`},
+		{`dc.date<>1970`, `dc.date <> "1970"`},
+		{`title == "The Hobbit"`, `title == "The Hobbit"`},
+		{`subject any "sci-fi fantasy"`, `subject any "sci-fi fantasy"`},
+		{`any`, `cql.serverchoice = "any"`},
+		{`"and"`, `cql.serverchoice = "and"`},
+		{`a and b`, `(cql.serverchoice = "a" and cql.serverchoice = "b")`},
+		// Booleans have equal precedence and associate to the left.
+		{`a or b and c`, `((cql.serverchoice = "a" or cql.serverchoice = "b") and cql.serverchoice = "c")`},
+		{`a and b or c`, `((cql.serverchoice = "a" and cql.serverchoice = "b") or cql.serverchoice = "c")`},
+		{`a NOT b`, `(cql.serverchoice = "a" not cql.serverchoice = "b")`},
+		{`a or (b and c)`, `(cql.serverchoice = "a" or (cql.serverchoice = "b" and cql.serverchoice = "c"))`},
+		{`((a))`, `cql.serverchoice = "a"`},
+		{`(title = dune or subject = fantasy) not dc.date < 1950`, `((title = "dune" or subject = "fantasy") not dc.date < "1950")`},
+	} {
+		node, err := Parse(test.query)
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", test.query, err)
+			continue
+		}
+		if got := node.String(); got != test.want {
+			t.Errorf("Parse(%q) = %s, want %s", test.query, got, test.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range []struct {
+		query      string
+		diagnostic int
+	}{
+		{``, DiagQuerySyntax},
+		{`   `, DiagQuerySyntax},
+		{`"dune`, DiagQuerySyntax},
+		{`(dune`, DiagQuerySyntax},
+		{`dune)`, DiagQuerySyntax},
+		{`a and`, DiagQuerySyntax},
+		{`and a`, DiagQuerySyntax},
+		{`= dune`, DiagQuerySyntax},
+		{`title =`, DiagQuerySyntax},
+		{`title = (`, DiagQuerySyntax},
+		{`()`, DiagQuerySyntax},
+		{`title =/stem dune`, DiagUnsupportedModifier},
+		{`title any/relevant "a b"`, DiagUnsupportedModifier},
+		{`a and/rel.algorithm=cql b`, DiagUnsupportedBoolean},
+		{`a prox b`, DiagUnsupportedBoolean},
+		{`dune sortby title`, DiagSortNotSupported},
+	} {
+		_, err := Parse(test.query)
+		var cqlErr *Error
+		if !errors.As(err, &cqlErr) {
+			t.Errorf("Parse(%q) error = %v, want a *Error", test.query, err)
+			continue
+		}
+		if cqlErr.Diagnostic != test.diagnostic {
+			t.Errorf("Parse(%q) diagnostic = %d (%v), want %d", test.query, cqlErr.Diagnostic, err, test.diagnostic)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package e2etest
+
+import (
+	"net/http"
+	"testing"
+)
+
+// books are created in order by the tests that need a catalogue, so that they get ids 1 to 3.
+var books = []map[string]any{
+	{"title": "Dune", "authors": []string{"Frank Herbert"}, "publisher": "Chilton Books", "published": 1965, "pages": 412, "genres": []string{"sci-fi", "adventure"}},
+	{"title": "Children Of Dune", "authors": []string{"Frank Herbert"}, "published": 1976, "pages": 444, "genres": []string{"sci-fi"}},
+	{"title": "The Hobbit", "authors": []string{"J. R. R. Tolkien"}, "published": 1937, "pages": 310, "genres": []string{"fantasy", "adventure"}},
+}
+
+func seed(s *Server) {
+	s.t.Helper()
+	for _, book := range books {
+		s.Post("/v1/books", book).Expect(http.StatusCreated)
+	}
+}
+
+// Run covers the requests of the Insomnia collection, the ways they can fail and the formats
+// and fieldsets the book endpoints offer, against the handler built by newHandler. Every
+// subtest starts on a new database.
+func Run(t *testing.T, newHandler NewHandler) {
+	for _, test := range []struct {
+		name string
+		run  func(t *testing.T, s *Server)
+	}{
+		{"create book", testCreateBook},
+		{"get books", testGetBooks},
+		{"get book", testGetBook},
+		{"update book", testUpdateBook},
+		{"get books w pagination parameters", testPagination},
+		{"get books filtered by title", testFilterByTitle},
+		{"get books filtered by genre", testFilterByGenre},
+		{"search books", testSearchBooks},
+		{"sorted book list", testSortBooks},
+		{"delete book", testDeleteBook},
+		{"unknown routes", testUnknownRoutes},
+		{"bad filters", testBadFilters},
+		{"content negotiation", testContentNegotiation},
+		{"sparse fieldsets and relations", testFieldsets},
+		{"search books w fieldsets and citations", testSearchFormats},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, Start(t, newHandler))
+		})
+	}
+}
+
+func testCreateBook(t *testing.T, s *Server) {
+	s.Post("/v1/books", books[0]).Expect(http.StatusCreated).
+		HasHeader("Location", "/v1/books/1").
+		JSON("book.id", 1).
+		JSON("book.title", "Dune").
+		JSON("book.authors", []string{"Frank Herbert"}).
+		JSON("book.pages", "412").
+		JSON("book.genres", []string{"sci-fi", "adventure"}).
+		JSON("book.version", 1)
+	s.Get("/v1/books/1").Expect(http.StatusAccepted).JSON("book.publisher", "Chilton Books")
+
+	s.Post("/v1/books", `{"title": "Dune"`).Expect(http.StatusBadRequest).
+		JSON("error", "body contains badly-formed JSON")
+	s.Post("/v1/books", `{"title": "Dune", "isbn": "0441013597"}`).Expect(http.StatusBadRequest).
+		JSON("error", `body contains unknown key "isbn"`)
+	s.Post("/v1/books", ``).Expect(http.StatusBadRequest).
+		JSON("error", "body must not be empty")
+	s.Post("/v1/books", `{"title": 1}`).Expect(http.StatusBadRequest).
+		JSON("error", `body contains incorrect JSON type for field "title"`)
+	s.Post("/v1/books", map[string]any{"title": "Dune", "published": 1965}).Expect(http.StatusUnprocessableEntity).
+		JSON("error.pages", "must be provided").
+		JSON("error.genres", "must be provided").
+		Missing("error.title")
+	s.Post("/v1/books", map[string]any{"title": "Dune", "published": 1965, "pages": 412, "genres": []string{"sci-fi", "sci-fi"}}).
+		Header("Accept", "application/problem+json").
+		Expect(http.StatusUnprocessableEntity).
+		HasHeader("Content-Type", "application/problem+json").
+		JSON("status", 422).
+		JSON("code", "validation_failed").
+		JSON("invalid_params", []map[string]string{{"name": "genres", "reason": "cannot contain duplicate genres"}})
+	s.Get("/v1/books").Expect(http.StatusOK).Len("books", 1)
+}
+
+func testGetBooks(t *testing.T, s *Server) {
+	s.Get("/v1/books").Expect(http.StatusOK).Len("books", 0).JSON("metadata", map[string]any{})
+	seed(s)
+	s.Get("/v1/books").Expect(http.StatusOK).
+		Len("books", 3).
+		JSON("books.0.title", "Dune").
+		JSON("metadata", map[string]int{"current_page": 1, "page_size": 12, "first_page": 1, "last_page": 1, "total_records": 3})
+	s.Get("/v1/books").Query("fields", "title").Expect(http.StatusOK).
+		JSON("books.1", map[string]any{"title": "Children Of Dune"})
+}
+
+func testGetBook(t *testing.T, s *Server) {
+	seed(s)
+	s.Get("/v1/books/3").Expect(http.StatusAccepted).
+		JSON("book.id", 3).
+		JSON("book.title", "The Hobbit").
+		JSON("book.published", 1937)
+	for _, path := range []string{"/v1/books/5", "/v1/books/0", "/v1/books/-1", "/v1/books/abc"} {
+		s.Get(path).Expect(http.StatusNotFound).JSON("error", "the requested resource could not be found")
+	}
+	s.Get("/v1/books/5").Header("Accept", "application/problem+json").Expect(http.StatusNotFound).
+		JSON("code", "not_found").
+		JSON("instance", "/v1/books/5")
+}
+
+func testUpdateBook(t *testing.T, s *Server) {
+	seed(s)
+	s.Patch("/v1/books/1", map[string]any{"pages": 896, "publisher": "Ace"}).Expect(http.StatusOK).
+		JSON("book.pages", "896").
+		JSON("book.publisher", "Ace").
+		JSON("book.title", "Dune").
+		JSON("book.version", 2)
+	s.Get("/v1/books/1").Expect(http.StatusAccepted).JSON("book.pages", "896").JSON("book.version", 2)
+
+	s.Patch("/v1/books/1", map[string]any{"title": "Dune Messiah"}).Header("X-Expected-Version", "1").
+		Expect(http.StatusConflict).
+		JSON("error", "unable to complete the update due to a conflict, try again")
+	s.Patch("/v1/books/1", map[string]any{"title": "Dune Messiah"}).Header("X-Expected-Version", "2").
+		Expect(http.StatusOK).
+		JSON("book.version", 3)
+
+	s.Patch("/v1/books/1", map[string]any{"pages": -1}).Expect(http.StatusUnprocessableEntity).
+		JSON("error.pages", "must be above 0")
+	s.Patch("/v1/books/1", `{"pages": "many"}`).Expect(http.StatusBadRequest).
+		JSON("error", `body contains incorrect JSON type for field "pages"`)
+	s.Patch("/v1/books/1", map[string]any{"title": "", "genres": []string{}}).Expect(http.StatusUnprocessableEntity).
+		JSON("error.title", "must be provided").
+		Has("error.genres")
+	s.Patch("/v1/books/1", `{"isbn": "0441013597"}`).Expect(http.StatusBadRequest).
+		JSON("error", `body contains unknown key "isbn"`)
+	s.Patch("/v1/books/1", map[string]any{"pages": 1}).Header("X-Expected-Version", "1").
+		Header("Accept", "application/problem+json").
+		Expect(http.StatusConflict).
+		JSON("status", 409).
+		JSON("code", "edit_conflict")
+	s.Patch("/v1/books/99", map[string]any{"pages": 1}).Expect(http.StatusNotFound)
+	s.Patch("/v1/books/99", map[string]any{"pages": 1}).Header("X-Expected-Version", "1").Expect(http.StatusNotFound)
+	s.Patch("/v1/books/abc", map[string]any{"pages": 1}).Expect(http.StatusNotFound)
+	s.Get("/v1/books/1").Expect(http.StatusAccepted).JSON("book.version", 3)
+}
+
+func testPagination(t *testing.T, s *Server) {
+	seed(s)
+	s.Get("/v1/books").Query("page", "2").Query("size", "2").Expect(http.StatusOK).
+		Len("books", 1).
+		JSON("books.0.id", 3).
+		JSON("metadata", map[string]int{"current_page": 2, "page_size": 2, "first_page": 1, "last_page": 2, "total_records": 3})
+	s.Get("/v1/books").Query("page", "3").Query("size", "2").Expect(http.StatusOK).
+		Len("books", 0).
+		JSON("metadata", map[string]any{})
+	s.Get("/v1/books").Query("page", "abc").Expect(http.StatusUnprocessableEntity).Has("error.page")
+	s.Get("/v1/books").Query("page", "0").Expect(http.StatusUnprocessableEntity).Has("error.page")
+	s.Get("/v1/books").Query("size", "21").Expect(http.StatusUnprocessableEntity).Has("error.size")
+}
+
+func testFilterByTitle(t *testing.T, s *Server) {
+	seed(s)
+	s.Get("/v1/books").Query("title", "the hobbit").Expect(http.StatusOK).
+		Len("books", 1).
+		JSON("books.0.id", 3)
+	s.Get("/v1/books").Query("title", "hobbit").Expect(http.StatusOK).Len("books", 0)
+}
+
+func testFilterByGenre(t *testing.T, s *Server) {
+	seed(s)
+	s.Get("/v1/books").Query("genres", "adventure").Expect(http.StatusOK).
+		Len("books", 2).
+		JSON("books.0.id", 1).
+		JSON("books.1.id", 3)
+	s.Get("/v1/books").Query("genres", "sci-fi,adventure").Expect(http.StatusOK).
+		Len("books", 1).
+		JSON("books.0.id", 1)
+	s.Get("/v1/books").Query("genres", "romance").Expect(http.StatusOK).Len("books", 0)
+}
+
+func testSearchBooks(t *testing.T, s *Server) {
+	seed(s)
+	s.Get("/v1/books/search").Query("q", "dune").Expect(http.StatusOK).
+		Len("books", 2).
+		JSON("books.0.id", 1).
+		JSON("books.1.id", 2)
+	s.Get("/v1/books/search").Query("q", "children dune").Expect(http.StatusOK).
+		Len("books", 1).
+		JSON("books.0.title", "Children Of Dune")
+	s.Get("/v1/books/search").Query("q", "silmarillion").Expect(http.StatusOK).Len("books", 0)
+}
+
+func testSortBooks(t *testing.T, s *Server) {
+	seed(s)
+	s.Get("/v1/books").Query("sort", "-published").Expect(http.StatusOK).
+		JSON("books.0.id", 2).
+		JSON("books.1.id", 1).
+		JSON("books.2.id", 3)
+	s.Get("/v1/books").Query("sort", "title").Expect(http.StatusOK).
+		JSON("books.0.title", "Children Of Dune").
+		JSON("books.2.title", "The Hobbit")
+	s.Get("/v1/books").Query("sort", "isbn").Expect(http.StatusUnprocessableEntity).Has("error.sort")
+}
+
+func testDeleteBook(t *testing.T, s *Server) {
+	seed(s)
+	resp := s.Delete("/v1/books/2").Expect(http.StatusNoContent)
+	if len(resp.Body) != 0 {
+		t.Errorf("DELETE /v1/books/2: body %s, want none", resp.Body)
+	}
+	s.Get("/v1/books/2").Expect(http.StatusNotFound)
+	s.Delete("/v1/books/2").Expect(http.StatusNotFound)
+	s.Delete("/v1/books/abc").Expect(http.StatusNotFound)
+	s.Get("/v1/books").Expect(http.StatusOK).Len("books", 2)
+}
+
+func testUnknownRoutes(t *testing.T, s *Server) {
+	s.Get("/v1/authors").Expect(http.StatusNotFound).JSON("error", "the requested resource could not be found")
+	s.Request(http.MethodPut, "/v1/books/1").Expect(http.StatusMethodNotAllowed).
+		JSON("error", "the PUT method is not supported for this resource")
+}
+
+func testBadFilters(t *testing.T, s *Server) {
+	seed(s)
+	s.Get("/v1/books").Query("size", "0").Expect(http.StatusUnprocessableEntity).
+		JSON("error.size", "value must be greater than: 1")
+	s.Get("/v1/books").Query("page", "10000001").Expect(http.StatusUnprocessableEntity).
+		JSON("error.page", "value must be less than: 1000")
+	s.Get("/v1/books").Query("page", "0").Query("size", "50").Expect(http.StatusUnprocessableEntity).
+		Has("error.page").
+		Has("error.size")
+	s.Get("/v1/books").Query("sort", "TITLE").Expect(http.StatusUnprocessableEntity).Has("error.sort")
+	s.Get("/v1/books").Query("fields", "isbn").Expect(http.StatusUnprocessableEntity).
+		JSON("error.fields", `unknown field "isbn", must be one of: id, title, authors, publisher, published, pages, genres, version`)
+	s.Get("/v1/books").Query("include", "loans").Expect(http.StatusUnprocessableEntity).
+		JSON("error.include", `unknown relation "loans", must be one of: authors, copies`)
+	s.Get("/v1/books").Query("compact", "maybe").Expect(http.StatusBadRequest).
+		JSON("error", "compact must be a boolean")
+	s.Get("/v1/books").Query("format", "docx").Expect(http.StatusBadRequest).
+		JSON("error", `unsupported format "docx"`)
+	s.Get("/v1/books").Query("genres", "").Expect(http.StatusOK).Len("books", 3)
+}
+
+func testContentNegotiation(t *testing.T, s *Server) {
+	seed(s)
+	s.Get("/v1/books").Header("Accept", "image/png").Expect(http.StatusNotAcceptable).
+		JSON("error", "the requested representation is not available, supported media types: application/json, application/xml, text/csv, application/msgpack, application/vnd.citationstyles.csl+json, application/x-bibtex, application/x-research-info-systems")
+	s.Get("/v1/books/1").Header("Accept", "image/png").Expect(http.StatusNotAcceptable).
+		JSON("error", "the requested representation is not available, supported media types: application/json, application/xml, text/csv, application/msgpack, application/vnd.citationstyles.csl+json, application/x-bibtex, application/x-research-info-systems")
+	// Writes offer no citation formats.
+	s.Post("/v1/books", books[0]).Header("Accept", "application/x-bibtex").Expect(http.StatusNotAcceptable).
+		JSON("error", "the requested representation is not available, supported media types: application/json, application/xml, text/csv, application/msgpack")
+	s.Delete("/v1/books/1").Header("Accept", "image/png").Expect(http.StatusNotAcceptable)
+	s.Get("/v1/books/1").Expect(http.StatusAccepted)
+
+	s.Get("/v1/books").Header("Accept", "application/xml").Expect(http.StatusOK).
+		HasHeader("Content-Type", "application/xml")
+	s.Get("/v1/books").Header("Accept", "text/csv").Expect(http.StatusOK).
+		HasHeader("Content-Type", "text/csv; charset=utf-8")
+	s.Get("/v1/books/1").Header("Accept", "application/x-research-info-systems").Expect(http.StatusOK).
+		HasHeader("Content-Type", "application/x-research-info-systems; charset=utf-8")
+	s.Get("/v1/books").Query("format", "bibtex").Expect(http.StatusOK).
+		HasHeader("Content-Type", "application/x-bibtex; charset=utf-8")
+	s.Post("/v1/books", map[string]any{}).Header("Accept", "text/csv").Expect(http.StatusUnprocessableEntity).
+		HasHeader("Content-Type", "text/csv; charset=utf-8")
+}
+
+func testFieldsets(t *testing.T, s *Server) {
+	seed(s)
+	s.Get("/v1/books/1").Query("fields", "title,pages").Expect(http.StatusAccepted).
+		JSON("book", map[string]any{"title": "Dune", "pages": "412"})
+	s.Get("/v1/books").Query("fields", "id").Query("include", "authors").Expect(http.StatusOK).
+		JSON("books.2", map[string]any{"id": 3, "authors": []string{"J. R. R. Tolkien"}})
+	// Books without copies embed an empty list.
+	s.Get("/v1/books").Query("fields", "id").Query("include", "copies").Expect(http.StatusOK).
+		JSON("books.0", map[string]any{"id": 1, "copies": []any{}})
+}
+
+func testSearchFormats(t *testing.T, s *Server) {
+	seed(s)
+	s.Get("/v1/books/search").Query("q", "dune").Query("fields", "title").Expect(http.StatusOK).
+		JSON("books", []map[string]any{{"title": "Dune"}, {"title": "Children Of Dune"}})
+	s.Get("/v1/books/search").Query("q", "dune").Query("fields", "isbn").Expect(http.StatusUnprocessableEntity).
+		Has("error.fields")
+	s.Get("/v1/books/search").Query("q", "hobbit").Query("format", "csl-json").Expect(http.StatusOK).
+		HasHeader("Content-Type", "application/vnd.citationstyles.csl+json; charset=utf-8").
+		JSON("0.title", "The Hobbit")
+	// Without a query every book matches.
+	s.Get("/v1/books/search").Expect(http.StatusOK).Len("books", 3)
+}
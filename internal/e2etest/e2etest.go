@@ -0,0 +1,357 @@
+// Package e2etest runs the API end to end: every test gets an empty database schema migrated
+// from migrations/, and the handler under test is served over HTTP. The handler is built by
+// the caller, since the application lives in package main:
+//
+//	func TestAPI(t *testing.T) {
+//		e2etest.Run(t, func(t *testing.T, db *pgxpool.Pool, logger *slog.Logger) http.Handler {
+//			return newApplication(config{}, logger, db).routes()
+//		})
+//	}
+//
+// Tests are skipped unless TEST_DATABASE_URL names a database the harness may create schemas
+// in.
+package e2etest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/themilar/plibrary/internal/migrate"
+	"github.com/themilar/plibrary/migrations"
+)
+
+// DatabaseURLEnv names the variable holding the DSN of the database schemas are created in.
+const DatabaseURLEnv = "TEST_DATABASE_URL"
+
+// NewHandler builds the handler under test on db, logging to logger.
+type NewHandler func(t *testing.T, db *pgxpool.Pool, logger *slog.Logger) http.Handler
+
+// NewDatabase creates a schema of its own for t, migrated to the latest version, and returns
+// a pool whose connections use it. The schema is dropped once t completes. t is skipped when
+// TEST_DATABASE_URL is unset.
+func NewDatabase(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv(DatabaseURLEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", DatabaseURLEnv)
+	}
+	ctx := context.Background()
+	b := make([]byte, 8)
+	rand.Read(b)
+	schema := "e2e_" + hex.EncodeToString(b)
+
+	admin, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close(ctx)
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn, err := pgx.Connect(context.Background(), dsn)
+		if err != nil {
+			t.Errorf("drop schema %s: %v", schema, err)
+			return
+		}
+		defer conn.Close(context.Background())
+		if _, err := conn.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Errorf("drop schema %s: %v", schema, err)
+		}
+	})
+
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+	db, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cleanups run last in first, so the pool is closed before the schema is dropped.
+	t.Cleanup(db.Close)
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx, 0); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// testWriter writes every line logged to the log of a test.
+type testWriter struct{ t *testing.T }
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Helper()
+	w.t.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// NewLogger returns a text logger writing to the log of t at debug level, so that the logs of
+// a failing test are shown with it.
+func NewLogger(t *testing.T) *slog.Logger {
+	return slog.New(slog.NewTextHandler(testWriter{t}, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// Server is the handler under test served over HTTP.
+type Server struct {
+	t   *testing.T
+	URL string
+}
+
+// NewServer serves handler until t completes.
+func NewServer(t *testing.T, handler http.Handler) *Server {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return &Server{t: t, URL: srv.URL}
+}
+
+// Start serves the handler built by newHandler on a new database.
+func Start(t *testing.T, newHandler NewHandler) *Server {
+	t.Helper()
+	db := NewDatabase(t)
+	return NewServer(t, newHandler(t, db, NewLogger(t)))
+}
+
+// Request is a request being built. Bodies that are strings or byte slices are sent as
+// they are, anything else is encoded as JSON.
+type Request struct {
+	t       *testing.T
+	method  string
+	url     string
+	query   url.Values
+	header  http.Header
+	body    any
+	hasBody bool
+}
+
+func (s *Server) Request(method, path string) *Request {
+	return &Request{t: s.t, method: method, url: s.URL + path, query: url.Values{}, header: http.Header{}}
+}
+
+func (s *Server) Get(path string) *Request {
+	return s.Request(http.MethodGet, path)
+}
+
+func (s *Server) Post(path string, body any) *Request {
+	return s.Request(http.MethodPost, path).Body(body)
+}
+
+func (s *Server) Patch(path string, body any) *Request {
+	return s.Request(http.MethodPatch, path).Body(body)
+}
+
+func (s *Server) Delete(path string) *Request {
+	return s.Request(http.MethodDelete, path)
+}
+
+func (r *Request) Header(key, value string) *Request {
+	r.header.Set(key, value)
+	return r
+}
+
+func (r *Request) Query(key, value string) *Request {
+	r.query.Add(key, value)
+	return r
+}
+
+func (r *Request) Body(body any) *Request {
+	r.body, r.hasBody = body, true
+	return r
+}
+
+// Send sends the request, failing the test when no response comes back.
+func (r *Request) Send() *Response {
+	r.t.Helper()
+	var body io.Reader
+	switch b := r.body.(type) {
+	case string:
+		body = strings.NewReader(b)
+	case []byte:
+		body = bytes.NewReader(b)
+	default:
+		if r.hasBody {
+			encoded, err := json.Marshal(b)
+			if err != nil {
+				r.t.Fatal(err)
+			}
+			body = bytes.NewReader(encoded)
+			if r.header.Get("Content-Type") == "" {
+				r.header.Set("Content-Type", "application/json")
+			}
+		}
+	}
+	u := r.url
+	if len(r.query) > 0 {
+		u += "?" + r.query.Encode()
+	}
+	req, err := http.NewRequest(r.method, u, body)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	req.Header = r.header
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		r.t.Fatalf("%s %s: %v", r.method, u, err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		r.t.Fatalf("%s %s: %v", r.method, u, err)
+	}
+	return &Response{t: r.t, name: r.method + " " + u, Response: resp, Body: b}
+}
+
+// Expect sends the request and checks the status of the response.
+func (r *Request) Expect(status int) *Response {
+	r.t.Helper()
+	return r.Send().Status(status)
+}
+
+// Response is a response with its body read. Its assertions report failures with Errorf so
+// that they can be chained; paths name values of the JSON body by their keys and indexes
+// separated by dots, as in "books.0.title", the empty path being the whole body.
+type Response struct {
+	*http.Response
+	t    *testing.T
+	name string
+	Body []byte
+}
+
+func (r *Response) Status(status int) *Response {
+	r.t.Helper()
+	if r.StatusCode != status {
+		r.t.Errorf("%s: status %d, want %d; body %s", r.name, r.StatusCode, status, r.Body)
+	}
+	return r
+}
+
+func (r *Response) HasHeader(key, want string) *Response {
+	r.t.Helper()
+	if got := r.Header.Get(key); got != want {
+		r.t.Errorf("%s: header %s = %q, want %q", r.name, key, got, want)
+	}
+	return r
+}
+
+// Decode decodes the JSON body into v, failing the test when it cannot.
+func (r *Response) Decode(v any) *Response {
+	r.t.Helper()
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		r.t.Fatalf("%s: decode body %s: %v", r.name, r.Body, err)
+	}
+	return r
+}
+
+// Value returns the value at path as decoded by encoding/json into an any, failing the test
+// when there is none.
+func (r *Response) Value(path string) any {
+	r.t.Helper()
+	value, err := r.lookup(path)
+	if err != nil {
+		r.t.Fatalf("%s: %v; body %s", r.name, err, r.Body)
+	}
+	return value
+}
+
+func (r *Response) lookup(path string) (any, error) {
+	var value any
+	if err := json.Unmarshal(r.Body, &value); err != nil {
+		return nil, fmt.Errorf("body is not JSON: %w", err)
+	}
+	if path == "" {
+		return value, nil
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]any:
+			var ok bool
+			if value, ok = v[key]; !ok {
+				return nil, fmt.Errorf("no %s in %s", key, path)
+			}
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("no element %s of %s", key, path)
+			}
+			value = v[i]
+		default:
+			return nil, fmt.Errorf("%s of %s is not an object or array", key, path)
+		}
+	}
+	return value, nil
+}
+
+// JSON checks that the value at path is want once encoded and decoded as JSON, so that
+// numbers, structs and maps compare with what was decoded.
+func (r *Response) JSON(path string, want any) *Response {
+	r.t.Helper()
+	got, err := r.lookup(path)
+	if err != nil {
+		r.t.Errorf("%s: %v; body %s", r.name, err, r.Body)
+		return r
+	}
+	encoded, err := json.Marshal(want)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	var normalized any
+	json.Unmarshal(encoded, &normalized)
+	if !reflect.DeepEqual(got, normalized) {
+		gotJSON, _ := json.Marshal(got)
+		r.t.Errorf("%s: %s = %s, want %s", r.name, path, gotJSON, encoded)
+	}
+	return r
+}
+
+// Has checks that there is a value at path.
+func (r *Response) Has(path string) *Response {
+	r.t.Helper()
+	if _, err := r.lookup(path); err != nil {
+		r.t.Errorf("%s: %v; body %s", r.name, err, r.Body)
+	}
+	return r
+}
+
+// Missing checks that there is no value at path.
+func (r *Response) Missing(path string) *Response {
+	r.t.Helper()
+	if value, err := r.lookup(path); err == nil {
+		r.t.Errorf("%s: unexpected %s = %v", r.name, path, value)
+	}
+	return r
+}
+
+// Len checks that the value at path is an array or object of n elements.
+func (r *Response) Len(path string, n int) *Response {
+	r.t.Helper()
+	value, err := r.lookup(path)
+	if err != nil {
+		r.t.Errorf("%s: %v; body %s", r.name, err, r.Body)
+		return r
+	}
+	if got := reflect.ValueOf(value); (got.Kind() != reflect.Slice && got.Kind() != reflect.Map) || got.Len() != n {
+		r.t.Errorf("%s: %s = %v, want %d elements", r.name, path, value, n)
+	}
+	return r
+}
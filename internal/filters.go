@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"maps"
 	"math"
-	"reflect"
 	"strings"
 
 	"slices"
@@ -13,10 +12,14 @@ import (
 	"github.com/go-playground/validator/v10"
 )
 
+// SortValues lists the accepted values of Filters.Sort: a column, descending when prefixed
+// with "-". It is the safelist that keeps Sort out of SQL injection reach.
+var SortValues = []string{"id", "title", "published", "pages", "-id", "-title", "-published", "-pages"}
+
 type Filters struct {
-	Page int    `validate:"max=1000,min=1"`
-	Size int    `validate:"max=20,min=1"`
-	Sort string `validate:"oneofci=id title published pages -title -published -pages"`
+	Page int `validate:"max=1000,min=1"`
+	Size int `validate:"max=20,min=1"`
+	Sort string
 }
 type FilterValidationErrors struct {
 	Errors map[string]string
@@ -34,10 +37,11 @@ func CalculateMetadata(totalRecords, page, pageSize int) *PaginationMetadata {
 		return &PaginationMetadata{}
 	}
 	return &PaginationMetadata{
-		CurrentPage: page,
-		PageSize:    pageSize,
-		FirstPage:   1,
-		LastPage:    int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		TotalRecords: totalRecords,
 	}
 }
 func (fve *FilterValidationErrors) AddError(key, message string) {
@@ -46,15 +50,7 @@ func (fve *FilterValidationErrors) AddError(key, message string) {
 	}
 }
 func (f Filters) SortColumn() string {
-	sortField, ok := reflect.TypeOf(f).FieldByName("Sort")
-	validateTag := sortField.Tag.Get("validate")
-	vtSlice := strings.Split(validateTag, "=")
-	safeSortValues := vtSlice[1]
-	if !ok {
-		panic("that field does not exist")
-	}
-
-	if slices.Contains(strings.Split(safeSortValues, " "), f.Sort) {
+	if slices.Contains(SortValues, f.Sort) {
 		return strings.TrimPrefix(f.Sort, "-")
 	}
 	panic("unsafe sort param: " + f.Sort)
@@ -77,19 +73,19 @@ func ValidateFilters(f Filters, fte map[string]string) map[string]string {
 	var fve = FilterValidationErrors{
 		Errors: make(map[string]string),
 	}
+	if !slices.Contains(SortValues, f.Sort) {
+		fve.AddError("sort", fmt.Sprintf("can only contain values: %v", strings.Join(SortValues, " ")))
+	}
 	err := v.Struct(f)
 	if err != nil {
 		var validateErrs validator.ValidationErrors
 		if errors.As(err, &validateErrs) {
 			for _, e := range validateErrs {
-				fmt.Println(e.Tag(), e.Param(), e.Field(), e.Value())
 				switch {
 				case e.Tag() == "max":
 					fve.AddError(strings.ToLower(e.Field()), fmt.Sprintf("value must be less than: %v", e.Param()))
 				case e.Tag() == "min":
 					fve.AddError(strings.ToLower(e.Field()), fmt.Sprintf("value must be greater than: %v", e.Param()))
-				case e.Tag() == "oneofci":
-					fve.AddError(strings.ToLower(e.Field()), fmt.Sprintf("can only contain values: %v", e.Param()))
 				}
 			}
 			maps.Copy(fve.Errors, fte)
@@ -98,6 +94,10 @@ func ValidateFilters(f Filters, fte map[string]string) map[string]string {
 		// clear(Fve)
 		return nil
 	}
+	if len(fve.Errors) > 0 {
+		maps.Copy(fve.Errors, fte)
+		return fve.Errors
+	}
 	return nil
 }
 
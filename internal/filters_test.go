@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestValidateFilters(t *testing.T) {
+	sortError := "can only contain values: id title published pages -id -title -published -pages"
+	for _, test := range []struct {
+		filters Filters
+		want    map[string]string
+	}{
+		{Filters{Page: 1, Size: 12, Sort: "id"}, nil},
+		{Filters{Page: 1000, Size: 20, Sort: "-published"}, nil},
+		{Filters{Page: 0, Size: 12, Sort: "id"}, map[string]string{"page": "value must be greater than: 1"}},
+		{Filters{Page: 1, Size: 21, Sort: "id"}, map[string]string{"size": "value must be less than: 20"}},
+		{Filters{Page: 1, Size: 12, Sort: "isbn"}, map[string]string{"sort": sortError}},
+		// Sort is interpolated into SQL, so only the exact values are accepted.
+		{Filters{Page: 1, Size: 12, Sort: "TITLE"}, map[string]string{"sort": sortError}},
+		{Filters{Page: 1, Size: 12, Sort: "title; DROP TABLE books"}, map[string]string{"sort": sortError}},
+		{Filters{Page: 1001, Size: 0, Sort: ""}, map[string]string{"page": "value must be less than: 1000", "size": "value must be greater than: 1", "sort": sortError}},
+	} {
+		if got := ValidateFilters(test.filters, nil); !maps.Equal(got, test.want) {
+			t.Errorf("ValidateFilters(%+v) = %v, want %v", test.filters, got, test.want)
+		}
+	}
+
+	// Errors from parsing the parameters replace those of validation.
+	got := ValidateFilters(Filters{Page: 0, Size: 12, Sort: "id"}, map[string]string{"page": "must be an integer value"})
+	if want := map[string]string{"page": "must be an integer value"}; !maps.Equal(got, want) {
+		t.Errorf("ValidateFilters with type errors = %v, want %v", got, want)
+	}
+}
+
+func TestSortColumn(t *testing.T) {
+	for _, sort := range SortValues {
+		f := Filters{Sort: sort}
+		column, direction := f.SortColumn(), f.SortDirection()
+		if "-"+column != sort && column != sort || (direction == "DESC") != (sort[0] == '-') {
+			t.Errorf("sort %q: column %q %s", sort, column, direction)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("SortColumn of an unsafe sort did not panic")
+		}
+	}()
+	Filters{Sort: "title desc"}.SortColumn()
+}
@@ -0,0 +1,59 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/themilar/plibrary/internal/logging"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "warn", "JSON")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("hidden")
+	logger.Warn("shown", "book_id", 7)
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("log output %q is not a single JSON object: %v", buf.String(), err)
+	}
+	if entry["level"] != "WARN" || entry["msg"] != "shown" || entry["book_id"] != 7.0 {
+		t.Errorf("logged %v", entry)
+	}
+
+	buf.Reset()
+	logger, err = logging.New(&buf, "debug", "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("query finished", "query", "BookModel.Get")
+	if !strings.Contains(buf.String(), `level=DEBUG msg="query finished" query=BookModel.Get`) {
+		t.Errorf("logged %q", buf.String())
+	}
+
+	for _, test := range []struct{ level, format, err string }{
+		{"loud", "json", `invalid log level "loud"`},
+		{"info", "xml", `invalid log format "xml"`},
+	} {
+		if _, err := logging.New(&buf, test.level, test.format); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("New(%q, %q): error %v, want %q", test.level, test.format, err, test.err)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	if logging.FromContext(context.Background()) != slog.Default() {
+		t.Error("FromContext without a logger does not return the default logger")
+	}
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)).With("request_id", "abc")
+	ctx := logging.WithLogger(context.Background(), logger)
+	if logging.FromContext(ctx) != logger {
+		t.Error("FromContext does not return the logger of WithLogger")
+	}
+}
@@ -0,0 +1,288 @@
+// Package migrate applies the SQL migrations embedded in the binary. It keeps its state in
+// the schema_migrations table used by golang-migrate, so databases migrated with the migrate
+// tool can be managed by either.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// lockID is the key of the advisory lock held while migrating, so that two instances
+// never migrate at the same time.
+const lockID = 7_243_190_512
+
+var (
+	ErrDirty        = errors.New("migrate: database is dirty, fix it and force a version")
+	ErrSchemaBehind = errors.New("migrate: database schema is behind")
+	ErrSchemaAhead  = errors.New("migrate: database schema is newer than the binary")
+	ErrNoChange     = errors.New("migrate: no change")
+	ErrNotMigrated  = errors.New("migrate: database has never been migrated")
+)
+
+var filenamePattern = regexp.MustCompile(`^([0-9]+)_(.*)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Load reads the migrations in the root of fsys, ordered by version.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		m := filenamePattern.FindStringSubmatch(entry.Name())
+		if m == nil || entry.IsDir() {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: %s: %w", entry.Name(), err)
+		}
+		sql, err := fs.ReadFile(fsys, path.Join(".", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		} else if migration.Name != m[2] {
+			return nil, fmt.Errorf("migrate: version %d is used by %q and %q", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(sql)
+		} else {
+			migration.Down = string(sql)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migrate: version %d has no up migration", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+type Migrator struct {
+	DB         *pgxpool.Pool
+	Migrations []Migration
+}
+
+func New(db *pgxpool.Pool, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// Latest returns the version of the newest migration, or 0 when there are none.
+func (m *Migrator) Latest() int64 {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+func ensureTable(ctx context.Context, conn *pgxpool.Conn) error {
+	_, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)`)
+	return err
+}
+
+func readVersion(ctx context.Context, q interface {
+	QueryRow(context.Context, string, ...any) pgx.Row
+}) (int64, bool, error) {
+	var version int64
+	var dirty bool
+	err := q.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// Version returns the current schema version, 0 when no migration has been applied.
+func (m *Migrator) Version(ctx context.Context) (version int64, dirty bool, err error) {
+	conn, err := m.DB.Acquire(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Release()
+	if err := ensureTable(ctx, conn); err != nil {
+		return 0, false, err
+	}
+	return readVersion(ctx, conn)
+}
+
+// ReadVersion returns the current schema version like Version, but only reads: it fails with
+// ErrNotMigrated when the database has no schema_migrations table rather than creating it.
+func (m *Migrator) ReadVersion(ctx context.Context) (version int64, dirty bool, err error) {
+	var exists bool
+	if err := m.DB.QueryRow(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return 0, false, err
+	}
+	if !exists {
+		return 0, false, ErrNotMigrated
+	}
+	return readVersion(ctx, m.DB)
+}
+
+// withLock runs fn on a connection holding the migration lock.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.DB.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+	if err := ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// apply runs sql and records version in one transaction, so a failed migration leaves the
+// schema as it was.
+func apply(ctx context.Context, conn *pgxpool.Conn, sql string, version int64) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return err
+	}
+	if version > 0 {
+		if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, version); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// Up applies up to n pending migrations, all of them when n is not positive. It returns the
+// migrations applied.
+func (m *Migrator) Up(ctx context.Context, n int) ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		current, dirty, err := readVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+		for _, migration := range m.Migrations {
+			if migration.Version <= current {
+				continue
+			}
+			if n > 0 && len(applied) == n {
+				break
+			}
+			if err := apply(ctx, conn, migration.Up, migration.Version); err != nil {
+				return fmt.Errorf("migrate: %d_%s up: %w", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, migration)
+		}
+		if len(applied) == 0 {
+			return ErrNoChange
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the last n applied migrations, all of them when n is not positive. It returns
+// the migrations reverted.
+func (m *Migrator) Down(ctx context.Context, n int) ([]Migration, error) {
+	var reverted []Migration
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		current, dirty, err := readVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+		for i := len(m.Migrations) - 1; i >= 0; i-- {
+			migration := m.Migrations[i]
+			if migration.Version > current {
+				continue
+			}
+			if n > 0 && len(reverted) == n {
+				break
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migrate: %d_%s has no down migration", migration.Version, migration.Name)
+			}
+			var previous int64
+			if i > 0 {
+				previous = m.Migrations[i-1].Version
+			}
+			if err := apply(ctx, conn, migration.Down, previous); err != nil {
+				return fmt.Errorf("migrate: %d_%s down: %w", migration.Version, migration.Name, err)
+			}
+			reverted = append(reverted, migration)
+		}
+		if len(reverted) == 0 {
+			return ErrNoChange
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Force records version as the current clean version without running any migration, to
+// recover from a dirty database. A version of 0 records that nothing is applied.
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	return m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		return apply(ctx, conn, `SELECT 1`, version)
+	})
+}
+
+// Check returns nil when the database is clean and at the latest version. It only reads.
+func (m *Migrator) Check(ctx context.Context) error {
+	return m.CheckVersion(m.ReadVersion(ctx))
+}
+
+// CheckVersion is Check for the results of a call to ReadVersion.
+func (m *Migrator) CheckVersion(version int64, dirty bool, err error) error {
+	switch {
+	case errors.Is(err, ErrNotMigrated) && m.Latest() == 0:
+		return nil
+	case err != nil:
+		return err
+	case dirty:
+		return fmt.Errorf("%w (version %d)", ErrDirty, version)
+	case version < m.Latest():
+		return fmt.Errorf("%w: at version %d, expected %d", ErrSchemaBehind, version, m.Latest())
+	case version > m.Latest():
+		return fmt.Errorf("%w: at version %d, expected %d", ErrSchemaAhead, version, m.Latest())
+	}
+	return nil
+}
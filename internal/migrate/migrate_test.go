@@ -0,0 +1,205 @@
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/themilar/plibrary/internal/e2etest"
+	"github.com/themilar/plibrary/internal/migrate"
+	"github.com/themilar/plibrary/migrations"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"000010_add_index.up.sql":      {Data: []byte("CREATE INDEX")},
+		"000002_create_table.up.sql":   {Data: []byte("CREATE TABLE")},
+		"000002_create_table.down.sql": {Data: []byte("DROP TABLE")},
+		"README.md":                    {Data: []byte("not a migration")},
+		"000003_nested.up.sql/x":       {Data: []byte("a directory")},
+	}
+	loaded, err := migrate.Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range loaded {
+		got = append(got, fmt.Sprintf("%d %s %q %q", m.Version, m.Name, m.Up, m.Down))
+	}
+	want := []string{`2 create_table "CREATE TABLE" "DROP TABLE"`, `10 add_index "CREATE INDEX" ""`}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Load =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	for _, test := range []struct {
+		fsys fstest.MapFS
+		err  string
+	}{
+		{fstest.MapFS{"000001_a.up.sql": {}, "000001_b.down.sql": {}}, `version 1 is used by`},
+		{fstest.MapFS{"000001_a.down.sql": {Data: []byte("DROP TABLE")}}, "version 1 has no up migration"},
+		{fstest.MapFS{"99999999999999999999_a.up.sql": {Data: []byte("SELECT 1")}}, "value out of range"},
+	} {
+		if _, err := migrate.Load(test.fsys); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Load(%v): error %v, want %q", test.fsys, err, test.err)
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	loaded, err := migrate.Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range loaded {
+		if m.Version != int64(i+1) {
+			t.Errorf("migration %s has version %d, want %d", m.Name, m.Version, i+1)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %d_%s has no down migration", m.Version, m.Name)
+		}
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	m := &migrate.Migrator{Migrations: []migrate.Migration{{Version: 1}, {Version: 2}}}
+	empty := &migrate.Migrator{}
+	failure := errors.New("connection refused")
+
+	for _, test := range []struct {
+		name     string
+		migrator *migrate.Migrator
+		version  int64
+		dirty    bool
+		err      error
+		want     error
+	}{
+		{"current", m, 2, false, nil, nil},
+		{"behind", m, 1, false, nil, migrate.ErrSchemaBehind},
+		{"ahead", m, 3, false, nil, migrate.ErrSchemaAhead},
+		{"dirty", m, 2, true, nil, migrate.ErrDirty},
+		{"not migrated", m, 0, false, migrate.ErrNotMigrated, migrate.ErrNotMigrated},
+		{"nothing to migrate", empty, 0, false, migrate.ErrNotMigrated, nil},
+		{"failure", m, 0, false, failure, failure},
+	} {
+		err := test.migrator.CheckVersion(test.version, test.dirty, test.err)
+		if !errors.Is(err, test.want) || (test.want == nil) != (err == nil) {
+			t.Errorf("%s: CheckVersion = %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+// versions formats the versions of migrations.
+func versions(ms []migrate.Migration) string {
+	var s []string
+	for _, m := range ms {
+		s = append(s, fmt.Sprint(m.Version))
+	}
+	return strings.Join(s, " ")
+}
+
+func TestMigrator(t *testing.T) {
+	db := e2etest.NewDatabase(t)
+	ctx := context.Background()
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := m.Latest()
+	if err := m.Check(ctx); err != nil {
+		t.Fatalf("Check on a migrated database: %v", err)
+	}
+	if _, err := m.Up(ctx, 0); !errors.Is(err, migrate.ErrNoChange) {
+		t.Errorf("Up on a migrated database: %v, want ErrNoChange", err)
+	}
+
+	reverted, err := m.Down(ctx, 2)
+	if err != nil || versions(reverted) != fmt.Sprintf("%d %d", latest, latest-1) {
+		t.Fatalf("Down(2) = %s, %v", versions(reverted), err)
+	}
+	if err := m.Check(ctx); !errors.Is(err, migrate.ErrSchemaBehind) {
+		t.Errorf("Check after Down: %v, want ErrSchemaBehind", err)
+	}
+	applied, err := m.Up(ctx, 1)
+	if err != nil || versions(applied) != fmt.Sprint(latest-1) {
+		t.Errorf("Up(1) = %s, %v", versions(applied), err)
+	}
+	if _, err := m.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Down(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if version, dirty, err := m.Version(ctx); version != 0 || dirty || err != nil {
+		t.Errorf("Version after reverting everything = %d, %t, %v", version, dirty, err)
+	}
+	if _, err := m.Down(ctx, 0); !errors.Is(err, migrate.ErrNoChange) {
+		t.Errorf("Down with nothing applied: %v, want ErrNoChange", err)
+	}
+
+	// ReadVersion and Check never create the schema_migrations table.
+	if _, err := db.Exec(ctx, `DROP TABLE schema_migrations`); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.ReadVersion(ctx); !errors.Is(err, migrate.ErrNotMigrated) {
+		t.Errorf("ReadVersion without schema_migrations: %v, want ErrNotMigrated", err)
+	}
+	if err := m.Check(ctx); !errors.Is(err, migrate.ErrNotMigrated) {
+		t.Errorf("Check without schema_migrations: %v, want ErrNotMigrated", err)
+	}
+	var exists bool
+	if err := db.QueryRow(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil || exists {
+		t.Errorf("schema_migrations exists %t after ReadVersion and Check, %v", exists, err)
+	}
+
+	if _, err := m.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, `UPDATE schema_migrations SET dirty = true`); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Check(ctx); !errors.Is(err, migrate.ErrDirty) {
+		t.Errorf("Check on a dirty database: %v, want ErrDirty", err)
+	}
+	if _, err := m.Up(ctx, 0); !errors.Is(err, migrate.ErrDirty) {
+		t.Errorf("Up on a dirty database: %v, want ErrDirty", err)
+	}
+	if err := m.Force(ctx, latest); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Check(ctx); err != nil {
+		t.Errorf("Check after Force: %v", err)
+	}
+}
+
+func TestMigratorFailedMigration(t *testing.T) {
+	db := e2etest.NewDatabase(t)
+	ctx := context.Background()
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := m.Latest()
+	m.Migrations = append(m.Migrations, migrate.Migration{
+		Version: latest + 1,
+		Name:    "broken",
+		Up:      `CREATE TABLE half_done (id bigint); SELECT no_such_column FROM half_done`,
+	})
+
+	_, err = m.Up(ctx, 0)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("%d_broken up", latest+1)) {
+		t.Fatalf("Up with a broken migration: %v", err)
+	}
+	// The migration ran in a transaction, so nothing of it is left behind.
+	version, dirty, err := m.Version(ctx)
+	if version != latest || dirty || err != nil {
+		t.Errorf("Version after a failed migration = %d, %t, %v; want %d", version, dirty, err, latest)
+	}
+	var exists bool
+	if err := db.QueryRow(ctx, `SELECT to_regclass('half_done') IS NOT NULL`).Scan(&exists); err != nil || exists {
+		t.Errorf("table of the failed migration exists: %t, %v", exists, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,8 +24,15 @@ type Book struct {
 	// The title of the book
 	// required: true
 	// example: Black Panther
-	Title     string    `json:"title" validate:"required,max=56"`
+	Title string `json:"title" validate:"required,max=56"`
+	// The authors of the book
+	// example: ["Frank Herbert"]
+	Authors []string `json:"authors,omitempty" validate:"unique,lt=11,dive,required,max=100"`
+	// The publisher of the book
+	// example: Chilton Books
+	Publisher string    `json:"publisher,omitempty" validate:"max=100"`
 	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
 	// The year the book was published
 	// required: true
 	// example: 2018
@@ -41,33 +49,107 @@ type Book struct {
 }
 type BookModel struct {
 	DB *pgxpool.Pool
+	// QueryTimeout limits how long each query may run, when it is positive.
+	QueryTimeout time.Duration
+}
+
+// BookFields are the book fields that can be selected with ?fields=, in the order they are rendered.
+var BookFields = []string{"id", "title", "authors", "publisher", "published", "pages", "genres", "version"}
+
+var bookAllColumns = []string{"id", "created_at", "updated_at", "title", "authors", "publisher", "published", "pages", "genres", "version"}
+
+// bookColumns returns the columns to select for the given fields. The id is always selected so
+// related records can be matched to their book, and no fields selects every column.
+func bookColumns(fields []string) []string {
+	if len(fields) == 0 {
+		return bookAllColumns
+	}
+	columns := []string{"id"}
+	for _, field := range fields {
+		if field != "id" {
+			columns = append(columns, field)
+		}
+	}
+	return columns
+}
+
+func (book *Book) scanTargets(columns []string) []any {
+	targets := make([]any, len(columns))
+	for i, column := range columns {
+		switch column {
+		case "id":
+			targets[i] = &book.ID
+		case "created_at":
+			targets[i] = &book.CreatedAt
+		case "updated_at":
+			targets[i] = &book.UpdatedAt
+		case "title":
+			targets[i] = &book.Title
+		case "authors":
+			targets[i] = &book.Authors
+		case "publisher":
+			targets[i] = &book.Publisher
+		case "published":
+			targets[i] = &book.Published
+		case "pages":
+			targets[i] = &book.Pages
+		case "genres":
+			targets[i] = &book.Genres
+		case "version":
+			targets[i] = &book.Version
+		}
+	}
+	return targets
 }
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
+	// ErrCanceled is returned when a query is abandoned because its context was cancelled,
+	// by the client going away or the server shutting down.
+	ErrCanceled = errors.New("query canceled")
+	// ErrTimeout is returned when a query runs out of time.
+	ErrTimeout = errors.New("query timed out")
 )
 
 type Models struct {
-	Books BookModel
+	Books      BookRepository
+	Copies     CopyModel
+	Outbox     OutboxModel
+	Webhooks   WebhookModel
+	Deliveries DeliveryModel
 }
 
 func NewModels(db *pgxpool.Pool) Models {
 	return Models{
-		Books: BookModel{DB: db},
+		Books:      BookModel{DB: db},
+		Copies:     CopyModel{DB: db},
+		Outbox:     OutboxModel{DB: db},
+		Webhooks:   WebhookModel{DB: db},
+		Deliveries: DeliveryModel{DB: db},
 	}
 }
-func (b BookModel) All(title string, genres []string, filters internal.Filters) ([]*Book, *internal.PaginationMetadata, error) {
-	query := fmt.Sprintf(`SELECT COUNT(*) OVER(), id,created_at,title,published,pages,genres,version 
+
+// startQuery starts a query on the books table, limited to the model's query timeout.
+func (b BookModel) startQuery(ctx context.Context, name, operation, statement string) (context.Context, *query) {
+	return startQuery(ctx, b.QueryTimeout, "BookModel."+name, operation, "books", statement)
+}
+
+// All returns a page of books matching the title and genres. When fields are given only those
+// columns are selected and the remaining fields of each book are left zero.
+func (b BookModel) All(ctx context.Context, title string, genres []string, filters internal.Filters, fields ...string) ([]*Book, *internal.PaginationMetadata, error) {
+	columns := bookColumns(fields)
+	query := fmt.Sprintf(`SELECT COUNT(*) OVER(), %s
 	FROM books 
 	WHERE (LOWER(title)=LOWER($1) OR $1='')
 	AND (genres@>$2 OR $2='{}') 
 	ORDER BY %s %s, id ASC
-	LIMIT $3 OFFSET $4`, filters.SortColumn(), filters.SortDirection())
+	LIMIT $3 OFFSET $4`, strings.Join(columns, ","), filters.SortColumn(), filters.SortDirection())
+	ctx, q := b.startQuery(ctx, "All", "SELECT", query)
 	params := []any{title, genres, filters.Limit(), filters.Offset()}
-	rows, err := b.DB.Query(context.Background(), query, params...)
+	rows, err := b.DB.Query(ctx, query, params...)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, q.end(err)
 	}
 	defer rows.Close()
 
@@ -75,93 +157,261 @@ func (b BookModel) All(title string, genres []string, filters internal.Filters)
 	books := []*Book{}
 	for rows.Next() {
 		var book Book
-		err := rows.Scan(&totalRecords, &book.ID, &book.CreatedAt, &book.Title, &book.Published, &book.Pages, &book.Genres, &book.Version)
+		err := rows.Scan(append([]any{&totalRecords}, book.scanTargets(columns)...)...)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, q.end(err)
 		}
 		books = append(books, &book)
 	}
 	if err = rows.Err(); err != nil {
-		return nil, nil, err
+		return nil, nil, q.end(err)
 	}
+	q.end(nil, returnedRows.Int(len(books)))
 	metadata := internal.CalculateMetadata(totalRecords, filters.Page, filters.Size)
 	return books, metadata, nil
 }
-func (b BookModel) FullTextSearch(title string) ([]*Book, error) {
-	query := `SELECT * FROM books WHERE (to_tsvector('simple',title) @@ plainto_tsquery('simple',$1) OR $1='') ORDER BY id`
-	rows, err := b.DB.Query(context.Background(), query, title)
+func (b BookModel) FullTextSearch(ctx context.Context, title string, fields ...string) ([]*Book, error) {
+	columns := bookColumns(fields)
+	query := fmt.Sprintf(`SELECT %s FROM books WHERE (to_tsvector('simple',title) @@ plainto_tsquery('simple',$1) OR $1='') ORDER BY id`, strings.Join(columns, ","))
+	ctx, q := b.startQuery(ctx, "FullTextSearch", "SELECT", query)
+	rows, err := b.DB.Query(ctx, query, title)
 	if err != nil {
-		return nil, err
+		return nil, q.end(err)
 	}
 	defer rows.Close()
 
 	books := []*Book{}
 	for rows.Next() {
 		var book Book
-		err := rows.Scan(&book.ID, &book.CreatedAt, &book.Title, &book.Published, &book.Pages, &book.Genres, &book.Version)
+		err := rows.Scan(book.scanTargets(columns)...)
 		if err != nil {
-			return nil, err
+			return nil, q.end(err)
 		}
 		books = append(books, &book)
 	}
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, q.end(err)
+	}
+	q.end(nil, returnedRows.Int(len(books)))
+	return books, nil
+}
+
+// ByAuthors returns the books written by each of the given authors, keyed by author name,
+// fetched in a single query.
+func (b BookModel) ByAuthors(ctx context.Context, names []string) (map[string][]*Book, error) {
+	books := make(map[string][]*Book, len(names))
+	if len(names) == 0 {
+		return books, nil
+	}
+	query := fmt.Sprintf(`SELECT author, %s FROM books, unnest(authors) AS author WHERE author=ANY($1) ORDER BY author, id`, strings.Join(bookAllColumns, ","))
+	ctx, q := b.startQuery(ctx, "ByAuthors", "SELECT", query)
+	rows, err := b.DB.Query(ctx, query, names)
+	if err != nil {
+		return nil, q.end(err)
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var author string
+		var book Book
+		err := rows.Scan(append([]any{&author}, book.scanTargets(bookAllColumns)...)...)
+		if err != nil {
+			return nil, q.end(err)
+		}
+		books[author] = append(books[author], &book)
+		count++
+	}
+	if err = rows.Err(); err != nil {
+		return nil, q.end(err)
 	}
+	q.end(nil, returnedRows.Int(count))
 	return books, nil
 }
 
-func (b BookModel) Insert(book *Book) error {
-	query := `INSERT INTO books (title,published,pages,genres)
-	VALUES ($1,$2,$3,$4) RETURNING id,created_at,version`
-	params := []any{book.Title, book.Published, book.Pages, book.Genres}
-	return b.DB.QueryRow(context.Background(), query, params...).Scan(&book.ID, &book.CreatedAt, &book.Version)
+// Genre is a distinct genre in the catalogue along with the number of books tagged with it.
+type Genre struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func (b BookModel) Genres(ctx context.Context) ([]*Genre, error) {
+	query := `SELECT genre, COUNT(*) FROM books, unnest(genres) AS genre GROUP BY genre ORDER BY genre`
+	ctx, q := b.startQuery(ctx, "Genres", "SELECT", query)
+	rows, err := b.DB.Query(ctx, query)
+	if err != nil {
+		return nil, q.end(err)
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+	for rows.Next() {
+		var genre Genre
+		err := rows.Scan(&genre.Name, &genre.Count)
+		if err != nil {
+			return nil, q.end(err)
+		}
+		genres = append(genres, &genre)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, q.end(err)
+	}
+	q.end(nil, returnedRows.Int(len(genres)))
+	return genres, nil
+}
+
+// Harvest returns up to limit books whose last modification falls within [from, until] and
+// that are tagged with genre, ordered by id and starting after afterID. A nil bound or an
+// empty genre is not filtered on. The total number of matching books, ignoring afterID and
+// limit, is returned alongside so that callers can report the complete list size.
+func (b BookModel) Harvest(ctx context.Context, from, until *time.Time, genre string, afterID int64, limit int) ([]*Book, int, error) {
+	query := `SELECT total,id,created_at,updated_at,title,authors,publisher,published,pages,genres,version FROM (
+		SELECT COUNT(*) OVER() AS total, id,created_at,updated_at,title,authors,publisher,published,pages,genres,version
+		FROM books
+		WHERE ($1::timestamptz IS NULL OR updated_at>=$1)
+		AND ($2::timestamptz IS NULL OR updated_at<=$2)
+		AND ($3='' OR genres@>ARRAY[$3])
+	) matches
+	WHERE id>$4
+	ORDER BY id ASC
+	LIMIT $5`
+	ctx, q := b.startQuery(ctx, "Harvest", "SELECT", query)
+	params := []any{from, until, genre, afterID, limit}
+	rows, err := b.DB.Query(ctx, query, params...)
+	if err != nil {
+		return nil, 0, q.end(err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	books := []*Book{}
+	for rows.Next() {
+		var book Book
+		err := rows.Scan(&totalRecords, &book.ID, &book.CreatedAt, &book.UpdatedAt, &book.Title, &book.Authors, &book.Publisher, &book.Published, &book.Pages, &book.Genres, &book.Version)
+		if err != nil {
+			return nil, 0, q.end(err)
+		}
+		books = append(books, &book)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, q.end(err)
+	}
+	q.end(nil, returnedRows.Int(len(books)))
+	return books, totalRecords, nil
+}
+
+// EarliestDatestamp returns the oldest modification time in the catalogue, or the zero time
+// when there are no books.
+func (b BookModel) EarliestDatestamp(ctx context.Context) (time.Time, error) {
+	query := `SELECT MIN(updated_at) FROM books`
+	ctx, q := b.startQuery(ctx, "EarliestDatestamp", "SELECT", query)
+	var earliest *time.Time
+	err := q.end(b.DB.QueryRow(ctx, query).Scan(&earliest), returnedRows.Int(1))
+	if err != nil || earliest == nil {
+		return time.Time{}, err
+	}
+	return *earliest, nil
+}
+
+func (b BookModel) Insert(ctx context.Context, book *Book) error {
+	if book.Authors == nil {
+		book.Authors = []string{}
+	}
+	query := `INSERT INTO books (title,authors,publisher,published,pages,genres)
+	VALUES ($1,$2,$3,$4,$5,$6) RETURNING id,created_at,updated_at,version`
+	ctx, q := b.startQuery(ctx, "Insert", "INSERT", query)
+	tx, err := b.DB.Begin(ctx)
+	if err != nil {
+		return q.end(err)
+	}
+	// The context of the query is done once it ends, before the deferred rollback runs.
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	params := []any{book.Title, book.Authors, book.Publisher, book.Published, book.Pages, book.Genres}
+	err = tx.QueryRow(ctx, query, params...).Scan(&book.ID, &book.CreatedAt, &book.UpdatedAt, &book.Version)
+	if err != nil {
+		return q.end(err)
+	}
+	if err := recordEvent(ctx, tx, EventBookCreated, book.ID, map[string]any{"book": book}); err != nil {
+		return q.end(err)
+	}
+	return q.end(tx.Commit(ctx), affectedRows.Int(1))
 }
-func (b BookModel) Get(id int64) (*Book, error) {
+func (b BookModel) Get(ctx context.Context, id int64, fields ...string) (*Book, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `SELECT id,created_at,title,published,pages,genres,version FROM books WHERE id=$1`
+	columns := bookColumns(fields)
+	query := fmt.Sprintf(`SELECT %s FROM books WHERE id=$1`, strings.Join(columns, ","))
+	ctx, q := b.startQuery(ctx, "Get", "SELECT", query)
 	var book Book
-	err := b.DB.QueryRow(context.Background(), query, id).Scan(&book.ID, &book.CreatedAt, &book.Title, &book.Published, &book.Pages, &book.Genres, &book.Version)
+	err := b.DB.QueryRow(ctx, query, id).Scan(book.scanTargets(columns)...)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
+			q.end(nil, returnedRows.Int(0))
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, q.end(err)
 		}
 	}
+	q.end(nil, returnedRows.Int(1))
 	return &book, nil
 }
-func (b BookModel) Update(book *Book) error {
-	query := `UPDATE books SET title=$1,published=$2,pages=$3,genres=$4,version=version+1 WHERE id=$5 AND version=$6 RETURNING version`
-	params := []any{book.Title, book.Published, book.Pages, book.Genres, book.ID, book.Version}
-	err := b.DB.QueryRow(context.Background(), query, params...).Scan(&book.Version)
+func (b BookModel) Update(ctx context.Context, book *Book) error {
+	if book.Authors == nil {
+		book.Authors = []string{}
+	}
+	query := `UPDATE books SET title=$1,authors=$2,publisher=$3,published=$4,pages=$5,genres=$6,version=version+1,updated_at=NOW() WHERE id=$7 AND version=$8 RETURNING version,updated_at`
+	ctx, q := b.startQuery(ctx, "Update", "UPDATE", query)
+	tx, err := b.DB.Begin(ctx)
+	if err != nil {
+		return q.end(err)
+	}
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	params := []any{book.Title, book.Authors, book.Publisher, book.Published, book.Pages, book.Genres, book.ID, book.Version}
+	err = tx.QueryRow(ctx, query, params...).Scan(&book.Version, &book.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
+			q.end(nil, affectedRows.Int(0))
 			return ErrEditConflict
 		default:
-			return err
+			return q.end(err)
 		}
 	}
-	return nil
+	if err := recordEvent(ctx, tx, EventBookUpdated, book.ID, map[string]any{"book": book}); err != nil {
+		return q.end(err)
+	}
+	return q.end(tx.Commit(ctx), affectedRows.Int(1))
 }
-func (b BookModel) Delete(id int64) error {
+func (b BookModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 	query := `DELETE FROM books WHERE ID=$1`
-	result, err := b.DB.Exec(context.Background(), query, id)
+	ctx, q := b.startQuery(ctx, "Delete", "DELETE", query)
+	tx, err := b.DB.Begin(ctx)
 	if err != nil {
-		return err
+		return q.end(err)
+	}
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	result, err := tx.Exec(ctx, query, id)
+	if err != nil {
+		return q.end(err)
 	}
 	rowsAffected := result.RowsAffected()
 
 	if rowsAffected == 0 {
+		q.end(nil, affectedRows.Int(0))
 		return ErrRecordNotFound
 	}
-	return nil
+	if err := recordEvent(ctx, tx, EventBookDeleted, id, map[string]any{"book": map[string]int64{"id": id}}); err != nil {
+		return q.end(err)
+	}
+	return q.end(tx.Commit(ctx), affectedRows.Int64(rowsAffected))
 }
 
 type JsonValidationError struct {
@@ -201,11 +451,12 @@ func (b *Book) Validate() map[string]string {
 				case e.Tag() == "gt":
 					jv.AddError(strings.ToLower(e.Field()), "must be above 0")
 				case e.Tag() == "lt":
-					jv.AddError(strings.ToLower(e.Field()), "must not exceed 5 items")
+					limit, _ := strconv.Atoi(e.Param())
+					jv.AddError(strings.ToLower(e.Field()), fmt.Sprintf("must not exceed %d items", limit-1))
 				case e.Tag() == "publication_date":
 					jv.AddError(strings.ToLower(e.Field()), fmt.Sprintf("publication date cannot exceed the range: 1430-%v", time.Now().Year()))
 				case e.Tag() == "unique":
-					jv.AddError(strings.ToLower(e.Field()), "cannot contain duplicate genres")
+					jv.AddError(strings.ToLower(e.Field()), fmt.Sprintf("cannot contain duplicate %s", strings.ToLower(e.Field())))
 				}
 			}
 			return jv.Errors
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Channels the books and copies tables notify of every change on.
+const (
+	bookChangesChannel = "book_changes"
+	copyChangesChannel = "copy_changes"
+)
+
+// BookChange is a notification of a book being inserted, updated or deleted. Seq increases
+// with every change; deleted books carry their last state.
+type BookChange struct {
+	Seq  int64  `json:"seq"`
+	Op   string `json:"op"`
+	Book *Book  `json:"book"`
+}
+
+// CopyChange is a notification of a copy changing status or patron, or being added.
+// PreviousStatus is empty for new copies. Patron is who the copy is now lent to or held for,
+// and PreviousPatron who it was, so that a return reaches the patron returning it.
+type CopyChange struct {
+	Copy           *Copy  `json:"copy"`
+	PreviousStatus string `json:"previous_status"`
+	Patron         string `json:"patron"`
+	PreviousPatron string `json:"previous_patron"`
+}
+
+// listen passes the payloads sent on channel to handle until ctx is done or the connection
+// fails. Once it is listening, it calls ready with the connection, which it can query.
+func listen(ctx context.Context, db *pgxpool.Pool, channel string, ready func(conn *pgx.Conn) error, handle func(payload []byte) error) error {
+	pooled, err := db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// The connection is left in LISTEN mode, so it is taken out of the pool for good.
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+		return err
+	}
+	if err := ready(conn); err != nil {
+		return err
+	}
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		if err := handle([]byte(notification.Payload)); err != nil {
+			return err
+		}
+	}
+}
+
+// ListenChanges passes every book change committed from now on to handle until ctx is done or
+// the connection fails. Once it is listening, it calls ready with the sequence number of the
+// last change made before then; changes up to it will never be handled.
+func (b BookModel) ListenChanges(ctx context.Context, ready func(floor int64), handle func(*BookChange)) error {
+	return listen(ctx, b.DB, bookChangesChannel, func(conn *pgx.Conn) error {
+		var floor int64
+		err := conn.QueryRow(ctx, `SELECT CASE WHEN is_called THEN last_value ELSE 0 END FROM book_changes_seq`).Scan(&floor)
+		if err == nil {
+			ready(floor)
+		}
+		return err
+	}, func(payload []byte) error {
+		var change BookChange
+		if err := json.Unmarshal(payload, &change); err != nil {
+			return err
+		}
+		handle(&change)
+		return nil
+	})
+}
+
+// ListenChanges passes every copy status change committed from now on to handle until ctx is
+// done or the connection fails.
+func (c CopyModel) ListenChanges(ctx context.Context, handle func(*CopyChange)) error {
+	return listen(ctx, c.DB, copyChangesChannel, func(*pgx.Conn) error { return nil }, func(payload []byte) error {
+		var change CopyChange
+		if err := json.Unmarshal(payload, &change); err != nil {
+			return err
+		}
+		if change.Copy != nil {
+			change.Copy.Patron = change.Patron
+		}
+		handle(&change)
+		return nil
+	})
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Copy is a physical copy of a book held at a branch.
+type Copy struct {
+	ID        int64     `json:"id"`
+	BookID    int64     `json:"book_id"`
+	CreatedAt time.Time `json:"-"`
+	Barcode   string    `json:"barcode"`
+	Branch    string    `json:"branch"`
+	Status    string    `json:"status"`
+	// Patron identifies who has the copy on loan or on hold. It is kept out of the catalogue.
+	Patron  string `json:"-"`
+	Version int    `json:"version"`
+}
+
+type CopyModel struct {
+	DB *pgxpool.Pool
+}
+
+// ForBooks returns the copies of the given books keyed by book id, fetched in a single query.
+func (c CopyModel) ForBooks(ctx context.Context, bookIDs []int64) (map[int64][]*Copy, error) {
+	copies := make(map[int64][]*Copy, len(bookIDs))
+	if len(bookIDs) == 0 {
+		return copies, nil
+	}
+	query := `SELECT id,book_id,created_at,barcode,branch,status,coalesce(patron,''),version FROM copies WHERE book_id=ANY($1) ORDER BY book_id, id`
+	rows, err := c.DB.Query(ctx, query, bookIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var copy Copy
+		err := rows.Scan(&copy.ID, &copy.BookID, &copy.CreatedAt, &copy.Barcode, &copy.Branch, &copy.Status, &copy.Patron, &copy.Version)
+		if err != nil {
+			return nil, err
+		}
+		copies[copy.BookID] = append(copies[copy.BookID], &copy)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return copies, nil
+}
+
+func (c CopyModel) Insert(copy *Copy) error {
+	if copy.Status == "" {
+		copy.Status = "available"
+	}
+	query := `INSERT INTO copies (book_id,barcode,branch,status,patron) VALUES ($1,$2,$3,$4,NULLIF($5,'')) RETURNING id,created_at,version`
+	params := []any{copy.BookID, copy.Barcode, copy.Branch, copy.Status, copy.Patron}
+	return c.DB.QueryRow(context.Background(), query, params...).Scan(&copy.ID, &copy.CreatedAt, &copy.Version)
+}
+
+// UpdateStatus records a copy moving to its Status, lent to or held for its Patron when it is
+// on_loan or on_hold; the patron is cleared otherwise. It fails with ErrEditConflict when the
+// copy has changed since its Version was read.
+func (c CopyModel) UpdateStatus(ctx context.Context, copy *Copy) error {
+	if copy.Status != "on_loan" && copy.Status != "on_hold" {
+		copy.Patron = ""
+	}
+	query := `UPDATE copies SET status=$1,patron=NULLIF($2,''),version=version+1 WHERE id=$3 AND version=$4 RETURNING version`
+	params := []any{copy.Status, copy.Patron, copy.ID, copy.Version}
+	err := c.DB.QueryRow(ctx, query, params...).Scan(&copy.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/themilar/plibrary/internal/e2etest"
+	"github.com/themilar/plibrary/internal/models"
+)
+
+func TestCopyPatron(t *testing.T) {
+	db := e2etest.NewDatabase(t)
+	ctx := context.Background()
+	books, copies := models.BookModel{DB: db}, models.CopyModel{DB: db}
+	book := &models.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Published: 1965, Pages: 412, Genres: []string{"sci-fi"}}
+	if err := books.Insert(ctx, book); err != nil {
+		t.Fatal(err)
+	}
+	copy := &models.Copy{BookID: book.ID, Barcode: "TEST-PATRON-1", Branch: "Central"}
+	if err := copies.Insert(copy); err != nil {
+		t.Fatal(err)
+	}
+
+	listenCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	changes := make(chan *models.CopyChange, 10)
+	// Notifications are shared by every schema of the database, so only this copy's are kept.
+	go copies.ListenChanges(listenCtx, func(change *models.CopyChange) {
+		if change.Copy.Barcode == copy.Barcode {
+			changes <- change
+		}
+	})
+	// Give the listener time to start before changing the copy.
+	time.Sleep(200 * time.Millisecond)
+
+	next := func() *models.CopyChange {
+		t.Helper()
+		select {
+		case change := <-changes:
+			return change
+		case <-time.After(5 * time.Second):
+			t.Fatal("no copy change notified")
+			return nil
+		}
+	}
+	patronOf := func() string {
+		t.Helper()
+		held, err := copies.ForBooks(ctx, []int64{book.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return held[book.ID][0].Patron
+	}
+
+	copy.Status, copy.Patron = "on_loan", "P-1"
+	if err := copies.UpdateStatus(ctx, copy); err != nil {
+		t.Fatal(err)
+	}
+	if got := patronOf(); got != "P-1" {
+		t.Errorf("patron after checkout = %q, want P-1", got)
+	}
+	if change := next(); change.Patron != "P-1" || change.PreviousPatron != "" || change.Copy.Patron != "P-1" || change.PreviousStatus != "available" {
+		t.Errorf("checkout notified %+v", change)
+	}
+
+	copy.Status, copy.Patron = "available", "P-1"
+	if err := copies.UpdateStatus(ctx, copy); err != nil {
+		t.Fatal(err)
+	}
+	if got := patronOf(); got != "" {
+		t.Errorf("patron after return = %q, want none", got)
+	}
+	if change := next(); change.Patron != "" || change.PreviousPatron != "P-1" {
+		t.Errorf("return notified %+v", change)
+	}
+
+	stale := *copy
+	stale.Version--
+	stale.Status = "lost"
+	if err := copies.UpdateStatus(ctx, &stale); !errors.Is(err, models.ErrEditConflict) {
+		t.Errorf("UpdateStatus of a stale copy = %v, want %v", err, models.ErrEditConflict)
+	}
+}
+
+func TestCopiesForBooksContext(t *testing.T) {
+	copies := models.CopyModel{DB: e2etest.NewDatabase(t)}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := copies.ForBooks(ctx, []int64{1}); !errors.Is(err, context.Canceled) {
+		t.Errorf("ForBooks with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+	held, err := copies.ForBooks(ctx, nil)
+	if err != nil || len(held) != 0 {
+		t.Errorf("ForBooks of no books = %v, %v; want an empty map without a query", held, err)
+	}
+}
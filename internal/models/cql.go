@@ -0,0 +1,171 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/themilar/plibrary/internal/cql"
+)
+
+// cqlIndexes maps the CQL indexes understood by SearchCQL to book columns. Unprefixed
+// names resolve to the Dublin Core context set.
+var cqlIndexes = map[string]string{
+	cql.DefaultIndex: "title",
+	"cql.keywords":   "title",
+	"cql.allrecords": "",
+	"dc.title":       "title",
+	"title":          "title",
+	"dc.date":        "published",
+	"date":           "published",
+	"dc.subject":     "genres",
+	"subject":        "genres",
+	"dc.identifier":  "id",
+	"identifier":     "id",
+	"plib.pages":     "pages",
+}
+
+// cqlWhere translates a parsed CQL query into a SQL boolean expression, appending the
+// search terms to params as positional arguments.
+func cqlWhere(node cql.Node, params *[]any) (string, error) {
+	switch n := node.(type) {
+	case *cql.Boolean:
+		left, err := cqlWhere(n.Left, params)
+		if err != nil {
+			return "", err
+		}
+		right, err := cqlWhere(n.Right, params)
+		if err != nil {
+			return "", err
+		}
+		switch n.Op {
+		case "and":
+			return fmt.Sprintf("(%s AND %s)", left, right), nil
+		case "or":
+			return fmt.Sprintf("(%s OR %s)", left, right), nil
+		case "not":
+			return fmt.Sprintf("(%s AND NOT %s)", left, right), nil
+		}
+		return "", &cql.Error{Diagnostic: cql.DiagUnsupportedBoolean, Message: "Unsupported boolean operator", Details: n.Op}
+	case *cql.Clause:
+		return cqlClause(n, params)
+	}
+	return "", fmt.Errorf("unexpected CQL node %T", node)
+}
+
+func cqlClause(c *cql.Clause, params *[]any) (string, error) {
+	column, ok := cqlIndexes[c.Index]
+	if !ok {
+		return "", &cql.Error{Diagnostic: cql.DiagUnsupportedIndex, Message: "Unsupported index", Details: c.Index}
+	}
+	if strings.ContainsAny(c.Term, "*?^") {
+		return "", &cql.Error{Diagnostic: cql.DiagMaskingNotSupported, Message: "Masking character not supported", Details: c.Term}
+	}
+	unsupported := &cql.Error{Diagnostic: cql.DiagUnsupportedRelation, Message: "Unsupported relation", Details: fmt.Sprintf("%s for %s", c.Relation, c.Index)}
+	param := func(v any) string {
+		*params = append(*params, v)
+		return fmt.Sprintf("$%d", len(*params))
+	}
+
+	switch column {
+	case "":
+		return "TRUE", nil
+	case "title":
+		switch c.Relation {
+		case "=", "adj":
+			return fmt.Sprintf("to_tsvector('simple',title) @@ phraseto_tsquery('simple',%s)", param(c.Term)), nil
+		case "any", "all":
+			words := strings.Fields(c.Term)
+			if len(words) == 0 {
+				return "FALSE", nil
+			}
+			clauses := make([]string, len(words))
+			for i, word := range words {
+				clauses[i] = fmt.Sprintf("to_tsvector('simple',title) @@ plainto_tsquery('simple',%s)", param(word))
+			}
+			op := " OR "
+			if c.Relation == "all" {
+				op = " AND "
+			}
+			return "(" + strings.Join(clauses, op) + ")", nil
+		case "==":
+			return fmt.Sprintf("LOWER(title)=LOWER(%s)", param(c.Term)), nil
+		case "<>":
+			return fmt.Sprintf("LOWER(title)<>LOWER(%s)", param(c.Term)), nil
+		}
+		return "", unsupported
+	case "genres":
+		switch c.Relation {
+		case "=", "==":
+			return fmt.Sprintf("genres@>ARRAY[%s::text]", param(c.Term)), nil
+		case "<>":
+			return fmt.Sprintf("NOT genres@>ARRAY[%s::text]", param(c.Term)), nil
+		case "any":
+			return fmt.Sprintf("genres&&%s::text[]", param(strings.Fields(c.Term))), nil
+		case "all":
+			return fmt.Sprintf("genres@>%s::text[]", param(strings.Fields(c.Term))), nil
+		}
+		return "", unsupported
+	default:
+		value, err := strconv.ParseInt(c.Term, 10, 64)
+		if err != nil {
+			return "", &cql.Error{Diagnostic: cql.DiagInvalidTermFormat, Message: "Term in invalid format for index or relation", Details: c.Term}
+		}
+		switch c.Relation {
+		case "=", "==":
+			return fmt.Sprintf("%s=%s", column, param(value)), nil
+		case "<>", "<", ">", "<=", ">=":
+			return fmt.Sprintf("%s%s%s", column, c.Relation, param(value)), nil
+		}
+		return "", unsupported
+	}
+}
+
+// SearchCQL returns the books matching a parsed CQL query, ordered by id, skipping offset
+// records and returning at most limit. The total number of matches is returned alongside.
+func (b BookModel) SearchCQL(ctx context.Context, query cql.Node, offset, limit int) ([]*Book, int, error) {
+	params := []any{}
+	where, err := cqlWhere(query, &params)
+	if err != nil {
+		return nil, 0, err
+	}
+	params = append(params, limit, offset)
+	sql := fmt.Sprintf(`SELECT COUNT(*) OVER(), id,created_at,updated_at,title,authors,publisher,published,pages,genres,version
+	FROM books
+	WHERE %s
+	ORDER BY id ASC
+	LIMIT $%d OFFSET $%d`, where, len(params)-1, len(params))
+	queryCtx, q := b.startQuery(ctx, "SearchCQL", "SELECT", sql)
+	rows, err := b.DB.Query(queryCtx, sql, params...)
+	if err != nil {
+		return nil, 0, q.end(err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	books := []*Book{}
+	for rows.Next() {
+		var book Book
+		err := rows.Scan(&totalRecords, &book.ID, &book.CreatedAt, &book.UpdatedAt, &book.Title, &book.Authors, &book.Publisher, &book.Published, &book.Pages, &book.Genres, &book.Version)
+		if err != nil {
+			return nil, 0, q.end(err)
+		}
+		books = append(books, &book)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, q.end(err)
+	}
+	q.end(nil, returnedRows.Int(len(books)))
+	// Past the last match the window count is unavailable, so count separately.
+	if len(books) == 0 && offset > 0 {
+		sql = fmt.Sprintf(`SELECT COUNT(*) FROM books WHERE %s`, where)
+		queryCtx, q = b.startQuery(ctx, "SearchCQL", "SELECT", sql)
+		err = b.DB.QueryRow(queryCtx, sql, params[:len(params)-2]...).Scan(&totalRecords)
+		if err != nil {
+			return nil, 0, q.end(err)
+		}
+		q.end(nil)
+	}
+	return books, totalRecords, nil
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/themilar/plibrary/internal/cql"
+)
+
+func TestCQLWhere(t *testing.T) {
+	for _, test := range []struct {
+		query  string
+		sql    string
+		params []any
+	}{
+		{`dune`, `to_tsvector('simple',title) @@ phraseto_tsquery('simple',$1)`, []any{"dune"}},
+		{`dc.title adj "children of"`, `to_tsvector('simple',title) @@ phraseto_tsquery('simple',$1)`, []any{"children of"}},
+		{`title any "dune hobbit"`, `(to_tsvector('simple',title) @@ plainto_tsquery('simple',$1) OR to_tsvector('simple',title) @@ plainto_tsquery('simple',$2))`, []any{"dune", "hobbit"}},
+		{`title all "dune children"`, `(to_tsvector('simple',title) @@ plainto_tsquery('simple',$1) AND to_tsvector('simple',title) @@ plainto_tsquery('simple',$2))`, []any{"dune", "children"}},
+		{`title any ""`, `FALSE`, []any{}},
+		{`title == "The Hobbit"`, `LOWER(title)=LOWER($1)`, []any{"The Hobbit"}},
+		{`title <> dune`, `LOWER(title)<>LOWER($1)`, []any{"dune"}},
+		{`subject = fantasy`, `genres@>ARRAY[$1::text]`, []any{"fantasy"}},
+		{`dc.subject <> fantasy`, `NOT genres@>ARRAY[$1::text]`, []any{"fantasy"}},
+		{`subject any "comedy cyberpunk"`, `genres&&$1::text[]`, []any{[]string{"comedy", "cyberpunk"}}},
+		{`subject all "sci-fi adventure"`, `genres@>$1::text[]`, []any{[]string{"sci-fi", "adventure"}}},
+		{`dc.date = 1965`, `published=$1`, []any{int64(1965)}},
+		{`date >= 1970`, `published>=$1`, []any{int64(1970)}},
+		{`plib.pages < 300`, `pages<$1`, []any{int64(300)}},
+		{`dc.identifier == 5`, `id=$1`, []any{int64(5)}},
+		{`cql.allrecords = 1`, `TRUE`, []any{}},
+		{`dune and date < 1970 or subject = fantasy`, `((to_tsvector('simple',title) @@ phraseto_tsquery('simple',$1) AND published<$2) OR genres@>ARRAY[$3::text])`, []any{"dune", int64(1970), "fantasy"}},
+		{`subject = sci-fi not dune`, `(genres@>ARRAY[$1::text] AND NOT to_tsvector('simple',title) @@ phraseto_tsquery('simple',$2))`, []any{"sci-fi", "dune"}},
+	} {
+		node, err := cql.Parse(test.query)
+		if err != nil {
+			t.Fatalf("parse %q: %v", test.query, err)
+		}
+		params := []any{}
+		sql, err := cqlWhere(node, &params)
+		if err != nil {
+			t.Errorf("cqlWhere(%q) error = %v", test.query, err)
+			continue
+		}
+		if sql != test.sql || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("cqlWhere(%q) = %s %#v, want %s %#v", test.query, sql, params, test.sql, test.params)
+		}
+	}
+}
+
+func TestCQLWhereErrors(t *testing.T) {
+	for _, test := range []struct {
+		query      string
+		diagnostic int
+	}{
+		{`dc.creator = herbert`, cql.DiagUnsupportedIndex},
+		{`title = dune and isbn = 123`, cql.DiagUnsupportedIndex},
+		{`title < dune`, cql.DiagUnsupportedRelation},
+		{`title within "a b"`, cql.DiagUnsupportedRelation},
+		{`subject > fantasy`, cql.DiagUnsupportedRelation},
+		{`date any "1965 1976"`, cql.DiagInvalidTermFormat},
+		{`date adj 1965`, cql.DiagUnsupportedRelation},
+		{`date = soon`, cql.DiagInvalidTermFormat},
+		{`dun*`, cql.DiagMaskingNotSupported},
+		{`title = "d?ne"`, cql.DiagMaskingNotSupported},
+	} {
+		node, err := cql.Parse(test.query)
+		if err != nil {
+			t.Fatalf("parse %q: %v", test.query, err)
+		}
+		_, err = cqlWhere(node, &[]any{})
+		var cqlErr *cql.Error
+		if !errors.As(err, &cqlErr) || cqlErr.Diagnostic != test.diagnostic {
+			t.Errorf("cqlWhere(%q) error = %v, want diagnostic %d", test.query, err, test.diagnostic)
+		}
+	}
+}
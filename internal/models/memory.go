@@ -0,0 +1,480 @@
+package models
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/themilar/plibrary/internal"
+	"github.com/themilar/plibrary/internal/cql"
+)
+
+// MemoryBookModel is a BookRepository keeping books in memory, for tests that do not need
+// Postgres. It behaves like BookModel except that titles and genres are sorted by byte order
+// rather than the database collation, that full text search splits titles on anything but
+// letters and digits, and that it records no outbox events.
+type MemoryBookModel struct {
+	mu     sync.RWMutex
+	books  map[int64]*Book
+	lastID int64
+	// seq numbers the changes, as book_changes_seq does.
+	seq       int64
+	listeners map[*memoryListener]struct{}
+}
+
+func NewMemoryBookModel() *MemoryBookModel {
+	return &MemoryBookModel{books: map[int64]*Book{}, listeners: map[*memoryListener]struct{}{}}
+}
+
+// memoryListener queues the changes for a ListenChanges call, so that writers never wait for
+// it to handle them.
+type memoryListener struct {
+	mu      sync.Mutex
+	changes []*BookChange
+	wake    chan struct{}
+}
+
+// now returns the current time at the precision of the timestamp(0) columns.
+func now() time.Time {
+	return time.Now().Round(time.Second)
+}
+
+func cloneBook(book *Book) *Book {
+	clone := *book
+	clone.Authors = slices.Clone(book.Authors)
+	clone.Genres = slices.Clone(book.Genres)
+	return &clone
+}
+
+// project copies the given columns of book, leaving the other fields zero as a query
+// selecting only those columns would.
+func project(book *Book, columns []string) *Book {
+	p := &Book{}
+	for _, column := range columns {
+		switch column {
+		case "id":
+			p.ID = book.ID
+		case "created_at":
+			p.CreatedAt = book.CreatedAt
+		case "updated_at":
+			p.UpdatedAt = book.UpdatedAt
+		case "title":
+			p.Title = book.Title
+		case "authors":
+			p.Authors = slices.Clone(book.Authors)
+		case "publisher":
+			p.Publisher = book.Publisher
+		case "published":
+			p.Published = book.Published
+		case "pages":
+			p.Pages = book.Pages
+		case "genres":
+			p.Genres = slices.Clone(book.Genres)
+		case "version":
+			p.Version = book.Version
+		}
+	}
+	return p
+}
+
+// lexemes splits text into lowercase words, as the simple text search configuration does for
+// plain titles.
+func lexemes(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsAll reports whether words contains every one of terms. Like a text search query
+// without lexemes, no terms match nothing.
+func containsAll(words, terms []string) bool {
+	if len(terms) == 0 {
+		return false
+	}
+	for _, term := range terms {
+		if !slices.Contains(words, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsPhrase reports whether words contains terms one after the other.
+func containsPhrase(words, terms []string) bool {
+	if len(terms) == 0 {
+		return false
+	}
+	for i := 0; i+len(terms) <= len(words); i++ {
+		if slices.Equal(words[i:i+len(terms)], terms) {
+			return true
+		}
+	}
+	return false
+}
+
+// sorted returns the books matching keep, ordered by id.
+func (m *MemoryBookModel) sorted(keep func(*Book) bool) []*Book {
+	books := []*Book{}
+	for _, book := range m.books {
+		if keep(book) {
+			books = append(books, book)
+		}
+	}
+	slices.SortFunc(books, func(a, b *Book) int { return cmp.Compare(a.ID, b.ID) })
+	return books
+}
+
+// page returns the books after offset, at most limit of them, along with the number of
+// matches. Like the window count of a query, the total is 0 when the page is empty.
+func page(books []*Book, offset, limit int, columns []string) ([]*Book, int) {
+	offset = min(max(offset, 0), len(books))
+	end := min(offset+max(limit, 0), len(books))
+	result := make([]*Book, 0, end-offset)
+	for _, book := range books[offset:end] {
+		result = append(result, project(book, columns))
+	}
+	if len(result) == 0 {
+		return result, 0
+	}
+	return result, len(books)
+}
+
+func (m *MemoryBookModel) All(ctx context.Context, title string, genres []string, filters internal.Filters, fields ...string) ([]*Book, *internal.PaginationMetadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, abandoned(err, err)
+	}
+	column, descending := filters.SortColumn(), filters.SortDirection() == "DESC"
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	books := m.sorted(func(book *Book) bool {
+		return (title == "" || strings.ToLower(book.Title) == strings.ToLower(title)) &&
+			(len(genres) == 0 || containsAll(book.Genres, genres))
+	})
+	slices.SortStableFunc(books, func(a, b *Book) int {
+		var c int
+		switch column {
+		case "id":
+			c = cmp.Compare(a.ID, b.ID)
+		case "title":
+			c = cmp.Compare(a.Title, b.Title)
+		case "published":
+			c = cmp.Compare(a.Published, b.Published)
+		case "pages":
+			c = cmp.Compare(a.Pages, b.Pages)
+		}
+		if descending {
+			c = -c
+		}
+		return c
+	})
+	result, totalRecords := page(books, filters.Offset(), filters.Limit(), bookColumns(fields))
+	return result, internal.CalculateMetadata(totalRecords, filters.Page, filters.Size), nil
+}
+
+func (m *MemoryBookModel) FullTextSearch(ctx context.Context, title string, fields ...string) ([]*Book, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, abandoned(err, err)
+	}
+	terms := lexemes(title)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	books := m.sorted(func(book *Book) bool {
+		return title == "" || containsAll(lexemes(book.Title), terms)
+	})
+	result, _ := page(books, 0, len(books), bookColumns(fields))
+	return result, nil
+}
+
+func (m *MemoryBookModel) SearchCQL(ctx context.Context, query cql.Node, offset, limit int) ([]*Book, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, abandoned(err, err)
+	}
+	// Translating the query reports the same errors for what BookModel cannot search.
+	if _, err := cqlWhere(query, &[]any{}); err != nil {
+		return nil, 0, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	books := m.sorted(func(book *Book) bool { return matchCQL(query, book) })
+	// Like BookModel, a page past the last match still reports how many there are.
+	result, _ := page(books, offset, limit, bookAllColumns)
+	return result, len(books), nil
+}
+
+// matchCQL reports whether book matches a CQL query that cqlWhere accepts.
+func matchCQL(node cql.Node, book *Book) bool {
+	switch n := node.(type) {
+	case *cql.Boolean:
+		left, right := matchCQL(n.Left, book), matchCQL(n.Right, book)
+		switch n.Op {
+		case "and":
+			return left && right
+		case "or":
+			return left || right
+		case "not":
+			return left && !right
+		}
+	case *cql.Clause:
+		return matchCQLClause(n, book)
+	}
+	return false
+}
+
+func matchCQLClause(c *cql.Clause, book *Book) bool {
+	switch column := cqlIndexes[c.Index]; column {
+	case "":
+		return true
+	case "title":
+		words := lexemes(book.Title)
+		switch c.Relation {
+		case "=", "adj":
+			return containsPhrase(words, lexemes(c.Term))
+		case "any", "all":
+			terms := strings.Fields(c.Term)
+			if len(terms) == 0 {
+				return false
+			}
+			all := c.Relation == "all"
+			for _, term := range terms {
+				if containsAll(words, lexemes(term)) != all {
+					return !all
+				}
+			}
+			return all
+		case "==":
+			return strings.ToLower(book.Title) == strings.ToLower(c.Term)
+		case "<>":
+			return strings.ToLower(book.Title) != strings.ToLower(c.Term)
+		}
+	case "genres":
+		switch c.Relation {
+		case "=", "==":
+			return slices.Contains(book.Genres, c.Term)
+		case "<>":
+			return !slices.Contains(book.Genres, c.Term)
+		case "any":
+			return slices.ContainsFunc(strings.Fields(c.Term), func(genre string) bool { return slices.Contains(book.Genres, genre) })
+		case "all":
+			return !slices.ContainsFunc(strings.Fields(c.Term), func(genre string) bool { return !slices.Contains(book.Genres, genre) })
+		}
+	default:
+		value, _ := strconv.ParseInt(c.Term, 10, 64)
+		field := map[string]int64{"id": book.ID, "published": int64(book.Published), "pages": int64(book.Pages)}[column]
+		switch c.Relation {
+		case "=", "==":
+			return field == value
+		case "<>":
+			return field != value
+		case "<":
+			return field < value
+		case ">":
+			return field > value
+		case "<=":
+			return field <= value
+		case ">=":
+			return field >= value
+		}
+	}
+	return false
+}
+
+func (m *MemoryBookModel) ByAuthors(ctx context.Context, names []string) (map[string][]*Book, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, abandoned(err, err)
+	}
+	books := make(map[string][]*Book, len(names))
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, book := range m.sorted(func(*Book) bool { return true }) {
+		for _, author := range book.Authors {
+			if slices.Contains(names, author) {
+				books[author] = append(books[author], cloneBook(book))
+			}
+		}
+	}
+	return books, nil
+}
+
+func (m *MemoryBookModel) Genres(ctx context.Context) ([]*Genre, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, abandoned(err, err)
+	}
+	counts := map[string]int{}
+	m.mu.RLock()
+	for _, book := range m.books {
+		for _, genre := range book.Genres {
+			counts[genre]++
+		}
+	}
+	m.mu.RUnlock()
+	genres := []*Genre{}
+	for name, count := range counts {
+		genres = append(genres, &Genre{Name: name, Count: count})
+	}
+	slices.SortFunc(genres, func(a, b *Genre) int { return cmp.Compare(a.Name, b.Name) })
+	return genres, nil
+}
+
+func (m *MemoryBookModel) Harvest(ctx context.Context, from, until *time.Time, genre string, afterID int64, limit int) ([]*Book, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, abandoned(err, err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	matches := m.sorted(func(book *Book) bool {
+		return (from == nil || !book.UpdatedAt.Before(*from)) &&
+			(until == nil || !book.UpdatedAt.After(*until)) &&
+			(genre == "" || slices.Contains(book.Genres, genre))
+	})
+	after := slices.IndexFunc(matches, func(book *Book) bool { return book.ID > afterID })
+	if after < 0 {
+		return []*Book{}, 0, nil
+	}
+	books, _ := page(matches[after:], 0, limit, bookAllColumns)
+	if len(books) == 0 {
+		return books, 0, nil
+	}
+	return books, len(matches), nil
+}
+
+func (m *MemoryBookModel) EarliestDatestamp(ctx context.Context) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, abandoned(err, err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var earliest time.Time
+	for _, book := range m.books {
+		if earliest.IsZero() || book.UpdatedAt.Before(earliest) {
+			earliest = book.UpdatedAt
+		}
+	}
+	return earliest, nil
+}
+
+func (m *MemoryBookModel) Get(ctx context.Context, id int64, fields ...string) (*Book, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, abandoned(err, err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	book, ok := m.books[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	return project(book, bookColumns(fields)), nil
+}
+
+func (m *MemoryBookModel) Insert(ctx context.Context, book *Book) error {
+	if book.Authors == nil {
+		book.Authors = []string{}
+	}
+	if err := ctx.Err(); err != nil {
+		return abandoned(err, err)
+	}
+	if book.Genres == nil {
+		return errors.New(`null value in column "genres" violates not-null constraint`)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastID++
+	book.ID = m.lastID
+	book.CreatedAt = now()
+	book.UpdatedAt = book.CreatedAt
+	book.Version = 1
+	m.books[book.ID] = cloneBook(book)
+	m.notify("insert", book)
+	return nil
+}
+
+func (m *MemoryBookModel) Update(ctx context.Context, book *Book) error {
+	if book.Authors == nil {
+		book.Authors = []string{}
+	}
+	if err := ctx.Err(); err != nil {
+		return abandoned(err, err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.books[book.ID]
+	if !ok || stored.Version != book.Version {
+		return ErrEditConflict
+	}
+	book.Version++
+	book.UpdatedAt = now()
+	updated := cloneBook(book)
+	updated.CreatedAt = stored.CreatedAt
+	m.books[book.ID] = updated
+	m.notify("update", updated)
+	return nil
+}
+
+func (m *MemoryBookModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	if err := ctx.Err(); err != nil {
+		return abandoned(err, err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	book, ok := m.books[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	delete(m.books, id)
+	m.notify("delete", book)
+	return nil
+}
+
+// notify queues a change for every listener. The caller holds the lock.
+func (m *MemoryBookModel) notify(op string, book *Book) {
+	m.seq++
+	for l := range m.listeners {
+		l.mu.Lock()
+		l.changes = append(l.changes, &BookChange{Seq: m.seq, Op: op, Book: cloneBook(book)})
+		l.mu.Unlock()
+		select {
+		case l.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (m *MemoryBookModel) ListenChanges(ctx context.Context, ready func(floor int64), handle func(*BookChange)) error {
+	l := &memoryListener{wake: make(chan struct{}, 1)}
+	m.mu.Lock()
+	m.listeners[l] = struct{}{}
+	floor := m.seq
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.listeners, l)
+		m.mu.Unlock()
+	}()
+
+	ready(floor)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-l.wake:
+		}
+		l.mu.Lock()
+		changes := l.changes
+		l.changes = nil
+		l.mu.Unlock()
+		for _, change := range changes {
+			handle(change)
+		}
+	}
+}
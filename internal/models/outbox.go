@@ -0,0 +1,134 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Catalogue change events. They are recorded in the outbox in the same transaction as the
+// change that raised them.
+const (
+	EventBookCreated = "book.created"
+	EventBookUpdated = "book.updated"
+	EventBookDeleted = "book.deleted"
+)
+
+// outboxLockKey is the advisory lock held while relaying, so that only one relay publishes at
+// a time and events leave in the order they were committed.
+const outboxLockKey = 0x706c6962_6f757462
+
+// OutboxEvent is a change event waiting to be published. DedupID is the same every time the
+// event is published, so consumers can discard the copies at-least-once delivery can cause.
+type OutboxEvent struct {
+	ID          int64
+	DedupID     string
+	CreatedAt   time.Time
+	Event       string
+	AggregateID int64
+	Payload     json.RawMessage
+}
+
+type OutboxModel struct {
+	DB *pgxpool.Pool
+}
+
+// recordEvent adds an event to the outbox as part of tx. The event takes the next outbox
+// position, which locks the position counter until tx ends, so it is best called just before
+// committing.
+func recordEvent(ctx context.Context, tx pgx.Tx, event string, aggregateID int64, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO outbox (event,aggregate_id,payload) VALUES ($1,$2,$3)`
+	_, err = tx.Exec(ctx, query, event, aggregateID, payload)
+	return err
+}
+
+// Relay passes up to limit unpublished events to publish in commit order, and marks those it
+// accepts as published. It stops at the first event publish fails on, which is tried again by
+// the next call, and returns the number of events published. When another relay is running,
+// Relay does nothing.
+//
+// The lock that keeps events in order is held while publishing, so a batch is given at most
+// maxHold: publish is passed a context that ends then, and the events it has not reached are
+// left to the next call. Should the process stall, the database ends the transaction once it
+// has been idle for longer than maxHold.
+func (o OutboxModel) Relay(ctx context.Context, limit int, maxHold time.Duration, publish func(context.Context, *OutboxEvent) error) (int, error) {
+	tx, err := o.DB.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(context.Background())
+
+	var locked bool
+	if err := tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1)`, outboxLockKey).Scan(&locked); err != nil || !locked {
+		return 0, err
+	}
+	idleTimeout := strconv.FormatInt((maxHold + time.Second).Milliseconds(), 10)
+	if _, err := tx.Exec(ctx, `SELECT set_config('idle_in_transaction_session_timeout',$1,true)`, idleTimeout); err != nil {
+		return 0, err
+	}
+	query := `SELECT id,dedup_id::text,created_at,event,aggregate_id,payload FROM outbox
+	WHERE published_at IS NULL ORDER BY position LIMIT $1`
+	rows, err := tx.Query(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+	events := []*OutboxEvent{}
+	for rows.Next() {
+		var event OutboxEvent
+		if err := rows.Scan(&event.ID, &event.DedupID, &event.CreatedAt, &event.Event, &event.AggregateID, &event.Payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		events = append(events, &event)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	holdCtx, cancel := context.WithTimeout(ctx, maxHold)
+	defer cancel()
+	var published []int64
+	var publishErr error
+	for _, event := range events {
+		if holdCtx.Err() != nil {
+			break
+		}
+		if publishErr = publish(holdCtx, event); publishErr != nil {
+			break
+		}
+		published = append(published, event.ID)
+	}
+	// An event cut short by maxHold is not a failure of its sinks.
+	if publishErr != nil && ctx.Err() == nil && errors.Is(holdCtx.Err(), context.DeadlineExceeded) {
+		publishErr = nil
+	}
+	if len(published) > 0 {
+		_, err = tx.Exec(ctx, `UPDATE outbox SET published_at=NOW() WHERE id=ANY($1)`, published)
+		if err == nil {
+			err = tx.Commit(ctx)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(published), publishErr
+}
+
+// Purge deletes the events published before the given time.
+func (o OutboxModel) Purge(ctx context.Context, before time.Time) (int, error) {
+	result, err := o.DB.Exec(ctx, `DELETE FROM outbox WHERE published_at<$1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return int(result.RowsAffected()), nil
+}
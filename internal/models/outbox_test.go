@@ -0,0 +1,272 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/themilar/plibrary/internal/e2etest"
+	"github.com/themilar/plibrary/internal/models"
+)
+
+// newOutbox returns an outbox holding an event for each of the given aggregate ids, recorded
+// in that order.
+func newOutbox(t *testing.T, aggregateIDs ...int64) (models.OutboxModel, *pgxpool.Pool) {
+	t.Helper()
+	db := e2etest.NewDatabase(t)
+	for _, id := range aggregateIDs {
+		query := `INSERT INTO outbox (event,aggregate_id,payload) VALUES ($1,$2,'{}')`
+		if _, err := db.Exec(context.Background(), query, models.EventBookCreated, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return models.OutboxModel{DB: db}, db
+}
+
+// recorder is a publish func that keeps the events it is passed and fails on those listed in
+// fail.
+type recorder struct {
+	events []*models.OutboxEvent
+	fail   map[int64]bool
+}
+
+var errSink = errors.New("sink unavailable")
+
+func (r *recorder) publish(_ context.Context, event *models.OutboxEvent) error {
+	r.events = append(r.events, event)
+	if r.fail[event.AggregateID] {
+		return errSink
+	}
+	return nil
+}
+
+func (r *recorder) aggregateIDs() []int64 {
+	var ids []int64
+	for _, event := range r.events {
+		ids = append(ids, event.AggregateID)
+	}
+	return ids
+}
+
+func TestOutboxRelayOrder(t *testing.T) {
+	outbox, _ := newOutbox(t, 3, 1, 2, 5, 4)
+	ctx := context.Background()
+
+	var r recorder
+	n, err := outbox.Relay(ctx, 3, time.Minute, r.publish)
+	if err != nil || n != 3 {
+		t.Fatalf("Relay = %d, %v; want 3, nil", n, err)
+	}
+	n, err = outbox.Relay(ctx, 3, time.Minute, r.publish)
+	if err != nil || n != 2 {
+		t.Fatalf("second Relay = %d, %v; want 2, nil", n, err)
+	}
+	if got, want := r.aggregateIDs(), []int64{3, 1, 2, 5, 4}; !slices.Equal(got, want) {
+		t.Errorf("published %v, want the order recorded %v", got, want)
+	}
+	if n, err := outbox.Relay(ctx, 3, time.Minute, r.publish); err != nil || n != 0 {
+		t.Errorf("Relay with nothing left = %d, %v; want 0, nil", n, err)
+	}
+}
+
+func TestOutboxRelayCommitOrder(t *testing.T) {
+	outbox, db := newOutbox(t)
+	ctx := context.Background()
+	record := func(tx pgx.Tx, aggregateID int64) error {
+		query := `INSERT INTO outbox (event,aggregate_id,payload) VALUES ($1,$2,'{}')`
+		_, err := tx.Exec(ctx, query, models.EventBookCreated, aggregateID)
+		return err
+	}
+
+	first, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Rollback(ctx)
+	if err := record(first, 1); err != nil {
+		t.Fatal(err)
+	}
+	// The second transaction takes a later id and tries to commit while the first is open.
+	committed := make(chan error, 1)
+	go func() {
+		second, err := db.Begin(ctx)
+		if err != nil {
+			committed <- err
+			return
+		}
+		defer second.Rollback(ctx)
+		if err := record(second, 2); err != nil {
+			committed <- err
+			return
+		}
+		committed <- second.Commit(ctx)
+	}()
+	select {
+	case err := <-committed:
+		t.Errorf("second transaction ended before the first: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	var r recorder
+	if _, err := outbox.Relay(ctx, 10, time.Minute, r.publish); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-committed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second transaction still blocked after the first committed")
+	}
+	if _, err := outbox.Relay(ctx, 10, time.Minute, r.publish); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.aggregateIDs(), []int64{1, 2}; !slices.Equal(got, want) {
+		t.Errorf("published %v, want the commit order %v", got, want)
+	}
+}
+
+func TestOutboxRelayStopsAtFirstFailure(t *testing.T) {
+	outbox, _ := newOutbox(t, 1, 2, 3)
+	ctx := context.Background()
+
+	r := recorder{fail: map[int64]bool{2: true}}
+	n, err := outbox.Relay(ctx, 10, time.Minute, r.publish)
+	if !errors.Is(err, errSink) || n != 1 {
+		t.Fatalf("Relay = %d, %v; want 1, %v", n, err, errSink)
+	}
+	if got, want := r.aggregateIDs(), []int64{1, 2}; !slices.Equal(got, want) {
+		t.Errorf("published %v, want %v and nothing after the failure", got, want)
+	}
+
+	// The failed event comes first on the next call, and the one published is not repeated.
+	r = recorder{}
+	n, err = outbox.Relay(ctx, 10, time.Minute, r.publish)
+	if err != nil || n != 2 {
+		t.Fatalf("Relay after the failure = %d, %v; want 2, nil", n, err)
+	}
+	if got, want := r.aggregateIDs(), []int64{2, 3}; !slices.Equal(got, want) {
+		t.Errorf("published %v after the failure, want %v", got, want)
+	}
+}
+
+func TestOutboxRelayDedup(t *testing.T) {
+	outbox, db := newOutbox(t, 1)
+	ctx := context.Background()
+
+	failing := recorder{fail: map[int64]bool{1: true}}
+	if _, err := outbox.Relay(ctx, 10, time.Minute, failing.publish); !errors.Is(err, errSink) {
+		t.Fatalf("Relay error = %v, want %v", err, errSink)
+	}
+	var r recorder
+	if n, err := outbox.Relay(ctx, 10, time.Minute, r.publish); err != nil || n != 1 {
+		t.Fatalf("Relay = %d, %v; want 1, nil", n, err)
+	}
+	first, retry := failing.events[0], r.events[0]
+	if first.DedupID == "" || retry.DedupID != first.DedupID {
+		t.Errorf("DedupID %q on retry, want %q as on the first attempt", retry.DedupID, first.DedupID)
+	}
+
+	// Webhook deliveries are queued once per event, however often it is published.
+	webhook := &models.Webhook{URL: "https://example.com/hooks", Secret: "0123456789abcdef", Events: []string{models.EventBookCreated}, Active: true}
+	if err := (models.WebhookModel{DB: db}).Insert(webhook); err != nil {
+		t.Fatal(err)
+	}
+	deliveries := models.DeliveryModel{DB: db}
+	for i, want := range []int{1, 0} {
+		n, err := deliveries.Enqueue(retry.DedupID, retry.Event, []byte(`{}`))
+		if err != nil || n != want {
+			t.Errorf("Enqueue #%d = %d, %v; want %d, nil", i+1, n, err, want)
+		}
+	}
+}
+
+func TestOutboxRelayLock(t *testing.T) {
+	outbox, db := newOutbox(t, 1)
+	ctx := context.Background()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(0x706c6962_6f757462)`); err != nil {
+		t.Fatal(err)
+	}
+	var r recorder
+	if n, err := outbox.Relay(ctx, 10, time.Minute, r.publish); err != nil || n != 0 || len(r.events) != 0 {
+		t.Errorf("Relay while another relay runs = %d, %v, published %v; want nothing", n, err, r.aggregateIDs())
+	}
+}
+
+func TestOutboxRelayHold(t *testing.T) {
+	outbox, _ := newOutbox(t, 1, 2, 3)
+	ctx := context.Background()
+
+	// The second event outlasts the hold; it and those after it are left for the next call.
+	var published []int64
+	slow := func(ctx context.Context, event *models.OutboxEvent) error {
+		if event.AggregateID == 2 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		published = append(published, event.AggregateID)
+		return nil
+	}
+	n, err := outbox.Relay(ctx, 10, 100*time.Millisecond, slow)
+	if err != nil || n != 1 {
+		t.Fatalf("Relay = %d, %v; want 1, nil", n, err)
+	}
+	var r recorder
+	if n, err := outbox.Relay(ctx, 10, time.Minute, r.publish); err != nil || n != 2 {
+		t.Fatalf("Relay after the hold = %d, %v; want 2, nil", n, err)
+	}
+	if got, want := r.aggregateIDs(), []int64{2, 3}; !slices.Equal(got, want) {
+		t.Errorf("published %v after the hold, want %v", got, want)
+	}
+
+	// A relay that is itself cancelled reports it.
+	outbox, _ = newOutbox(t, 1)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := outbox.Relay(cancelled, 10, time.Minute, r.publish); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled Relay error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestOutboxPurge(t *testing.T) {
+	outbox, db := newOutbox(t, 1, 2, 3)
+	ctx := context.Background()
+	_, err := db.Exec(ctx, `UPDATE outbox SET published_at=NOW()-interval '8 days' WHERE aggregate_id=1;
+	UPDATE outbox SET published_at=NOW() WHERE aggregate_id=2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := outbox.Purge(ctx, time.Now().Add(-7*24*time.Hour)); err != nil || n != 1 {
+		t.Fatalf("Purge = %d, %v; want 1, nil", n, err)
+	}
+	var left []int64
+	rows, err := db.Query(ctx, `SELECT aggregate_id FROM outbox ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		left = append(left, id)
+	}
+	if want := []int64{2, 3}; !slices.Equal(left, want) {
+		t.Errorf("outbox after Purge holds %v, want %v", left, want)
+	}
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/themilar/plibrary/internal"
+	"github.com/themilar/plibrary/internal/cql"
+)
+
+// BookRepository stores the books of the catalogue. BookModel keeps them in Postgres and
+// MemoryBookModel in memory, for tests; the repotest package checks that both behave alike.
+type BookRepository interface {
+	All(ctx context.Context, title string, genres []string, filters internal.Filters, fields ...string) ([]*Book, *internal.PaginationMetadata, error)
+	FullTextSearch(ctx context.Context, title string, fields ...string) ([]*Book, error)
+	SearchCQL(ctx context.Context, query cql.Node, offset, limit int) ([]*Book, int, error)
+	ByAuthors(ctx context.Context, names []string) (map[string][]*Book, error)
+	Genres(ctx context.Context) ([]*Genre, error)
+	Harvest(ctx context.Context, from, until *time.Time, genre string, afterID int64, limit int) ([]*Book, int, error)
+	EarliestDatestamp(ctx context.Context) (time.Time, error)
+	Get(ctx context.Context, id int64, fields ...string) (*Book, error)
+	Insert(ctx context.Context, book *Book) error
+	Update(ctx context.Context, book *Book) error
+	Delete(ctx context.Context, id int64) error
+	ListenChanges(ctx context.Context, ready func(floor int64), handle func(*BookChange)) error
+}
+
+var (
+	_ BookRepository = BookModel{}
+	_ BookRepository = (*MemoryBookModel)(nil)
+)
@@ -0,0 +1,12 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/themilar/plibrary/internal/models/repotest"
+)
+
+func TestBookRepositories(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { repotest.Run(t, repotest.Memory) })
+	t.Run("postgres", func(t *testing.T) { repotest.Run(t, repotest.Postgres) })
+}
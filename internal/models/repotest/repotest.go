@@ -0,0 +1,429 @@
+// Package repotest is a conformance suite for implementations of models.BookRepository, so
+// that the in-memory model can stand in for Postgres in tests. Run it from a test with each
+// implementation:
+//
+//	func TestBookRepositories(t *testing.T) {
+//		t.Run("memory", func(t *testing.T) { repotest.Run(t, repotest.Memory) })
+//		t.Run("postgres", func(t *testing.T) { repotest.Run(t, repotest.Postgres) })
+//	}
+//
+// The Postgres run is skipped unless TEST_DATABASE_URL names a database the suite may create
+// schemas in.
+package repotest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/themilar/plibrary/internal"
+	"github.com/themilar/plibrary/internal/cql"
+	"github.com/themilar/plibrary/internal/e2etest"
+	"github.com/themilar/plibrary/internal/models"
+)
+
+// Memory returns an empty in-memory repository.
+func Memory(t *testing.T) models.BookRepository {
+	return models.NewMemoryBookModel()
+}
+
+// Postgres returns an empty BookModel on a schema of its own, created by e2etest.NewDatabase
+// and dropped once t completes.
+func Postgres(t *testing.T) models.BookRepository {
+	return models.BookModel{DB: e2etest.NewDatabase(t)}
+}
+
+// fixtures are inserted in order, so that they get ids 1 to 5. Titles are capitalized ASCII,
+// which sorts the same in byte order and in the usual database collations.
+func fixtures() []*models.Book {
+	return []*models.Book{
+		{Title: "Dune", Authors: []string{"Frank Herbert"}, Publisher: "Chilton Books", Published: 1965, Pages: 412, Genres: []string{"sci-fi", "adventure"}},
+		{Title: "Children Of Dune", Authors: []string{"Frank Herbert"}, Published: 1976, Pages: 444, Genres: []string{"sci-fi"}},
+		{Title: "The Hobbit", Authors: []string{"J. R. R. Tolkien"}, Published: 1937, Pages: 310, Genres: []string{"fantasy", "adventure"}},
+		{Title: "Good Omens", Authors: []string{"Terry Pratchett", "Neil Gaiman"}, Published: 1990, Pages: 288, Genres: []string{"fantasy", "comedy"}},
+		{Title: "Neuromancer", Published: 1984, Pages: 271, Genres: []string{"sci-fi", "cyberpunk"}},
+	}
+}
+
+// Run checks that the repositories built by newRepo behave like BookModel. Every subtest
+// gets a new, empty repository.
+func Run(t *testing.T, newRepo func(t *testing.T) models.BookRepository) {
+	for _, test := range []struct {
+		name string
+		// seed inserts the fixtures before the test runs.
+		seed bool
+		run  func(t *testing.T, repo models.BookRepository)
+	}{
+		{"InsertGet", false, testInsertGet},
+		{"Update", true, testUpdate},
+		{"Delete", true, testDelete},
+		{"All", true, testAll},
+		{"FullTextSearch", true, testFullTextSearch},
+		{"SearchCQL", true, testSearchCQL},
+		{"ByAuthors", true, testByAuthors},
+		{"Genres", true, testGenres},
+		{"Harvest", true, testHarvest},
+		{"ListenChanges", false, testListenChanges},
+		{"Canceled", true, testCanceled},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			repo := newRepo(t)
+			if test.seed {
+				for _, book := range fixtures() {
+					if err := repo.Insert(context.Background(), book); err != nil {
+						t.Fatalf("insert %q: %v", book.Title, err)
+					}
+				}
+			}
+			test.run(t, repo)
+		})
+	}
+}
+
+func ids(books []*models.Book) []int64 {
+	ids := make([]int64, len(books))
+	for i, book := range books {
+		ids[i] = book.ID
+	}
+	return ids
+}
+
+func testInsertGet(t *testing.T, repo models.BookRepository) {
+	ctx := context.Background()
+	if earliest, err := repo.EarliestDatestamp(ctx); err != nil || !earliest.IsZero() {
+		t.Fatalf("EarliestDatestamp of no books = %v, %v, want zero time", earliest, err)
+	}
+	book := fixtures()[4]
+	if err := repo.Insert(ctx, book); err != nil {
+		t.Fatal(err)
+	}
+	if book.ID != 1 || book.Version != 1 || book.CreatedAt.IsZero() || !book.UpdatedAt.Equal(book.CreatedAt) {
+		t.Fatalf("inserted book has id %d, version %d, created at %v, updated at %v", book.ID, book.Version, book.CreatedAt, book.UpdatedAt)
+	}
+	if book.Authors == nil {
+		t.Error("Insert left nil authors")
+	}
+
+	got, err := repo.Get(ctx, book.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != book.Title || got.Pages != book.Pages || !slices.Equal(got.Genres, book.Genres) || got.Authors == nil || len(got.Authors) != 0 || !got.CreatedAt.Equal(book.CreatedAt) {
+		t.Errorf("Get = %+v, want %+v", got, book)
+	}
+	got, err = repo.Get(ctx, book.ID, "title", "pages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (models.Book{ID: book.ID, Title: book.Title, Pages: book.Pages}); got.ID != want.ID || got.Title != want.Title || got.Pages != want.Pages || got.Genres != nil || got.Version != 0 {
+		t.Errorf("Get with fields = %+v, want %+v", got, want)
+	}
+	for _, id := range []int64{0, 2} {
+		if _, err := repo.Get(ctx, id); !errors.Is(err, models.ErrRecordNotFound) {
+			t.Errorf("Get(%d) error = %v, want ErrRecordNotFound", id, err)
+		}
+	}
+	if earliest, err := repo.EarliestDatestamp(ctx); err != nil || !earliest.Equal(book.UpdatedAt) {
+		t.Errorf("EarliestDatestamp = %v, %v, want %v", earliest, err, book.UpdatedAt)
+	}
+}
+
+func testUpdate(t *testing.T, repo models.BookRepository) {
+	ctx := context.Background()
+	book, err := repo.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := *book
+	book.Pages = 896
+	if err := repo.Update(ctx, book); err != nil {
+		t.Fatal(err)
+	}
+	if book.Version != 2 || book.UpdatedAt.Before(book.CreatedAt) {
+		t.Errorf("updated book has version %d, updated at %v", book.Version, book.UpdatedAt)
+	}
+	if err := repo.Update(ctx, &stale); !errors.Is(err, models.ErrEditConflict) {
+		t.Errorf("Update of a stale version error = %v, want ErrEditConflict", err)
+	}
+	if err := repo.Update(ctx, &models.Book{ID: 99, Version: 1, Title: "Missing", Published: 2000, Pages: 1, Genres: []string{"x"}}); !errors.Is(err, models.ErrEditConflict) {
+		t.Errorf("Update of a missing book error = %v, want ErrEditConflict", err)
+	}
+	got, err := repo.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Pages != 896 || got.Version != 2 || !got.CreatedAt.Equal(stale.CreatedAt) {
+		t.Errorf("Get after Update = %+v", got)
+	}
+}
+
+func testDelete(t *testing.T, repo models.BookRepository) {
+	ctx := context.Background()
+	if err := repo.Delete(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{3, 0, 99} {
+		if err := repo.Delete(ctx, id); !errors.Is(err, models.ErrRecordNotFound) {
+			t.Errorf("Delete(%d) error = %v, want ErrRecordNotFound", id, err)
+		}
+	}
+	if _, err := repo.Get(ctx, 3); !errors.Is(err, models.ErrRecordNotFound) {
+		t.Errorf("Get of a deleted book error = %v, want ErrRecordNotFound", err)
+	}
+}
+
+func testAll(t *testing.T, repo models.BookRepository) {
+	for _, test := range []struct {
+		name    string
+		title   string
+		genres  []string
+		filters internal.Filters
+		want    []int64
+		total   int
+	}{
+		{"everything", "", []string{}, internal.Filters{Page: 1, Size: 20, Sort: "id"}, []int64{1, 2, 3, 4, 5}, 5},
+		{"title", "dune", []string{}, internal.Filters{Page: 1, Size: 20, Sort: "id"}, []int64{1}, 1},
+		{"genres", "", []string{"sci-fi", "adventure"}, internal.Filters{Page: 1, Size: 20, Sort: "id"}, []int64{1}, 1},
+		{"sort by title", "", []string{}, internal.Filters{Page: 1, Size: 20, Sort: "title"}, []int64{2, 1, 4, 5, 3}, 5},
+		{"sort descending", "", []string{}, internal.Filters{Page: 1, Size: 20, Sort: "-published"}, []int64{4, 5, 2, 1, 3}, 5},
+		{"ties by id", "", []string{"sci-fi"}, internal.Filters{Page: 1, Size: 20, Sort: "-pages"}, []int64{2, 1, 5}, 3},
+		{"second page", "", []string{}, internal.Filters{Page: 2, Size: 2, Sort: "pages"}, []int64{3, 1}, 5},
+		{"past the end", "", []string{}, internal.Filters{Page: 4, Size: 2, Sort: "id"}, []int64{}, 0},
+		{"no match", "Missing", []string{}, internal.Filters{Page: 1, Size: 20, Sort: "id"}, []int64{}, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			books, metadata, err := repo.All(context.Background(), test.title, test.genres, test.filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(books); !slices.Equal(got, test.want) {
+				t.Errorf("ids = %v, want %v", got, test.want)
+			}
+			if want := internal.CalculateMetadata(test.total, test.filters.Page, test.filters.Size); *metadata != *want {
+				t.Errorf("metadata = %+v, want %+v", *metadata, *want)
+			}
+		})
+	}
+
+	books, _, err := repo.All(context.Background(), "", []string{}, internal.Filters{Page: 1, Size: 1, Sort: "id"}, "id", "title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 1 || books[0].ID != 1 || books[0].Title != "Dune" || books[0].Pages != 0 || books[0].Genres != nil {
+		t.Errorf("All with fields = %+v", books[0])
+	}
+}
+
+func testFullTextSearch(t *testing.T, repo models.BookRepository) {
+	for query, want := range map[string][]int64{
+		"dune":        {1, 2},
+		"DUNE":        {1, 2},
+		"dune of":     {2},
+		"of dune":     {2},
+		"hobbit":      {3},
+		"":            {1, 2, 3, 4, 5},
+		"dune hobbit": {},
+		"zzz":         {},
+	} {
+		books, err := repo.FullTextSearch(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(books); !slices.Equal(got, want) {
+			t.Errorf("FullTextSearch(%q) = %v, want %v", query, got, want)
+		}
+	}
+}
+
+func testSearchCQL(t *testing.T, repo models.BookRepository) {
+	for _, test := range []struct {
+		query         string
+		offset, limit int
+		want          []int64
+		total         int
+	}{
+		{"dune", 0, 10, []int64{1, 2}, 2},
+		{`title = "children of"`, 0, 10, []int64{2}, 1},
+		{`title = "of children"`, 0, 10, []int64{}, 0},
+		{`title any "hobbit omens"`, 0, 10, []int64{3, 4}, 2},
+		{`title all "dune children"`, 0, 10, []int64{2}, 1},
+		{`title == "the hobbit"`, 0, 10, []int64{3}, 1},
+		{`title <> "dune"`, 0, 10, []int64{2, 3, 4, 5}, 4},
+		{"subject = fantasy", 0, 10, []int64{3, 4}, 2},
+		{"subject <> sci-fi", 0, 10, []int64{3, 4}, 2},
+		{`subject any "comedy cyberpunk"`, 0, 10, []int64{4, 5}, 2},
+		{`subject all "sci-fi adventure"`, 0, 10, []int64{1}, 1},
+		{"date < 1970", 0, 10, []int64{1, 3}, 2},
+		{"date >= 1984 and plib.pages > 280", 0, 10, []int64{4}, 1},
+		{"dc.identifier = 5 or subject = comedy", 0, 10, []int64{4, 5}, 2},
+		{"subject = sci-fi not dune", 0, 10, []int64{5}, 1},
+		{"cql.allrecords = 1", 1, 2, []int64{2, 3}, 5},
+		{"cql.allrecords = 1", 5, 2, []int64{}, 5},
+	} {
+		node, err := cql.Parse(test.query)
+		if err != nil {
+			t.Fatalf("parse %q: %v", test.query, err)
+		}
+		books, total, err := repo.SearchCQL(context.Background(), node, test.offset, test.limit)
+		if err != nil {
+			t.Errorf("SearchCQL(%q) error = %v", test.query, err)
+			continue
+		}
+		if got := ids(books); !slices.Equal(got, test.want) || total != test.total {
+			t.Errorf("SearchCQL(%q) = %v, %d, want %v, %d", test.query, got, total, test.want, test.total)
+		}
+	}
+
+	for _, query := range []string{"dc.creator = herbert", "date = soon", "subject < fantasy"} {
+		node, err := cql.Parse(query)
+		if err != nil {
+			t.Fatalf("parse %q: %v", query, err)
+		}
+		var cqlErr *cql.Error
+		if _, _, err := repo.SearchCQL(context.Background(), node, 0, 10); !errors.As(err, &cqlErr) {
+			t.Errorf("SearchCQL(%q) error = %v, want a *cql.Error", query, err)
+		}
+	}
+}
+
+func testByAuthors(t *testing.T, repo models.BookRepository) {
+	books, err := repo.ByAuthors(context.Background(), []string{"Frank Herbert", "Neil Gaiman", "Nobody"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 2 || !slices.Equal(ids(books["Frank Herbert"]), []int64{1, 2}) || !slices.Equal(ids(books["Neil Gaiman"]), []int64{4}) {
+		t.Errorf("ByAuthors = %v", books)
+	}
+	if books, err := repo.ByAuthors(context.Background(), nil); err != nil || len(books) != 0 {
+		t.Errorf("ByAuthors of no names = %v, %v", books, err)
+	}
+}
+
+func testGenres(t *testing.T, repo models.BookRepository) {
+	genres, err := repo.Genres(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.Genre{{Name: "adventure", Count: 2}, {Name: "comedy", Count: 1}, {Name: "cyberpunk", Count: 1}, {Name: "fantasy", Count: 2}, {Name: "sci-fi", Count: 3}}
+	got := make([]models.Genre, len(genres))
+	for i, genre := range genres {
+		got[i] = *genre
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Genres = %v, want %v", got, want)
+	}
+}
+
+func testHarvest(t *testing.T, repo models.BookRepository) {
+	ctx := context.Background()
+	books, total, err := repo.Harvest(ctx, nil, nil, "", 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids(books), []int64{1, 2}) || total != 5 {
+		t.Errorf("first page = %v, %d", ids(books), total)
+	}
+	books, total, err = repo.Harvest(ctx, nil, nil, "sci-fi", 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids(books), []int64{2, 5}) || total != 3 {
+		t.Errorf("sci-fi after 1 = %v, %d, want [2 5], 3", ids(books), total)
+	}
+	if books, total, err := repo.Harvest(ctx, nil, nil, "", 5, 10); err != nil || len(books) != 0 || total != 0 {
+		t.Errorf("past the end = %v, %d, %v", ids(books), total, err)
+	}
+
+	earliest, err := repo.EarliestDatestamp(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	future := earliest.Add(time.Hour)
+	if books, _, err := repo.Harvest(ctx, &future, nil, "", 0, 10); err != nil || len(books) != 0 {
+		t.Errorf("from an hour after the earliest datestamp = %v, %v", ids(books), err)
+	}
+	if books, _, err := repo.Harvest(ctx, &earliest, &future, "", 0, 10); err != nil || len(books) != 5 {
+		t.Errorf("from the earliest datestamp = %v, %v", ids(books), err)
+	}
+}
+
+func testListenChanges(t *testing.T, repo models.BookRepository) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	floors := make(chan int64, 1)
+	changes := make(chan *models.BookChange, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- repo.ListenChanges(ctx, func(floor int64) { floors <- floor }, func(change *models.BookChange) { changes <- change })
+	}()
+	var floor int64
+	select {
+	case floor = <-floors:
+	case err := <-done:
+		t.Fatalf("ListenChanges returned before it was ready: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListenChanges was not ready after 5s")
+	}
+
+	book := fixtures()[0]
+	if err := repo.Insert(ctx, book); err != nil {
+		t.Fatal(err)
+	}
+	book.Pages++
+	if err := repo.Update(ctx, book); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Delete(ctx, book.ID); err != nil {
+		t.Fatal(err)
+	}
+	seq := floor
+	for _, op := range []string{"insert", "update", "delete"} {
+		select {
+		case change := <-changes:
+			if change.Op != op || change.Seq <= seq || change.Book.ID != book.ID || change.Book.Title != book.Title {
+				t.Errorf("change = %+v %+v, want %s of book %d after seq %d", change, change.Book, op, book.ID, seq)
+			}
+			seq = change.Seq
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s change after 5s", op)
+		}
+	}
+	select {
+	case change := <-changes:
+		t.Errorf("unexpected change %+v", change)
+	default:
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("ListenChanges error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListenChanges did not return after its context was canceled")
+	}
+}
+
+func testCanceled(t *testing.T, repo models.BookRepository) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for name, call := range map[string]func() error{
+		"All": func() error {
+			_, _, err := repo.All(ctx, "", []string{}, internal.Filters{Page: 1, Size: 20, Sort: "id"})
+			return err
+		},
+		"Get":    func() error { _, err := repo.Get(ctx, 1); return err },
+		"Insert": func() error { return repo.Insert(ctx, fixtures()[0]) },
+		"Delete": func() error { return repo.Delete(ctx, 1) },
+	} {
+		if err := call(); !errors.Is(err, models.ErrCanceled) {
+			t.Errorf("%s with a canceled context error = %v, want ErrCanceled", name, err)
+		}
+	}
+	if _, err := repo.Get(context.Background(), 1); err != nil {
+		t.Errorf("Get after a canceled Delete: %v", err)
+	}
+}
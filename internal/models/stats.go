@@ -0,0 +1,35 @@
+package models
+
+import "context"
+
+// Stats are catalogue totals reported as metrics. Copies counts copies by status; active
+// loans are the copies on loan.
+type Stats struct {
+	Books  int
+	Copies map[string]int
+}
+
+func (m Models) Stats() (*Stats, error) {
+	ctx := context.Background()
+	stats := &Stats{Copies: map[string]int{"available": 0, "on_loan": 0, "on_hold": 0, "lost": 0}}
+	if err := m.Copies.DB.QueryRow(ctx, `SELECT count(*) FROM books`).Scan(&stats.Books); err != nil {
+		return nil, err
+	}
+	rows, err := m.Copies.DB.Query(ctx, `SELECT status,count(*) FROM copies GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		stats.Copies[status] = count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/themilar/plibrary/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/themilar/plibrary/internal/models")
+
+// Row counts recorded on query spans.
+var (
+	returnedRows = attribute.Key("db.response.returned_rows")
+	affectedRows = attribute.Key("db.response.affected_rows")
+)
+
+// query is a statement run by a model, traced as a span and logged at debug level by the
+// logger of its context once it ends.
+type query struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	span   trace.Span
+	name   string
+	start  time.Time
+}
+
+// startQuery starts the span of a query on a table, recording its SQL statement. The context
+// it returns is done once the query ends, or after timeout when it is positive.
+func startQuery(ctx context.Context, timeout time.Duration, name, operation, table, statement string) (context.Context, *query) {
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemPostgreSQL,
+		semconv.DBOperationName(operation),
+		semconv.DBCollectionName(table),
+		semconv.DBQueryText(statement),
+	))
+	return ctx, &query{ctx: ctx, cancel: cancel, span: span, name: name, start: time.Now()}
+}
+
+// abandoned wraps err, returned by a query whose context is done with ctxErr, in ErrTimeout
+// or ErrCanceled.
+func abandoned(ctxErr, err error) error {
+	if errors.Is(ctxErr, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return fmt.Errorf("%w: %w", ErrCanceled, err)
+}
+
+// end ends the query with the given attributes, marking it failed when err is not nil. It
+// returns err so that callers can end the query as they return, wrapped in ErrCanceled or
+// ErrTimeout when the query failed because its context was done.
+func (q *query) end(err error, attrs ...attribute.KeyValue) error {
+	if ctxErr := q.ctx.Err(); err != nil && ctxErr != nil {
+		err = abandoned(ctxErr, err)
+	}
+	q.cancel()
+	q.span.SetAttributes(attrs...)
+	args := []any{"query", q.name, "duration", time.Since(q.start).String()}
+	for _, attr := range attrs {
+		args = append(args, string(attr.Key), attr.Value.AsInterface())
+	}
+	if err != nil {
+		q.span.RecordError(err)
+		q.span.SetStatus(codes.Error, err.Error())
+		args = append(args, "error", err.Error())
+	}
+	q.span.End()
+	logging.FromContext(q.ctx).Debug("query finished", args...)
+	return err
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/themilar/plibrary/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestQuerySpans(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	var logs bytes.Buffer
+	ctx := logging.WithLogger(context.Background(), slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	parent, span := otel.Tracer("test").Start(ctx, "bookList")
+	qctx, q := startQuery(parent, 0, "BookModel.All", "SELECT", "books", "SELECT id FROM books")
+	if q.end(nil, returnedRows.Int(3)) != nil {
+		t.Fatal("end returned an error for a successful query")
+	}
+	if qctx.Err() == nil {
+		t.Error("the context of a query is not done once it ends")
+	}
+	span.End()
+
+	ended := spans.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(ended))
+	}
+	got := ended[0]
+	if got.Name() != "BookModel.All" || got.SpanKind() != trace.SpanKindClient || got.Parent().SpanID() != ended[1].SpanContext().SpanID() {
+		t.Errorf("query span %q of kind %v is not a client span beneath the handler", got.Name(), got.SpanKind())
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, attr := range got.Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	for key, want := range map[attribute.Key]string{
+		"db.system":                 "postgresql",
+		"db.operation.name":         "SELECT",
+		"db.collection.name":        "books",
+		"db.query.text":             "SELECT id FROM books",
+		"db.response.returned_rows": "3",
+	} {
+		if attrs[key].Emit() != want {
+			t.Errorf("query span %s = %q, want %q", key, attrs[key].Emit(), want)
+		}
+	}
+	if !strings.Contains(logs.String(), "level=DEBUG msg=\"query finished\" query=BookModel.All") ||
+		!strings.Contains(logs.String(), "db.response.returned_rows=3") {
+		t.Errorf("the query is not logged at debug level:\n%s", logs.String())
+	}
+}
+
+func TestQueryEnd(t *testing.T) {
+	failure := errors.New("syntax error")
+
+	_, q := startQuery(context.Background(), 0, "BookModel.Get", "SELECT", "books", "SELECT")
+	if err := q.end(failure); err != failure {
+		t.Errorf("end(%v) = %v", failure, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, q = startQuery(ctx, 0, "BookModel.Get", "SELECT", "books", "SELECT")
+	cancel()
+	if err := q.end(failure); !errors.Is(err, ErrCanceled) || !errors.Is(err, failure) {
+		t.Errorf("end after the request was canceled = %v, want ErrCanceled", err)
+	}
+
+	qctx, q := startQuery(context.Background(), time.Nanosecond, "BookModel.Get", "SELECT", "books", "SELECT")
+	<-qctx.Done()
+	if err := q.end(failure); !errors.Is(err, ErrTimeout) {
+		t.Errorf("end after the query timeout = %v, want ErrTimeout", err)
+	}
+	// Only failed queries are abandoned.
+	_, q = startQuery(ctx, 0, "BookModel.Get", "SELECT", "books", "SELECT")
+	if err := q.end(nil); err != nil {
+		t.Errorf("end(nil) after the request was canceled = %v", err)
+	}
+}
+
+func TestQuerySpanError(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	ctx, span := provider.Tracer("test").Start(context.Background(), "query")
+	q := &query{ctx: ctx, cancel: func() {}, span: span, name: "BookModel.Insert", start: time.Now()}
+	q.end(errors.New("duplicate key"))
+
+	got := spans.Ended()[0]
+	if got.Status().Code != codes.Error || got.Status().Description != "duplicate key" || len(got.Events()) != 1 || got.Events()[0].Name != "exception" {
+		t.Errorf("failed query span has status %v and events %v", got.Status(), got.Events())
+	}
+}
@@ -0,0 +1,346 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Delivery statuses. Pending deliveries are retried until they succeed or run out of
+// attempts, when they are dead-lettered.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Webhook is a subscription to catalogue events, delivered as signed POST requests to URL.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	URL       string    `json:"url" validate:"required,http_url,max=2000"`
+	// Secret signs the deliveries. It is only shown when the webhook is created.
+	Secret  string   `json:"-" validate:"required,min=16,max=200"`
+	Events  []string `json:"events" validate:"required,gt=0,unique,dive,oneof=book.created book.updated book.deleted"`
+	Active  bool     `json:"active"`
+	Version int      `json:"version"`
+}
+
+type WebhookModel struct {
+	DB *pgxpool.Pool
+}
+
+func (w WebhookModel) Insert(webhook *Webhook) error {
+	query := `INSERT INTO webhooks (url,secret,events,active) VALUES ($1,$2,$3,$4) RETURNING id,created_at,version`
+	params := []any{webhook.URL, webhook.Secret, webhook.Events, webhook.Active}
+	return w.DB.QueryRow(context.Background(), query, params...).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+}
+
+func (w WebhookModel) Get(id int64) (*Webhook, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT id,created_at,url,secret,events,active,version FROM webhooks WHERE id=$1`
+	var webhook Webhook
+	err := w.DB.QueryRow(context.Background(), query, id).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.URL, &webhook.Secret, &webhook.Events, &webhook.Active, &webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &webhook, nil
+}
+
+func (w WebhookModel) All() ([]*Webhook, error) {
+	query := `SELECT id,created_at,url,secret,events,active,version FROM webhooks ORDER BY id`
+	rows, err := w.DB.Query(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		var webhook Webhook
+		err := rows.Scan(&webhook.ID, &webhook.CreatedAt, &webhook.URL, &webhook.Secret, &webhook.Events, &webhook.Active, &webhook.Version)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, &webhook)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (w WebhookModel) Update(webhook *Webhook) error {
+	query := `UPDATE webhooks SET url=$1,events=$2,active=$3,version=version+1 WHERE id=$4 AND version=$5 RETURNING version`
+	params := []any{webhook.URL, webhook.Events, webhook.Active, webhook.ID, webhook.Version}
+	err := w.DB.QueryRow(context.Background(), query, params...).Scan(&webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (w WebhookModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	result, err := w.DB.Exec(context.Background(), `DELETE FROM webhooks WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (webhook *Webhook) Validate() map[string]string {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	jv := JsonValidationError{
+		Errors: make(map[string]string),
+	}
+	err := validate.Struct(webhook)
+	if err != nil {
+		var validateErrs validator.ValidationErrors
+		if errors.As(err, &validateErrs) {
+			for _, e := range validateErrs {
+				// Errors on single events are reported on the list.
+				field, _, _ := strings.Cut(strings.ToLower(e.Field()), "[")
+				switch {
+				case e.Tag() == "required":
+					jv.AddError(field, "must be provided")
+				case e.Tag() == "http_url":
+					jv.AddError(field, "must be an absolute http or https URL")
+				case e.Tag() == "max":
+					jv.AddError(field, fmt.Sprintf("above the character limit: %v", e.Param()))
+				case e.Tag() == "min":
+					jv.AddError(field, fmt.Sprintf("must be at least %v characters long", e.Param()))
+				case e.Tag() == "gt":
+					jv.AddError(field, "must contain at least one event")
+				case e.Tag() == "unique":
+					jv.AddError(field, "cannot contain duplicate events")
+				case e.Tag() == "oneof":
+					jv.AddError(field, fmt.Sprintf("unknown event %q, must be one of: %s", e.Value(), strings.ReplaceAll(e.Param(), " ", ", ")))
+				}
+			}
+			return jv.Errors
+		}
+		return nil
+	}
+	return nil
+}
+
+// Delivery is one event sent to one webhook, along with the attempts made so far.
+type Delivery struct {
+	ID            int64              `json:"id"`
+	WebhookID     int64              `json:"webhook_id"`
+	CreatedAt     time.Time          `json:"created_at"`
+	Event         string             `json:"event"`
+	Payload       json.RawMessage    `json:"payload"`
+	Status        string             `json:"status"`
+	Attempts      int                `json:"attempts"`
+	NextAttemptAt time.Time          `json:"next_attempt_at"`
+	Log           []*DeliveryAttempt `json:"log,omitempty"`
+}
+
+// DeliveryAttempt records the outcome of one request made for a delivery. ResponseStatus is
+// zero when no response was received, in which case Error says why.
+type DeliveryAttempt struct {
+	AttemptedAt    time.Time `json:"attempted_at"`
+	DurationMS     int       `json:"duration_ms"`
+	ResponseStatus int       `json:"response_status,omitempty"`
+	ResponseBody   string    `json:"response_body,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// DueDelivery is a delivery claimed for sending, with the target of its webhook.
+type DueDelivery struct {
+	Delivery
+	URL    string
+	Secret string
+}
+
+type DeliveryModel struct {
+	DB *pgxpool.Pool
+}
+
+const deliveryColumns = `d.id,d.webhook_id,d.created_at,d.event,d.payload,d.status,d.attempts,d.next_attempt_at`
+
+func (delivery *Delivery) scanTargets() []any {
+	return []any{&delivery.ID, &delivery.WebhookID, &delivery.CreatedAt, &delivery.Event, &delivery.Payload, &delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt}
+}
+
+// Enqueue queues payload for every active webhook subscribed to event, returning the number
+// of deliveries created. An event is only queued once per webhook however often it is
+// enqueued under the same eventID.
+func (d DeliveryModel) Enqueue(eventID, event string, payload []byte) (int, error) {
+	query := `INSERT INTO webhook_deliveries (webhook_id,event_id,event,payload)
+	SELECT id,$1::uuid,$2,$3 FROM webhooks WHERE active AND $2=ANY(events)
+	ON CONFLICT (webhook_id,event_id) DO NOTHING`
+	result, err := d.DB.Exec(context.Background(), query, eventID, event, payload)
+	if err != nil {
+		return 0, err
+	}
+	return int(result.RowsAffected()), nil
+}
+
+// ForWebhook returns the latest deliveries of a webhook, newest first, optionally only those
+// with the given status.
+func (d DeliveryModel) ForWebhook(webhookID int64, status string, limit int) ([]*Delivery, error) {
+	query := fmt.Sprintf(`SELECT %s FROM webhook_deliveries d
+	WHERE d.webhook_id=$1 AND (d.status=$2 OR $2='')
+	ORDER BY d.id DESC LIMIT $3`, deliveryColumns)
+	rows, err := d.DB.Query(context.Background(), query, webhookID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*Delivery{}
+	for rows.Next() {
+		var delivery Delivery
+		if err := rows.Scan(delivery.scanTargets()...); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// Get returns a delivery of a webhook with the log of its attempts.
+func (d DeliveryModel) Get(webhookID, id int64) (*Delivery, error) {
+	query := fmt.Sprintf(`SELECT %s FROM webhook_deliveries d WHERE d.id=$1 AND d.webhook_id=$2`, deliveryColumns)
+	var delivery Delivery
+	err := d.DB.QueryRow(context.Background(), query, id, webhookID).Scan(delivery.scanTargets()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `SELECT attempted_at,duration_ms,COALESCE(response_status,0),response_body,error
+	FROM webhook_delivery_attempts WHERE delivery_id=$1 ORDER BY id`
+	rows, err := d.DB.Query(context.Background(), query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	delivery.Log = []*DeliveryAttempt{}
+	for rows.Next() {
+		var attempt DeliveryAttempt
+		if err := rows.Scan(&attempt.AttemptedAt, &attempt.DurationMS, &attempt.ResponseStatus, &attempt.ResponseBody, &attempt.Error); err != nil {
+			return nil, err
+		}
+		delivery.Log = append(delivery.Log, &attempt)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// Redeliver queues a new delivery with the event and payload of an earlier one, leaving the
+// earlier delivery and its log untouched.
+func (d DeliveryModel) Redeliver(webhookID, id int64) (*Delivery, error) {
+	query := fmt.Sprintf(`INSERT INTO webhook_deliveries AS d (webhook_id,event,payload)
+	SELECT webhook_id,event,payload FROM webhook_deliveries WHERE id=$1 AND webhook_id=$2
+	RETURNING %s`, deliveryColumns)
+	var delivery Delivery
+	err := d.DB.QueryRow(context.Background(), query, id, webhookID).Scan(delivery.scanTargets()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &delivery, nil
+}
+
+// Claim picks up to limit pending deliveries that are due, oldest first, for active webhooks.
+// Their next attempt is pushed back by lease so that other dispatchers skip them while they
+// are being sent; if the dispatcher dies, they become due again when the lease runs out.
+func (d DeliveryModel) Claim(limit int, lease time.Duration) ([]*DueDelivery, error) {
+	query := fmt.Sprintf(`WITH due AS (
+		SELECT d.id FROM webhook_deliveries d JOIN webhooks w ON w.id=d.webhook_id
+		WHERE d.status='pending' AND d.next_attempt_at<=NOW() AND w.active
+		ORDER BY d.next_attempt_at
+		LIMIT $1
+		FOR UPDATE OF d SKIP LOCKED
+	)
+	UPDATE webhook_deliveries d SET next_attempt_at=NOW()+make_interval(secs => $2)
+	FROM due, webhooks w
+	WHERE d.id=due.id AND w.id=d.webhook_id
+	RETURNING %s,w.url,w.secret`, deliveryColumns)
+	rows, err := d.DB.Query(context.Background(), query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*DueDelivery{}
+	for rows.Next() {
+		var delivery DueDelivery
+		if err := rows.Scan(append(delivery.scanTargets(), &delivery.URL, &delivery.Secret)...); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// RecordAttempt logs an attempt and moves the delivery to status, to be tried again at next
+// when it is still pending.
+func (d DeliveryModel) RecordAttempt(id int64, attempt *DeliveryAttempt, status string, next time.Time) error {
+	ctx := context.Background()
+	tx, err := d.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var responseStatus *int
+	if attempt.ResponseStatus != 0 {
+		responseStatus = &attempt.ResponseStatus
+	}
+	query := `INSERT INTO webhook_delivery_attempts (delivery_id,attempted_at,duration_ms,response_status,response_body,error)
+	VALUES ($1,$2,$3,$4,$5,$6)`
+	params := []any{id, attempt.AttemptedAt, attempt.DurationMS, responseStatus, attempt.ResponseBody, attempt.Error}
+	if _, err := tx.Exec(ctx, query, params...); err != nil {
+		return err
+	}
+	query = `UPDATE webhook_deliveries SET status=$1,attempts=attempts+1,next_attempt_at=$2 WHERE id=$3`
+	if _, err := tx.Exec(ctx, query, status, next, id); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
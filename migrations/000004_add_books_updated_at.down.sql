@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS books_updated_at_idx;
+ALTER TABLE books DROP COLUMN IF EXISTS updated_at;
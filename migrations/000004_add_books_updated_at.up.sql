@@ -0,0 +1,3 @@
+ALTER TABLE books ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+UPDATE books SET updated_at = created_at;
+CREATE INDEX IF NOT EXISTS books_updated_at_idx ON books (updated_at);
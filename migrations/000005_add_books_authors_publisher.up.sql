@@ -0,0 +1,2 @@
+ALTER TABLE books ADD COLUMN IF NOT EXISTS authors text[] NOT NULL DEFAULT '{}';
+ALTER TABLE books ADD COLUMN IF NOT EXISTS publisher text NOT NULL DEFAULT '';
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS copies(
+    id bigserial PRIMARY KEY,
+    book_id bigint NOT NULL REFERENCES books ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    barcode text NOT NULL UNIQUE,
+    branch text NOT NULL,
+    status text NOT NULL DEFAULT 'available',
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT copies_status_check CHECK (status IN ('available', 'on_loan', 'on_hold', 'lost'))
+);
+CREATE INDEX IF NOT EXISTS copies_book_id_idx ON copies (book_id);
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS webhooks(
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    url text NOT NULL,
+    secret text NOT NULL,
+    events text[] NOT NULL,
+    active boolean NOT NULL DEFAULT true,
+    version integer NOT NULL DEFAULT 1
+);
+CREATE TABLE IF NOT EXISTS webhook_deliveries(
+    id bigserial PRIMARY KEY,
+    webhook_id bigint NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    event text NOT NULL,
+    payload jsonb NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT webhook_deliveries_status_check CHECK (status IN ('pending', 'delivered', 'dead'))
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, id);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE TABLE IF NOT EXISTS webhook_delivery_attempts(
+    id bigserial PRIMARY KEY,
+    delivery_id bigint NOT NULL REFERENCES webhook_deliveries ON DELETE CASCADE,
+    attempted_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    duration_ms integer NOT NULL,
+    response_status integer,
+    response_body text NOT NULL DEFAULT '',
+    error text NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS webhook_delivery_attempts_delivery_id_idx ON webhook_delivery_attempts (delivery_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS webhook_deliveries_event_id_idx;
+ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS event_id;
+DROP TABLE IF EXISTS outbox;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS outbox(
+    id bigserial PRIMARY KEY,
+    dedup_id uuid NOT NULL DEFAULT gen_random_uuid() UNIQUE,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    event text NOT NULL,
+    aggregate_id bigint NOT NULL,
+    payload jsonb NOT NULL,
+    published_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL;
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS event_id uuid;
+CREATE UNIQUE INDEX IF NOT EXISTS webhook_deliveries_event_id_idx ON webhook_deliveries (webhook_id, event_id);
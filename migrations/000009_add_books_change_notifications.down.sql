@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS books_notify_change ON books;
+DROP FUNCTION IF EXISTS notify_book_change();
+DROP SEQUENCE IF EXISTS book_changes_seq;
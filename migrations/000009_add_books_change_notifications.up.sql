@@ -0,0 +1,32 @@
+CREATE SEQUENCE IF NOT EXISTS book_changes_seq;
+CREATE OR REPLACE FUNCTION notify_book_change() RETURNS trigger AS $$
+DECLARE
+    book books%ROWTYPE;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        book := OLD;
+    ELSE
+        book := NEW;
+    END IF;
+    -- Pages are sent as a string, as the API renders them.
+    PERFORM pg_notify('book_changes', json_build_object(
+        'seq', nextval('book_changes_seq'),
+        'op', lower(TG_OP),
+        'book', json_build_object(
+            'id', book.id,
+            'title', book.title,
+            'authors', book.authors,
+            'publisher', book.publisher,
+            'published', book.published,
+            'pages', book.pages::text,
+            'genres', book.genres,
+            'version', book.version
+        )
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS books_notify_change ON books;
+CREATE TRIGGER books_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON books
+    FOR EACH ROW EXECUTE FUNCTION notify_book_change();
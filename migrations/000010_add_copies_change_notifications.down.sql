@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS copies_notify_change ON copies;
+DROP FUNCTION IF EXISTS notify_copy_change();
@@ -0,0 +1,31 @@
+CREATE OR REPLACE FUNCTION notify_copy_change() RETURNS trigger AS $$
+DECLARE
+    previous text := '';
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        IF OLD.status = NEW.status THEN
+            RETURN NULL;
+        END IF;
+        previous := OLD.status;
+    END IF;
+    PERFORM pg_notify('copy_changes', json_build_object(
+        'copy', json_build_object(
+            'id', NEW.id,
+            'book_id', NEW.book_id,
+            'barcode', NEW.barcode,
+            'branch', NEW.branch,
+            'status', NEW.status,
+            'version', NEW.version
+        ),
+        'previous_status', previous
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS copies_notify_change ON copies;
+CREATE TRIGGER copies_notify_change
+    AFTER INSERT OR UPDATE OF status ON copies
+    FOR EACH ROW EXECUTE FUNCTION notify_copy_change();
+DROP INDEX IF EXISTS copies_patron_idx;
+ALTER TABLE copies DROP CONSTRAINT IF EXISTS copies_patron_check;
+ALTER TABLE copies DROP COLUMN IF EXISTS patron;
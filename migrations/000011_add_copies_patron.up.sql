@@ -0,0 +1,36 @@
+ALTER TABLE copies ADD COLUMN IF NOT EXISTS patron text;
+ALTER TABLE copies DROP CONSTRAINT IF EXISTS copies_patron_check;
+ALTER TABLE copies ADD CONSTRAINT copies_patron_check CHECK (patron IS NULL OR status IN ('on_loan', 'on_hold'));
+CREATE INDEX IF NOT EXISTS copies_patron_idx ON copies (patron) WHERE patron IS NOT NULL;
+CREATE OR REPLACE FUNCTION notify_copy_change() RETURNS trigger AS $$
+DECLARE
+    previous text := '';
+    previous_patron text := '';
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        IF OLD.status = NEW.status AND OLD.patron IS NOT DISTINCT FROM NEW.patron THEN
+            RETURN NULL;
+        END IF;
+        previous := OLD.status;
+        previous_patron := coalesce(OLD.patron, '');
+    END IF;
+    PERFORM pg_notify('copy_changes', json_build_object(
+        'copy', json_build_object(
+            'id', NEW.id,
+            'book_id', NEW.book_id,
+            'barcode', NEW.barcode,
+            'branch', NEW.branch,
+            'status', NEW.status,
+            'version', NEW.version
+        ),
+        'previous_status', previous,
+        'patron', coalesce(NEW.patron, ''),
+        'previous_patron', previous_patron
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS copies_notify_change ON copies;
+CREATE TRIGGER copies_notify_change
+    AFTER INSERT OR UPDATE OF status, patron ON copies
+    FOR EACH ROW EXECUTE FUNCTION notify_copy_change();
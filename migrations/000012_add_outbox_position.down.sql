@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS outbox_unpublished_position_idx;
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL;
+DROP INDEX IF EXISTS outbox_position_idx;
+ALTER TABLE outbox DROP COLUMN IF EXISTS position;
+DROP FUNCTION IF EXISTS next_outbox_position();
+DROP TABLE IF EXISTS outbox_position;
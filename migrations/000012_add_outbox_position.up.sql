@@ -0,0 +1,17 @@
+-- Positions are taken from a single counter row, which the transaction recording an event keeps
+-- locked until it commits. Positions are therefore gapless and follow commit order, unlike ids.
+CREATE TABLE IF NOT EXISTS outbox_position(
+    only_row boolean PRIMARY KEY DEFAULT true CHECK (only_row),
+    last bigint NOT NULL
+);
+INSERT INTO outbox_position (last) SELECT COALESCE(MAX(id), 0) FROM outbox ON CONFLICT DO NOTHING;
+CREATE OR REPLACE FUNCTION next_outbox_position() RETURNS bigint AS $$
+    UPDATE outbox_position SET last = last + 1 RETURNING last;
+$$ LANGUAGE sql;
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS position bigint;
+UPDATE outbox SET position = id WHERE position IS NULL;
+ALTER TABLE outbox ALTER COLUMN position SET DEFAULT next_outbox_position();
+ALTER TABLE outbox ALTER COLUMN position SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS outbox_position_idx ON outbox (position);
+DROP INDEX IF EXISTS outbox_unpublished_idx;
+CREATE INDEX IF NOT EXISTS outbox_unpublished_position_idx ON outbox (position) WHERE published_at IS NULL;
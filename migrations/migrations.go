@@ -0,0 +1,8 @@
+// Package migrations embeds the SQL migrations so that binaries can apply them without the
+// migrate tool. Files follow the golang-migrate naming scheme, {version}_{title}.{up|down}.sql.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,202 @@
+package client
+
+import (
+	"context"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Book is a book as returned by the API.
+type Book struct {
+	ID        int64    `json:"id"`
+	Title     string   `json:"title"`
+	Authors   []string `json:"authors,omitempty"`
+	Publisher string   `json:"publisher,omitempty"`
+	Published int      `json:"published"`
+	Pages     int      `json:"pages,omitempty,string"`
+	Genres    []string `json:"genres,omitempty"`
+	Version   int      `json:"version"`
+	// Copies is only filled in when requested with Include.
+	Copies []Copy `json:"copies,omitempty"`
+}
+
+// Copy is a physical copy of a book.
+type Copy struct {
+	ID      int64  `json:"id"`
+	BookID  int64  `json:"book_id"`
+	Barcode string `json:"barcode"`
+	Branch  string `json:"branch"`
+	Status  string `json:"status"`
+	Version int    `json:"version"`
+}
+
+// BookInput holds the fields of a new book.
+type BookInput struct {
+	Title     string   `json:"title"`
+	Authors   []string `json:"authors,omitempty"`
+	Publisher string   `json:"publisher,omitempty"`
+	Published int      `json:"published"`
+	Pages     int      `json:"pages"`
+	Genres    []string `json:"genres"`
+}
+
+// BookUpdate holds the fields to change on a book; nil fields are left as they are, so
+// clearing the authors takes an empty, non-nil slice.
+type BookUpdate struct {
+	Title     *string  `json:"title,omitempty"`
+	Authors   []string `json:"authors"`
+	Publisher *string  `json:"publisher,omitempty"`
+	Published *int     `json:"published,omitempty"`
+	Pages     *int     `json:"pages,omitempty"`
+	Genres    []string `json:"genres"`
+}
+
+// ListOptions filters and pages the book list. Zero values use the server defaults.
+type ListOptions struct {
+	Title  string
+	Genres []string
+	Page   int
+	Size   int
+	// Sort is a field name, prefixed with "-" for descending order.
+	Sort string
+	// Fields restricts the returned fields and Include embeds relations, such as "copies".
+	Fields  []string
+	Include []string
+}
+
+func (opts ListOptions) values() url.Values {
+	v := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	set("title", opts.Title)
+	set("genres", strings.Join(opts.Genres, ","))
+	if opts.Page > 0 {
+		v.Set("page", strconv.Itoa(opts.Page))
+	}
+	if opts.Size > 0 {
+		v.Set("size", strconv.Itoa(opts.Size))
+	}
+	set("sort", opts.Sort)
+	set("fields", strings.Join(opts.Fields, ","))
+	set("include", strings.Join(opts.Include, ","))
+	return v
+}
+
+// Metadata describes a page of results. It is empty when nothing matched.
+type Metadata struct {
+	CurrentPage  int `json:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty"`
+	TotalRecords int `json:"total_records,omitempty"`
+}
+
+// BookList is a page of books.
+type BookList struct {
+	Books    []*Book  `json:"books"`
+	Metadata Metadata `json:"metadata"`
+}
+
+// BooksService accesses the /v1/books endpoints.
+type BooksService struct {
+	client *Client
+}
+
+// List returns a single page of books.
+func (s *BooksService) List(ctx context.Context, opts ListOptions) (*BookList, error) {
+	var list BookList
+	err := s.client.do(ctx, http.MethodGet, "/v1/books", opts.values(), nil, nil, &list)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// All iterates over every book matching opts, fetching pages as needed starting from
+// opts.Page. Iteration stops after the first error.
+func (s *BooksService) All(ctx context.Context, opts ListOptions) iter.Seq2[*Book, error] {
+	return func(yield func(*Book, error) bool) {
+		page := max(opts.Page, 1)
+		for {
+			opts.Page = page
+			list, err := s.List(ctx, opts)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, book := range list.Books {
+				if !yield(book, nil) {
+					return
+				}
+			}
+			if len(list.Books) == 0 || page >= list.Metadata.LastPage {
+				return
+			}
+			page++
+		}
+	}
+}
+
+// Get returns the book with the given id.
+func (s *BooksService) Get(ctx context.Context, id int64) (*Book, error) {
+	var resp struct {
+		Book *Book `json:"book"`
+	}
+	err := s.client.do(ctx, http.MethodGet, "/v1/books/"+strconv.FormatInt(id, 10), nil, nil, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Book, nil
+}
+
+// Search returns the books whose titles contain the words of q.
+func (s *BooksService) Search(ctx context.Context, q string) ([]*Book, error) {
+	var resp struct {
+		Books []*Book `json:"books"`
+	}
+	err := s.client.do(ctx, http.MethodGet, "/v1/books/search", url.Values{"q": {q}}, nil, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Books, nil
+}
+
+// Create adds a book and returns it as stored.
+func (s *BooksService) Create(ctx context.Context, input BookInput) (*Book, error) {
+	var resp struct {
+		Book *Book `json:"book"`
+	}
+	err := s.client.do(ctx, http.MethodPost, "/v1/books", nil, nil, input, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Book, nil
+}
+
+// Update changes a book. When version is non-zero the update only succeeds if the book is
+// still at that version, failing with ErrEditConflict otherwise.
+func (s *BooksService) Update(ctx context.Context, id int64, update BookUpdate, version int) (*Book, error) {
+	var header http.Header
+	if version != 0 {
+		header = http.Header{"X-Expected-Version": {strconv.Itoa(version)}}
+	}
+	var resp struct {
+		Book *Book `json:"book"`
+	}
+	err := s.client.do(ctx, http.MethodPatch, "/v1/books/"+strconv.FormatInt(id, 10), nil, header, update, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Book, nil
+}
+
+// Delete removes a book.
+func (s *BooksService) Delete(ctx context.Context, id int64) error {
+	return s.client.do(ctx, http.MethodDelete, "/v1/books/"+strconv.FormatInt(id, 10), nil, nil, nil, nil)
+}
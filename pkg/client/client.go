@@ -0,0 +1,179 @@
+// Package client is a typed Go client for the plibrary API.
+//
+//	c, err := client.New("https://plibrary.example.com")
+//	if err != nil {
+//		// handle error
+//	}
+//	book, err := c.Books.Get(ctx, 13)
+//	if errors.Is(err, client.ErrNotFound) {
+//		// handle missing book
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultBackoff    = 500 * time.Millisecond
+	maxBackoff        = time.Minute
+)
+
+// Client talks to a plibrary server. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	userAgent  string
+	token      string
+	maxRetries int
+
+	Books *BooksService
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests, http.DefaultClient by default.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithMaxRetries sets how many times a rate limited request is retried, 3 by default.
+func WithMaxRetries(n int) Option {
+	return func(c *Client) {
+		c.maxRetries = n
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithToken sends token as a bearer token with every request.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// New returns a client for the server at baseURL, such as "http://localhost:4000".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("client: base URL %q must be absolute", baseURL)
+	}
+	c := &Client{
+		baseURL:    u,
+		httpClient: http.DefaultClient,
+		userAgent:  "plibrary-go-client",
+		maxRetries: defaultMaxRetries,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.Books = &BooksService{client: c}
+	return c, nil
+}
+
+// do sends a request with an optional JSON body and decodes a JSON response into out.
+// Requests rejected by the rate limiter are retried after the delay the server asks for.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+	u := c.baseURL.JoinPath(path)
+	u.RawQuery = query.Encode()
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		// Asking for problem+json as well gets machine readable error codes.
+		req.Header.Set("Accept", "application/json, application/problem+json")
+		req.Header.Set("User-Agent", c.userAgent)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		if in != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < c.maxRetries {
+			delay := retryDelay(resp.Header, attempt)
+			resp.Body.Close()
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+			continue
+		}
+		return decodeResponse(resp, out)
+	}
+}
+
+func decodeResponse(resp *http.Response, out any) error {
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return newError(resp, data)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("client: decoding %s response: %w", resp.Request.URL.Path, err)
+	}
+	return nil
+}
+
+// retryDelay picks the wait before retrying a rate limited request from Retry-After, then
+// X-RateLimit-Reset, falling back to exponential backoff.
+func retryDelay(header http.Header, attempt int) time.Duration {
+	if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil && seconds >= 0 {
+		return min(time.Duration(seconds)*time.Second, maxBackoff)
+	}
+	if when, err := http.ParseTime(header.Get("Retry-After")); err == nil {
+		return min(max(time.Until(when), 0), maxBackoff)
+	}
+	if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		return min(max(time.Until(time.Unix(reset, 0)), 0), maxBackoff)
+	}
+	return min(defaultBackoff<<attempt, maxBackoff)
+}
@@ -0,0 +1,263 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recorded is a request as seen by the test server.
+type recorded struct {
+	method, uri, body string
+	header            http.Header
+}
+
+// newTestClient starts a server answering every request with handler and returns a client
+// for it, along with the last request it received.
+func newTestClient(t *testing.T, handler http.HandlerFunc, opts ...Option) (*Client, *recorded) {
+	t.Helper()
+	last := &recorded{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*last = recorded{method: r.Method, uri: r.URL.RequestURI(), body: string(body), header: r.Header}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	c, err := New(server.URL+"/", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, last
+}
+
+func respond(status int, contentType, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, baseURL := range []string{"localhost:4000", "/v1", "http://[::1", ""} {
+		if _, err := New(baseURL); err == nil {
+			t.Errorf("New(%q) = nil error, want one", baseURL)
+		}
+	}
+}
+
+func TestBooksRequests(t *testing.T) {
+	ctx := context.Background()
+	book := `{"book": {"id": 1, "title": "Dune", "authors": ["Frank Herbert"], "published": 1965, "pages": "412", "genres": ["sci-fi"], "version": 2}}`
+	c, last := newTestClient(t, respond(http.StatusOK, "application/json", book), WithToken("desk-1"), WithUserAgent("plibrary-test"))
+
+	got, err := c.Books.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Dune" || got.Pages != 412 || got.Version != 2 {
+		t.Errorf("Get = %+v", got)
+	}
+	if last.method != http.MethodGet || last.uri != "/v1/books/1" {
+		t.Errorf("Get sent %s %s", last.method, last.uri)
+	}
+	for name, want := range map[string]string{
+		"Authorization": "Bearer desk-1",
+		"User-Agent":    "plibrary-test",
+		"Accept":        "application/json, application/problem+json",
+		"Content-Type":  "",
+	} {
+		if got := last.header.Get(name); got != want {
+			t.Errorf("Get sent %s %q, want %q", name, got, want)
+		}
+	}
+
+	_, err = c.Books.List(ctx, ListOptions{Title: "dune", Genres: []string{"sci-fi", "classics"}, Page: 2, Size: 5, Sort: "-published", Fields: []string{"id", "title"}, Include: []string{"copies"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/v1/books?fields=id%2Ctitle&genres=sci-fi%2Cclassics&include=copies&page=2&size=5&sort=-published&title=dune"; last.uri != want {
+		t.Errorf("List sent %s, want %s", last.uri, want)
+	}
+	if _, err = c.Books.List(ctx, ListOptions{}); err != nil || last.uri != "/v1/books" {
+		t.Errorf("List with no options sent %s, error %v", last.uri, err)
+	}
+
+	if _, err = c.Books.Search(ctx, "dune messiah"); err != nil || last.uri != "/v1/books/search?q=dune+messiah" {
+		t.Errorf("Search sent %s, error %v", last.uri, err)
+	}
+
+	_, err = c.Books.Create(ctx, BookInput{Title: "Dune", Published: 1965, Pages: 412, Genres: []string{"sci-fi"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"title":"Dune","published":1965,"pages":412,"genres":["sci-fi"]}`; last.method != http.MethodPost || last.body != want || last.header.Get("Content-Type") != "application/json" {
+		t.Errorf("Create sent %s %s %s, want POST %s", last.method, last.header.Get("Content-Type"), last.body, want)
+	}
+
+	title := "Dune Messiah"
+	_, err = c.Books.Update(ctx, 1, BookUpdate{Title: &title, Authors: []string{}}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"title":"Dune Messiah","authors":[],"genres":null}`; last.method != http.MethodPatch || last.body != want {
+		t.Errorf("Update sent %s %s, want PATCH %s", last.method, last.body, want)
+	}
+	if got := last.header.Get("X-Expected-Version"); got != "2" {
+		t.Errorf("Update sent X-Expected-Version %q, want 2", got)
+	}
+	if _, err = c.Books.Update(ctx, 1, BookUpdate{Title: &title}, 0); err != nil || last.header.Get("X-Expected-Version") != "" {
+		t.Errorf("Update without a version sent X-Expected-Version %q, error %v", last.header.Get("X-Expected-Version"), err)
+	}
+
+	c, last = newTestClient(t, respond(http.StatusNoContent, "", ""))
+	if err := c.Books.Delete(ctx, 7); err != nil || last.method != http.MethodDelete || last.uri != "/v1/books/7" {
+		t.Errorf("Delete sent %s %s, error %v", last.method, last.uri, err)
+	}
+}
+
+func TestBooksAll(t *testing.T) {
+	var requests atomic.Int32
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		fmt.Fprintf(w, `{"books": [{"id": %d}, {"id": %d}], "metadata": {"current_page": %d, "last_page": 3}}`, 2*page-1, 2*page, page)
+	})
+
+	var ids []string
+	for book, err := range c.Books.All(context.Background(), ListOptions{Page: 2, Size: 2}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, strconv.FormatInt(book.ID, 10))
+	}
+	if got := strings.Join(ids, " "); got != "3 4 5 6" || requests.Load() != 2 {
+		t.Errorf("All from page 2 = %s in %d requests, want 3 4 5 6 in 2", got, requests.Load())
+	}
+
+	requests.Store(0)
+	for book := range c.Books.All(context.Background(), ListOptions{}) {
+		if book.ID == 2 {
+			break
+		}
+	}
+	if requests.Load() != 1 {
+		t.Errorf("breaking out of All after the first page made %d requests", requests.Load())
+	}
+
+	c, _ = newTestClient(t, respond(http.StatusInternalServerError, "text/plain", "boom"))
+	for book, err := range c.Books.All(context.Background(), ListOptions{}) {
+		if book != nil || !errors.Is(err, ErrServer) {
+			t.Errorf("All yielded %v, %v; want ErrServer", book, err)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		status   int
+		body     string
+		sentinel error
+		want     Error
+	}{
+		{"problem", http.StatusConflict, `{"status": 409, "code": "edit_conflict", "detail": "unable to complete the update due to a conflict, try again"}`,
+			ErrEditConflict, Error{StatusCode: 409, Code: "edit_conflict", Message: "unable to complete the update due to a conflict, try again"}},
+		{"problem fields", http.StatusUnprocessableEntity, `{"code": "validation_failed", "detail": "one or more parameters failed validation", "invalid_params": [{"name": "title", "reason": "must be provided"}]}`,
+			ErrValidation, Error{StatusCode: 422, Code: "validation_failed", Message: "one or more parameters failed validation", Fields: map[string]string{"title": "must be provided"}}},
+		{"envelope", http.StatusNotFound, `{"error": "the requested resource could not be found"}`,
+			ErrNotFound, Error{StatusCode: 404, Message: "the requested resource could not be found"}},
+		{"envelope fields", http.StatusUnprocessableEntity, `{"error": {"page": "must be greater than zero"}}`,
+			ErrValidation, Error{StatusCode: 422, Message: "Unprocessable Entity", Fields: map[string]string{"page": "must be greater than zero"}}},
+		{"not json", http.StatusBadGateway, `<html>bad gateway</html>`,
+			ErrServer, Error{StatusCode: 502, Message: "Bad Gateway"}},
+		{"unknown code", http.StatusUnauthorized, `{"code": "unauthorized", "detail": "invalid staff token"}`,
+			nil, Error{StatusCode: 401, Code: "unauthorized", Message: "invalid staff token"}},
+	} {
+		c, _ := newTestClient(t, respond(test.status, "application/problem+json", test.body))
+		_, err := c.Books.Get(context.Background(), 1)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("%s: error %v, want an *Error", test.name, err)
+			continue
+		}
+		if fmt.Sprint(*e) != fmt.Sprint(test.want) {
+			t.Errorf("%s: error %+v, want %+v", test.name, *e, test.want)
+		}
+		for _, sentinel := range []error{ErrNotFound, ErrEditConflict, ErrValidation, ErrServer} {
+			if errors.Is(err, sentinel) != (sentinel == test.sentinel) {
+				t.Errorf("%s: errors.Is(%v, %v) = %t", test.name, err, sentinel, !(sentinel == test.sentinel))
+			}
+		}
+	}
+
+	c, _ := newTestClient(t, respond(http.StatusOK, "application/json", `{"book": `))
+	if _, err := c.Books.Get(context.Background(), 1); err == nil || !strings.Contains(err.Error(), "decoding /v1/books/1 response") {
+		t.Errorf("truncated response: error %v", err)
+	}
+}
+
+func TestRateLimitRetries(t *testing.T) {
+	var requests atomic.Int32
+	limited := func(times int32) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if requests.Add(1) <= times {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			io.WriteString(w, `{"book": {"id": 1}}`)
+		}
+	}
+
+	c, _ := newTestClient(t, limited(2))
+	if _, err := c.Books.Get(context.Background(), 1); err != nil || requests.Load() != 3 {
+		t.Errorf("Get after two 429s: error %v after %d requests, want success after 3", err, requests.Load())
+	}
+
+	requests.Store(0)
+	c, _ = newTestClient(t, limited(5), WithMaxRetries(1))
+	if _, err := c.Books.Get(context.Background(), 1); !errors.Is(err, ErrRateLimited) || requests.Load() != 2 {
+		t.Errorf("Get with one retry: error %v after %d requests, want ErrRateLimited after 2", err, requests.Load())
+	}
+
+	c, _ = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Books.Get(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get cancelled while waiting to retry: error %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	in := func(d time.Duration) string { return time.Now().Add(d).UTC().Format(http.TimeFormat) }
+	for _, test := range []struct {
+		name     string
+		header   http.Header
+		attempt  int
+		min, max time.Duration
+	}{
+		{"seconds", http.Header{"Retry-After": {"7"}}, 0, 7 * time.Second, 7 * time.Second},
+		{"capped", http.Header{"Retry-After": {"3600"}}, 0, maxBackoff, maxBackoff},
+		{"date", http.Header{"Retry-After": {in(10 * time.Second)}}, 0, 8 * time.Second, 10 * time.Second},
+		{"past date", http.Header{"Retry-After": {in(-time.Hour)}}, 0, 0, 0},
+		{"reset", http.Header{"X-Ratelimit-Reset": {strconv.FormatInt(time.Now().Add(20*time.Second).Unix(), 10)}}, 0, 18 * time.Second, 20 * time.Second},
+		{"backoff", http.Header{}, 2, 4 * defaultBackoff, 4 * defaultBackoff},
+		{"backoff capped", http.Header{"Retry-After": {"soon"}}, 20, maxBackoff, maxBackoff},
+	} {
+		if got := retryDelay(test.header, test.attempt); got < test.min || got > test.max {
+			t.Errorf("%s: retryDelay = %v, want between %v and %v", test.name, got, test.min, test.max)
+		}
+	}
+}
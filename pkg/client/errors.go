@@ -0,0 +1,106 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Sentinel errors matched by errors.Is against the *Error returned for failed requests.
+var (
+	ErrNotFound         = errors.New("client: not found")
+	ErrEditConflict     = errors.New("client: edit conflict")
+	ErrValidation       = errors.New("client: validation failed")
+	ErrBadRequest       = errors.New("client: bad request")
+	ErrRateLimited      = errors.New("client: rate limit exceeded")
+	ErrNotAcceptable    = errors.New("client: not acceptable")
+	ErrMethodNotAllowed = errors.New("client: method not allowed")
+	ErrServer           = errors.New("client: server error")
+)
+
+// errorCodes maps the problem codes reported by the server to the sentinel errors.
+var errorCodes = map[string]error{
+	"not_found":          ErrNotFound,
+	"edit_conflict":      ErrEditConflict,
+	"validation_failed":  ErrValidation,
+	"bad_request":        ErrBadRequest,
+	"rate_limited":       ErrRateLimited,
+	"not_acceptable":     ErrNotAcceptable,
+	"method_not_allowed": ErrMethodNotAllowed,
+	"server_error":       ErrServer,
+}
+
+var errorStatuses = map[int]error{
+	http.StatusNotFound:            ErrNotFound,
+	http.StatusConflict:            ErrEditConflict,
+	http.StatusUnprocessableEntity: ErrValidation,
+	http.StatusBadRequest:          ErrBadRequest,
+	http.StatusTooManyRequests:     ErrRateLimited,
+	http.StatusNotAcceptable:       ErrNotAcceptable,
+	http.StatusMethodNotAllowed:    ErrMethodNotAllowed,
+}
+
+// Error is returned when the server responds with an error status.
+type Error struct {
+	StatusCode int
+	// Code is the machine readable error code, such as "edit_conflict".
+	Code    string
+	Message string
+	// Fields holds the reason each invalid parameter was rejected.
+	Fields map[string]string
+}
+
+func (e *Error) Error() string {
+	if len(e.Fields) > 0 {
+		return fmt.Sprintf("plibrary: %d %s: %v", e.StatusCode, e.Message, e.Fields)
+	}
+	return fmt.Sprintf("plibrary: %d %s", e.StatusCode, e.Message)
+}
+
+// Is reports whether target is the sentinel error matching the code or status of e.
+func (e *Error) Is(target error) bool {
+	if err, ok := errorCodes[e.Code]; ok {
+		return err == target
+	}
+	if err, ok := errorStatuses[e.StatusCode]; ok {
+		return err == target
+	}
+	return e.StatusCode >= 500 && target == ErrServer
+}
+
+// newError decodes a problem+json body or the legacy {"error": ...} envelope.
+func newError(resp *http.Response, data []byte) error {
+	e := &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	var body struct {
+		Code          string `json:"code"`
+		Detail        string `json:"detail"`
+		InvalidParams []struct {
+			Name   string `json:"name"`
+			Reason string `json:"reason"`
+		} `json:"invalid_params"`
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(data, &body) != nil {
+		return e
+	}
+	e.Code = body.Code
+	if body.Detail != "" {
+		e.Message = body.Detail
+	}
+	for _, param := range body.InvalidParams {
+		if e.Fields == nil {
+			e.Fields = map[string]string{}
+		}
+		e.Fields[param.Name] = param.Reason
+	}
+	if len(body.Error) > 0 {
+		var message string
+		if json.Unmarshal(body.Error, &message) == nil {
+			e.Message = message
+		} else {
+			json.Unmarshal(body.Error, &e.Fields)
+		}
+	}
+	return e
+}